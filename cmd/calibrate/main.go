@@ -0,0 +1,78 @@
+// Command calibrate fits scoring weights and thresholds to recorded warmup
+// outcomes and writes a candidate scoring policy plus an evaluation report.
+//
+//	go run ./cmd/calibrate -data ./data -policy scoring_policy.json -out candidate.json
+//
+// Load the candidate as SCORING_CANDIDATE_POLICY_FILE to compare it in
+// shadow mode before making it the active policy.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"domain-vetting-poc/vetting"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	opts := vetting.DefaultCalibrationOptions()
+	dataDir := flag.String("data", os.Getenv("VETTING_DATA_DIR"), "directory with vet_results.jsonl and outcomes.jsonl")
+	policyPath := flag.String("policy", os.Getenv("SCORING_POLICY_FILE"), "policy to calibrate (built-in policy if empty)")
+	outPath := flag.String("out", "candidate_policy.json", "candidate policy file to write")
+	reportPath := flag.String("report", "calibration_report.json", "evaluation report file to write")
+	flag.StringVar(&opts.Version, "version", "", "candidate policy version (default <base>-calibrated-<date>)")
+	flag.IntVar(&opts.MinExamples, "min-examples", opts.MinExamples, "minimum labeled examples")
+	flag.Float64Var(&opts.PointsToDoubleOdds, "pdo", opts.PointsToDoubleOdds, "penalty points that double the odds of a bad outcome")
+	flag.Float64Var(&opts.L2, "l2", opts.L2, "L2 regularization of the coefficients")
+	flag.Float64Var(&opts.HighRiskBadRate, "high-risk-bad-rate", opts.HighRiskBadRate, "bad-outcome rate at which scores become high-risk")
+	flag.Float64Var(&opts.MediumBadRate, "medium-bad-rate", opts.MediumBadRate, "bad-outcome rate at which scores become medium")
+	flag.Float64Var(&opts.Criteria.MaxBounceRate, "max-bounce-rate", opts.Criteria.MaxBounceRate, "bounce rate above which an outcome is bad")
+	flag.Float64Var(&opts.Criteria.MaxComplaintRate, "max-complaint-rate", opts.Criteria.MaxComplaintRate, "complaint rate above which an outcome is bad")
+	flag.Float64Var(&opts.Criteria.MinInboxPlacement, "min-inbox-placement", opts.Criteria.MinInboxPlacement, "inbox placement below which an outcome is bad")
+	flag.Parse()
+
+	if *dataDir == "" {
+		log.Fatal("[Calibrate] -data (or VETTING_DATA_DIR) is required")
+	}
+	store, err := vetting.NewOutcomeStore(*dataDir)
+	if err != nil {
+		log.Fatalf("[Calibrate] Cannot read %s: %v", *dataDir, err)
+	}
+
+	base := vetting.DefaultScoringPolicy()
+	if *policyPath != "" {
+		if base, err = vetting.LoadScoringPolicy(*policyPath); err != nil {
+			log.Fatalf("[Calibrate] %v", err)
+		}
+	}
+
+	candidate, report, err := vetting.Calibrate(base, store.LabeledDataset(), opts)
+	if err != nil {
+		log.Fatalf("[Calibrate] ❌ %v", err)
+	}
+
+	if err := writeJSON(*outPath, candidate); err != nil {
+		log.Fatalf("[Calibrate] Cannot write candidate policy: %v", err)
+	}
+	if err := writeJSON(*reportPath, report); err != nil {
+		log.Fatalf("[Calibrate] Cannot write report: %v", err)
+	}
+
+	fmt.Println(report)
+	log.Printf("[Calibrate] ✔ Candidate policy %s written to %s (report: %s)", candidate.Version, *outPath, *reportPath)
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -1,13 +1,15 @@
 module domain-vetting-poc
 
-go 1.24
+go 1.24.0
 
 require (
 	github.com/chromedp/chromedp v0.14.2
 	github.com/joho/godotenv v1.5.1
 	github.com/likexian/whois v1.15.5
 	github.com/likexian/whois-parser v1.24.20
-	golang.org/x/sync v0.10.0
+	github.com/miekg/dns v1.1.72
+	golang.org/x/net v0.48.0
+	golang.org/x/sync v0.19.0
 )
 
 require (
@@ -18,7 +20,8 @@ require (
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.4.0 // indirect
 	github.com/likexian/gokit v0.25.15 // indirect
-	golang.org/x/net v0.33.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/mod v0.31.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+	golang.org/x/tools v0.40.0 // indirect
 )
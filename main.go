@@ -14,6 +14,22 @@ import (
 func main() {
 	_ = godotenv.Load()
 
+	// DNS upstreams (VETTING_DNS_SERVERS: plain, DoT or DoH resolvers)
+	vetting.ConfigureDNSFromEnv()
+
+	// Turn off checks listed in VETTING_DISABLED_CHECKS (per-deployment)
+	vetting.ConfigureChecksFromEnv()
+
+	// Load the scoring policy file (SCORING_POLICY_FILE) and the optional shadow
+	// candidate (SCORING_CANDIDATE_POLICY_FILE), and watch them for changes
+	vetting.ConfigureScoringPolicyFromEnv()
+
+	// Record ML feature vectors of vetted domains (VETTING_FEATURES_LOG)
+	vetting.ConfigureFeatureLogFromEnv()
+
+	// Store vetting results and warmup outcomes in VETTING_DATA_DIR
+	vetting.ConfigureOutcomeStoreFromEnv()
+
 	// Get port from environment (for cloud deployment) or default to 8080
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -23,6 +39,10 @@ func main() {
 	// Vetting endpoints
 	http.HandleFunc("/vet", vetting.VetHandler)
 	http.HandleFunc("/warmup", vetting.WarmupHandler)
+	http.HandleFunc("/vet/result", vetting.VetResultHandler)
+	http.HandleFunc("/outcomes", vetting.OutcomeHandler)
+	http.HandleFunc("/features/export", vetting.FeaturesExportHandler)
+	http.HandleFunc("/dns/health", vetting.DNSHealthHandler)
 
 	// AI Chat endpoints (Backend-Driven)
 	http.HandleFunc("/chat/start", ai.StartChatHandler) // Initialize new chat session
@@ -35,6 +55,10 @@ func main() {
 	log.Println("📍 Endpoints:")
 	log.Println("   POST /vet          - Domain vetting")
 	log.Println("   POST /warmup       - Warmup calculation")
+	log.Println("   GET  /vet/result   - Stored vetting result by id")
+	log.Println("   POST /outcomes     - Record warmup outcome for a vetting result")
+	log.Println("   GET  /features/export - ML feature export (csv, columnar)")
+	log.Println("   GET  /dns/health   - DNS upstream health")
 	log.Println("   POST /chat/start   - Start AI chat session")
 	log.Println("   POST /chat         - Send chat message")
 
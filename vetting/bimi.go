@@ -0,0 +1,541 @@
+package vetting
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//
+// BIMI (Brand Indicators for Message Identification)
+//
+// The record is looked up at default._bimi.<domain>, falling back to the
+// organizational domain like DMARC. l= points to the logo, which must be an
+// SVG Tiny Portable/Secure (SVG Tiny PS) document; a= points to the evidence
+// certificate (VMC for registered marks, CMC for common marks), a PEM chain
+// whose leaf must chain to a trusted root, name the domain and embed the same
+// logo. Mailbox providers only show the logo if DMARC is enforced
+// (quarantine or reject at pct=100), so the check reuses the DMARC analysis.
+// Ready sums it up for BIMI readiness reports.
+//
+
+const (
+	bimiMaxFetchSize = 1 << 20   // Download limit for logos and certificates
+	bimiMaxLogoSize  = 32 * 1024 // BIMI group recommendation for the SVG
+)
+
+var (
+	// id-kp-BrandIndicatorforMessageIdentification (RFC 9323 §5)
+	oidBIMIExtKeyUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 31}
+	// id-pe-logotype (RFC 3709)
+	oidLogotype = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 12}
+	// Subject attribute with the mark type of the certificate
+	oidBIMIMarkType = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53087, 1, 13}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// svgTinyPSForbidden are the elements SVG Tiny PS removes from SVG Tiny 1.2
+// (scripting, animation, external and embedded content)
+var svgTinyPSForbidden = map[string]bool{
+	"script": true, "foreignObject": true, "image": true, "video": true, "audio": true,
+	"animate": true, "animateColor": true, "animateMotion": true, "animateTransform": true, "set": true,
+}
+
+// BIMILogo - the SVG at l= checked against the SVG Tiny PS profile
+type BIMILogo struct {
+	Size     int      `json:"size"`
+	SHA256   string   `json:"sha256"`
+	Valid    bool     `json:"valid"` // SVG Tiny PS
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// BIMICertificate - the evidence certificate at a=
+type BIMICertificate struct {
+	Type         string    `json:"type"`                // VMC or CMC
+	MarkType     string    `json:"mark_type,omitempty"` // e.g. Registered Mark, Prior Use Mark
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	NotAfter     time.Time `json:"not_after"`
+	Domains      []string  `json:"domains"`
+	Chains       bool      `json:"chains"` // Leaf verifies to a trusted root
+	ChainError   string    `json:"chain_error,omitempty"`
+	CoversDomain bool      `json:"covers_domain"`
+	HasLogo      bool      `json:"has_logo"`     // Logotype extension with an embedded SVG
+	LogoMatches  bool      `json:"logo_matches"` // Embedded SVG is the l= logo
+	Errors       []string  `json:"errors,omitempty"`
+}
+
+// Valid reports whether mailbox providers would accept the certificate
+func (c *BIMICertificate) Valid() bool {
+	return c != nil && c.Chains && c.CoversDomain && c.LogoMatches && len(c.Errors) == 0
+}
+
+// BIMIResult - BIMI record, logo and evidence certificate
+type BIMIResult struct {
+	CheckOutcome
+	Record      string           `json:"record,omitempty"`
+	Domain      string           `json:"domain,omitempty"` // Where the record was found
+	Inherited   bool             `json:"inherited"`        // Found at the organizational domain
+	Declined    bool             `json:"declined"`         // Empty l= and a=: the domain opts out
+	LogoURL     string           `json:"logo_url,omitempty"`
+	EvidenceURL string           `json:"evidence_url,omitempty"`
+	Logo        *BIMILogo        `json:"logo,omitempty"`
+	Certificate *BIMICertificate `json:"certificate,omitempty"`
+
+	// DMARC is quarantine/reject at pct=100 (required for the logo to show)
+	DMARCEnforced bool `json:"dmarc_enforced"`
+	// Record, SVG Tiny PS logo, enforced DMARC and (if a= is set) a valid certificate
+	Ready  bool     `json:"ready"`
+	Issues []string `json:"issues,omitempty"` // What keeps the logo from showing (everywhere)
+}
+
+// bimiClient downloads logos and certificates
+var bimiClient = &http.Client{Timeout: 8 * time.Second}
+
+// AnalyzeBIMI looks up the BIMI record of domain and validates its logo and
+// evidence certificate; dmarc is the domain's DMARC analysis (nil if none)
+func AnalyzeBIMI(ctx context.Context, domain string, dmarc *DMARCPolicy) BIMIResult {
+	return analyzeBIMI(ctx, defaultDNS(), bimiClient, domain, dmarc)
+}
+
+func analyzeBIMI(ctx context.Context, dns DNSClient, client *http.Client, domain string, dmarc *DMARCPolicy) BIMIResult {
+	res := BIMIResult{DMARCEnforced: dmarc.Enforced() && dmarc.Pct == 100}
+	if !res.DMARCEnforced {
+		res.Issues = append(res.Issues, "DMARC must be quarantine or reject at pct=100 for the logo to be shown")
+	}
+
+	record, recordDomain, err := discoverBIMI(ctx, dns, domain)
+	if err != nil {
+		res.CheckOutcome = outcomeOf(err)
+		return res
+	}
+	if record == "" {
+		res.CheckOutcome = negativeIf(true)
+		res.Issues = append(res.Issues, "no BIMI record at default._bimi."+domain)
+		log.Printf("[BIMI] %s: no record (dmarc enforced=%v)", domain, res.DMARCEnforced)
+		return res
+	}
+	res.CheckOutcome = CheckOutcome{Status: StatusOK}
+	res.Record = record
+	res.Domain = recordDomain
+	res.Inherited = !strings.EqualFold(recordDomain, domain)
+
+	tags := parseBIMITags(record)
+	res.LogoURL, res.EvidenceURL = tags["l"], tags["a"]
+	if res.LogoURL == "" && res.EvidenceURL == "" {
+		res.Declined = true
+		res.Issues = append(res.Issues, "BIMI record declines to publish a logo (empty l= and a=)")
+		return res
+	}
+
+	var svg []byte
+	if res.LogoURL == "" {
+		res.Issues = append(res.Issues, "l= (logo URL) is missing")
+	} else if svg, err = fetchBIMIAsset(ctx, client, res.LogoURL); err != nil {
+		res.Issues = append(res.Issues, "logo could not be fetched: "+err.Error())
+	} else {
+		logo := ValidateSVGTinyPS(svg)
+		res.Logo = &logo
+		if !logo.Valid {
+			res.Issues = append(res.Issues, "logo is not SVG Tiny PS: "+strings.Join(logo.Errors, "; "))
+		}
+	}
+
+	if res.EvidenceURL != "" {
+		if pemData, err := fetchBIMIAsset(ctx, client, res.EvidenceURL); err != nil {
+			res.Issues = append(res.Issues, "evidence certificate could not be fetched: "+err.Error())
+		} else {
+			cert := verifyBIMICertificate(pemData, recordDomain, svg)
+			res.Certificate = &cert
+			if !cert.Valid() {
+				res.Issues = append(res.Issues, "evidence certificate is not valid: "+strings.Join(bimiCertificateProblems(cert), "; "))
+			}
+		}
+	} else {
+		res.Issues = append(res.Issues, "no evidence certificate (a=): Gmail and Apple Mail require a VMC or CMC")
+	}
+
+	res.Ready = res.DMARCEnforced && res.Logo != nil && res.Logo.Valid &&
+		(res.EvidenceURL == "" || res.Certificate.Valid())
+	log.Printf("[BIMI] %s: record at %s, logo valid=%v, certificate valid=%v, ready=%v",
+		domain, recordDomain, res.Logo != nil && res.Logo.Valid, res.Certificate.Valid(), res.Ready)
+	return res
+}
+
+// discoverBIMI returns the BIMI record of domain or, if it has none, of its
+// organizational domain ("" if neither has one)
+func discoverBIMI(ctx context.Context, dns DNSClient, domain string) (string, string, error) {
+	candidates := []string{domain}
+	if org := organizationalDomain(domain); !strings.EqualFold(org, domain) {
+		candidates = append(candidates, org)
+	}
+	for _, d := range candidates {
+		txts, err := dns.LookupTXT(ctx, "default._bimi."+d)
+		if err != nil && !isDNSNotFound(err) {
+			return "", "", err
+		}
+		for _, txt := range txts {
+			if isBIMIRecord(txt) {
+				return txt, d, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// isBIMIRecord reports whether a TXT record starts with the v=BIMI1 tag
+func isBIMIRecord(txt string) bool {
+	tag, _, _ := strings.Cut(txt, ";")
+	name, value, ok := strings.Cut(tag, "=")
+	return ok && strings.TrimSpace(name) == "v" && strings.EqualFold(strings.TrimSpace(value), "BIMI1")
+}
+
+// parseBIMITags splits a BIMI record into its tags
+func parseBIMITags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(record, ";") {
+		if name, value, ok := strings.Cut(strings.TrimSpace(tag), "="); ok {
+			tags[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+	}
+	return tags
+}
+
+// fetchBIMIAsset downloads a logo or certificate (HTTPS only)
+func fetchBIMIAsset(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	if !strings.HasPrefix(strings.ToLower(url), "https://") {
+		return nil, fmt.Errorf("%s is not an https:// URL", url)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bimiMaxFetchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > bimiMaxFetchSize {
+		return nil, fmt.Errorf("larger than %d bytes", bimiMaxFetchSize)
+	}
+	return body, nil
+}
+
+// ValidateSVGTinyPS checks an SVG against the SVG Tiny PS profile required
+// for BIMI logos
+func ValidateSVGTinyPS(svg []byte) BIMILogo {
+	sum := sha256.Sum256(svg)
+	logo := BIMILogo{Size: len(svg), SHA256: hex.EncodeToString(sum[:])}
+	if len(svg) > bimiMaxLogoSize {
+		logo.Warnings = append(logo.Warnings, fmt.Sprintf("%d bytes: keep the logo under %d bytes", len(svg), bimiMaxLogoSize))
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	depth, hasTitle := 0, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logo.Errors = append(logo.Errors, "not well-formed XML: "+err.Error())
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1:
+				logo.Errors = append(logo.Errors, svgRootErrors(t, &logo.Warnings)...)
+			case depth == 2 && t.Name.Local == "title":
+				hasTitle = true
+			}
+			if svgTinyPSForbidden[t.Name.Local] {
+				logo.Errors = append(logo.Errors, fmt.Sprintf("<%s> is not allowed", t.Name.Local))
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case strings.HasPrefix(strings.ToLower(attr.Name.Local), "on"):
+					logo.Errors = append(logo.Errors, fmt.Sprintf("event handler %s= on <%s> is not allowed", attr.Name.Local, t.Name.Local))
+				case attr.Name.Local == "href" && !strings.HasPrefix(attr.Value, "#"):
+					logo.Errors = append(logo.Errors, fmt.Sprintf("external reference %q on <%s> is not allowed", attr.Value, t.Name.Local))
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	if logo.Size > 0 && !hasTitle {
+		logo.Errors = append(logo.Errors, "<title> is required as a child of <svg>")
+	}
+	logo.Valid = logo.Size > 0 && len(logo.Errors) == 0
+	return logo
+}
+
+// svgRootErrors checks the root element: <svg version="1.2"
+// baseProfile="tiny-ps">, no x/y, square viewBox recommended
+func svgRootErrors(root xml.StartElement, warnings *[]string) []string {
+	if root.Name.Local != "svg" {
+		return []string{fmt.Sprintf("root element is <%s>, want <svg>", root.Name.Local)}
+	}
+	var errs []string
+	attrs := map[string]string{}
+	for _, attr := range root.Attr {
+		attrs[attr.Name.Local] = attr.Value
+	}
+	if attrs["baseProfile"] != "tiny-ps" {
+		errs = append(errs, fmt.Sprintf(`baseProfile is %q, want "tiny-ps"`, attrs["baseProfile"]))
+	}
+	if attrs["version"] != "1.2" {
+		errs = append(errs, fmt.Sprintf(`version is %q, want "1.2"`, attrs["version"]))
+	}
+	for _, name := range []string{"x", "y"} {
+		if _, ok := attrs[name]; ok {
+			errs = append(errs, fmt.Sprintf("%s= is not allowed on <svg>", name))
+		}
+	}
+	if box := strings.Fields(strings.ReplaceAll(attrs["viewBox"], ",", " ")); len(box) != 4 {
+		*warnings = append(*warnings, "no viewBox: the logo may not scale")
+	} else if box[2] != box[3] {
+		*warnings = append(*warnings, fmt.Sprintf("viewBox is %sx%s: logos are shown in a square (or circle)", box[2], box[3]))
+	}
+	return errs
+}
+
+// verifyBIMICertificate checks the PEM chain at a=: the leaf chains to a
+// trusted root for BIMI use, names domain and embeds the logo svg
+func verifyBIMICertificate(pemData []byte, domain string, svg []byte) BIMICertificate {
+	var cert BIMICertificate
+	var chain []*x509.Certificate
+	for rest := pemData; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			cert.Errors = append(cert.Errors, "unparseable certificate: "+err.Error())
+			continue
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		cert.Errors = append(cert.Errors, "no PEM certificates")
+		return cert
+	}
+
+	leaf := chain[0]
+	cert.Subject = leaf.Subject.String()
+	cert.Issuer = leaf.Issuer.String()
+	cert.NotAfter = leaf.NotAfter
+	cert.Domains = leaf.DNSNames
+	cert.Type = "CMC"
+	for _, name := range leaf.Subject.Names {
+		if name.Type.Equal(oidBIMIMarkType) {
+			cert.MarkType = fmt.Sprint(name.Value)
+		}
+	}
+	switch cert.MarkType {
+	case "Registered Mark", "Government Mark":
+		cert.Type = "VMC"
+	}
+
+	// Chain: the leaf must be issued for BIMI and verify to a trusted root
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         bimiRoots(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	cert.Chains = err == nil
+	if err != nil {
+		cert.ChainError = err.Error()
+	}
+	hasBIMIUsage := false
+	for _, oid := range leaf.UnknownExtKeyUsage {
+		if oid.Equal(oidBIMIExtKeyUsage) {
+			hasBIMIUsage = true
+		}
+	}
+	if !hasBIMIUsage {
+		cert.Errors = append(cert.Errors, "certificate is not issued for BIMI (missing the BIMI extended key usage)")
+	}
+
+	cert.CoversDomain = leaf.VerifyHostname(domain) == nil
+
+	// Logo: the embedded SVG must be the published one
+	embedded, err := bimiCertificateLogo(leaf)
+	switch {
+	case err != nil:
+		cert.Errors = append(cert.Errors, err.Error())
+	case embedded != nil:
+		cert.HasLogo = true
+		cert.LogoMatches = svg != nil && bytes.Equal(embedded, svg)
+	}
+	return cert
+}
+
+// bimiRoots are the system roots plus the PEM file in VETTING_BIMI_ROOTS
+// (VMC/CMC roots are not in every system store)
+func bimiRoots() *x509.CertPool {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if path := os.Getenv("VETTING_BIMI_ROOTS"); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			pool.AppendCertsFromPEM(data)
+		} else {
+			log.Printf("[BIMI] ⚠️ Cannot read VETTING_BIMI_ROOTS: %v", err)
+		}
+	}
+	return pool
+}
+
+// bimiCertificateProblems describes why a certificate is not valid
+func bimiCertificateProblems(c BIMICertificate) []string {
+	problems := append([]string{}, c.Errors...)
+	if !c.Chains {
+		problems = append(problems, "does not chain to a trusted root: "+c.ChainError)
+	}
+	if !c.CoversDomain {
+		problems = append(problems, fmt.Sprintf("issued for %s, not this domain", strings.Join(c.Domains, ", ")))
+	}
+	switch {
+	case !c.HasLogo:
+		problems = append(problems, "no embedded logo")
+	case !c.LogoMatches:
+		problems = append(problems, "embedded logo differs from the l= logo")
+	}
+	return problems
+}
+
+// logotypeDetails is LogotypeDetails from RFC 3709 (a SEQUENCE OF IA5String
+// parses into []string without a tag)
+type logotypeDetails struct {
+	MediaType string `asn1:"ia5"`
+	Hashes    []logotypeHash
+	URIs      []string
+}
+
+type logotypeHash struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Value     []byte
+}
+
+// bimiCertificateLogo returns the SVG embedded in the logotype extension as a
+// data: URI (gzip-compressed per RFC 6170), after checking it against its
+// hash; nil if the certificate has no logotype extension
+func bimiCertificateLogo(cert *x509.Certificate) ([]byte, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidLogotype) {
+			continue
+		}
+		details := findLogotypeDetails(ext.Value)
+		if details == nil {
+			return nil, errors.New("logotype extension has no image")
+		}
+		for _, uri := range details.URIs {
+			_, data, ok := strings.Cut(uri, ";base64,")
+			if !strings.HasPrefix(uri, "data:") || !ok {
+				continue
+			}
+			raw, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return nil, fmt.Errorf("embedded logo is not valid base64: %v", err)
+			}
+			if !logotypeHashMatches(details.Hashes, raw) {
+				return nil, errors.New("embedded logo does not match its hash")
+			}
+			if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+				return raw, nil
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("embedded logo: %v", err)
+			}
+			svg, err := io.ReadAll(io.LimitReader(zr, bimiMaxFetchSize))
+			if err != nil {
+				return nil, fmt.Errorf("embedded logo: %v", err)
+			}
+			return svg, nil
+		}
+		return nil, errors.New("logotype extension has no embedded (data:) logo")
+	}
+	return nil, nil
+}
+
+// findLogotypeDetails walks the logotype extension for the first
+// LogotypeDetails (the CHOICE/tagged wrappers vary between issuers)
+func findLogotypeDetails(der []byte) *logotypeDetails {
+	var details logotypeDetails
+	if rest, err := asn1.Unmarshal(der, &details); err == nil && len(rest) == 0 && len(details.URIs) > 0 {
+		return &details
+	}
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &raw); err != nil || !raw.IsCompound {
+		return nil
+	}
+	for inner := raw.Bytes; len(inner) > 0; {
+		var child asn1.RawValue
+		rest, err := asn1.Unmarshal(inner, &child)
+		if err != nil {
+			return nil
+		}
+		if found := findLogotypeDetails(child.FullBytes); found != nil {
+			return found
+		}
+		inner = rest
+	}
+	return nil
+}
+
+// logotypeHashMatches checks data against the first hash it can compute
+func logotypeHashMatches(hashes []logotypeHash, data []byte) bool {
+	for _, h := range hashes {
+		switch {
+		case h.Algorithm.Algorithm.Equal(oidSHA256):
+			sum := sha256.Sum256(data)
+			return bytes.Equal(sum[:], h.Value)
+		case h.Algorithm.Algorithm.Equal(oidSHA1):
+			sum := sha1.Sum(data)
+			return bytes.Equal(sum[:], h.Value)
+		}
+	}
+	return len(hashes) == 0
+}
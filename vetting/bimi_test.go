@@ -0,0 +1,287 @@
+package vetting
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBIMILogo = `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 100 100">` +
+	`<title>Example</title><circle cx="50" cy="50" r="40" fill="#c00"/></svg>`
+
+func TestValidateSVGTinyPS(t *testing.T) {
+	svg := func(attrs, body string) string {
+		return `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" ` + attrs + `>` + body + `</svg>`
+	}
+	root := `version="1.2" baseProfile="tiny-ps" viewBox="0 0 100 100"`
+	tests := []struct {
+		name    string
+		svg     string
+		err     string // Substring of an error ("" = valid)
+		warning string
+	}{
+		{name: "valid", svg: testBIMILogo},
+		{name: "internal reference", svg: svg(root, `<title>x</title><defs><circle id="c" r="1"/></defs><use xlink:href="#c"/>`)},
+		{name: "script", svg: svg(root, `<title>x</title><script>alert(1)</script>`), err: "<script> is not allowed"},
+		{name: "embedded image", svg: svg(root, `<title>x</title><g><image xlink:href="#i"/></g>`), err: "<image> is not allowed"},
+		{name: "animation", svg: svg(root, `<title>x</title><circle r="1"><animate attributeName="r"/></circle>`), err: "<animate> is not allowed"},
+		{name: "foreignObject", svg: svg(root, `<title>x</title><foreignObject/>`), err: "<foreignObject> is not allowed"},
+		{name: "event handler", svg: svg(root, `<title>x</title><circle r="1" onclick="x()"/>`), err: "event handler onclick= on <circle>"},
+		{name: "event handler on the root", svg: svg(root+` onload="x()"`, `<title>x</title>`), err: "event handler onload= on <svg>"},
+		{name: "external reference", svg: svg(root, `<title>x</title><use xlink:href="https://example.com/a.svg#x"/>`), err: "external reference"},
+		{name: "missing title", svg: svg(root, `<circle r="1"/>`), err: "<title> is required"},
+		{name: "nested title", svg: svg(root, `<g><title>x</title></g>`), err: "<title> is required"},
+		{name: "missing baseProfile", svg: svg(`version="1.2" viewBox="0 0 1 1"`, `<title>x</title>`), err: `baseProfile is "", want "tiny-ps"`},
+		{name: "tiny baseProfile", svg: svg(`version="1.2" baseProfile="tiny" viewBox="0 0 1 1"`, `<title>x</title>`), err: `baseProfile is "tiny"`},
+		{name: "version", svg: svg(`version="1.1" baseProfile="tiny-ps" viewBox="0 0 1 1"`, `<title>x</title>`), err: `version is "1.1"`},
+		{name: "x on the root", svg: svg(root+` x="0"`, `<title>x</title>`), err: "x= is not allowed"},
+		{name: "not svg", svg: `<html><title>x</title></html>`, err: "root element is <html>"},
+		{name: "malformed", svg: `<svg version="1.2" baseProfile="tiny-ps"><title>x</svg>`, err: "not well-formed XML"},
+		{name: "empty", svg: ``},
+		{name: "no viewBox", svg: svg(`version="1.2" baseProfile="tiny-ps"`, `<title>x</title>`), warning: "no viewBox"},
+		{name: "not square", svg: svg(`version="1.2" baseProfile="tiny-ps" viewBox="0,0,200,100"`, `<title>x</title>`), warning: "viewBox is 200x100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logo := ValidateSVGTinyPS([]byte(tt.svg))
+			errs := strings.Join(logo.Errors, "; ")
+			wantValid := tt.err == "" && tt.svg != ""
+			if logo.Valid != wantValid || !strings.Contains(errs, tt.err) {
+				t.Errorf("valid=%v errors %q, want %q", logo.Valid, errs, tt.err)
+			}
+			if warnings := strings.Join(logo.Warnings, "; "); !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings %q, want %q", warnings, tt.warning)
+			}
+		})
+	}
+	if logo := ValidateSVGTinyPS([]byte(testBIMILogo)); logo.Size != len(testBIMILogo) || len(logo.SHA256) != 64 {
+		t.Errorf("size %d sha256 %q", logo.Size, logo.SHA256)
+	}
+}
+
+// logotypeExtension builds an RFC 3709 logotype extension with the
+// gzip-compressed svg as a data: URI (subjectLogo, direct image), hashed
+// with hash (nil = the real SHA-256)
+func logotypeExtension(t *testing.T, svg []byte, hash []byte) []byte {
+	t.Helper()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(svg)
+	zw.Close()
+	if hash == nil {
+		sum := sha256.Sum256(gz.Bytes())
+		hash = sum[:]
+	}
+	details, err := asn1.Marshal(logotypeDetails{
+		MediaType: "image/svg+xml",
+		Hashes:    []logotypeHash{{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, Value: hash}},
+		URIs:      []string{"data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(gz.Bytes())},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrap := func(class, tag int, inner []byte) []byte {
+		der, err := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: inner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	image := wrap(asn1.ClassUniversal, asn1.TagSequence, details) // LogotypeImage
+	images := wrap(asn1.ClassUniversal, asn1.TagSequence, image)  // SEQUENCE OF LogotypeImage
+	direct := wrap(asn1.ClassContextSpecific, 0, images)          // LogotypeInfo: direct [0] LogotypeData
+	subject := wrap(asn1.ClassContextSpecific, 2, direct)         // subjectLogo [2]
+	return wrap(asn1.ClassUniversal, asn1.TagSequence, subject)   // LogotypeExtn
+}
+
+// bimiTestChain issues a root, an intermediate and a leaf for domain;
+// returns the leaf+intermediate PEM chain and the root PEM
+func bimiTestChain(t *testing.T, domain string, bimiUsage bool, logotype []byte) (chain, root []byte) {
+	t.Helper()
+	now := time.Now()
+	issue := func(tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		tmpl.NotBefore, tmpl.NotAfter = now.Add(-time.Hour), now.Add(24*time.Hour)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			KeyUsage:              x509.KeyUsageCertSign,
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+	}
+	rootCert, rootKey := issue(ca(1, "Test BIMI Root"), nil, nil)
+	interCert, interKey := issue(ca(2, "Test BIMI Intermediate"), rootCert, rootKey)
+
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject: pkix.Name{
+			CommonName: "Example Inc",
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidBIMIMarkType, Value: "Registered Mark"}},
+		},
+		DNSNames: []string{domain},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}
+	if bimiUsage {
+		leafTmpl.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidBIMIExtKeyUsage}
+	}
+	if logotype != nil {
+		leafTmpl.ExtraExtensions = []pkix.Extension{{Id: oidLogotype, Value: logotype}}
+	}
+	leaf, _ := issue(leafTmpl, interCert, interKey)
+
+	for _, c := range []*x509.Certificate{leaf, interCert} {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCert.Raw})
+}
+
+// trustBIMIRoot adds root to the BIMI roots for the test
+func trustBIMIRoot(t *testing.T, root []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bimi-roots.pem")
+	if err := os.WriteFile(path, root, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VETTING_BIMI_ROOTS", path)
+}
+
+func TestVerifyBIMICertificate(t *testing.T) {
+	logo := []byte(testBIMILogo)
+	chain, root := bimiTestChain(t, "example.com", true, logotypeExtension(t, logo, nil))
+
+	t.Run("untrusted root", func(t *testing.T) {
+		cert := verifyBIMICertificate(chain, "example.com", logo)
+		if cert.Chains || cert.ChainError == "" || cert.Valid() {
+			t.Errorf("chains=%v error %q", cert.Chains, cert.ChainError)
+		}
+	})
+
+	trustBIMIRoot(t, root)
+	t.Run("valid", func(t *testing.T) {
+		cert := verifyBIMICertificate(chain, "example.com", logo)
+		if !cert.Valid() || cert.Type != "VMC" || cert.MarkType != "Registered Mark" || !cert.HasLogo {
+			t.Errorf("certificate %+v, want a valid VMC", cert)
+		}
+		if problems := bimiCertificateProblems(cert); len(problems) != 0 {
+			t.Errorf("problems %q", problems)
+		}
+	})
+	t.Run("other domain", func(t *testing.T) {
+		cert := verifyBIMICertificate(chain, "other.com", logo)
+		if !cert.Chains || cert.CoversDomain || cert.Valid() {
+			t.Errorf("chains=%v covers=%v", cert.Chains, cert.CoversDomain)
+		}
+	})
+	t.Run("different logo", func(t *testing.T) {
+		cert := verifyBIMICertificate(chain, "example.com", []byte(strings.Replace(testBIMILogo, "#c00", "#00c", 1)))
+		if !cert.HasLogo || cert.LogoMatches || cert.Valid() {
+			t.Errorf("has logo=%v matches=%v", cert.HasLogo, cert.LogoMatches)
+		}
+	})
+	t.Run("not issued for BIMI", func(t *testing.T) {
+		chain, root := bimiTestChain(t, "example.com", false, logotypeExtension(t, logo, nil))
+		trustBIMIRoot(t, root)
+		cert := verifyBIMICertificate(chain, "example.com", logo)
+		if !cert.Chains || cert.Valid() || !strings.Contains(strings.Join(cert.Errors, "; "), "BIMI extended key usage") {
+			t.Errorf("chains=%v errors %q", cert.Chains, cert.Errors)
+		}
+	})
+	t.Run("logo hash mismatch", func(t *testing.T) {
+		chain, root := bimiTestChain(t, "example.com", true, logotypeExtension(t, logo, make([]byte, 32)))
+		trustBIMIRoot(t, root)
+		cert := verifyBIMICertificate(chain, "example.com", logo)
+		if cert.HasLogo || !strings.Contains(strings.Join(cert.Errors, "; "), "does not match its hash") {
+			t.Errorf("has logo=%v errors %q", cert.HasLogo, cert.Errors)
+		}
+	})
+	t.Run("no logotype", func(t *testing.T) {
+		chain, root := bimiTestChain(t, "example.com", true, nil)
+		trustBIMIRoot(t, root)
+		cert := verifyBIMICertificate(chain, "example.com", logo)
+		if cert.HasLogo || cert.Valid() || !strings.Contains(strings.Join(bimiCertificateProblems(cert), "; "), "no embedded logo") {
+			t.Errorf("certificate %+v", cert)
+		}
+	})
+	t.Run("not PEM", func(t *testing.T) {
+		cert := verifyBIMICertificate([]byte("not a certificate"), "example.com", logo)
+		if len(cert.Errors) != 1 || cert.Errors[0] != "no PEM certificates" {
+			t.Errorf("errors %q", cert.Errors)
+		}
+	})
+}
+
+func TestFindLogotypeDetails(t *testing.T) {
+	ext := logotypeExtension(t, []byte(testBIMILogo), nil)
+	details := findLogotypeDetails(ext)
+	if details == nil || details.MediaType != "image/svg+xml" || len(details.Hashes) != 1 || len(details.URIs) != 1 {
+		t.Fatalf("details = %+v", details)
+	}
+	if !strings.HasPrefix(details.URIs[0], "data:image/svg+xml;base64,") {
+		t.Errorf("URI %q", details.URIs[0])
+	}
+	for _, der := range [][]byte{nil, {0x30, 0x00}, {0x04, 0x01, 0x00}, ext[:len(ext)-3]} {
+		if d := findLogotypeDetails(der); d != nil {
+			t.Errorf("findLogotypeDetails(% x) = %+v", der, d)
+		}
+	}
+}
+
+func TestLogotypeHashMatches(t *testing.T) {
+	data := []byte("logo")
+	s256 := sha256.Sum256(data)
+	s1 := sha1.Sum(data)
+	hash := func(oid asn1.ObjectIdentifier, value []byte) logotypeHash {
+		return logotypeHash{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid}, Value: value}
+	}
+	md5 := asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}
+	tests := []struct {
+		name   string
+		hashes []logotypeHash
+		want   bool
+	}{
+		{"sha256", []logotypeHash{hash(oidSHA256, s256[:])}, true},
+		{"sha1", []logotypeHash{hash(oidSHA1, s1[:])}, true},
+		{"mismatch", []logotypeHash{hash(oidSHA256, s1[:])}, false},
+		{"first computable hash decides", []logotypeHash{hash(md5, nil), hash(oidSHA1, s1[:]), hash(oidSHA256, nil)}, true},
+		{"unknown algorithm only", []logotypeHash{hash(md5, nil)}, false},
+		{"no hashes", nil, true},
+	}
+	for _, tt := range tests {
+		if got := logotypeHashMatches(tt.hashes, data); got != tt.want {
+			t.Errorf("%s: logotypeHashMatches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
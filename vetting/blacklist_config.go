@@ -8,6 +8,7 @@ import (
 
 // Critical blacklists - if found on ANY of these, domain is REJECTED
 // No warmup plan will be generated
+// (built-in defaults; the scoring policy file can override these lists)
 var CriticalBlacklists = []string{
 	"spamhaus",    // zen.spamhaus.org
 	"ivmurl",      // ivmuri.invaluement.com
@@ -42,8 +43,13 @@ type BlacklistAnalysis struct {
 	PenaltyDetails []string `json:"penalty_details,omitempty"`
 }
 
-// AnalyzeBlacklists checks all blacklist entries and returns analysis
+// AnalyzeBlacklists checks all blacklist entries against the active scoring policy
 func AnalyzeBlacklists(entries []BlacklistEntry) BlacklistAnalysis {
+	return ActiveScoringPolicy().AnalyzeBlacklists(entries)
+}
+
+// AnalyzeBlacklists checks all blacklist entries and returns analysis
+func (p *ScoringPolicy) AnalyzeBlacklists(entries []BlacklistEntry) BlacklistAnalysis {
 	result := BlacklistAnalysis{
 		IsRejected:     false,
 		TotalPenalty:   0,
@@ -60,7 +66,7 @@ func AnalyzeBlacklists(entries []BlacklistEntry) BlacklistAnalysis {
 		log.Printf("[BlacklistAnalysis] Processing blacklist entry: %s (lowercase: %s)", entry.Source, sourceLower)
 
 		// Check for critical blacklists
-		if isCriticalBlacklist(sourceLower) {
+		if p.isCriticalBlacklist(sourceLower) {
 			log.Printf("[BlacklistAnalysis] ⚠️ CRITICAL blacklist detected: %s", entry.Source)
 			result.IsRejected = true
 			result.CriticalHits = append(result.CriticalHits, entry.Source)
@@ -70,7 +76,7 @@ func AnalyzeBlacklists(entries []BlacklistEntry) BlacklistAnalysis {
 		// Check for UCEProtect levels
 		if strings.Contains(sourceLower, "uceprotect") {
 			level := getUCEProtectLevel(sourceLower)
-			penalty := UCEProtectLevelPenalties[level]
+			penalty := p.UCEProtectLevelPenalties[level]
 			log.Printf("[BlacklistAnalysis] UCEProtect Level %d detected: %s (penalty: -%d)", level, entry.Source, penalty)
 			result.TotalPenalty += penalty
 			result.PenaltyDetails = append(result.PenaltyDetails,
@@ -79,9 +85,9 @@ func AnalyzeBlacklists(entries []BlacklistEntry) BlacklistAnalysis {
 		}
 
 		// Check for other known blacklists
-		penalty := getBlacklistPenalty(sourceLower)
-		if penalty == 10 && !strings.Contains(sourceLower, "uceprotect") {
-			log.Printf("[BlacklistAnalysis] ⚠️ Unknown blacklist (default penalty -10): %s", entry.Source)
+		penalty, known := p.getBlacklistPenalty(sourceLower)
+		if !known {
+			log.Printf("[BlacklistAnalysis] ⚠️ Unknown blacklist (default penalty -%d): %s", penalty, entry.Source)
 		} else {
 			log.Printf("[BlacklistAnalysis] Known blacklist: %s (penalty: -%d)", entry.Source, penalty)
 		}
@@ -99,8 +105,8 @@ func AnalyzeBlacklists(entries []BlacklistEntry) BlacklistAnalysis {
 }
 
 // isCriticalBlacklist checks if the source matches any critical blacklist
-func isCriticalBlacklist(source string) bool {
-	for _, critical := range CriticalBlacklists {
+func (p *ScoringPolicy) isCriticalBlacklist(source string) bool {
+	for _, critical := range p.CriticalBlacklists {
 		if strings.Contains(source, critical) {
 			return true
 		}
@@ -121,24 +127,30 @@ func getUCEProtectLevel(source string) int {
 }
 
 // getBlacklistPenalty returns penalty for a known blacklist
-func getBlacklistPenalty(source string) int {
-	for name, penalty := range BlacklistPenalties {
+// (false = unknown blacklist, default penalty)
+func (p *ScoringPolicy) getBlacklistPenalty(source string) (int, bool) {
+	for name, penalty := range p.BlacklistPenalties {
 		if strings.Contains(source, name) {
-			return penalty
+			return penalty, true
 		}
 	}
 	// Default penalty for unknown blacklists
-	return 10
+	return p.Weights.UnknownBlacklist, false
 }
 
 // CheckMXReputationAllowed returns true if MX reputation allows proceeding
 // If MX reputation is too low, domain should be rejected
 // Note: mxRep = 0 means API didn't return data, so we allow proceeding
 func CheckMXReputationAllowed(mxRep int) bool {
+	return ActiveScoringPolicy().MXReputationAllowed(mxRep)
+}
+
+// MXReputationAllowed is CheckMXReputationAllowed with this policy's minimum
+func (p *ScoringPolicy) MXReputationAllowed(mxRep int) bool {
 	// If MX reputation is 0, API didn't return data - allow proceeding
 	if mxRep == 0 {
 		return true
 	}
-	// If MX reputation is below 40, reject the domain
-	return mxRep >= 40
+	// If MX reputation is below the minimum (default 40), reject the domain
+	return mxRep >= p.Thresholds.MinMXReputation
 }
@@ -0,0 +1,331 @@
+package vetting
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Built-in check names (use these in DependsOn of in-house checks)
+const (
+	CheckIP                 = "ip"
+	CheckParentIP           = "parent_ip"
+	CheckGeo                = "geo"
+	CheckReverseDNS         = "reverse_dns"
+	CheckDNSSEC             = "dnssec"
+	CheckDNSConsensus       = "dns_consensus"
+	CheckDNSHealth          = "dns_health"
+	CheckTLSHandshake       = "tls_handshake"
+	CheckHTTPS              = "https"
+	CheckSSLQualityName     = "ssl_quality"
+	CheckTLSExpiry          = "tls_expiry"
+	CheckEmailSecurity      = "email_security"
+	CheckMXHosts            = "mx_hosts"
+	CheckSPFAuthorization   = "spf_authorization"
+	CheckDKIM               = "dkim"
+	CheckMTASTS             = "mta_sts"
+	CheckBIMI               = "bimi"
+	CheckWhois              = "whois"
+	CheckGoogleSafeBrowsing = "google_safe_browsing"
+	CheckMXToolbox          = "mxtoolbox"
+	CheckAbuseFeeds         = "abuse_feeds"
+	CheckParentAbuseFeeds   = "parent_abuse_feeds"
+	CheckBlacklists         = "blacklists"
+	CheckWebsiteExists      = "website_exists"
+	CheckWebsiteName        = "website"
+	CheckOptIn              = "optin"
+)
+
+// WhoisResult - domain age from WHOIS
+type WhoisResult struct {
+	CheckOutcome
+	AgeDays   int    `json:"age_days"`
+	CreatedOn string `json:"created_on"`
+	UpdatedOn string `json:"updated_on"`
+}
+
+// SafeBrowsingResult - Google Safe Browsing verdict (exact + parent domain)
+type SafeBrowsingResult struct {
+	CheckOutcome
+	Flagged bool   `json:"flagged"`
+	Reason  string `json:"reason"`
+}
+
+// WebsiteExistence - whether the website answered with a 2xx/3xx
+type WebsiteExistence struct {
+	CheckOutcome
+	Exists bool `json:"exists"`
+}
+
+// BlacklistSummary - merged blacklist hits from MXToolbox and RBL feeds
+type BlacklistSummary struct {
+	CheckOutcome
+	Hits     []BlacklistEntry  `json:"hits"`
+	Analysis BlacklistAnalysis `json:"analysis"`
+	MxRep    int               `json:"mx_rep"`
+
+	// Source outcomes: MxRep is only meaningful if MXToolbox is ok/negative
+	MXToolbox CheckOutcome `json:"mxtoolbox"`
+	Feeds     CheckOutcome `json:"feeds"`
+}
+
+func init() {
+	registerBuiltinChecks(DefaultRegistry)
+}
+
+func registerBuiltinChecks(r *Registry) {
+	// BASIC CHECKS - resolved once, shared with the IP RBL checks
+	r.Register(WithTimeout(NewCheck(CheckIP, TargetExact, nil, func(ctx context.Context, in CheckInput) (ResolvedIPs, error) {
+		return ResolveIPs(ctx, in.Domain)
+	}), 5*time.Second))
+	r.Register(WithTimeout(NewCheck(CheckParentIP, TargetParent, nil, func(ctx context.Context, in CheckInput) (ResolvedIPs, error) {
+		if !in.IsSubdomain {
+			return ResolvedIPs{CheckOutcome: skipped("not a subdomain")}, nil
+		}
+		return ResolveIPs(ctx, in.Domain)
+	}), 5*time.Second))
+	// Country and ASN of the resolved address (feature export only, not scored)
+	r.Register(WithTimeout(NewCheck(CheckGeo, TargetExact, []string{CheckIP}, func(ctx context.Context, in CheckInput) (GeoInfo, error) {
+		ips, _ := Result[ResolvedIPs](in.Results, CheckIP)
+		return LookupGeo(ctx, ips.Primary)
+	}), 6*time.Second))
+	// FCrDNS and PTR class of every address of the domain and of our sending IPs
+	r.Register(WithTimeout(NewCheck(CheckReverseDNS, TargetExact, []string{CheckIP}, func(ctx context.Context, in CheckInput) (ReverseDNSResult, error) {
+		ips, _ := Result[ResolvedIPs](in.Results, CheckIP)
+		sendingIPs, _ := SendingIdentity(in.Request)
+		return AnalyzeReverseDNS(ctx, ips.All, sendingIPs), nil
+	}), 15*time.Second))
+
+	// HTTPS/Website checks on PARENT domain for subdomains
+	// One TLS handshake shared by the HTTPS, SSL quality and expiry checks.
+	// A refused connection is a finding (no HTTPS, negative); a timeout is not.
+	r.Register(WithTimeout(NewCheck(CheckTLSHandshake, TargetParent, nil, func(ctx context.Context, in CheckInput) (TLSHandshake, error) {
+		hs, err := DialTLSHandshake(ctx, in.Domain)
+		if err != nil && !hs.Known() {
+			return hs, err
+		}
+		return hs, nil
+	}), 6*time.Second))
+	r.Register(NewCheck(CheckHTTPS, TargetParent, []string{CheckTLSHandshake}, func(ctx context.Context, in CheckInput) (HTTPSResult, error) {
+		hs, err := Dependency[TLSHandshake](in.Results, CheckTLSHandshake)
+		if err != nil {
+			return HTTPSResult{}, err
+		}
+		return hs.HTTPSResult(), nil
+	}))
+	r.Register(NewCheck(CheckSSLQualityName, TargetParent, []string{CheckTLSHandshake}, func(ctx context.Context, in CheckInput) (SSLQuality, error) {
+		hs, err := Dependency[TLSHandshake](in.Results, CheckTLSHandshake)
+		if err != nil {
+			return SSLQuality{}, err
+		}
+		return hs.SSLQuality(), nil
+	}))
+	r.Register(NewCheck(CheckTLSExpiry, TargetParent, []string{CheckTLSHandshake}, func(ctx context.Context, in CheckInput) (TLSExpiry, error) {
+		hs, err := Dependency[TLSHandshake](in.Results, CheckTLSHandshake)
+		if err != nil {
+			return TLSExpiry{}, err
+		}
+		return hs.TLSExpiry(), nil
+	}))
+
+	// Email security on EXACT domain entered (subdomain needs its own MX/SPF/DMARC)
+	r.Register(WithTimeout(NewCheck(CheckEmailSecurity, TargetExact, nil, func(ctx context.Context, in CheckInput) (EmailSecurity, error) {
+		return GetEmailSecurity(ctx, in.Domain), nil
+	}), 20*time.Second))
+
+	// MX hosts resolved, FCrDNS-checked and optionally probed over SMTP
+	r.Register(WithTimeout(NewCheck(CheckMXHosts, TargetExact, []string{CheckEmailSecurity}, func(ctx context.Context, in CheckInput) (MXAnalysis, error) {
+		emailSec, err := Dependency[EmailSecurity](in.Results, CheckEmailSecurity)
+		if err != nil {
+			return MXAnalysis{}, err
+		}
+		return AnalyzeMXHosts(ctx, emailSec.MXHosts, smtpProbeEnabled(in.Request)), nil
+	}), 10*time.Second)) // Starts after email_security (up to 20s) within the 30s budget
+
+	// Does the domain's SPF authorize our sending IPs? (reuses the analyzed SPF tree)
+	r.Register(WithTimeout(NewCheck(CheckSPFAuthorization, TargetExact, []string{CheckEmailSecurity}, func(ctx context.Context, in CheckInput) (SPFAuthorization, error) {
+		ips, include := SendingIdentity(in.Request)
+		emailSec, _ := Result[EmailSecurity](in.Results, CheckEmailSecurity)
+		return EvaluateSPFAuthorization(ctx, in.Domain, emailSec.SPF, ips, include), nil
+	}), 15*time.Second))
+
+	// DKIM keys on common + requested selectors (exact domain: the signing domain)
+	r.Register(WithTimeout(NewCheck(CheckDKIM, TargetExact, nil, func(ctx context.Context, in CheckInput) (DKIMResult, error) {
+		return ProbeDKIM(ctx, in.Domain, dkimSelectors(in.Request)), nil
+	}), 10*time.Second))
+
+	// MTA-STS policy and TLS-RPT (mx patterns are cross-checked against the MX records)
+	r.Register(WithTimeout(NewCheck(CheckMTASTS, TargetExact, []string{CheckEmailSecurity}, func(ctx context.Context, in CheckInput) (MTASTSResult, error) {
+		emailSec, _ := Result[EmailSecurity](in.Results, CheckEmailSecurity)
+		return AnalyzeMTASTS(ctx, in.Domain, emailSec.MXHosts), nil
+	}), 15*time.Second))
+
+	// DNSSEC chain of trust from the root anchor (validated here, not by the resolvers)
+	r.Register(WithTimeout(NewCheck(CheckDNSSEC, TargetExact, nil, func(ctx context.Context, in CheckInput) (DNSSECResult, error) {
+		return AnalyzeDNSSEC(ctx, in.Domain), nil
+	}), 30*time.Second))
+
+	// Do the DNS upstreams agree on the domain's A/MX/TXT records?
+	r.Register(WithTimeout(NewCheck(CheckDNSConsensus, TargetExact, nil, func(ctx context.Context, in CheckInput) (DNSConsensusResult, error) {
+		return AnalyzeDNSConsensus(ctx, in.Domain), nil
+	}), 15*time.Second))
+
+	// Delegation (NS count/ASNs, lame servers, SOA) and zone hygiene (wildcards, dangling CNAMEs)
+	r.Register(WithTimeout(NewCheck(CheckDNSHealth, TargetExact, nil, func(ctx context.Context, in CheckInput) (DNSHealthResult, error) {
+		return AnalyzeDNSHealth(ctx, in.Domain), nil
+	}), 20*time.Second))
+
+	// BIMI record, logo and VMC/CMC (reuses the DMARC analysis: BIMI needs enforcement)
+	r.Register(WithTimeout(NewCheck(CheckBIMI, TargetExact, []string{CheckEmailSecurity}, func(ctx context.Context, in CheckInput) (BIMIResult, error) {
+		emailSec, _ := Result[EmailSecurity](in.Results, CheckEmailSecurity)
+		return AnalyzeBIMI(ctx, in.Domain, emailSec.DMARC), nil
+	}), 20*time.Second))
+
+	// Use parent for WHOIS
+	r.Register(WithTimeout(NewCheck(CheckWhois, TargetParent, nil, func(ctx context.Context, in CheckInput) (WhoisResult, error) {
+		days, created, updated, err := WhoisAgeDays(ctx, in.Domain)
+		if err != nil {
+			return WhoisResult{}, err
+		}
+		return WhoisResult{CheckOutcome: CheckOutcome{Status: StatusOK}, AgeDays: days, CreatedOn: created, UpdatedOn: updated}, nil
+	}), 10*time.Second))
+
+	// Google Safe Browsing - check BOTH domains (in parallel)
+	// Flagged on either domain is negative; otherwise the exact domain's outcome counts
+	r.Register(WithTimeout(NewCheck(CheckGoogleSafeBrowsing, TargetExact, nil, func(ctx context.Context, in CheckInput) (SafeBrowsingResult, error) {
+		var parent SafeBrowsingResult
+
+		var g errgroup.Group
+		if in.IsSubdomain {
+			g.Go(func() error {
+				parent = LookupSafeBrowsing(ctx, in.ParentDomain)
+				return nil
+			})
+		}
+		res := LookupSafeBrowsing(ctx, in.ExactDomain)
+		_ = g.Wait()
+
+		if !res.Flagged && parent.Flagged {
+			res = SafeBrowsingResult{
+				CheckOutcome: parent.CheckOutcome,
+				Flagged:      true,
+				Reason:       "Parent domain flagged: " + parent.Reason,
+			}
+		}
+		return res, nil
+	}), 8*time.Second))
+
+	// MXToolbox - check parent domain for subdomains (blacklists typically list parent domains)
+	r.Register(WithTimeout(NewCheck(CheckMXToolbox, TargetParent, nil, func(ctx context.Context, in CheckInput) (*MXBlacklistResult, error) {
+		if in.IsSubdomain {
+			log.Printf("🔍 MXToolbox: Checking parent domain %s for subdomain %s", in.ParentDomain, in.ExactDomain)
+		}
+		return FetchMXToolboxBlacklist(ctx, in.Domain)
+	}), 8*time.Second))
+
+	// RBL/Abuse - check exact domain (IP RBLs use the already resolved address)
+	r.Register(WithTimeout(NewCheck(CheckAbuseFeeds, TargetExact, []string{CheckIP}, func(ctx context.Context, in CheckInput) (AbuseFeedResult, error) {
+		ips, _ := Result[ResolvedIPs](in.Results, CheckIP)
+		return FetchAdditionalAbuseFeeds(ctx, in.Domain, ips.Primary), nil
+	}), 10*time.Second))
+
+	// If subdomain, also check parent domain for blacklists
+	r.Register(WithTimeout(NewCheck(CheckParentAbuseFeeds, TargetParent, []string{CheckParentIP}, func(ctx context.Context, in CheckInput) (AbuseFeedResult, error) {
+		if !in.IsSubdomain {
+			return AbuseFeedResult{CheckOutcome: skipped("not a subdomain")}, nil
+		}
+		ips, _ := Result[ResolvedIPs](in.Results, CheckParentIP)
+		return FetchAdditionalAbuseFeeds(ctx, in.Domain, ips.Primary), nil
+	}), 10*time.Second))
+
+	// MERGE BLACKLIST RESULTS (both subdomain and parent) and ANALYZE (Critical vs Penalty-based)
+	r.Register(NewCheck(CheckBlacklists, TargetExact,
+		[]string{CheckMXToolbox, CheckAbuseFeeds, CheckParentAbuseFeeds},
+		func(ctx context.Context, in CheckInput) (BlacklistSummary, error) {
+			var combined []BlacklistEntry
+			mxRep := 0
+
+			if mxRes, ok := Result[*MXBlacklistResult](in.Results, CheckMXToolbox); ok && mxRes != nil {
+				combined = append(combined, convertMXToBlacklist(mxRes)...)
+				mxRep = mxRes.MxRep
+			}
+
+			abuse, _ := Result[AbuseFeedResult](in.Results, CheckAbuseFeeds)
+			combined = append(combined, abuse.Hits...)
+
+			// Add parent domain blacklist hits (if subdomain)
+			parentAbuse, _ := Result[AbuseFeedResult](in.Results, CheckParentAbuseFeeds)
+			if in.IsSubdomain && len(parentAbuse.Hits) > 0 {
+				for i := range parentAbuse.Hits {
+					parentAbuse.Hits[i].Info = "Parent domain: " + in.ParentDomain
+				}
+				combined = append(combined, parentAbuse.Hits...)
+			}
+
+			mxOutcome := in.Results.Outcome(CheckMXToolbox)
+			feedsOutcome := combineOutcomes(in.Results.Outcome(CheckAbuseFeeds), in.Results.Outcome(CheckParentAbuseFeeds))
+
+			// Hits are negative; no source answering at all is unknown, not clean
+			outcome := negativeIf(len(combined) > 0)
+			if len(combined) == 0 && !mxOutcome.Known() && !feedsOutcome.Known() {
+				outcome = CheckOutcome{Status: StatusError, Error: "no blacklist source answered"}
+			}
+
+			return BlacklistSummary{
+				CheckOutcome: outcome,
+				Hits:         combined,
+				Analysis:     AnalyzeBlacklists(combined),
+				MxRep:        mxRep,
+				MXToolbox:    mxOutcome,
+				Feeds:        feedsOutcome,
+			}, nil
+		}))
+
+	// Website existence is probed once (used for both existence and traffic score)
+	r.Register(WithTimeout(NewCheck(CheckWebsiteExists, TargetParent, nil, func(ctx context.Context, in CheckInput) (WebsiteExistence, error) {
+		exists, err := CheckWebsiteExistence(ctx, in.Domain)
+		if err != nil {
+			return WebsiteExistence{}, err
+		}
+		return WebsiteExistence{CheckOutcome: negativeIf(!exists), Exists: exists}, nil
+	}), 12*time.Second))
+
+	// WEBSITE CHECKS on parent domain for subdomains
+	r.Register(NewCheck(CheckWebsiteName, TargetParent,
+		[]string{CheckWebsiteExists, CheckWhois, CheckHTTPS, CheckSSLQualityName, CheckBlacklists, CheckGoogleSafeBrowsing, CheckEmailSecurity},
+		func(ctx context.Context, in CheckInput) (WebsiteCheck, error) {
+			// A working HTTPS site proves existence even if the probe timed out
+			https, _ := Result[HTTPSResult](in.Results, CheckHTTPS)
+			probe, err := Dependency[WebsiteExistence](in.Results, CheckWebsiteExists)
+			if err != nil && !https.OK {
+				return WebsiteCheck{}, err
+			}
+			whois, _ := Result[WhoisResult](in.Results, CheckWhois)
+			ssl, _ := Result[SSLQuality](in.Results, CheckSSLQualityName)
+			blacklists, _ := Result[BlacklistSummary](in.Results, CheckBlacklists)
+			google, _ := Result[SafeBrowsingResult](in.Results, CheckGoogleSafeBrowsing)
+			emailSec, _ := Result[EmailSecurity](in.Results, CheckEmailSecurity)
+
+			return CheckWebsite(
+				probe.Exists,
+				whois.AgeDays,
+				https.OK,
+				ssl,
+				len(blacklists.Hits),
+				blacklists.MxRep,
+				google.Flagged,
+				emailSec,
+			), nil
+		}))
+
+	// OPT-IN CHECKS - Real-time CAPTCHA detection (on parent domain for subdomains)
+	r.Register(WithTimeout(NewCheck(CheckOptIn, TargetParent, nil, func(ctx context.Context, in CheckInput) (OptInCheck, error) {
+		var selfAttested *SelfAttestedOptIn
+		if in.Request != nil {
+			selfAttested = in.Request.SelfAttested
+		}
+		return EvaluateOptIn(ctx, selfAttested, in.Domain), nil
+	}), 20*time.Second))
+}
@@ -0,0 +1,553 @@
+package vetting
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// WEIGHT CALIBRATION
+//
+// Fits the penalty weights and risk thresholds of a scoring policy to the
+// labeled dataset (vetting results with recorded warmup outcomes, see
+// outcomes.go), replacing hand-tuned constants:
+//
+//  1. Each outcome is labeled bad or good (OutcomeCriteria).
+//  2. A logistic regression of bad on the built-in penalty rules that fired
+//     (plus the blacklist penalty as a covariate) gives each rule's effect on
+//     the log-odds of a bad outcome. Effects become penalty points with the
+//     scorecard convention: PointsToDoubleOdds points double the odds of a
+//     bad outcome. Rules that do not increase the risk get 0.
+//  3. The stored inputs are rescored with the new weights and an isotonic
+//     (monotone) fit of bad rate vs score places the thresholds: high-risk
+//     where the bad rate reaches HighRiskBadRate, medium at MediumBadRate.
+//
+// The result is a candidate policy (load it as SCORING_CANDIDATE_POLICY_FILE
+// to compare it in shadow mode first) and a report evaluating the current
+// and the candidate policy on the same examples (AUC, confusion matrices).
+//
+
+// OutcomeCriteria decides when a warmup outcome counts as bad
+type OutcomeCriteria struct {
+	MaxBounceRate     float64 `json:"max_bounce_rate"`     // Default: 0.05 (above = bad)
+	MaxComplaintRate  float64 `json:"max_complaint_rate"`  // Default: 0.003 (above = bad)
+	MinInboxPlacement float64 `json:"min_inbox_placement"` // Default: 0.70 (below = bad)
+	// Being blocked by any mailbox provider is always bad
+}
+
+// DefaultOutcomeCriteria returns the default bad-outcome criteria
+func DefaultOutcomeCriteria() OutcomeCriteria {
+	return OutcomeCriteria{
+		MaxBounceRate:     0.05,
+		MaxComplaintRate:  0.003,
+		MinInboxPlacement: 0.70,
+	}
+}
+
+// IsBad labels an outcome
+func (c OutcomeCriteria) IsBad(o WarmupOutcome) bool {
+	switch {
+	case len(o.BlockedBy) > 0:
+		return true
+	case o.BounceRate != nil && *o.BounceRate > c.MaxBounceRate:
+		return true
+	case o.ComplaintRate != nil && *o.ComplaintRate > c.MaxComplaintRate:
+		return true
+	case o.InboxPlacement != nil && *o.InboxPlacement < c.MinInboxPlacement:
+		return true
+	}
+	return false
+}
+
+// CalibrationOptions configures a calibration run
+type CalibrationOptions struct {
+	Criteria           OutcomeCriteria `json:"criteria"`
+	MinExamples        int             `json:"min_examples"`          // Default: 30
+	PointsToDoubleOdds float64         `json:"points_to_double_odds"` // Default: 20
+	L2                 float64         `json:"l2"`                    // Ridge penalty on the coefficients. Default: 1
+	HighRiskBadRate    float64         `json:"high_risk_bad_rate"`    // Default: 0.5
+	MediumBadRate      float64         `json:"medium_bad_rate"`       // Default: 0.2
+	Version            string          `json:"version"`               // Candidate version. Default: <base>-calibrated-<date>
+}
+
+// DefaultCalibrationOptions returns the default calibration options
+func DefaultCalibrationOptions() CalibrationOptions {
+	return CalibrationOptions{
+		Criteria:           DefaultOutcomeCriteria(),
+		MinExamples:        30,
+		PointsToDoubleOdds: 20,
+		L2:                 1,
+		HighRiskBadRate:    0.5,
+		MediumBadRate:      0.2,
+	}
+}
+
+// calibratedWeight is a ScoringWeights field fitted from its penalty rule
+type calibratedWeight struct {
+	rule  string
+	field func(w *ScoringWeights) *int
+}
+
+// calibratedWeights are the weights calibration fits (in regression order)
+var calibratedWeights = []calibratedWeight{
+	{RuleDomainTooNew, func(w *ScoringWeights) *int { return &w.DomainTooNew }},
+	{RuleNoMXRecord, func(w *ScoringWeights) *int { return &w.NoMXRecord }},
+	{RuleNoDMARC, func(w *ScoringWeights) *int { return &w.NoDMARC }},
+	{RuleDMARCPolicyNone, func(w *ScoringWeights) *int { return &w.DMARCPolicyNone }},
+	{RuleNoDKIM, func(w *ScoringWeights) *int { return &w.NoDKIM }},
+	{RuleWeakDKIMKey, func(w *ScoringWeights) *int { return &w.WeakDKIMKey }},
+	{RuleSingleNameServer, func(w *ScoringWeights) *int { return &w.SingleNameServer }},
+	{RuleLameDelegation, func(w *ScoringWeights) *int { return &w.LameDelegation }},
+	{RuleApexCNAME, func(w *ScoringWeights) *int { return &w.ApexCNAME }},
+	{RuleWildcardMX, func(w *ScoringWeights) *int { return &w.WildcardMX }},
+	{RuleDanglingCNAME, func(w *ScoringWeights) *int { return &w.DanglingCNAME }},
+}
+
+// blacklistPenaltyScale scales the blacklist.penalty covariate (points -> tens of points)
+const blacklistPenaltyScale = 10.0
+
+// CalibrationReport describes a calibration run
+type CalibrationReport struct {
+	BaseVersion      string             `json:"base_version"`
+	CandidateVersion string             `json:"candidate_version"`
+	GeneratedAt      string             `json:"generated_at"`
+	Options          CalibrationOptions `json:"options"`
+
+	Examples    int `json:"examples"`
+	BadOutcomes int `json:"bad_outcomes"`
+	Skipped     int `json:"skipped"` // Stored results without scoring inputs (recorded before calibration support)
+
+	Intercept    float64              `json:"intercept"`
+	Coefficients []CalibrationCoef    `json:"coefficients"`
+	Thresholds   ThresholdCalibration `json:"thresholds"`
+	Current      PolicyEvaluation     `json:"current"`
+	Candidate    PolicyEvaluation     `json:"candidate"`
+	Warnings     []string             `json:"warnings,omitempty"`
+}
+
+// CalibrationCoef is one fitted regression coefficient
+type CalibrationCoef struct {
+	Feature       string  `json:"feature"`
+	Fired         int     `json:"fired"` // Examples where the rule fired
+	Coefficient   float64 `json:"coefficient"`
+	OddsRatio     float64 `json:"odds_ratio"`
+	CurrentWeight int     `json:"current_weight,omitempty"`
+	NewWeight     int     `json:"new_weight,omitempty"`
+}
+
+// ThresholdCalibration is the isotonic bad-rate curve and the thresholds read from it
+type ThresholdCalibration struct {
+	Curve       []BadRatePoint `json:"curve"` // By score, bad rate non-increasing
+	HighRiskMax int            `json:"high_risk_max"`
+	MediumMax   int            `json:"medium_max"`
+}
+
+// BadRatePoint is the calibrated bad rate of a score range
+type BadRatePoint struct {
+	MinScore int     `json:"min_score"`
+	MaxScore int     `json:"max_score"`
+	BadRate  float64 `json:"bad_rate"`
+	Examples int     `json:"examples"`
+}
+
+// PolicyEvaluation is how well a policy's scores separate bad outcomes
+type PolicyEvaluation struct {
+	Version     string          `json:"version"`
+	HighRiskMax int             `json:"high_risk_max"`
+	MediumMax   int             `json:"medium_max"`
+	AUC         float64         `json:"auc"`          // P(bad domain scores lower than good domain)
+	HighRisk    ConfusionMatrix `json:"at_high_risk"` // Predicted bad = rejected or high-risk
+	MediumOrBad ConfusionMatrix `json:"at_medium"`    // Predicted bad = anything but good
+}
+
+// ConfusionMatrix counts predictions against outcomes (positive = bad outcome)
+type ConfusionMatrix struct {
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	TrueNegatives  int     `json:"true_negatives"`
+	FalseNegatives int     `json:"false_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	Accuracy       float64 `json:"accuracy"`
+}
+
+// Calibrate fits weights and thresholds of base to the labeled examples.
+// Only policies using the built-in rules can be calibrated (custom rules
+// carry their own penalties).
+func Calibrate(base *ScoringPolicy, examples []LabeledExample, opts CalibrationOptions) (*ScoringPolicy, CalibrationReport, error) {
+	report := CalibrationReport{
+		BaseVersion: base.Version,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Options:     opts,
+	}
+	if len(base.Rules) > 0 {
+		return nil, report, errors.New("policy has custom rules; only the built-in rule weights can be calibrated")
+	}
+
+	// Rule indicators are read with every weight at 1, so a rule whose current
+	// weight is 0 still shows whether it would have fired
+	probe := base.clone()
+	for _, cw := range calibratedWeights {
+		*cw.field(&probe.Weights) = 1
+	}
+	if err := probe.resolve(); err != nil {
+		return nil, report, err
+	}
+
+	var (
+		inputs []ScoreInputs
+		labels []bool
+		rows   [][]float64
+	)
+	for _, ex := range examples {
+		if ex.Inputs == nil || len(ex.Outcomes) == 0 {
+			report.Skipped++
+			continue
+		}
+		bad := opts.Criteria.IsBad(ex.Latest())
+		features := probe.Features(*ex.Inputs)
+		fired := map[string]bool{}
+		for _, r := range EvaluateRules(probe.rules, features.RuleFeatures).Fired {
+			fired[r.ID] = true
+		}
+
+		row := make([]float64, 0, len(calibratedWeights)+1)
+		for _, cw := range calibratedWeights {
+			row = append(row, boolFloat(fired[cw.rule]))
+		}
+		row = append(row, float64(features.Blacklist.TotalPenalty)/blacklistPenaltyScale)
+
+		inputs = append(inputs, *ex.Inputs)
+		labels = append(labels, bad)
+		rows = append(rows, row)
+		if bad {
+			report.BadOutcomes++
+		}
+	}
+	report.Examples = len(rows)
+
+	if report.Examples < opts.MinExamples {
+		return nil, report, fmt.Errorf("not enough labeled examples: %d (need %d)", report.Examples, opts.MinExamples)
+	}
+	if report.BadOutcomes == 0 || report.BadOutcomes == report.Examples {
+		return nil, report, fmt.Errorf("labeled examples are all %s; need both good and bad outcomes", map[bool]string{true: "bad", false: "good"}[report.BadOutcomes > 0])
+	}
+
+	// 1. LOGISTIC REGRESSION -> WEIGHTS
+	intercept, coefs := fitLogistic(rows, labels, opts.L2)
+	report.Intercept = intercept
+
+	candidate := base.clone()
+	candidate.ProfileSpecs = base.ProfileSpecs
+	pointsPerLogit := opts.PointsToDoubleOdds / math.Ln2
+	for i, cw := range calibratedWeights {
+		coef := CalibrationCoef{
+			Feature:       cw.rule,
+			Coefficient:   coefs[i],
+			OddsRatio:     math.Exp(coefs[i]),
+			CurrentWeight: *cw.field(&base.Weights),
+		}
+		for _, row := range rows {
+			coef.Fired += int(row[i])
+		}
+		weight := int(math.Round(coefs[i] * pointsPerLogit))
+		if weight < 0 {
+			weight = 0
+		}
+		if weight > 100 {
+			weight = 100
+		}
+		if coef.Fired == 0 {
+			// No evidence either way
+			weight = coef.CurrentWeight
+		}
+		coef.NewWeight = weight
+		*cw.field(&candidate.Weights) = weight
+
+		switch {
+		case coef.Fired == 0:
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s never fired; current weight kept", cw.rule))
+		case coef.Fired == len(rows):
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s fired on every example; its effect cannot be told apart from the intercept", cw.rule))
+		case coefs[i] < 0:
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s is associated with better outcomes (odds ratio %.2f); weight set to 0", cw.rule, coef.OddsRatio))
+		}
+		report.Coefficients = append(report.Coefficients, coef)
+	}
+	blacklistCoef := CalibrationCoef{
+		Feature:     fmt.Sprintf("blacklist.penalty/%g (covariate, not changed)", blacklistPenaltyScale),
+		Coefficient: coefs[len(calibratedWeights)],
+		OddsRatio:   math.Exp(coefs[len(calibratedWeights)]),
+	}
+	for _, row := range rows {
+		if row[len(calibratedWeights)] > 0 {
+			blacklistCoef.Fired++
+		}
+	}
+	report.Coefficients = append(report.Coefficients, blacklistCoef)
+
+	// 2. ISOTONIC FIT -> THRESHOLDS
+	if err := candidate.resolve(); err != nil {
+		return nil, report, err
+	}
+	candidateScores := make([]int, len(inputs))
+	for i, in := range inputs {
+		candidateScores[i] = candidate.Score(in).Score
+	}
+	curve := isotonicBadRate(candidateScores, labels)
+	report.Thresholds = ThresholdCalibration{
+		Curve:       curve,
+		HighRiskMax: thresholdAt(curve, opts.HighRiskBadRate, base.Thresholds.HighRiskMax),
+		MediumMax:   thresholdAt(curve, opts.MediumBadRate, base.Thresholds.MediumMax),
+	}
+	if report.Thresholds.MediumMax <= report.Thresholds.HighRiskMax {
+		report.Thresholds.MediumMax = report.Thresholds.HighRiskMax + 1
+	}
+	if report.Thresholds.MediumMax > 99 {
+		report.Thresholds.MediumMax = 99
+		report.Thresholds.HighRiskMax = min(report.Thresholds.HighRiskMax, 98)
+	}
+	candidate.Thresholds.HighRiskMax = report.Thresholds.HighRiskMax
+	candidate.Thresholds.MediumMax = report.Thresholds.MediumMax
+
+	candidate.Version = opts.Version
+	if candidate.Version == "" {
+		candidate.Version = fmt.Sprintf("%s-calibrated-%s", base.Version, time.Now().UTC().Format("20060102"))
+	}
+	if err := candidate.Validate(); err != nil {
+		return nil, report, fmt.Errorf("candidate policy: %w", err)
+	}
+	if err := candidate.resolve(); err != nil {
+		return nil, report, fmt.Errorf("candidate policy: %w", err)
+	}
+	report.CandidateVersion = candidate.Version
+
+	// 3. EVALUATION (same examples, current vs candidate)
+	report.Current = evaluatePolicy(base, inputs, labels)
+	report.Candidate = evaluatePolicy(candidate, inputs, labels)
+	return candidate, report, nil
+}
+
+// fitLogistic fits P(bad) = sigmoid(b0 + x·b) by gradient descent with an L2
+// penalty on b (not on the intercept); returns b0 and b
+func fitLogistic(rows [][]float64, labels []bool, l2 float64) (float64, []float64) {
+	const (
+		iterations   = 20000
+		learningRate = 0.5
+		tolerance    = 1e-9
+	)
+	n := float64(len(rows))
+	coefs := make([]float64, len(rows[0]))
+	intercept := 0.0
+
+	grad := make([]float64, len(coefs))
+	for iter := 0; iter < iterations; iter++ {
+		gradIntercept := 0.0
+		for j := range grad {
+			grad[j] = l2 * coefs[j] / n
+		}
+		for i, row := range rows {
+			z := intercept
+			for j, x := range row {
+				z += coefs[j] * x
+			}
+			diff := sigmoid(z) - boolFloat(labels[i])
+			gradIntercept += diff / n
+			for j, x := range row {
+				grad[j] += diff * x / n
+			}
+		}
+
+		step := math.Abs(gradIntercept)
+		intercept -= learningRate * gradIntercept
+		for j := range coefs {
+			coefs[j] -= learningRate * grad[j]
+			step = math.Max(step, math.Abs(grad[j]))
+		}
+		if step < tolerance {
+			break
+		}
+	}
+	return intercept, coefs
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// isotonicBadRate fits a bad rate that does not increase with the score
+// (pool adjacent violators over score-sorted examples)
+func isotonicBadRate(scores []int, labels []bool) []BadRatePoint {
+	type block struct {
+		minScore, maxScore int
+		bad, n             float64
+	}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] < scores[order[b]] })
+
+	var blocks []block
+	for _, i := range order {
+		s := scores[i]
+		if len(blocks) > 0 && blocks[len(blocks)-1].maxScore == s {
+			blocks[len(blocks)-1].bad += boolFloat(labels[i])
+			blocks[len(blocks)-1].n++
+		} else {
+			blocks = append(blocks, block{minScore: s, maxScore: s, bad: boolFloat(labels[i]), n: 1})
+		}
+		// Merge while a higher score has a higher bad rate than the block before it
+		for len(blocks) > 1 {
+			last, prev := blocks[len(blocks)-1], blocks[len(blocks)-2]
+			if last.bad/last.n <= prev.bad/prev.n {
+				break
+			}
+			blocks = blocks[:len(blocks)-2]
+			blocks = append(blocks, block{minScore: prev.minScore, maxScore: last.maxScore, bad: prev.bad + last.bad, n: prev.n + last.n})
+		}
+	}
+
+	curve := make([]BadRatePoint, len(blocks))
+	for i, b := range blocks {
+		curve[i] = BadRatePoint{MinScore: b.minScore, MaxScore: b.maxScore, BadRate: b.bad / b.n, Examples: int(b.n)}
+	}
+	return curve
+}
+
+// thresholdAt returns the highest score whose calibrated bad rate is at least
+// rate (fallback if no score range reaches it)
+func thresholdAt(curve []BadRatePoint, rate float64, fallback int) int {
+	threshold := -1
+	for i, p := range curve {
+		if p.BadRate < rate {
+			break
+		}
+		// Scores between this range and the next one get this range's rate
+		threshold = p.MaxScore
+		if i+1 < len(curve) {
+			threshold = curve[i+1].MinScore - 1
+		}
+	}
+	if threshold < 0 {
+		return fallback
+	}
+	return threshold
+}
+
+// evaluatePolicy scores the inputs with p and compares with the labels
+func evaluatePolicy(p *ScoringPolicy, inputs []ScoreInputs, labels []bool) PolicyEvaluation {
+	eval := PolicyEvaluation{
+		Version:     p.Version,
+		HighRiskMax: p.Thresholds.HighRiskMax,
+		MediumMax:   p.Thresholds.MediumMax,
+	}
+	scores := make([]int, len(inputs))
+	highRisk := make([]bool, len(inputs))
+	notGood := make([]bool, len(inputs))
+	for i, in := range inputs {
+		s := p.Score(in)
+		scores[i] = s.Score
+		highRisk[i] = s.Level == "rejected" || s.Level == "high-risk"
+		notGood[i] = s.Level != "good"
+	}
+	eval.AUC = scoreAUC(scores, labels)
+	eval.HighRisk = confusion(highRisk, labels)
+	eval.MediumOrBad = confusion(notGood, labels)
+	return eval
+}
+
+// scoreAUC is the probability that a random bad example scores lower than a
+// random good one (ties count half) - 0.5 is no better than chance
+func scoreAUC(scores []int, labels []bool) float64 {
+	var pairs, wins float64
+	for i := range scores {
+		if !labels[i] {
+			continue
+		}
+		for j := range scores {
+			if labels[j] {
+				continue
+			}
+			pairs++
+			switch {
+			case scores[i] < scores[j]:
+				wins++
+			case scores[i] == scores[j]:
+				wins += 0.5
+			}
+		}
+	}
+	if pairs == 0 {
+		return 0
+	}
+	return wins / pairs
+}
+
+// confusion counts predicted-bad against actual-bad
+func confusion(predicted, actual []bool) ConfusionMatrix {
+	var m ConfusionMatrix
+	for i := range predicted {
+		switch {
+		case predicted[i] && actual[i]:
+			m.TruePositives++
+		case predicted[i] && !actual[i]:
+			m.FalsePositives++
+		case !predicted[i] && actual[i]:
+			m.FalseNegatives++
+		default:
+			m.TrueNegatives++
+		}
+	}
+	if tp := float64(m.TruePositives); tp > 0 {
+		m.Precision = tp / float64(m.TruePositives+m.FalsePositives)
+		m.Recall = tp / float64(m.TruePositives+m.FalseNegatives)
+	}
+	m.Accuracy = float64(m.TruePositives+m.TrueNegatives) / float64(len(predicted))
+	return m
+}
+
+// String is a human-readable summary of the report
+func (r CalibrationReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Calibration of %s -> %s (%s)\n", r.BaseVersion, r.CandidateVersion, r.GeneratedAt)
+	fmt.Fprintf(&b, "Examples: %d (%d bad, %d skipped without inputs)\n\n", r.Examples, r.BadOutcomes, r.Skipped)
+
+	fmt.Fprintf(&b, "%-50s %6s %8s %8s %8s %8s\n", "feature", "fired", "coef", "odds", "weight", "new")
+	for _, c := range r.Coefficients {
+		fmt.Fprintf(&b, "%-50s %6d %8.3f %8.2f %8d %8d\n", c.Feature, c.Fired, c.Coefficient, c.OddsRatio, c.CurrentWeight, c.NewWeight)
+	}
+	fmt.Fprintf(&b, "\nThresholds: high-risk <= %d, medium <= %d\n", r.Thresholds.HighRiskMax, r.Thresholds.MediumMax)
+	for _, p := range r.Thresholds.Curve {
+		fmt.Fprintf(&b, "  score %3d-%3d: bad rate %.2f (%d)\n", p.MinScore, p.MaxScore, p.BadRate, p.Examples)
+	}
+
+	for _, e := range []PolicyEvaluation{r.Current, r.Candidate} {
+		fmt.Fprintf(&b, "\n%s (high-risk <= %d, medium <= %d): AUC %.3f\n", e.Version, e.HighRiskMax, e.MediumMax, e.AUC)
+		for _, cm := range []struct {
+			name string
+			m    ConfusionMatrix
+		}{{"rejected/high-risk", e.HighRisk}, {"not good", e.MediumOrBad}} {
+			fmt.Fprintf(&b, "  predicted bad = %-18s TP %4d  FP %4d  TN %4d  FN %4d  precision %.2f recall %.2f accuracy %.2f\n",
+				cm.name, cm.m.TruePositives, cm.m.FalsePositives, cm.m.TrueNegatives, cm.m.FalseNegatives,
+				cm.m.Precision, cm.m.Recall, cm.m.Accuracy)
+		}
+	}
+
+	for _, w := range r.Warnings {
+		fmt.Fprintf(&b, "\n⚠️ %s", w)
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package vetting
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// healthyInputs passes every built-in rule
+func healthyInputs() ScoreInputs {
+	ok := CheckOutcome{Status: StatusOK}
+	return ScoreInputs{
+		HTTPS:        HTTPSResult{CheckOutcome: ok, OK: true, DaysLeft: 90},
+		Website:      WebsiteCheck{CheckOutcome: ok, Exists: true},
+		SafeBrowsing: SafeBrowsingResult{CheckOutcome: ok},
+		Whois:        WhoisResult{CheckOutcome: ok, AgeDays: 1000},
+		Blacklists:   BlacklistSummary{CheckOutcome: ok, MXToolbox: ok, Feeds: ok},
+		Email: EmailSecurity{
+			MXCheck: ok, HasValidMX: true,
+			DMARCCheck: ok, HasDMARC: true, DMARC: &DMARCPolicy{Effective: DMARCReject, Pct: 100},
+		},
+		DKIM:      DKIMResult{CheckOutcome: ok, HasValidKey: true},
+		OptIn:     OptInCheck{CheckOutcome: ok, Compliance: true},
+		DNSHealth: DNSHealthResult{NSCount: 2},
+	}
+}
+
+func TestFitLogisticSigns(t *testing.T) {
+	// Feature 0 comes with bad outcomes, feature 1 with good ones, feature 2 with neither
+	var (
+		rows   [][]float64
+		labels []bool
+	)
+	for i := range 40 {
+		bad := i%4 != 0
+		if i >= 20 {
+			bad = i%4 == 0
+		}
+		rows = append(rows, []float64{boolFloat(i < 20), boolFloat(i >= 20), boolFloat(i%2 == 0)})
+		labels = append(labels, bad)
+	}
+	_, coefs := fitLogistic(rows, labels, 1)
+	if coefs[0] <= 0 || coefs[1] >= 0 {
+		t.Errorf("coefficients %v, want positive then negative", coefs)
+	}
+	if math.Abs(coefs[2]) > 0.5 {
+		t.Errorf("uninformative feature coefficient %.3f, want near 0", coefs[2])
+	}
+}
+
+func TestIsotonicBadRate(t *testing.T) {
+	// 20 (good) then 30 (bad) violate the ordering and are pooled
+	curve := isotonicBadRate([]int{30, 10, 50, 20, 40}, []bool{true, true, false, false, false})
+	want := []BadRatePoint{
+		{MinScore: 10, MaxScore: 10, BadRate: 1, Examples: 1},
+		{MinScore: 20, MaxScore: 30, BadRate: 0.5, Examples: 2},
+		{MinScore: 40, MaxScore: 40, BadRate: 0, Examples: 1},
+		{MinScore: 50, MaxScore: 50, BadRate: 0, Examples: 1},
+	}
+	if fmt.Sprint(curve) != fmt.Sprint(want) {
+		t.Errorf("curve = %+v, want %+v", curve, want)
+	}
+
+	var scores []int
+	var labels []bool
+	for i := range 200 {
+		scores = append(scores, (i*37)%101)
+		labels = append(labels, (i*13)%7 < 3)
+	}
+	curve = isotonicBadRate(scores, labels)
+	n := 0
+	for i, p := range curve {
+		n += p.Examples
+		if i > 0 && (p.BadRate > curve[i-1].BadRate || p.MinScore <= curve[i-1].MaxScore) {
+			t.Errorf("curve[%d] = %+v after %+v", i, p, curve[i-1])
+		}
+	}
+	if n != len(scores) {
+		t.Errorf("curve covers %d examples, want %d", n, len(scores))
+	}
+}
+
+func TestThresholdAt(t *testing.T) {
+	curve := []BadRatePoint{
+		{MinScore: 10, MaxScore: 10, BadRate: 1},
+		{MinScore: 20, MaxScore: 30, BadRate: 0.5},
+		{MinScore: 40, MaxScore: 50, BadRate: 0},
+	}
+	tests := []struct {
+		rate float64
+		want int
+	}{
+		{0.9, 19}, // up to the next range's start
+		{0.5, 39},
+		{0, 50}, // the last range ends at its own max
+		{1.1, 77},
+	}
+	for _, tt := range tests {
+		if got := thresholdAt(curve, tt.rate, 77); got != tt.want {
+			t.Errorf("thresholdAt(%g) = %d, want %d", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestScoreAUC(t *testing.T) {
+	tests := []struct {
+		scores []int
+		labels []bool
+		want   float64
+	}{
+		{[]int{10, 20, 80, 90}, []bool{true, true, false, false}, 1},
+		{[]int{10, 20, 80, 90}, []bool{false, false, true, true}, 0},
+		{[]int{10, 20, 30, 40}, []bool{true, false, true, false}, 0.75},
+		{[]int{50, 50}, []bool{true, false}, 0.5},
+		{[]int{50, 60}, []bool{false, false}, 0},
+	}
+	for _, tt := range tests {
+		if got := scoreAUC(tt.scores, tt.labels); got != tt.want {
+			t.Errorf("scoreAUC(%v, %v) = %g, want %g", tt.scores, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	// Missing DMARC goes with bad outcomes; nothing else ever fires
+	var examples []LabeledExample
+	for i := range 40 {
+		in := healthyInputs()
+		bad := i%5 == 0
+		if i < 20 {
+			in.Email.HasDMARC, in.Email.DMARC = false, nil
+			bad = i%5 != 0
+		}
+		outcome := WarmupOutcome{BounceRate: rate(0.01)}
+		if bad {
+			outcome.BounceRate = rate(0.2)
+		}
+		examples = append(examples, LabeledExample{
+			StoredVetting: StoredVetting{Inputs: &in},
+			Outcomes:      []WarmupOutcome{outcome},
+		})
+	}
+	examples = append(examples, LabeledExample{Outcomes: []WarmupOutcome{{BounceRate: rate(0)}}})
+
+	// Thresholds this high fit the curve to 99: the enterprise profile must stay valid
+	base, err := ParseScoringPolicy([]byte(`{"version":"t","thresholds":{"high_risk_max":50,"medium_max":95}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultCalibrationOptions()
+	opts.Version = "t2"
+	candidate, report, err := Calibrate(base, examples, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Examples != 40 || report.BadOutcomes != 20 || report.Skipped != 1 {
+		t.Errorf("examples %d, bad %d, skipped %d", report.Examples, report.BadOutcomes, report.Skipped)
+	}
+	if candidate.Version != "t2" || candidate.Weights.NoDMARC <= 0 {
+		t.Errorf("candidate %s no_dmarc %d, want a positive weight", candidate.Version, candidate.Weights.NoDMARC)
+	}
+	if candidate.Weights.NoDKIM != base.Weights.NoDKIM {
+		t.Errorf("no_dkim never fired but changed %d -> %d", base.Weights.NoDKIM, candidate.Weights.NoDKIM)
+	}
+	th := candidate.Thresholds
+	if th.HighRiskMax >= th.MediumMax || th.MediumMax > 99 {
+		t.Errorf("candidate thresholds %d/%d", th.HighRiskMax, th.MediumMax)
+	}
+	if _, err := candidate.Profile(ProfileEnterprise); err != nil {
+		t.Error(err)
+	}
+	if report.Candidate.AUC <= 0.5 {
+		t.Errorf("candidate AUC %.2f, want better than chance", report.Candidate.AUC)
+	}
+
+	if _, _, err := Calibrate(base, examples[:10], opts); err == nil {
+		t.Error("calibrated on fewer than MinExamples")
+	}
+}
@@ -0,0 +1,518 @@
+package vetting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CheckTarget selects which domain a check runs against
+type CheckTarget int
+
+const (
+	// TargetExact runs the check on the domain exactly as entered
+	// (subdomains need their own MX/SPF/DMARC)
+	TargetExact CheckTarget = iota
+	// TargetParent runs the check on the parent domain for subdomains
+	// (website, WHOIS, TLS). For apex domains this is the domain itself.
+	TargetParent
+)
+
+// CheckInput is everything a check gets to work with
+type CheckInput struct {
+	Domain       string // Domain selected by the check's Target
+	ExactDomain  string // Domain as entered (normalized)
+	ParentDomain string // Parent domain (same as ExactDomain if not a subdomain)
+	IsSubdomain  bool
+	Request      *VetRequest
+	Results      *CheckResults // Results of checks that already ran (dependencies)
+}
+
+// Check is a single vetting step.
+// Built-in checks are registered in builtin_checks.go; in-house checks can be
+// added to DefaultRegistry (or a custom Registry) without touching VetHandler.
+type Check interface {
+	Name() string
+	Target() CheckTarget
+	DependsOn() []string
+	Run(ctx context.Context, in CheckInput) CheckResult
+}
+
+// TimedCheck is implemented by checks with their own deadline.
+// The deadline is capped by the overall vetting budget.
+type TimedCheck interface {
+	Check
+	Timeout() time.Duration
+}
+
+// CheckStatus is the outcome of a check run
+type CheckStatus string
+
+const (
+	StatusOK       CheckStatus = "ok"        // Ran and found the signal present/clean
+	StatusNegative CheckStatus = "negative"  // Ran and found the signal absent/bad (no MX, no HTTPS, flagged)
+	StatusError    CheckStatus = "error"     // Could not get an answer (lookup/API failure)
+	StatusTimedOut CheckStatus = "timed_out" // Ran out of time
+	StatusSkipped  CheckStatus = "skipped"   // Not applicable or not configured (e.g. API key missing)
+)
+
+// Known reports whether the status carries an actual answer (ok or negative)
+func (s CheckStatus) Known() bool {
+	return s == StatusOK || s == StatusNegative
+}
+
+// CheckOutcome is embedded in every check result type so that
+// "check failed" can be told apart from "check negative"
+type CheckOutcome struct {
+	Status CheckStatus `json:"status"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Known reports whether the check produced an actual answer
+func (o CheckOutcome) Known() bool {
+	return o.Status.Known()
+}
+
+// Outcome returns the outcome itself (lets runCheck read it from any result type)
+func (o CheckOutcome) Outcome() CheckOutcome {
+	return o
+}
+
+// outcomeOf derives the outcome from a check error: timeouts vs. failures
+func outcomeOf(err error) CheckOutcome {
+	switch {
+	case err == nil:
+		return CheckOutcome{Status: StatusOK}
+	case isTimeout(err):
+		return CheckOutcome{Status: StatusTimedOut, Error: err.Error()}
+	default:
+		return CheckOutcome{Status: StatusError, Error: err.Error()}
+	}
+}
+
+// negativeIf returns a negative outcome if cond is true, ok otherwise
+func negativeIf(cond bool) CheckOutcome {
+	if cond {
+		return CheckOutcome{Status: StatusNegative}
+	}
+	return CheckOutcome{Status: StatusOK}
+}
+
+// skipped returns a skipped outcome with the reason
+func skipped(reason string) CheckOutcome {
+	return CheckOutcome{Status: StatusSkipped, Error: reason}
+}
+
+// isTimeout reports whether err is a deadline/timeout (context or network)
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// outcomer is implemented by result types embedding CheckOutcome
+type outcomer interface {
+	Outcome() CheckOutcome
+}
+
+// CheckResult holds the outcome of one check
+type CheckResult struct {
+	Name     string
+	Status   CheckStatus
+	Value    any
+	Err      error
+	Duration time.Duration
+}
+
+// CheckReport is the per-check status returned in VetResponse
+type CheckReport struct {
+	Name       string      `json:"name"`
+	Status     CheckStatus `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// CheckResults collects results by check name
+type CheckResults struct {
+	mu      sync.RWMutex
+	results map[string]CheckResult
+	order   []string // Dependency order of the checks being run (for reports)
+}
+
+func newCheckResults(order []string) *CheckResults {
+	return &CheckResults{results: map[string]CheckResult{}, order: order}
+}
+
+func (r *CheckResults) set(res CheckResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[res.Name] = res
+}
+
+// Get returns the raw result of a check (false if it did not run)
+func (r *CheckResults) Get(name string) (CheckResult, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res, ok := r.results[name]
+	return res, ok
+}
+
+// Outcome returns the status of a check (skipped if it did not run)
+func (r *CheckResults) Outcome(name string) CheckOutcome {
+	res, ok := r.Get(name)
+	if !ok {
+		return skipped(name + " did not run")
+	}
+	o := CheckOutcome{Status: res.Status}
+	if res.Err != nil {
+		o.Error = res.Err.Error()
+	}
+	return o
+}
+
+// Reports returns the status of every check that ran (in dependency order)
+func (r *CheckResults) Reports() []CheckReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reports := make([]CheckReport, 0, len(r.order))
+	for _, name := range r.order {
+		res, ok := r.results[name]
+		if !ok {
+			continue
+		}
+		report := CheckReport{
+			Name:       name,
+			Status:     res.Status,
+			DurationMs: res.Duration.Milliseconds(),
+		}
+		if res.Err != nil {
+			report.Error = res.Err.Error()
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Result returns the typed value of a check.
+// Returns false if the check did not run, failed, timed out, was skipped or
+// produced a different type. Negative results are returned (they are answers).
+func Result[T any](results *CheckResults, name string) (T, bool) {
+	v, err := Dependency[T](results, name)
+	return v, err == nil
+}
+
+// Dependency is like Result but explains why the value is missing.
+// The error wraps the dependency's own error, so a check whose dependency
+// timed out is reported as timed_out too.
+func Dependency[T any](results *CheckResults, name string) (T, error) {
+	var zero T
+	res, ok := results.Get(name)
+	if !ok {
+		return zero, fmt.Errorf("%s did not run", name)
+	}
+	if !res.Status.Known() {
+		return zero, fmt.Errorf("%s %s: %w", name, res.Status, res.Err)
+	}
+	v, ok := res.Value.(T)
+	if !ok {
+		return zero, fmt.Errorf("%s returned %T, not %T", name, res.Value, zero)
+	}
+	return v, nil
+}
+
+// typedCheck adapts a plain function into a Check with a typed result
+type typedCheck[T any] struct {
+	name   string
+	target CheckTarget
+	deps   []string
+	run    func(ctx context.Context, in CheckInput) (T, error)
+}
+
+func (c *typedCheck[T]) Name() string        { return c.name }
+func (c *typedCheck[T]) Target() CheckTarget { return c.target }
+func (c *typedCheck[T]) DependsOn() []string { return c.deps }
+
+func (c *typedCheck[T]) Run(ctx context.Context, in CheckInput) CheckResult {
+	v, err := c.run(ctx, in)
+	res := CheckResult{Name: c.name, Value: v, Err: err}
+
+	// Result types embedding CheckOutcome decide their own status
+	if o, ok := any(v).(outcomer); ok && err == nil {
+		outcome := o.Outcome()
+		res.Status = outcome.Status
+		if outcome.Error != "" {
+			res.Err = errors.New(outcome.Error)
+		}
+	}
+	return res
+}
+
+// NewCheck builds a Check from a function returning a typed result.
+// Read the value back with Result[T](results, name).
+func NewCheck[T any](name string, target CheckTarget, deps []string, run func(ctx context.Context, in CheckInput) (T, error)) Check {
+	return &typedCheck[T]{name: name, target: target, deps: deps, run: run}
+}
+
+// timedCheck gives a check its own deadline
+type timedCheck struct {
+	Check
+	timeout time.Duration
+}
+
+func (c *timedCheck) Timeout() time.Duration { return c.timeout }
+
+// WithTimeout wraps a check so it gets its own deadline
+func WithTimeout(c Check, timeout time.Duration) Check {
+	return &timedCheck{Check: c, timeout: timeout}
+}
+
+// Registry holds the checks VetHandler runs
+type Registry struct {
+	mu       sync.RWMutex
+	checks   map[string]Check
+	order    []string // Registration order (used as tie-breaker when sorting)
+	disabled map[string]bool
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		checks:   map[string]Check{},
+		disabled: map[string]bool{},
+	}
+}
+
+// DefaultRegistry is the registry used by VetHandler
+var DefaultRegistry = NewRegistry()
+
+// Register adds a check. Panics if a check with the same name exists.
+func (r *Registry) Register(c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := c.Name()
+	if _, exists := r.checks[name]; exists {
+		panic("vetting: check registered twice: " + name)
+	}
+	r.checks[name] = c
+	r.order = append(r.order, name)
+}
+
+// Disable turns a check off (its dependents still run, without its result)
+func (r *Registry) Disable(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disabled[name] = true
+}
+
+// Enable turns a previously disabled check back on
+func (r *Registry) Enable(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.disabled, name)
+}
+
+// IsEnabled reports whether a check is registered and enabled
+func (r *Registry) IsEnabled(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.checks[name]
+	return ok && !r.disabled[name]
+}
+
+// Names returns all registered check names (in registration order)
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+// Enabled returns the enabled checks sorted so that dependencies come first.
+// Dependencies on unknown or disabled checks are ignored.
+func (r *Registry) Enabled() ([]Check, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	position := map[string]int{}
+	for i, name := range r.order {
+		position[name] = i
+	}
+
+	var sorted []Check
+	state := map[string]int{} // 0 = unvisited, 1 = visiting, 2 = done
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+
+		deps := append([]string(nil), r.checks[name].DependsOn()...)
+		sort.Slice(deps, func(i, j int) bool { return position[deps[i]] < position[deps[j]] })
+		for _, dep := range deps {
+			if _, ok := r.checks[dep]; !ok || r.disabled[dep] {
+				continue
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		state[name] = 2
+		sorted = append(sorted, r.checks[name])
+		return nil
+	}
+
+	for _, name := range r.order {
+		if r.disabled[name] {
+			continue
+		}
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// Run executes all enabled checks concurrently: each check starts as soon as
+// the checks it depends on have finished, independent checks run in parallel.
+// Checks still running when ctx (or their own deadline) expires are reported as timed_out.
+func (r *Registry) Run(ctx context.Context, domain string, req *VetRequest) (*CheckResults, error) {
+	checks, err := r.Enabled()
+	if err != nil {
+		return nil, err
+	}
+
+	isSubdom, parentDomain := isSubdomain(domain)
+
+	names := make([]string, 0, len(checks))
+	done := make(map[string]chan struct{}, len(checks))
+	for _, c := range checks {
+		names = append(names, c.Name())
+		done[c.Name()] = make(chan struct{})
+	}
+	results := newCheckResults(names)
+
+	var g errgroup.Group
+	for _, c := range checks {
+		g.Go(func() error {
+			defer close(done[c.Name()])
+
+			// Wait for dependencies (disabled/unknown ones have no channel)
+			for _, dep := range c.DependsOn() {
+				if ch, ok := done[dep]; ok {
+					<-ch
+				}
+			}
+
+			in := CheckInput{
+				Domain:       domain,
+				ExactDomain:  domain,
+				ParentDomain: parentDomain,
+				IsSubdomain:  isSubdom,
+				Request:      req,
+				Results:      results,
+			}
+			if c.Target() == TargetParent {
+				in.Domain = parentDomain
+			}
+
+			results.set(runCheck(ctx, c, in))
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return results, nil
+}
+
+// runCheck runs a single check under its deadline and records timing/status
+func runCheck(ctx context.Context, c Check, in CheckInput) CheckResult {
+	if err := ctx.Err(); err != nil {
+		// Budget already spent - don't start the check
+		log.Printf("[Checks] %s not started for %s: %v", c.Name(), in.Domain, err)
+		return CheckResult{Name: c.Name(), Status: StatusTimedOut, Err: err}
+	}
+
+	checkCtx := ctx
+	if tc, ok := c.(TimedCheck); ok && tc.Timeout() > 0 {
+		var cancel context.CancelFunc
+		checkCtx, cancel = context.WithTimeout(ctx, tc.Timeout())
+		defer cancel()
+	}
+
+	start := time.Now()
+	res := c.Run(checkCtx, in)
+	res.Name = c.Name()
+	res.Duration = time.Since(start)
+	if res.Status == "" {
+		res.Status = outcomeOf(res.Err).Status
+	}
+
+	// Ran out of time: don't pass off whatever zero values came back as a result
+	if err := checkCtx.Err(); err != nil {
+		res.Status = StatusTimedOut
+		if res.Err == nil || !errors.Is(res.Err, err) {
+			res.Err = err
+		}
+	} else if res.Status == StatusError && isTimeout(res.Err) {
+		// A dependency (or an inner lookup) timed out
+		res.Status = StatusTimedOut
+	}
+
+	switch res.Status {
+	case StatusTimedOut:
+		log.Printf("[Checks] %s timed out for %s after %v", c.Name(), in.Domain, res.Duration)
+	case StatusError:
+		log.Printf("[Checks] %s failed for %s: %v", c.Name(), in.Domain, res.Err)
+	}
+	return res
+}
+
+// defaultVettingBudget is the overall time allowed for one /vet request
+const defaultVettingBudget = 30 * time.Second
+
+// VettingBudget returns the overall vetting budget (VETTING_BUDGET, e.g. "20s")
+func VettingBudget() time.Duration {
+	if v := os.Getenv("VETTING_BUDGET"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+		log.Printf("[Checks] Invalid VETTING_BUDGET %q, using %v", v, defaultVettingBudget)
+	}
+	return defaultVettingBudget
+}
+
+// ConfigureChecksFromEnv disables checks listed in VETTING_DISABLED_CHECKS
+// (comma-separated check names, e.g. "mxtoolbox,optin")
+func ConfigureChecksFromEnv() {
+	for _, name := range strings.Split(os.Getenv("VETTING_DISABLED_CHECKS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !DefaultRegistry.IsEnabled(name) {
+			log.Printf("[Checks] VETTING_DISABLED_CHECKS: unknown check %q", name)
+			continue
+		}
+		DefaultRegistry.Disable(name)
+		log.Printf("[Checks] Check disabled: %s", name)
+	}
+}
@@ -2,68 +2,97 @@ package vetting
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"strings"
 	"time"
 
 	whois "github.com/likexian/whois"
 	parser "github.com/likexian/whois-parser"
+	"golang.org/x/sync/errgroup"
 )
 
 //
 // BASIC CHECKS
 //
 
-func LookupIP(domain string) string {
+// ResolvedIPs - addresses a domain resolves to (resolved once, shared by the IP RBL checks)
+type ResolvedIPs struct {
+	CheckOutcome
+	Primary string   `json:"primary"` // First IPv4 address (or first address if no IPv4)
+	All     []string `json:"all"`
+}
+
+// ResolveIPs looks up all addresses of a domain.
+// A domain without addresses is a negative result, not an error.
+func ResolveIPs(ctx context.Context, domain string) (ResolvedIPs, error) {
+	var res ResolvedIPs
+
 	host := domain
 	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
 		host = strings.SplitN(host, "//", 2)[1]
 	}
 	host = strings.Split(host, "/")[0]
 
-	ips, err := net.LookupIP(host)
-	if err != nil || len(ips) == 0 {
-		return ""
+	addrs, err := defaultDNS().LookupIP(ctx, "ip", host)
+	if err != nil {
+		if isDNSNotFound(err) {
+			res.CheckOutcome = negativeIf(true)
+			return res, nil
+		}
+		res.CheckOutcome = outcomeOf(err)
+		return res, err
 	}
-	for _, ip := range ips {
-		if ip.To4() != nil {
-			return ip.String()
+	for _, addr := range addrs {
+		res.All = append(res.All, addr.String())
+		if res.Primary == "" && addr.To4() != nil {
+			res.Primary = addr.String()
 		}
 	}
-	return ips[0].String()
+	if res.Primary == "" && len(res.All) > 0 {
+		res.Primary = res.All[0]
+	}
+	res.CheckOutcome = negativeIf(len(res.All) == 0)
+	return res, nil
 }
 
-func ProbeHTTPS(domain string) (bool, int) {
-	d := &net.Dialer{Timeout: 5 * time.Second}
-	conn, err := tls.DialWithDialer(d, "tcp", domain+":443", &tls.Config{ServerName: domain})
-	if err != nil {
-		return false, 0
-	}
-	defer conn.Close()
+func LookupIP(ctx context.Context, domain string) string {
+	ips, _ := ResolveIPs(ctx, domain)
+	return ips.Primary
+}
 
-	certs := conn.ConnectionState().PeerCertificates
-	if len(certs) == 0 {
+// isDNSNotFound reports whether err means the name/record doesn't exist
+// (as opposed to a timeout or server failure)
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func ProbeHTTPS(ctx context.Context, domain string) (bool, int) {
+	hs, err := DialTLSHandshake(ctx, domain)
+	if err != nil {
 		return false, 0
 	}
-
-	days := int(time.Until(certs[0].NotAfter).Hours() / 24)
-	return true, days
+	return hs.HTTPS()
 }
 
 //
 // WHOIS LOOKUP
 //
 
-func WhoisAgeDays(domain string) (int, string, string) {
-	raw, err := whois.Whois(domain)
+// WhoisAgeDays returns the domain age in days, created and updated dates.
+// An error means the age is unknown (lookup failed or no creation date) -
+// it must not be read as a zero-day-old domain.
+func WhoisAgeDays(ctx context.Context, domain string) (int, string, string, error) {
+	raw, err := whoisQuery(ctx, domain)
 	if err != nil {
-		return 0, "", ""
+		return 0, "", "", fmt.Errorf("whois lookup failed: %w", err)
 	}
 
 	p, err := parser.Parse(raw)
@@ -72,9 +101,9 @@ func WhoisAgeDays(domain string) (int, string, string) {
 		parts := strings.Split(domain, ".")
 		if len(parts) > 2 {
 			parentDomain := strings.Join(parts[1:], ".")
-			return WhoisAgeDays(parentDomain)
+			return WhoisAgeDays(ctx, parentDomain)
 		}
-		return 0, "", ""
+		return 0, "", "", fmt.Errorf("whois response could not be parsed: %v", err)
 	}
 
 	createdStr := strings.TrimSpace(p.Domain.CreatedDate)
@@ -107,11 +136,46 @@ func WhoisAgeDays(domain string) (int, string, string) {
 	}
 
 	if created.IsZero() {
-		return 0, "", ""
+		return 0, "", "", fmt.Errorf("whois creation date not found or not parseable: %q", createdStr)
 	}
 
 	ageDays := int(time.Since(created).Hours() / 24)
-	return ageDays, created.Format("02/01/2006"), updated.Format("02/01/2006")
+	return ageDays, created.Format("02/01/2006"), updated.Format("02/01/2006"), nil
+}
+
+// whoisQuery runs a WHOIS lookup bounded by ctx
+// (the whois client has no context support, so ctx is applied to the connection)
+func whoisQuery(ctx context.Context, domain string) (string, error) {
+	timeout := 10 * time.Second
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	client := whois.NewClient().SetDialer(contextDialer{ctx: ctx}).SetTimeout(timeout)
+	return client.Whois(domain)
+}
+
+// contextDialer dials with ctx and closes the connection when ctx is done
+type contextDialer struct {
+	ctx context.Context
+}
+
+func (d contextDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: 5 * time.Second}).DialContext(d.ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(d.ctx, func() { conn.Close() })
+	return &contextConn{Conn: conn, stop: stop}, nil
+}
+
+type contextConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *contextConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
 }
 
 //
@@ -126,10 +190,18 @@ type BlacklistEntry struct {
 }
 
 type MXBlacklistResult struct {
+	CheckOutcome
 	MxRep int              `json:"mx_rep"`
 	Lists []BlacklistEntry `json:"lists"`
 }
 
+// AbuseFeedResult - RBL hits plus the RBL queries that could not be answered
+type AbuseFeedResult struct {
+	CheckOutcome
+	Hits   []BlacklistEntry `json:"hits"`
+	Failed []string         `json:"failed,omitempty"` // RBL zones that errored/timed out
+}
+
 var domainRBLs = []string{
 	// CRITICAL - Auto Reject
 	"multi.surbl.org",        // SURBL
@@ -141,50 +213,14 @@ var domainRBLs = []string{
 	"ubl.unsubscore.com",
 }
 
-func checkDomainRBL(domain string) []BlacklistEntry {
-	var results []BlacklistEntry
-
+func checkDomainRBL(ctx context.Context, domain string) ([]BlacklistEntry, []string) {
 	log.Printf("[RBL] Checking domain %s against %d domain RBLs", domain, len(domainRBLs))
 
-	for _, rbl := range domainRBLs {
-		query := domain + "." + rbl
-
-		// Use custom resolver with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		resolver := &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{Timeout: 2 * time.Second}
-				return d.DialContext(ctx, "udp", "8.8.8.8:53")
-			},
-		}
-
-		addrs, err := resolver.LookupHost(ctx, query)
-		cancel()
-
-		if err == nil && len(addrs) > 0 {
-			// Verify it's a valid RBL response (should be 127.0.0.x)
-			isValidRBLResponse := false
-			for _, addr := range addrs {
-				if strings.HasPrefix(addr, "127.0.0.") {
-					isValidRBLResponse = true
-					break
-				}
-			}
-
-			if isValidRBLResponse {
-				log.Printf("[RBL] ⚠️ Domain LISTED on %s: %s (response: %v)", rbl, query, addrs)
-				results = append(results, BlacklistEntry{
-					Source: rbl,
-					Listed: true,
-				})
-			} else {
-				log.Printf("[RBL] Ignoring non-standard response from %s: %v", rbl, addrs)
-			}
-		}
+	queries := make([]string, len(domainRBLs))
+	for i, rbl := range domainRBLs {
+		queries[i] = domain + "." + rbl
 	}
-
-	return results
+	return queryRBLs(ctx, domainRBLs, queries)
 }
 
 var ipRBLs = []string{
@@ -221,85 +257,146 @@ func reverseIP(ip string) string {
 	return parts[3] + "." + parts[2] + "." + parts[1] + "." + parts[0]
 }
 
-func checkIPRBL(domain string) []BlacklistEntry {
-	ip := LookupIP(domain)
+func checkIPRBL(ctx context.Context, domain string, ip string) ([]BlacklistEntry, []string) {
 	if ip == "" {
 		log.Printf("[RBL] Could not resolve IP for domain: %s", domain)
-		return nil
+		return nil, nil
 	}
 
 	rev := reverseIP(ip)
 	if rev == "" {
 		log.Printf("[RBL] Could not reverse IP: %s for domain: %s", ip, domain)
-		return nil
+		return nil, nil
 	}
 
 	log.Printf("[RBL] Checking IP %s (reversed: %s) for domain: %s", ip, rev, domain)
 
-	var results []BlacklistEntry
+	queries := make([]string, len(ipRBLs))
+	for i, rbl := range ipRBLs {
+		queries[i] = rev + "." + rbl
+	}
+	return queryRBLs(ctx, ipRBLs, queries)
+}
+
+// queryRBLs looks up all RBL queries in parallel and returns the listings
+// and the RBLs that could not be queried (in the order of rbls)
+func queryRBLs(ctx context.Context, rbls []string, queries []string) ([]BlacklistEntry, []string) {
+	listed := make([]bool, len(rbls))
+	errs := make([]error, len(rbls))
+
+	var g errgroup.Group
+	for i, query := range queries {
+		g.Go(func() error {
+			listed[i], errs[i] = lookupRBL(ctx, rbls[i], query)
+			return nil
+		})
+	}
+	_ = g.Wait()
 
-	for _, rbl := range ipRBLs {
-		query := rev + "." + rbl
-
-		// Use custom resolver with timeout to avoid cloud DNS issues
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		resolver := &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{Timeout: 2 * time.Second}
-				// Use Google's DNS for more reliable results
-				return d.DialContext(ctx, "udp", "8.8.8.8:53")
-			},
+	var results []BlacklistEntry
+	var failed []string
+	for i, rbl := range rbls {
+		if listed[i] {
+			results = append(results, BlacklistEntry{
+				Source: rbl,
+				Listed: true,
+			})
+		}
+		if errs[i] != nil {
+			failed = append(failed, rbl)
 		}
+	}
+	return results, failed
+}
 
-		addrs, err := resolver.LookupHost(ctx, query)
-		cancel()
-
-		if err == nil && len(addrs) > 0 {
-			// Verify it's a valid RBL response (should be 127.0.0.x)
-			// False positives can occur if DNS returns unexpected results
-			isValidRBLResponse := false
-			for _, addr := range addrs {
-				if strings.HasPrefix(addr, "127.0.0.") {
-					isValidRBLResponse = true
-					break
-				}
-			}
-
-			if isValidRBLResponse {
-				log.Printf("[RBL] ⚠️ LISTED on %s: %s (response: %v)", rbl, query, addrs)
-				results = append(results, BlacklistEntry{
-					Source: rbl,
-					Listed: true,
-				})
-			} else {
-				log.Printf("[RBL] Ignoring non-standard RBL response from %s: %v", rbl, addrs)
-			}
-		} else if err != nil {
-			// Not listed (DNS lookup failed = not on blacklist)
-			// This is normal and expected for clean IPs
+// lookupRBL checks a single RBL query.
+// NXDOMAIN means "not listed"; any other lookup failure is returned as an error
+// (unknown, not clean).
+func lookupRBL(ctx context.Context, rbl string, query string) (bool, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	addrs, err := defaultDNS().LookupIP(lookupCtx, "ip4", query)
+	if err != nil && !isDNSNotFound(err) {
+		log.Printf("[RBL] Lookup failed on %s: %v", rbl, err)
+		return false, err
+	}
+	if len(addrs) == 0 {
+		// Not listed (NXDOMAIN = not on blacklist)
+		// This is normal and expected for clean IPs/domains
+		return false, nil
+	}
+
+	// Verify it's a valid RBL response (should be 127.0.0.x)
+	// False positives can occur if DNS returns unexpected results
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr.String(), "127.0.0.") {
+			log.Printf("[RBL] ⚠️ LISTED on %s: %s (response: %v)", rbl, query, addrs)
+			return true, nil
 		}
 	}
 
-	return results
+	log.Printf("[RBL] Ignoring non-standard RBL response from %s: %v", rbl, addrs)
+	return false, nil
 }
 
-func FetchAdditionalAbuseFeeds(domain string) []BlacklistEntry {
-	var combined []BlacklistEntry
-	combined = append(combined, checkDomainRBL(domain)...)
-	combined = append(combined, checkIPRBL(domain)...)
-	return combined
+// FetchAdditionalAbuseFeeds checks the domain RBLs and the IP RBLs for ip
+// (the domain's resolved address) in parallel
+func FetchAdditionalAbuseFeeds(ctx context.Context, domain string, ip string) AbuseFeedResult {
+	var domainHits, ipHits []BlacklistEntry
+	var domainFailed, ipFailed []string
+
+	var g errgroup.Group
+	g.Go(func() error {
+		domainHits, domainFailed = checkDomainRBL(ctx, domain)
+		return nil
+	})
+	g.Go(func() error {
+		ipHits, ipFailed = checkIPRBL(ctx, domain, ip)
+		return nil
+	})
+	_ = g.Wait()
+
+	res := AbuseFeedResult{}
+	res.Hits = append(res.Hits, domainHits...)
+	res.Hits = append(res.Hits, ipHits...)
+	res.Failed = append(res.Failed, domainFailed...)
+	res.Failed = append(res.Failed, ipFailed...)
+
+	queried := len(domainRBLs)
+	if ip != "" {
+		queried += len(ipRBLs)
+	}
+
+	switch {
+	case len(res.Hits) > 0:
+		res.CheckOutcome = negativeIf(true)
+	case len(res.Failed) == queried && ctx.Err() != nil:
+		res.CheckOutcome = outcomeOf(ctx.Err())
+	case len(res.Failed) == queried:
+		res.CheckOutcome = CheckOutcome{Status: StatusError, Error: "all RBL lookups failed"}
+	default:
+		res.CheckOutcome = negativeIf(false)
+	}
+	if len(res.Failed) > 0 && len(res.Failed) < queried {
+		log.Printf("[RBL] %d of %d RBL lookups failed for %s: %v", len(res.Failed), queried, domain, res.Failed)
+	}
+	return res
 }
 
 //
 // MXTOOLBOX BLACKLIST LOOKUP
 //
 
-func FetchMXToolboxBlacklist(domain string) (*MXBlacklistResult, error) {
+// FetchMXToolboxBlacklist returns an error when the API call fails;
+// without an API key the result is "skipped" (no reputation data)
+func FetchMXToolboxBlacklist(ctx context.Context, domain string) (*MXBlacklistResult, error) {
 	apiKey := os.Getenv("MXTOOLBOX_API_KEY")
+	if apiKey == "" {
+		return &MXBlacklistResult{CheckOutcome: skipped("MXTOOLBOX_API_KEY not set")}, nil
+	}
 	url := fmt.Sprintf("https://mxtoolbox.com/api/v1/Lookup?command=blacklist&argument=%s", domain)
 
-	req, _ := http.NewRequest("GET", url, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
 	req.Header.Set("Authorization", apiKey)
 	req.Header.Set("accept", "application/json")
 
@@ -310,6 +407,10 @@ func FetchMXToolboxBlacklist(domain string) (*MXBlacklistResult, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mxtoolbox error: %v", resp.Status)
+	}
+
 	var raw struct {
 		MxRep  int `json:"MxRep"`
 		Failed []struct {
@@ -339,8 +440,9 @@ func FetchMXToolboxBlacklist(domain string) (*MXBlacklistResult, error) {
 	}
 
 	return &MXBlacklistResult{
-		MxRep: raw.MxRep,
-		Lists: entries,
+		CheckOutcome: negativeIf(len(entries) > 0),
+		MxRep:        raw.MxRep,
+		Lists:        entries,
 	}, nil
 }
 
@@ -361,19 +463,16 @@ func convertMXToBlacklist(mx *MXBlacklistResult) []BlacklistEntry {
 // TLS + EXPIRY
 //
 
-func GetExpirationDate(domain string) (int, string) {
-	conn, err := tls.Dial("tcp", domain+":443", &tls.Config{ServerName: domain})
+func GetExpirationDate(ctx context.Context, domain string) (int, string) {
+	hs, err := DialTLSHandshake(ctx, domain)
 	if err != nil {
 		return 0, ""
 	}
-	defer conn.Close()
-
-	expiry := conn.ConnectionState().PeerCertificates[0].NotAfter
-	return int(time.Until(expiry).Hours() / 24), expiry.Format("02/01/2006")
+	return hs.Expiry()
 }
 
-func DomainExpiryDate(domain string) string {
-	raw, err := whois.Whois(domain)
+func DomainExpiryDate(ctx context.Context, domain string) string {
+	raw, err := whoisQuery(ctx, domain)
 	if err != nil {
 		return ""
 	}
@@ -384,7 +483,7 @@ func DomainExpiryDate(domain string) string {
 		parts := strings.Split(domain, ".")
 		if len(parts) > 2 {
 			parentDomain := strings.Join(parts[1:], ".")
-			return DomainExpiryDate(parentDomain)
+			return DomainExpiryDate(ctx, parentDomain)
 		}
 		return ""
 	}
@@ -418,10 +517,17 @@ func DomainExpiryDate(domain string) string {
 // GOOGLE SAFE BROWSING
 //
 
-func CheckGoogleReputation(domain string) (bool, string) {
+func CheckGoogleReputation(ctx context.Context, domain string) (bool, string) {
+	res := LookupSafeBrowsing(ctx, domain)
+	return res.Flagged, res.Reason
+}
+
+// LookupSafeBrowsing queries Google Safe Browsing.
+// Flagged domains are negative; a failed API call is an error (not "clean").
+func LookupSafeBrowsing(ctx context.Context, domain string) SafeBrowsingResult {
 	apiKey := os.Getenv("GOOGLE_SAFE_BROWSING_KEY")
 	if apiKey == "" {
-		return false, "API key missing"
+		return SafeBrowsingResult{CheckOutcome: skipped("GOOGLE_SAFE_BROWSING_KEY not set"), Reason: "API key missing"}
 	}
 
 	url := "https://safebrowsing.googleapis.com/v4/threatMatches:find?key=" + apiKey
@@ -440,21 +546,35 @@ func CheckGoogleReputation(domain string) (bool, string) {
       }
     }`, domain)
 
-	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{Timeout: 6 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, "API error"
+		// The request URL carries the API key - keep it out of the reported error
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return SafeBrowsingResult{CheckOutcome: outcomeOf(err), Reason: "API error"}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return SafeBrowsingResult{
+			CheckOutcome: CheckOutcome{Status: StatusError, Error: "safe browsing error: " + resp.Status},
+			Reason:       "API error",
+		}
+	}
+
 	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return SafeBrowsingResult{CheckOutcome: outcomeOf(err), Reason: "API error"}
+	}
 
 	if result["matches"] != nil {
-		return true, "Google flagged this domain"
+		return SafeBrowsingResult{CheckOutcome: negativeIf(true), Flagged: true, Reason: "Google flagged this domain"}
 	}
-	return false, "No threats"
+	return SafeBrowsingResult{CheckOutcome: negativeIf(false), Reason: "No threats"}
 }
@@ -0,0 +1,266 @@
+package vetting
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+//
+// DKIM (RFC 6376)
+//
+// DKIM selectors can't be listed, so common ones are probed at
+// <selector>._domainkey.<domain>: DKIMSelectors, plus VETTING_DKIM_SELECTORS
+// (comma-separated, e.g. our ESP's selector) and VetRequest.dkim_selectors.
+// Every key found is parsed for its type and RSA bit length; revoked keys
+// (empty p=) and RSA keys of 1024 bits or less are flagged.
+//
+
+// DKIMSelectors are the selectors probed for every domain
+var DKIMSelectors = []string{
+	"google",                 // Google Workspace
+	"selector1", "selector2", // Microsoft 365
+	"k1", "k2", // Mailchimp, Klaviyo
+	"s1", "s2", // SendGrid and others
+	"default",
+	"mandrill", // Mandrill
+	"dkim",
+	"mail",
+}
+
+// dkimMinRSABits is the RSA key length weak keys should be rotated to
+// (RFC 8301 allows 1024, receivers increasingly expect 2048)
+const dkimMinRSABits = 2048
+
+// DKIMKey is the key record published for one selector
+type DKIMKey struct {
+	Selector string   `json:"selector"`
+	Record   string   `json:"record"`
+	KeyType  string   `json:"key_type"`       // rsa, ed25519
+	Bits     int      `json:"bits,omitempty"` // RSA modulus length
+	Revoked  bool     `json:"revoked"`        // Empty p= (key withdrawn)
+	Testing  bool     `json:"testing"`        // t=y: receivers treat failures as unsigned
+	Weak     bool     `json:"weak"`           // RSA key of 1024 bits or less
+	Error    string   `json:"error,omitempty"`
+	Issues   []string `json:"issues,omitempty"`
+}
+
+// Usable reports whether the key can verify signatures
+func (k DKIMKey) Usable() bool {
+	return k.Error == "" && !k.Revoked
+}
+
+// DKIMResult - DKIM keys found on the probed selectors
+type DKIMResult struct {
+	CheckOutcome
+	Selectors   []string  `json:"selectors_probed"`
+	Keys        []DKIMKey `json:"keys,omitempty"`
+	HasValidKey bool      `json:"has_valid_key"` // At least one usable key
+	HasWeakKey  bool      `json:"has_weak_key"`  // A usable key is weak
+	HasRevoked  bool      `json:"has_revoked"`   // A selector has a revoked key
+	MinRSABits  int       `json:"min_rsa_bits"`  // Shortest usable RSA key (0 = none)
+}
+
+// dkimSelectors returns the selectors to probe (deduplicated, lowercased)
+func dkimSelectors(req *VetRequest) []string {
+	candidates := append([]string{}, DKIMSelectors...)
+	candidates = append(candidates, strings.Split(os.Getenv("VETTING_DKIM_SELECTORS"), ",")...)
+	if req != nil {
+		candidates = append(candidates, req.DKIMSelectors...)
+	}
+
+	seen := map[string]bool{}
+	var selectors []string
+	for _, s := range candidates {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		selectors = append(selectors, s)
+	}
+	return selectors
+}
+
+// ProbeDKIM looks up the DKIM keys of domain on the given selectors
+func ProbeDKIM(ctx context.Context, domain string, selectors []string) DKIMResult {
+	return probeDKIM(ctx, defaultDNS(), domain, selectors)
+}
+
+func probeDKIM(ctx context.Context, dns DNSClient, domain string, selectors []string) DKIMResult {
+	res := DKIMResult{Selectors: selectors}
+
+	var mu sync.Mutex
+	answered := 0
+	var lastErr error
+	var g errgroup.Group
+	g.SetLimit(4)
+	for _, selector := range selectors {
+		g.Go(func() error {
+			txts, err := dns.LookupTXT(ctx, selector+"._domainkey."+domain)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && !isDNSNotFound(err) {
+				lastErr = err
+				return nil
+			}
+			answered++
+			for _, txt := range txts {
+				if isDKIMRecord(txt) {
+					res.Keys = append(res.Keys, ParseDKIMKey(selector, txt))
+					break
+				}
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	sort.Slice(res.Keys, func(i, j int) bool { return res.Keys[i].Selector < res.Keys[j].Selector })
+
+	for _, k := range res.Keys {
+		if k.Revoked {
+			res.HasRevoked = true
+		}
+		if !k.Usable() {
+			continue
+		}
+		res.HasValidKey = true
+		if k.Weak {
+			res.HasWeakKey = true
+		}
+		if k.KeyType == "rsa" && (res.MinRSABits == 0 || k.Bits < res.MinRSABits) {
+			res.MinRSABits = k.Bits
+		}
+	}
+
+	// No key is only a finding if the selectors could actually be looked up
+	switch {
+	case res.HasValidKey:
+		res.CheckOutcome = CheckOutcome{Status: StatusOK}
+	case answered == 0 && lastErr != nil:
+		res.CheckOutcome = outcomeOf(lastErr)
+	default:
+		res.CheckOutcome = negativeIf(true)
+	}
+
+	log.Printf("[DKIM] %s: %d keys on %d selectors (valid=%v, weak=%v, revoked=%v)",
+		domain, len(res.Keys), len(selectors), res.HasValidKey, res.HasWeakKey, res.HasRevoked)
+	return res
+}
+
+// isDKIMRecord reports whether a TXT record looks like a DKIM key record:
+// v=DKIM1, or no v= tag and a p= tag (v= is optional, but any other version
+// is some other record, e.g. SPF from a wildcard TXT)
+func isDKIMRecord(txt string) bool {
+	hasKey := false
+	for _, tag := range strings.Split(txt, ";") {
+		name, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "v":
+			return strings.TrimSpace(value) == "DKIM1"
+		case "p":
+			hasKey = true
+		}
+	}
+	return hasKey
+}
+
+// ParseDKIMKey parses a DKIM key record (RFC 6376 §3.6.1)
+func ParseDKIMKey(selector, record string) DKIMKey {
+	key := DKIMKey{Selector: selector, Record: record, KeyType: "rsa"}
+	tags := map[string]string{}
+	for i, tag := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		// Whitespace is allowed anywhere in a tag value (folded base64)
+		value = strings.Join(strings.Fields(value), "")
+		if name == "v" && (i != 0 || value != "DKIM1") {
+			key.Issues = append(key.Issues, `v= must be first and "DKIM1"`)
+		}
+		tags[name] = value
+	}
+
+	if k, ok := tags["k"]; ok {
+		key.KeyType = strings.ToLower(k)
+	}
+	for _, flag := range strings.Split(tags["t"], ":") {
+		if strings.TrimSpace(flag) == "y" {
+			key.Testing = true
+			key.Issues = append(key.Issues, "t=y: testing mode, receivers treat failed signatures as unsigned")
+		}
+	}
+	if h, ok := tags["h"]; ok && !strings.Contains(strings.ToLower(h), "sha256") {
+		key.Issues = append(key.Issues, fmt.Sprintf("h=%s: only sha256 is accepted by receivers (RFC 8301)", h))
+	}
+
+	p, ok := tags["p"]
+	switch {
+	case !ok:
+		key.Error = "p= (public key) is missing"
+		return key
+	case p == "":
+		key.Revoked = true
+		key.Issues = append(key.Issues, "empty p=: the key has been revoked")
+		return key
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		key.Error = "p= is not valid base64: " + err.Error()
+		return key
+	}
+
+	switch key.KeyType {
+	case "rsa":
+		pub, err := parseDKIMRSAKey(der)
+		if err != nil {
+			key.Error = err.Error()
+			return key
+		}
+		key.Bits = pub.N.BitLen()
+		if key.Bits <= 1024 {
+			key.Weak = true
+			key.Issues = append(key.Issues, fmt.Sprintf("%d-bit RSA key: rotate to %d bits", key.Bits, dkimMinRSABits))
+		}
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			key.Error = fmt.Sprintf("ed25519 key is %d bytes, want %d", len(der), ed25519.PublicKeySize)
+		}
+	default:
+		key.Error = fmt.Sprintf("unknown key type k=%s", key.KeyType)
+	}
+	return key
+}
+
+// parseDKIMRSAKey accepts SubjectPublicKeyInfo (the standard) and bare
+// PKCS#1 keys (published by some providers)
+func parseDKIMRSAKey(der []byte) (*rsa.PublicKey, error) {
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("k=rsa but the key is %T", pub)
+		}
+		return rsaKey, nil
+	}
+	pub, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("p= is not an RSA public key: %v", err)
+	}
+	return pub, nil
+}
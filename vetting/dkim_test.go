@@ -0,0 +1,163 @@
+package vetting
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+func rsaKeyRecord(t *testing.T, bits int, pkcs1 bool) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	if !pkcs1 {
+		if der, err = x509.MarshalPKIXPublicKey(&key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func pkixEd25519(t *testing.T, pub ed25519.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	rsa1024 := rsaKeyRecord(t, 1024, false)
+	rsa2048 := rsaKeyRecord(t, 2048, false)
+	pkcs1 := rsaKeyRecord(t, 2048, true)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := base64.StdEncoding.EncodeToString(edPub)
+
+	tests := []struct {
+		name    string
+		record  string
+		keyType string
+		bits    int
+		weak    bool
+		revoked bool
+		testing bool
+		err     string
+		issue   string
+	}{
+		{name: "RSA-1024", record: "v=DKIM1; k=rsa; p=" + rsa1024, keyType: "rsa", bits: 1024, weak: true, issue: "1024-bit RSA key"},
+		{name: "RSA-2048", record: "v=DKIM1; k=rsa; p=" + rsa2048, keyType: "rsa", bits: 2048},
+		{name: "RSA-2048 folded, k= defaulted", record: "v=DKIM1; p=" + rsa2048[:100] + " " + rsa2048[100:], keyType: "rsa", bits: 2048},
+		{name: "PKCS#1", record: "v=DKIM1; p=" + pkcs1, keyType: "rsa", bits: 2048},
+		{name: "ed25519", record: "v=DKIM1; k=ed25519; p=" + ed, keyType: "ed25519"},
+		{name: "ed25519 wrong size", record: "v=DKIM1; k=ed25519; p=" + rsa2048, keyType: "ed25519", err: "ed25519 key is"},
+		{name: "revoked", record: "v=DKIM1; p=", keyType: "rsa", revoked: true, issue: "revoked"},
+		{name: "testing", record: "v=DKIM1; t=y; p=" + rsa2048, keyType: "rsa", bits: 2048, testing: true, issue: "t=y"},
+		{name: "bad base64", record: "v=DKIM1; p=not*base64", keyType: "rsa", err: "not valid base64"},
+		{name: "not a key", record: "v=DKIM1; p=" + base64.StdEncoding.EncodeToString([]byte("junk")), keyType: "rsa", err: "not an RSA public key"},
+		{name: "ed25519 key as k=rsa", record: "v=DKIM1; p=" + pkixEd25519(t, edPub), keyType: "rsa", err: "k=rsa but the key is"},
+		{name: "missing p=", record: "v=DKIM1; k=rsa", keyType: "rsa", err: "p= (public key) is missing"},
+		{name: "unknown k=", record: "v=DKIM1; k=dsa; p=" + rsa2048, keyType: "dsa", err: "unknown key type"},
+		{name: "v= not first", record: "k=rsa; v=DKIM1; p=" + rsa2048, keyType: "rsa", bits: 2048, issue: "v= must be first"},
+		{name: "sha1 only", record: "v=DKIM1; h=sha1; p=" + rsa2048, keyType: "rsa", bits: 2048, issue: "only sha256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := ParseDKIMKey("s1", tt.record)
+			if k.KeyType != tt.keyType || k.Bits != tt.bits || k.Weak != tt.weak || k.Revoked != tt.revoked || k.Testing != tt.testing {
+				t.Errorf("key = %s %d-bit weak=%v revoked=%v testing=%v", k.KeyType, k.Bits, k.Weak, k.Revoked, k.Testing)
+			}
+			if (tt.err == "") != (k.Error == "") || !strings.Contains(k.Error, tt.err) {
+				t.Errorf("error %q, want %q", k.Error, tt.err)
+			}
+			if tt.issue != "" && !strings.Contains(strings.Join(k.Issues, "; "), tt.issue) {
+				t.Errorf("issues %q, want %q", k.Issues, tt.issue)
+			}
+			if k.Usable() != (tt.err == "" && !tt.revoked) {
+				t.Errorf("Usable() = %v", k.Usable())
+			}
+		})
+	}
+}
+
+func TestIsDKIMRecord(t *testing.T) {
+	tests := []struct {
+		txt  string
+		want bool
+	}{
+		{"v=DKIM1; k=rsa; p=MIIB", true},
+		{"v=DKIM1; p=", true},
+		{"k=rsa; p=MIIB", true},
+		{"p=MIIB", true},
+		{"v=spf1 include:_spf.example.com -all", false},
+		{"v=spf1 redirect=_spf.example.com; p=x", false},
+		{"v=DMARC1; p=reject", false},
+		{"google-site-verification=abc", false},
+		{"k=rsa", false},
+	}
+	for _, tt := range tests {
+		if got := isDKIMRecord(tt.txt); got != tt.want {
+			t.Errorf("isDKIMRecord(%q) = %v, want %v", tt.txt, got, tt.want)
+		}
+	}
+}
+
+func TestProbeDKIM(t *testing.T) {
+	weak := "v=DKIM1; k=rsa; p=" + rsaKeyRecord(t, 1024, false)
+	strong := "v=DKIM1; k=rsa; p=" + rsaKeyRecord(t, 2048, false)
+	servfail := &net.DNSError{Err: "server misbehaving", Name: "broken.test"}
+	selectors := []string{"google", "s1", "s2", "k1"}
+
+	t.Run("keys found", func(t *testing.T) {
+		res := probeDKIM(context.Background(), &FakeDNS{TXT: map[string][]string{
+			"s1._domainkey.example.com":     {"unrelated", weak},
+			"google._domainkey.example.com": {strong},
+			"k1._domainkey.example.com":     {"v=DKIM1; p="},
+		}}, "example.com", selectors)
+		if res.Status != StatusOK || !res.HasValidKey || !res.HasWeakKey || !res.HasRevoked || res.MinRSABits != 1024 {
+			t.Errorf("result %+v", res)
+		}
+		var got []string
+		for _, k := range res.Keys {
+			got = append(got, k.Selector)
+		}
+		if strings.Join(got, ",") != "google,k1,s1" {
+			t.Errorf("keys on %v, want google, k1, s1", got)
+		}
+	})
+
+	t.Run("wildcard SPF TXT is not a key", func(t *testing.T) {
+		// A wildcard TXT answers every selector name with the SPF record
+		txt := map[string][]string{}
+		for _, s := range selectors {
+			txt[s+"._domainkey.wild.test"] = []string{"v=spf1 include:_spf.wild.test -all"}
+		}
+		res := probeDKIM(context.Background(), &FakeDNS{TXT: txt}, "wild.test", selectors)
+		if len(res.Keys) != 0 || res.HasValidKey || res.Status != StatusNegative {
+			t.Errorf("result %+v, want no keys and negative", res)
+		}
+	})
+
+	t.Run("lookup failures are unknown", func(t *testing.T) {
+		errs := map[string]error{}
+		for _, s := range selectors {
+			errs[s+"._domainkey.broken.test"] = servfail
+		}
+		res := probeDKIM(context.Background(), &FakeDNS{Errors: errs}, "broken.test", selectors)
+		if res.Status != StatusError {
+			t.Errorf("status %s, want error", res.Status)
+		}
+	})
+}
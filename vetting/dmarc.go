@@ -0,0 +1,380 @@
+package vetting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+//
+// DMARC (RFC 7489)
+//
+// The record is looked up at _dmarc.<domain>; if there is none, at
+// _dmarc.<organizational domain> (RFC 7489 §6.6.3), where the subdomain
+// policy (sp, else p) applies to the vetted domain. The parsed tag set and
+// the effective policy for the exact domain are returned in
+// EmailSecurity.DMARC; scoring uses the effective policy.
+//
+// Report destinations outside the record's organizational domain must
+// publish <domain>._report._dmarc.<destination> (RFC 7489 §7.1); reports to
+// destinations that don't are silently dropped, so they are flagged.
+//
+
+// DMARC policies
+const (
+	DMARCNone       = "none"
+	DMARCQuarantine = "quarantine"
+	DMARCReject     = "reject"
+)
+
+// DMARCReportURI is one rua/ruf destination
+type DMARCReportURI struct {
+	URI     string `json:"uri"`                // As published (without the size limit)
+	Address string `json:"address,omitempty"`  // Mailbox of a mailto: URI
+	Domain  string `json:"domain,omitempty"`   // Domain of the mailbox
+	MaxSize string `json:"max_size,omitempty"` // !size suffix (e.g. 10m)
+
+	// External destinations need an authorization record (RFC 7489 §7.1)
+	External   bool   `json:"external"`
+	Authorized bool   `json:"authorized"`           // Same organization, or authorization record found
+	AuthError  string `json:"auth_error,omitempty"` // Authorization lookup failed (unknown)
+}
+
+// DMARCPolicy is a parsed DMARC record. Unset tags hold their RFC defaults.
+type DMARCPolicy struct {
+	Record          string           `json:"record"`
+	Domain          string           `json:"domain"`             // Where the record was found
+	Inherited       bool             `json:"inherited"`          // Found at the organizational domain
+	Policy          string           `json:"p"`                  // none, quarantine, reject
+	SubdomainPolicy string           `json:"sp,omitempty"`       // As published ("" = same as p)
+	Pct             int              `json:"pct"`                // Share of failing mail the policy applies to
+	ADKIM           string           `json:"adkim"`              // r (relaxed) or s (strict)
+	ASPF            string           `json:"aspf"`               // r (relaxed) or s (strict)
+	RUA             []DMARCReportURI `json:"rua,omitempty"`      // Aggregate report destinations
+	RUF             []DMARCReportURI `json:"ruf,omitempty"`      // Failure report destinations
+	FO              []string         `json:"fo"`                 // Failure reporting options (0, 1, d, s)
+	RI              int              `json:"ri"`                 // Aggregate report interval (seconds)
+	Effective       string           `json:"effective_policy"`   // Policy applied to the vetted domain ("" if the record is unusable)
+	Valid           bool             `json:"valid"`              // Syntax ok
+	Errors          []string         `json:"errors,omitempty"`   // Syntax errors
+	Warnings        []string         `json:"warnings,omitempty"` // Unknown tags, questionable values
+}
+
+// Enforced reports whether failing mail is quarantined or rejected (pct > 0)
+func (p *DMARCPolicy) Enforced() bool {
+	return p != nil && (p.Effective == DMARCQuarantine || p.Effective == DMARCReject) && p.Pct > 0
+}
+
+// ParseDMARC parses a DMARC record. Errors are collected in Errors (the
+// record is ignored by receivers unless it still has a usable policy).
+func ParseDMARC(record string) DMARCPolicy {
+	p := DMARCPolicy{Record: record, Pct: 100, ADKIM: "r", ASPF: "r", FO: []string{"0"}, RI: 86400}
+	errorf := func(format string, args ...interface{}) { p.Errors = append(p.Errors, fmt.Sprintf(format, args...)) }
+	warnf := func(format string, args ...interface{}) {
+		p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	seen := map[string]bool{}
+	versionOK, policyOK, subdomainOK := false, false, true
+	for i, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if !ok {
+			errorf("%q is not a tag=value pair", part)
+			continue
+		}
+		if i == 0 {
+			versionOK = name == "v" && value == "DMARC1"
+			if !versionOK {
+				errorf(`record must start with "v=DMARC1"`)
+			}
+			seen["v"] = true
+			continue
+		}
+		if seen[name] {
+			errorf("tag %s appears more than once", name)
+			continue
+		}
+		seen[name] = true
+
+		switch name {
+		case "v":
+			errorf("v must be the first tag")
+		case "p", "sp":
+			v := strings.ToLower(value)
+			if v != DMARCNone && v != DMARCQuarantine && v != DMARCReject {
+				errorf("%s=%s is not none, quarantine or reject", name, value)
+				subdomainOK = subdomainOK && name != "sp"
+				continue
+			}
+			if name == "p" {
+				p.Policy, policyOK = v, true
+			} else {
+				p.SubdomainPolicy = v
+			}
+		case "pct":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > 100 {
+				errorf("pct=%s is not 0-100", value)
+				continue
+			}
+			p.Pct = n
+		case "adkim", "aspf":
+			v := strings.ToLower(value)
+			if v != "r" && v != "s" {
+				errorf("%s=%s is not r or s", name, value)
+				continue
+			}
+			if name == "adkim" {
+				p.ADKIM = v
+			} else {
+				p.ASPF = v
+			}
+		case "rua", "ruf":
+			uris, err := parseDMARCURIs(value)
+			if err != nil {
+				errorf("%s: %v", name, err)
+			}
+			if name == "rua" {
+				p.RUA = uris
+			} else {
+				p.RUF = uris
+			}
+		case "fo":
+			p.FO = nil
+			for _, opt := range strings.Split(value, ":") {
+				opt = strings.ToLower(strings.TrimSpace(opt))
+				if opt != "0" && opt != "1" && opt != "d" && opt != "s" {
+					errorf("fo=%s: option %q is not 0, 1, d or s", value, opt)
+					continue
+				}
+				p.FO = append(p.FO, opt)
+			}
+		case "ri":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				errorf("ri=%s is not a number of seconds", value)
+				continue
+			}
+			p.RI = int(n)
+		case "rf":
+			if !strings.EqualFold(value, "afrf") {
+				warnf("rf=%s: only afrf is defined", value)
+			}
+		default:
+			warnf("unknown tag %s (ignored)", name)
+		}
+	}
+
+	// §6.6.3: without a valid p (or with an invalid sp) the record counts as
+	// p=none if it has a valid rua, and is discarded otherwise
+	if !seen["p"] {
+		errorf("p is missing")
+	}
+	if !policyOK || !subdomainOK {
+		policyOK = len(p.RUA) > 0
+		if policyOK {
+			p.Policy, p.SubdomainPolicy = DMARCNone, ""
+			warnf("invalid policy; receivers treat the record as p=none because rua is present")
+		}
+	}
+	if policyOK && p.Pct < 100 && p.Policy != DMARCNone {
+		warnf("pct=%d: the policy applies to %d%% of failing mail only", p.Pct, p.Pct)
+	}
+
+	p.Valid = len(p.Errors) == 0
+	if versionOK && policyOK {
+		p.Effective = p.Policy
+	}
+	return p
+}
+
+// parseDMARCURIs parses a comma-separated list of report URIs (mailto: only
+// is widely supported; others are kept without an address)
+func parseDMARCURIs(value string) ([]DMARCReportURI, error) {
+	var uris []DMARCReportURI
+	var errs []string
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		uri := DMARCReportURI{URI: raw}
+		// A size limit follows the last "!" (e.g. mailto:dmarc@example.com!10m)
+		if i := strings.LastIndex(raw, "!"); i > 0 {
+			uri.URI, uri.MaxSize = raw[:i], raw[i+1:]
+		}
+		scheme, rest, ok := strings.Cut(uri.URI, ":")
+		if !ok || scheme == "" {
+			errs = append(errs, fmt.Sprintf("%q is not a URI", raw))
+			continue
+		}
+		if strings.EqualFold(scheme, "mailto") {
+			address, _, _ := strings.Cut(rest, "?")
+			local, domain, ok := strings.Cut(address, "@")
+			if !ok || local == "" || domain == "" {
+				errs = append(errs, fmt.Sprintf("%q is not a valid mailto: address", raw))
+				continue
+			}
+			uri.Address = address
+			uri.Domain = strings.ToLower(domain)
+		}
+		uris = append(uris, uri)
+	}
+	if len(errs) > 0 {
+		return uris, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return uris, nil
+}
+
+// organizationalDomain returns the registrable domain (public suffix + 1 label)
+func organizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return domain
+	}
+	return org
+}
+
+// DiscoverDMARC finds the DMARC policy for domain, falling back to the
+// organizational domain. Returns nil without error if neither publishes one;
+// the error is a DNS failure (no answer at all).
+func DiscoverDMARC(ctx context.Context, domain string) (*DMARCPolicy, error) {
+	return discoverDMARC(ctx, defaultDNS(), domain)
+}
+
+func discoverDMARC(ctx context.Context, dns DNSClient, domain string) (*DMARCPolicy, error) {
+	policy, err := lookupDMARC(ctx, dns, domain)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		verifyDMARCReportDestinations(ctx, dns, policy)
+		return policy, nil
+	}
+
+	org := organizationalDomain(domain)
+	if strings.EqualFold(org, domain) {
+		return nil, nil
+	}
+	policy, err = lookupDMARC(ctx, dns, org)
+	if policy == nil {
+		return nil, err
+	}
+
+	verifyDMARCReportDestinations(ctx, dns, policy)
+
+	// The organizational record covers the subdomain through sp (or p)
+	policy.Inherited = true
+	if policy.Effective != "" && policy.SubdomainPolicy != "" {
+		policy.Effective = policy.SubdomainPolicy
+	}
+	log.Printf("[DMARC] %s has no record, using %s's (effective policy %q)", domain, org, policy.Effective)
+	return policy, nil
+}
+
+// lookupDMARC reads the record at _dmarc.<domain> (nil if there is none)
+func lookupDMARC(ctx context.Context, dns DNSClient, domain string) (*DMARCPolicy, error) {
+	txts, err := dns.LookupTXT(ctx, "_dmarc."+domain)
+	if err != nil && !isDNSNotFound(err) {
+		return nil, err
+	}
+	var records []string
+	for _, txt := range txts {
+		if isDMARCRecord(txt) {
+			records = append(records, txt)
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	policy := ParseDMARC(records[0])
+	policy.Domain = domain
+	// §6.6.3: more than one record means no DMARC policy applies
+	if len(records) > 1 {
+		policy.Errors = append(policy.Errors, fmt.Sprintf("%d DMARC records published (must be exactly one); receivers apply none", len(records)))
+		policy.Valid = false
+		policy.Effective = ""
+	}
+	return &policy, nil
+}
+
+// isDMARCRecord reports whether a TXT record is a DMARC record (starts with
+// the v=DMARC1 tag)
+func isDMARCRecord(txt string) bool {
+	tag, _, _ := strings.Cut(txt, ";")
+	name, value, ok := strings.Cut(tag, "=")
+	return ok && strings.EqualFold(strings.TrimSpace(name), "v") && strings.EqualFold(strings.TrimSpace(value), "DMARC1")
+}
+
+// UnauthorizedDestinations lists the rua/ruf destinations that did not
+// authorize receiving this domain's reports
+func (p *DMARCPolicy) UnauthorizedDestinations() []string {
+	var out []string
+	if p == nil {
+		return out
+	}
+	for _, uri := range append(append([]DMARCReportURI{}, p.RUA...), p.RUF...) {
+		if uri.External && !uri.Authorized && uri.AuthError == "" {
+			out = append(out, uri.URI)
+		}
+	}
+	return out
+}
+
+// verifyDMARCReportDestinations checks every rua/ruf mailto: destination
+// outside the record's organizational domain for a
+// <domain>._report._dmarc.<destination> record
+func verifyDMARCReportDestinations(ctx context.Context, dns DNSClient, policy *DMARCPolicy) {
+	org := organizationalDomain(policy.Domain)
+	verified := map[string]*DMARCReportURI{} // Destination domain -> first URI checked
+
+	for _, uris := range [][]DMARCReportURI{policy.RUA, policy.RUF} {
+		for i := range uris {
+			uri := &uris[i]
+			if uri.Domain == "" {
+				continue // Not mailto:, nothing to verify
+			}
+			if strings.EqualFold(organizationalDomain(uri.Domain), org) {
+				uri.Authorized = true
+				continue
+			}
+			uri.External = true
+			if prev, ok := verified[uri.Domain]; ok {
+				uri.Authorized, uri.AuthError = prev.Authorized, prev.AuthError
+				continue
+			}
+			verified[uri.Domain] = uri
+
+			name := policy.Domain + "._report._dmarc." + uri.Domain
+			txts, err := dns.LookupTXT(ctx, name)
+			if err != nil && !isDNSNotFound(err) {
+				uri.AuthError = err.Error()
+				continue
+			}
+			for _, txt := range txts {
+				if isDMARCRecord(txt) {
+					uri.Authorized = true
+					break
+				}
+			}
+		}
+	}
+
+	for _, uri := range append(append([]DMARCReportURI{}, policy.RUA...), policy.RUF...) {
+		if uri.External && !uri.Authorized && uri.AuthError == "" {
+			policy.Warnings = append(policy.Warnings, fmt.Sprintf(
+				"report destination %s has not authorized reports for %s (no %s._report._dmarc.%s record); receivers will not send them",
+				uri.URI, policy.Domain, policy.Domain, uri.Domain))
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package vetting
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingDNS counts the TXT lookups of each name
+type countingDNS struct {
+	*FakeDNS
+	mu  sync.Mutex
+	txt map[string]int
+}
+
+func (c *countingDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	c.mu.Lock()
+	c.txt[fakeKey(name)]++
+	c.mu.Unlock()
+	return c.FakeDNS.LookupTXT(ctx, name)
+}
+
+func TestVerifyDMARCReportDestinations(t *testing.T) {
+	dns := &countingDNS{txt: map[string]int{}, FakeDNS: &FakeDNS{
+		TXT: map[string][]string{
+			"example.com._report._dmarc.reports.test": {"v=DMARC1"},
+			"example.com._report._dmarc.wrong.test":   {"v=spf1 -all"},
+		},
+		Errors: map[string]error{
+			"example.com._report._dmarc.broken.test": &net.DNSError{Err: "server misbehaving", Name: "broken.test"},
+		},
+	}}
+	policy := ParseDMARC("v=DMARC1; p=reject; " +
+		"rua=mailto:dmarc@example.com,mailto:agg@reports.test,mailto:a@unauthorized.test,mailto:x@broken.test,https://collect.test/dmarc; " +
+		"ruf=mailto:ruf@mail.example.com,mailto:fail@reports.test,mailto:b@unauthorized.test,mailto:c@wrong.test")
+	policy.Domain = "example.com"
+	verifyDMARCReportDestinations(context.Background(), dns, &policy)
+
+	type state struct{ external, authorized, authErr bool }
+	want := map[string]state{
+		"mailto:dmarc@example.com":    {false, true, false}, // Same organization
+		"mailto:ruf@mail.example.com": {false, true, false},
+		"mailto:agg@reports.test":     {true, true, false}, // Authorization record
+		"mailto:fail@reports.test":    {true, true, false},
+		"mailto:a@unauthorized.test":  {true, false, false}, // No record
+		"mailto:b@unauthorized.test":  {true, false, false},
+		"mailto:c@wrong.test":         {true, false, false}, // Not a DMARC record
+		"mailto:x@broken.test":        {true, false, true},  // Unknown
+		"https://collect.test/dmarc":  {false, false, false},
+	}
+	if n := len(policy.RUA) + len(policy.RUF); n != len(want) {
+		t.Fatalf("%d destinations parsed, want %d", n, len(want))
+	}
+	for _, uri := range append(append([]DMARCReportURI{}, policy.RUA...), policy.RUF...) {
+		got := state{uri.External, uri.Authorized, uri.AuthError != ""}
+		if got != want[uri.URI] {
+			t.Errorf("%s: external=%v authorized=%v auth error %q", uri.URI, uri.External, uri.Authorized, uri.AuthError)
+		}
+	}
+
+	unauthorized := policy.UnauthorizedDestinations()
+	if strings.Join(unauthorized, ",") != "mailto:a@unauthorized.test,mailto:b@unauthorized.test,mailto:c@wrong.test" {
+		t.Errorf("UnauthorizedDestinations = %v", unauthorized)
+	}
+	if len(policy.Warnings) != 3 {
+		t.Errorf("warnings %q, want one per unauthorized destination", policy.Warnings)
+	}
+
+	// One lookup per external destination domain across rua and ruf
+	for _, dest := range []string{"reports.test", "unauthorized.test", "wrong.test", "broken.test"} {
+		if n := dns.txt["example.com._report._dmarc."+dest]; n != 1 {
+			t.Errorf("%s looked up %d times, want 1", dest, n)
+		}
+	}
+	if len(dns.txt) != 4 {
+		t.Errorf("lookups %v, want the 4 external destinations only", dns.txt)
+	}
+}
+
+func TestParseDMARC(t *testing.T) {
+	tests := []struct {
+		name      string
+		record    string
+		effective string
+		err       string // Substring of an error ("" = valid)
+		warning   string
+		check     func(t *testing.T, p DMARCPolicy)
+	}{
+		{
+			name:      "defaults",
+			record:    "v=DMARC1; p=quarantine",
+			effective: DMARCQuarantine,
+			check: func(t *testing.T, p DMARCPolicy) {
+				if p.Pct != 100 || p.ADKIM != "r" || p.ASPF != "r" || strings.Join(p.FO, ":") != "0" || p.RI != 86400 {
+					t.Errorf("defaults pct=%d adkim=%s aspf=%s fo=%v ri=%d", p.Pct, p.ADKIM, p.ASPF, p.FO, p.RI)
+				}
+			},
+		},
+		{
+			name:      "invalid sp with rua falls back to p=none",
+			record:    "v=DMARC1; p=reject; sp=bogus; rua=mailto:dmarc@example.com",
+			effective: DMARCNone,
+			err:       "sp=bogus",
+			warning:   "treat the record as p=none",
+			check: func(t *testing.T, p DMARCPolicy) {
+				if p.Policy != DMARCNone || p.SubdomainPolicy != "" {
+					t.Errorf("p=%s sp=%s, want none and unset", p.Policy, p.SubdomainPolicy)
+				}
+			},
+		},
+		{
+			name:   "invalid sp without rua is discarded",
+			record: "v=DMARC1; p=reject; sp=bogus",
+			err:    "sp=bogus",
+		},
+		{
+			name:   "missing p without rua",
+			record: "v=DMARC1; adkim=s",
+			err:    "p is missing",
+		},
+		{
+			name:      "missing p with rua",
+			record:    "v=DMARC1; rua=mailto:dmarc@example.com",
+			effective: DMARCNone,
+			err:       "p is missing",
+		},
+		{
+			name:      "pct=0",
+			record:    "v=DMARC1; p=reject; pct=0",
+			effective: DMARCReject,
+			warning:   "applies to 0% of failing mail",
+			check: func(t *testing.T, p DMARCPolicy) {
+				if p.Pct != 0 || p.Enforced() {
+					t.Errorf("pct=%d enforced=%v, want 0 and not enforced", p.Pct, p.Enforced())
+				}
+			},
+		},
+		{
+			name:      "pct=100",
+			record:    "v=DMARC1; p=reject; pct=100",
+			effective: DMARCReject,
+			check: func(t *testing.T, p DMARCPolicy) {
+				if len(p.Warnings) != 0 || !p.Enforced() {
+					t.Errorf("warnings %q enforced=%v", p.Warnings, p.Enforced())
+				}
+			},
+		},
+		{
+			name:      "pct above 100",
+			record:    "v=DMARC1; p=reject; pct=101",
+			effective: DMARCReject,
+			err:       "pct=101 is not 0-100",
+			check: func(t *testing.T, p DMARCPolicy) {
+				if p.Pct != 100 {
+					t.Errorf("pct=%d, want the default 100", p.Pct)
+				}
+			},
+		},
+		{
+			name:      "negative pct",
+			record:    "v=DMARC1; p=reject; pct=-1",
+			effective: DMARCReject,
+			err:       "pct=-1 is not 0-100",
+		},
+		{
+			name:      "duplicate tag keeps the first",
+			record:    "v=DMARC1; p=reject; p=none",
+			effective: DMARCReject,
+			err:       "tag p appears more than once",
+		},
+		{
+			name:      "fo and ri",
+			record:    "v=DMARC1; p=none; fo=1:D:s; ri=3600",
+			effective: DMARCNone,
+			check: func(t *testing.T, p DMARCPolicy) {
+				if strings.Join(p.FO, ":") != "1:d:s" || p.RI != 3600 {
+					t.Errorf("fo=%v ri=%d", p.FO, p.RI)
+				}
+			},
+		},
+		{
+			name:      "invalid fo option and ri",
+			record:    "v=DMARC1; p=none; fo=1:x; ri=daily",
+			effective: DMARCNone,
+			err:       `option "x" is not 0, 1, d or s`,
+			check: func(t *testing.T, p DMARCPolicy) {
+				if strings.Join(p.FO, ":") != "1" || p.RI != 86400 {
+					t.Errorf("fo=%v ri=%d, want [1] and the default ri", p.FO, p.RI)
+				}
+				if !strings.Contains(strings.Join(p.Errors, "; "), "ri=daily") {
+					t.Errorf("errors %q, want ri=daily", p.Errors)
+				}
+			},
+		},
+		{
+			name:   "v not first",
+			record: "p=reject; v=DMARC1",
+			err:    `record must start with "v=DMARC1"`,
+		},
+		{
+			name:   "wrong version",
+			record: "v=DMARC2; p=reject",
+			err:    `record must start with "v=DMARC1"`,
+		},
+		{
+			name:      "size limits on rua URIs",
+			record:    "v=DMARC1; p=reject; rua=mailto:dmarc@Example.com!10m, mailto:agg@reports.test?subject=x, https://collect.test/r!1k",
+			effective: DMARCReject,
+			check: func(t *testing.T, p DMARCPolicy) {
+				want := []DMARCReportURI{
+					{URI: "mailto:dmarc@Example.com", Address: "dmarc@Example.com", Domain: "example.com", MaxSize: "10m"},
+					{URI: "mailto:agg@reports.test?subject=x", Address: "agg@reports.test", Domain: "reports.test"},
+					{URI: "https://collect.test/r", MaxSize: "1k"},
+				}
+				if len(p.RUA) != len(want) {
+					t.Fatalf("rua = %+v", p.RUA)
+				}
+				for i := range want {
+					if p.RUA[i] != want[i] {
+						t.Errorf("rua[%d] = %+v, want %+v", i, p.RUA[i], want[i])
+					}
+				}
+			},
+		},
+		{
+			name:      "invalid report address",
+			record:    "v=DMARC1; p=reject; ruf=mailto:nobody",
+			effective: DMARCReject,
+			err:       `"mailto:nobody" is not a valid mailto: address`,
+		},
+		{
+			name:      "alignment and unknown tags",
+			record:    "v=DMARC1; p=reject; adkim=s; aspf=x; foo=bar",
+			effective: DMARCReject,
+			err:       "aspf=x is not r or s",
+			warning:   "unknown tag foo",
+			check: func(t *testing.T, p DMARCPolicy) {
+				if p.ADKIM != "s" || p.ASPF != "r" {
+					t.Errorf("adkim=%s aspf=%s", p.ADKIM, p.ASPF)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ParseDMARC(tt.record)
+			if p.Effective != tt.effective {
+				t.Errorf("effective %q, want %q", p.Effective, tt.effective)
+			}
+			errs := strings.Join(p.Errors, "; ")
+			if p.Valid != (tt.err == "") || !strings.Contains(errs, tt.err) {
+				t.Errorf("valid=%v errors %q, want %q", p.Valid, errs, tt.err)
+			}
+			if warnings := strings.Join(p.Warnings, "; "); !strings.Contains(warnings, tt.warning) {
+				t.Errorf("warnings %q, want %q", warnings, tt.warning)
+			}
+			if tt.check != nil {
+				tt.check(t, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package vetting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// DNS CLIENT
+//
+// Every check resolves through one DNSClient. The default client asks the
+// upstreams in order (VETTING_DNS_SERVERS, else dnsServers, then the system
+// resolver; see dns_upstream.go for DoH/DoT) with EDNS0 and a 4096-byte
+// buffer, retries truncated UDP answers over TCP (large TXT RRsets no longer
+// lose the SPF record), and caches answers for their TTL - negative answers
+// for the SOA minimum (RFC 2308). An upstream that fails or answers
+// SERVFAIL/REFUSED moves on to the next one; NXDOMAIN and NODATA are answers.
+// Checks take the DNSClient as a parameter, so tests pass a FakeDNS
+// (dns_fake_test.go) or a dnsClient over a local dns.Server.
+//
+
+// DNSClient is the DNS access of the checks (*net.Resolver implements it).
+// A name without records is a *net.DNSError with IsNotFound set.
+type DNSClient interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// DNS servers to try (in order; any upstream spec, see dns_upstream.go)
+var dnsServers = []string{
+	"8.8.8.8:53", // Google Primary
+	"8.8.4.4:53", // Google Secondary
+	"1.1.1.1:53", // Cloudflare Primary
+	"1.0.0.1:53", // Cloudflare Secondary
+	"9.9.9.9:53", // Quad9
+}
+
+const (
+	dnsQueryTimeout   = 5 * time.Second
+	dnsUDPSize        = 4096
+	dnsMaxCacheTTL    = time.Hour
+	dnsNegativeTTL    = 5 * time.Minute // Negative answers without an SOA
+	dnsMaxCacheLength = 10000
+)
+
+var (
+	dnsClientMu      sync.Mutex
+	defaultDNSClient DNSClient
+)
+
+// defaultDNS returns the client checks resolve through (built on first use,
+// so VETTING_DNS_SERVERS from .env is honored)
+func defaultDNS() DNSClient {
+	dnsClientMu.Lock()
+	defer dnsClientMu.Unlock()
+	if defaultDNSClient == nil {
+		defaultDNSClient = newDefaultDNSClient()
+	}
+	return defaultDNSClient
+}
+
+// newDefaultDNSClient builds the client over the configured upstreams,
+// falling back to dnsServers if VETTING_DNS_SERVERS is invalid
+func newDefaultDNSClient() DNSClient {
+	c, err := NewDNSClient(nil)
+	if err != nil {
+		log.Printf("[DNS] ❌ VETTING_DNS_SERVERS: %v - using the default servers", err)
+		c, _ = NewDNSClient(dnsServers)
+	}
+	return c
+}
+
+// ConfigureDNSFromEnv builds the DNS client from VETTING_DNS_SERVERS
+// (comma-separated upstreams, e.g. "https://cloudflare-dns.com/dns-query,tls://8.8.8.8")
+func ConfigureDNSFromEnv() {
+	c := newDefaultDNSClient()
+	SetDNSClient(c)
+	if dc, ok := c.(*dnsClient); ok {
+		specs := make([]string, len(dc.upstreams))
+		for i, u := range dc.upstreams {
+			specs[i] = u.spec
+		}
+		log.Printf("[DNS] Upstreams: %s", strings.Join(specs, ", "))
+	}
+}
+
+// SetDNSClient replaces the client every check resolves through
+func SetDNSClient(c DNSClient) {
+	dnsClientMu.Lock()
+	defer dnsClientMu.Unlock()
+	defaultDNSClient = c
+}
+
+// resolvConfPath is where the system resolvers are read from
+var resolvConfPath = "/etc/resolv.conf"
+
+// configuredDNSUpstreams is VETTING_DNS_SERVERS (comma-separated upstream
+// specs) or dnsServers
+func configuredDNSUpstreams() []string {
+	var servers []string
+	for _, s := range strings.Split(os.Getenv("VETTING_DNS_SERVERS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == 0 {
+		servers = append(servers, dnsServers...)
+	}
+	return servers
+}
+
+// systemDNSUpstreams are the resolv.conf servers not already in configured
+func systemDNSUpstreams(configured []string) []string {
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil
+	}
+	var servers []string
+	for _, s := range conf.Servers {
+		if addr := net.JoinHostPort(s, conf.Port); !slices.Contains(configured, addr) && !slices.Contains(servers, addr) {
+			servers = append(servers, addr)
+		}
+	}
+	return servers
+}
+
+// dnsClient is the default DNSClient (see the section comment)
+type dnsClient struct {
+	upstreams []*dnsUpstream
+
+	mu    sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	msg     *dns.Msg
+	expires time.Time
+}
+
+// NewDNSClient returns a caching client over upstreams (specs, tried in
+// order); nil means the configured upstreams followed by the system
+// resolvers, which answer queries but are not asked for DNS consensus
+func NewDNSClient(upstreams []string) (DNSClient, error) {
+	system := false
+	if upstreams == nil {
+		upstreams = configuredDNSUpstreams()
+		system = true
+	}
+	parsed, err := parseDNSUpstreams(upstreams)
+	if err != nil {
+		return nil, err
+	}
+	if system {
+		for _, spec := range systemDNSUpstreams(upstreams) {
+			if u, err := parseDNSUpstream(spec); err == nil {
+				u.system = true
+				parsed = append(parsed, u)
+			}
+		}
+	}
+	return &dnsClient{upstreams: parsed, cache: map[string]dnsCacheEntry{}}, nil
+}
+
+// exchangeDNS sends m and retries over TCP if a UDP answer was truncated
+func exchangeDNS(ctx context.Context, client *dns.Client, m *dns.Msg, server string) (*dns.Msg, error) {
+	r, _, err := client.ExchangeContext(ctx, m, server)
+	if err == nil && r.Truncated && client.Net == "udp" {
+		tcp := *client
+		tcp.Net = "tcp"
+		r, _, err = tcp.ExchangeContext(ctx, m, server)
+	}
+	return r, err
+}
+
+// query returns the (cached) answer of the first upstream that answers
+func (c *dnsClient) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	name = dns.Fqdn(strings.ToLower(name))
+	key := name + "/" + dns.TypeToString[qtype]
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.msg, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(dnsUDPSize, false)
+
+	err := fmt.Errorf("no DNS servers configured")
+	for _, u := range orderUpstreams(c.upstreams) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		r, qerr := u.exchange(ctx, m)
+		switch {
+		case qerr != nil:
+			err = qerr
+		case r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError:
+			err = fmt.Errorf("%s from %s", dns.RcodeToString[r.Rcode], u.spec)
+		default:
+			c.store(key, r)
+			return r, nil
+		}
+		log.Printf("[DNS] %s %s failed via %s: %v", name, dns.TypeToString[qtype], u.spec, err)
+	}
+	return nil, &net.DNSError{Err: err.Error(), Name: strings.TrimSuffix(name, "."), IsTimeout: isTimeout(err)}
+}
+
+// store caches an answer for its lowest TTL (negative: SOA minimum)
+func (c *dnsClient) store(key string, r *dns.Msg) {
+	ttl := dnsMaxCacheTTL
+	for _, rr := range r.Answer {
+		ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+	}
+	if len(r.Answer) == 0 {
+		ttl = dnsNegativeTTL
+		for _, rr := range r.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(time.Duration(min(soa.Hdr.Ttl, soa.Minttl))*time.Second, dnsMaxCacheTTL)
+			}
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cache) >= dnsMaxCacheLength {
+		now := time.Now()
+		for k, e := range c.cache {
+			if now.After(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= dnsMaxCacheLength {
+			clear(c.cache)
+		}
+	}
+	c.cache[key] = dnsCacheEntry{msg: r, expires: time.Now().Add(ttl)}
+}
+
+// answers returns the records of type T in the answer of a name/qtype query
+// (following CNAMEs, which the resolver includes); none is a not-found error
+func answers[T dns.RR](ctx context.Context, c *dnsClient, name string, qtype uint16) ([]T, error) {
+	r, err := c.query(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	var out []T
+	for _, rr := range r.Answer {
+		if v, ok := rr.(T); ok {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return out, nil
+}
+
+func (c *dnsClient) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	rrs, err := answers[*dns.TXT](ctx, c, name, dns.TypeTXT)
+	var txts []string
+	for _, rr := range rrs {
+		// A record split into 255-byte strings is one value (RFC 7208 §3.3)
+		txts = append(txts, strings.Join(rr.Txt, ""))
+	}
+	return txts, err
+}
+
+func (c *dnsClient) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	rrs, err := answers[*dns.MX](ctx, c, name, dns.TypeMX)
+	var mxs []*net.MX
+	for _, rr := range rrs {
+		mxs = append(mxs, &net.MX{Host: rr.Mx, Pref: rr.Preference})
+	}
+	slices.SortStableFunc(mxs, func(a, b *net.MX) int { return int(a.Pref) - int(b.Pref) })
+	return mxs, err
+}
+
+// LookupIP looks up A ("ip4"), AAAA ("ip6") or both ("ip"); with both, one
+// failed family is ignored if the other answered
+func (c *dnsClient) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	var ips []net.IP
+	var errs []error
+	if network == "ip" || network == "ip4" {
+		rrs, err := answers[*dns.A](ctx, c, host, dns.TypeA)
+		for _, rr := range rrs {
+			ips = append(ips, rr.A)
+		}
+		errs = append(errs, err)
+	}
+	if network == "ip" || network == "ip6" {
+		rrs, err := answers[*dns.AAAA](ctx, c, host, dns.TypeAAAA)
+		for _, rr := range rrs {
+			ips = append(ips, rr.AAAA)
+		}
+		errs = append(errs, err)
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	// A lookup failure wins over "no records" in the other family
+	for _, err := range errs {
+		if err != nil && !isDNSNotFound(err) {
+			return nil, err
+		}
+	}
+	if len(errs) == 0 {
+		return nil, &net.DNSError{Err: "unsupported network " + network, Name: host}
+	}
+	return nil, errs[0]
+}
+
+func (c *dnsClient) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	arpa, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr}
+	}
+	rrs, err := answers[*dns.PTR](ctx, c, arpa, dns.TypePTR)
+	var names []string
+	for _, rr := range rrs {
+		names = append(names, rr.Ptr)
+	}
+	return names, err
+}
+
+// LookupCNAME returns the canonical name at the end of host's CNAME chain
+// (host itself if it is not an alias), like net.Resolver
+func (c *dnsClient) LookupCNAME(ctx context.Context, host string) (string, error) {
+	r, err := c.query(ctx, host, dns.TypeA)
+	if err != nil {
+		return "", err
+	}
+	if r.Rcode == dns.RcodeNameError {
+		return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	name := dns.Fqdn(strings.ToLower(host))
+	for range r.Answer { // Bounded: each step consumes one record
+		next := ""
+		for _, rr := range r.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				next = strings.ToLower(cname.Target)
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	return name, nil
+}
@@ -0,0 +1,212 @@
+package vetting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+)
+
+//
+// RESOLVER CONSENSUS
+//
+// The domain's A, MX and TXT records are asked of every healthy configured
+// upstream directly (bypassing the cache) and the answers compared. The
+// system resolvers (resolv.conf) don't vote: they are usually a local cache
+// or the hosting provider's resolver, not an independent view. Disagreement is a
+// signal of its own: split-horizon DNS, a change still propagating, or a
+// resolver being hijacked. A answers agree if they share an address - CDNs
+// and round-robin pools routinely hand different resolvers different subsets.
+//
+
+// ResolverAnswer is what one upstream answered
+type ResolverAnswer struct {
+	Upstream string   `json:"upstream"`
+	Rcode    string   `json:"rcode,omitempty"` // NOERROR, NXDOMAIN
+	Answer   []string `json:"answer,omitempty"`
+	Error    string   `json:"error,omitempty"` // No answer (timeout, SERVFAIL)
+}
+
+// DNSConsensusRecord is the answers of all upstreams for one record type
+type DNSConsensusRecord struct {
+	Type       string           `json:"type"`
+	Rcode      string           `json:"rcode"` // Of the consensus answer
+	Consensus  []string         `json:"consensus,omitempty"`
+	Agreeing   int              `json:"agreeing"` // Upstreams agreeing with the consensus
+	Answered   int              `json:"answered"`
+	Consistent bool             `json:"consistent"`
+	Answers    []ResolverAnswer `json:"answers"`
+}
+
+// DNSDivergence is an upstream answer that disagrees with the consensus
+type DNSDivergence struct {
+	Type      string   `json:"type"`
+	Upstream  string   `json:"upstream"`
+	Rcode     string   `json:"rcode"`
+	Answer    []string `json:"answer,omitempty"`
+	Consensus []string `json:"consensus,omitempty"`
+}
+
+// DNSConsensusResult - whether the upstreams agree on the domain's records
+type DNSConsensusResult struct {
+	CheckOutcome
+	Inconsistent bool                 `json:"dns_inconsistent"`
+	Divergent    []DNSDivergence      `json:"divergent,omitempty"`
+	Records      []DNSConsensusRecord `json:"records,omitempty"`
+}
+
+// dnsConsensusTypes are the record types compared
+var dnsConsensusTypes = []uint16{dns.TypeA, dns.TypeMX, dns.TypeTXT}
+
+// AnalyzeDNSConsensus compares the answers of the configured upstreams
+func AnalyzeDNSConsensus(ctx context.Context, domain string) DNSConsensusResult {
+	client, ok := defaultDNS().(*dnsClient)
+	if !ok {
+		return DNSConsensusResult{CheckOutcome: skipped("DNS client has no upstreams to compare")}
+	}
+	return analyzeDNSConsensus(ctx, consensusUpstreams(client), domain)
+}
+
+// consensusUpstreams are the healthy upstreams that are not system resolvers
+func consensusUpstreams(client *dnsClient) []*dnsUpstream {
+	var upstreams []*dnsUpstream
+	for _, u := range client.upstreams {
+		if u.healthy() && !u.system {
+			upstreams = append(upstreams, u)
+		}
+	}
+	return upstreams
+}
+
+func analyzeDNSConsensus(ctx context.Context, upstreams []*dnsUpstream, domain string) DNSConsensusResult {
+	var res DNSConsensusResult
+	if len(upstreams) < 2 {
+		res.CheckOutcome = skipped("fewer than two healthy configured DNS upstreams")
+		return res
+	}
+	name := dns.Fqdn(strings.ToLower(domain))
+
+	answers := make([][]ResolverAnswer, len(dnsConsensusTypes))
+	var g errgroup.Group
+	g.SetLimit(6)
+	for t, qtype := range dnsConsensusTypes {
+		answers[t] = make([]ResolverAnswer, len(upstreams))
+		for i, u := range upstreams {
+			g.Go(func() error {
+				answers[t][i] = askUpstream(ctx, u, name, qtype)
+				return nil
+			})
+		}
+	}
+	_ = g.Wait()
+
+	answered := false
+	for t, qtype := range dnsConsensusTypes {
+		rec := consensusOf(qtype, answers[t])
+		answered = answered || rec.Answered > 0
+		res.Records = append(res.Records, rec)
+		for _, a := range rec.Answers {
+			if a.Error == "" && !answersAgree(qtype, a, ResolverAnswer{Rcode: rec.Rcode, Answer: rec.Consensus}) {
+				res.Divergent = append(res.Divergent, DNSDivergence{
+					Type: rec.Type, Upstream: a.Upstream, Rcode: a.Rcode, Answer: a.Answer, Consensus: rec.Consensus,
+				})
+			}
+		}
+	}
+	res.Inconsistent = len(res.Divergent) > 0
+
+	if !answered {
+		res.CheckOutcome = CheckOutcome{Status: StatusError, Error: answers[0][0].Error}
+		return res
+	}
+	res.CheckOutcome = CheckOutcome{Status: StatusOK}
+
+	if res.Inconsistent {
+		for _, d := range res.Divergent {
+			log.Printf("[DNSConsensus] ⚠️ %s %s: %s answered %s %v (consensus %v)",
+				domain, d.Type, d.Upstream, d.Rcode, d.Answer, d.Consensus)
+		}
+	} else {
+		log.Printf("[DNSConsensus] ✓ %s: %d upstreams agree on A/MX/TXT", domain, len(upstreams))
+	}
+	return res
+}
+
+// askUpstream queries one upstream (uncached) and normalizes the answer
+func askUpstream(ctx context.Context, u *dnsUpstream, name string, qtype uint16) ResolverAnswer {
+	a := ResolverAnswer{Upstream: u.spec}
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(dnsUDPSize, false)
+	r, err := u.exchange(ctx, m)
+	switch {
+	case err != nil:
+		a.Error = err.Error()
+		return a
+	case r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError:
+		a.Error = dns.RcodeToString[r.Rcode]
+		return a
+	}
+	a.Rcode = dns.RcodeToString[r.Rcode]
+	for _, rr := range r.Answer {
+		var v string
+		switch rr := rr.(type) {
+		case *dns.A:
+			v = rr.A.String()
+		case *dns.MX:
+			v = fmt.Sprintf("%d %s", rr.Preference, strings.ToLower(rr.Mx))
+		case *dns.TXT:
+			v = strings.Join(rr.Txt, "")
+		default:
+			continue // CNAMEs on the way
+		}
+		if !slices.Contains(a.Answer, v) {
+			a.Answer = append(a.Answer, v)
+		}
+	}
+	slices.Sort(a.Answer)
+	return a
+}
+
+// consensusOf picks the answer most upstreams agree with (the first one
+// configured on a tie)
+func consensusOf(qtype uint16, answers []ResolverAnswer) DNSConsensusRecord {
+	rec := DNSConsensusRecord{Type: dns.TypeToString[qtype], Answers: answers}
+	best := -1
+	for i, a := range answers {
+		if a.Error != "" {
+			continue
+		}
+		rec.Answered++
+		agreeing := 0
+		for _, b := range answers {
+			if b.Error == "" && answersAgree(qtype, a, b) {
+				agreeing++
+			}
+		}
+		if agreeing > rec.Agreeing {
+			rec.Agreeing, best = agreeing, i
+		}
+	}
+	if best >= 0 {
+		rec.Rcode, rec.Consensus = answers[best].Rcode, answers[best].Answer
+	}
+	rec.Consistent = rec.Answered > 0 && rec.Agreeing == rec.Answered
+	return rec
+}
+
+// answersAgree compares two answers: the same rcode and records, or for A
+// records at least one shared address
+func answersAgree(qtype uint16, a, b ResolverAnswer) bool {
+	if a.Rcode != b.Rcode {
+		return false
+	}
+	if qtype == dns.TypeA && len(a.Answer) > 0 && len(b.Answer) > 0 {
+		return slices.ContainsFunc(a.Answer, func(ip string) bool { return slices.Contains(b.Answer, ip) })
+	}
+	return slices.Equal(a.Answer, b.Answer)
+}
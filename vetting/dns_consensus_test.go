@@ -0,0 +1,103 @@
+package vetting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestConsensusUpstreamsExcludeSystemResolvers(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "resolv.conf")
+	// 192.0.2.1 is also configured: listed once, as configured
+	if err := os.WriteFile(conf, []byte("nameserver 192.0.2.1\nnameserver 192.0.2.53\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := resolvConfPath
+	resolvConfPath = conf
+	t.Cleanup(func() { resolvConfPath = old })
+	t.Setenv("VETTING_DNS_SERVERS", "192.0.2.1:53, tls://192.0.2.2")
+
+	c, err := NewDNSClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*dnsClient)
+	var all, voting []string
+	for _, u := range client.upstreams {
+		all = append(all, u.spec)
+	}
+	for _, u := range consensusUpstreams(client) {
+		voting = append(voting, u.spec)
+	}
+	if want := []string{"192.0.2.1:53", "tls://192.0.2.2", "192.0.2.53:53"}; !slices.Equal(all, want) {
+		t.Errorf("upstreams = %v, want %v", all, want)
+	}
+	if want := []string{"192.0.2.1:53", "tls://192.0.2.2"}; !slices.Equal(voting, want) {
+		t.Errorf("consensus upstreams = %v, want %v", voting, want)
+	}
+
+	// An explicit list has no system resolvers
+	c, err = NewDNSClient([]string{"192.0.2.1:53"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.(*dnsClient).upstreams); n != 1 {
+		t.Errorf("explicit client has %d upstreams, want 1", n)
+	}
+}
+
+func TestAnalyzeDNSConsensus(t *testing.T) {
+	common := []string{
+		"example.test. 300 IN MX 10 mx.example.test.",
+		"example.test. 300 IN TXT \"v=spf1 -all\"",
+	}
+	server := func(records ...string) string {
+		return startTestDNSServer(t, &staticZone{records: mustRRs(t, append(records, common...)...)})
+	}
+	upstreams := func(addrs ...string) []*dnsUpstream {
+		var out []*dnsUpstream
+		for _, addr := range addrs {
+			u, err := parseDNSUpstream(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, u)
+		}
+		return out
+	}
+	a := server("example.test. 300 IN A 192.0.2.1", "example.test. 300 IN A 192.0.2.2")
+	b := server("example.test. 300 IN A 192.0.2.2")
+	hijacked := server("example.test. 300 IN A 203.0.113.66")
+
+	t.Run("overlapping A answers agree", func(t *testing.T) {
+		res := analyzeDNSConsensus(context.Background(), upstreams(a, b), "example.test")
+		if res.Status != StatusOK || res.Inconsistent || len(res.Divergent) != 0 {
+			t.Errorf("result %+v, want consistent", res)
+		}
+		for _, rec := range res.Records {
+			if !rec.Consistent || rec.Answered != 2 {
+				t.Errorf("%s: consistent=%v answered=%d", rec.Type, rec.Consistent, rec.Answered)
+			}
+		}
+	})
+
+	t.Run("divergent upstream", func(t *testing.T) {
+		res := analyzeDNSConsensus(context.Background(), upstreams(a, b, hijacked), "example.test")
+		if !res.Inconsistent || len(res.Divergent) != 1 {
+			t.Fatalf("divergent = %+v, want one", res.Divergent)
+		}
+		d := res.Divergent[0]
+		if d.Type != "A" || d.Upstream != hijacked || !slices.Equal(d.Answer, []string{"203.0.113.66"}) {
+			t.Errorf("divergence = %+v", d)
+		}
+	})
+
+	t.Run("one upstream", func(t *testing.T) {
+		res := analyzeDNSConsensus(context.Background(), upstreams(a), "example.test")
+		if res.Status != StatusSkipped {
+			t.Errorf("status %s, want skipped", res.Status)
+		}
+	})
+}
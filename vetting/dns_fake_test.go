@@ -0,0 +1,78 @@
+package vetting
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// FakeDNS is an in-memory DNSClient for tests. Names are matched without
+// case or trailing dot; a name missing from a map has no records of that
+// type (not found), and a name in Errors fails every lookup with that error.
+type FakeDNS struct {
+	TXT    map[string][]string
+	MX     map[string][]*net.MX
+	IP     map[string][]net.IP
+	PTR    map[string][]string // By address
+	CNAME  map[string]string
+	Errors map[string]error
+}
+
+// fakeKey normalizes a name for the FakeDNS maps
+func fakeKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// fakeLookup returns the records of name in m (or its error / not found)
+func fakeLookup[T any](f *FakeDNS, m map[string]T, name string) (T, error) {
+	var zero T
+	if err, ok := f.Errors[fakeKey(name)]; ok {
+		return zero, err
+	}
+	for k, v := range m {
+		if fakeKey(k) == fakeKey(name) {
+			return v, nil
+		}
+	}
+	return zero, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *FakeDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return fakeLookup(f, f.TXT, name)
+}
+
+func (f *FakeDNS) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return fakeLookup(f, f.MX, name)
+}
+
+func (f *FakeDNS) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, err := fakeLookup(f, f.IP, host)
+	if err != nil {
+		return nil, err
+	}
+	var out []net.IP
+	for _, ip := range ips {
+		if network == "ip" || (network == "ip4") == (ip.To4() != nil) {
+			out = append(out, ip)
+		}
+	}
+	if len(out) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return out, nil
+}
+
+func (f *FakeDNS) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return fakeLookup(f, f.PTR, addr)
+}
+
+// LookupCNAME returns the alias target, or host itself if it has addresses
+func (f *FakeDNS) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if target, err := fakeLookup(f, f.CNAME, host); err == nil {
+		return fakeKey(target) + ".", nil
+	}
+	if _, err := fakeLookup(f, f.IP, host); err != nil {
+		return "", err
+	}
+	return fakeKey(host) + ".", nil
+}
@@ -0,0 +1,470 @@
+package vetting
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+)
+
+//
+// DNS HEALTH
+//
+// Delegation and zone hygiene of the vetted domain. The zone and its NS set
+// come from the resolvers; every name server is then asked for the zone's SOA
+// directly (no recursion) - one that doesn't answer authoritatively is lame.
+// If none of them answers at all the network is the likelier culprit (outbound
+// UDP/53 blocked) and lameness is left unchecked. Name server ASNs come from
+// Team Cymru's IP-to-ASN zone, so the check is DNS only.
+//
+// Dangling CNAMEs: an alias of the domain (or a common subdomain) whose
+// target is NXDOMAIN. On hosting services where released names can be
+// registered by anyone the alias can be taken over - the new owner serves
+// content (and collects cookies) under the domain.
+//
+
+const (
+	dnsHealthNSTimeout = 3 * time.Second // Per direct name server query
+	dnsHealthParallel  = 4
+)
+
+// dnsHealthAliasLabels are the subdomains checked for dangling CNAMEs (with
+// the domain itself): the ones most often pointed at SaaS and marketing tools
+var dnsHealthAliasLabels = []string{
+	"www", "mail", "email", "click", "links", "track", "go", "blog", "shop",
+	"store", "help", "support", "docs", "status", "careers", "cdn", "app",
+}
+
+// takeoverSuffixes are hosting services answering NXDOMAIN for unclaimed
+// names that anyone can then claim (can-i-take-over-xyz)
+var takeoverSuffixes = []struct {
+	suffix, provider string
+}{
+	{".azurewebsites.net", "Azure App Service"},
+	{".cloudapp.net", "Azure Cloud Services"},
+	{".cloudapp.azure.com", "Azure VM"},
+	{".trafficmanager.net", "Azure Traffic Manager"},
+	{".blob.core.windows.net", "Azure Blob Storage"},
+	{".azureedge.net", "Azure CDN"},
+	{".azure-api.net", "Azure API Management"},
+	{".azurecontainer.io", "Azure Container Instances"},
+	{".azurefd.net", "Azure Front Door"},
+	{".elasticbeanstalk.com", "AWS Elastic Beanstalk"},
+	{".herokuapp.com", "Heroku"},
+	{".herokudns.com", "Heroku"},
+	{".ngrok.io", "ngrok"},
+	{".surge.sh", "Surge"},
+}
+
+// NameServer is one NS of the zone and how it answered for it
+type NameServer struct {
+	Name          string   `json:"name"`
+	Addresses     []string `json:"addresses,omitempty"`
+	ASN           int      `json:"asn,omitempty"`
+	Authoritative bool     `json:"authoritative"` // Answered the zone's SOA with AA set
+	Serial        uint32   `json:"serial,omitempty"`
+	Error         string   `json:"error,omitempty"` // Why it is (or may be) lame
+}
+
+// SOARecord is the zone's SOA (timers in seconds)
+type SOARecord struct {
+	MName   string `json:"mname"`
+	RName   string `json:"rname"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"` // Negative caching TTL (RFC 2308)
+}
+
+// DanglingCNAME is an alias whose target does not exist
+type DanglingCNAME struct {
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Provider string `json:"provider,omitempty"` // Hosting service of the target
+	Takeover bool   `json:"takeover"`           // Anyone can claim the target on Provider
+}
+
+// DNSHealthResult - delegation and zone hygiene of the domain
+type DNSHealthResult struct {
+	CheckOutcome
+	Zone           string          `json:"zone,omitempty"`
+	NameServers    []NameServer    `json:"nameservers,omitempty"`
+	NSCount        int             `json:"ns_count"`
+	ASNs           []int           `json:"asns,omitempty"`   // Distinct origin ASNs of the name servers
+	IPv6NS         int             `json:"ipv6_nameservers"` // Name servers with an AAAA record
+	LameChecked    bool            `json:"lame_checked"`     // At least one name server answered
+	Lame           []string        `json:"lame,omitempty"`   // Name servers not authoritative for the zone
+	SOA            *SOARecord      `json:"soa,omitempty"`
+	SOAIssues      []string        `json:"soa_issues,omitempty"`
+	SerialMismatch bool            `json:"serial_mismatch"` // Name servers serve different serials
+	WildcardA      bool            `json:"wildcard_a"`
+	WildcardMX     bool            `json:"wildcard_mx"`
+	ApexCNAME      string          `json:"apex_cname,omitempty"` // Target of a CNAME at the zone apex
+	Dangling       []DanglingCNAME `json:"dangling_cnames,omitempty"`
+	HasAAAA        bool            `json:"has_aaaa"`
+}
+
+// Takeovers lists the dangling CNAMEs that can be taken over ("name -> target (provider)")
+func (r DNSHealthResult) Takeovers() []string {
+	var out []string
+	for _, d := range r.Dangling {
+		if d.Takeover {
+			out = append(out, fmt.Sprintf("%s -> %s (%s)", d.Name, d.Target, d.Provider))
+		}
+	}
+	return out
+}
+
+// dnsHealthProbe resolves through client and asks name servers on nsPort
+type dnsHealthProbe struct {
+	client *dnsClient
+	nsPort string
+}
+
+// AnalyzeDNSHealth checks the delegation and zone of the domain
+func AnalyzeDNSHealth(ctx context.Context, domain string) DNSHealthResult {
+	// Raw SOA/NS queries go through the default client
+	client, ok := defaultDNS().(*dnsClient)
+	if !ok {
+		return DNSHealthResult{CheckOutcome: skipped("DNS client does not send raw queries")}
+	}
+	p := &dnsHealthProbe{client: client, nsPort: "53"}
+	return p.analyze(ctx, domain)
+}
+
+func (p *dnsHealthProbe) analyze(ctx context.Context, domain string) DNSHealthResult {
+	var res DNSHealthResult
+	name := dns.Fqdn(strings.ToLower(domain))
+
+	zone, soa, cname, err := p.findZone(ctx, name)
+	if err != nil {
+		if isDNSNotFound(err) {
+			res.CheckOutcome = CheckOutcome{Status: StatusNegative, Error: "domain does not exist"}
+		} else {
+			res.CheckOutcome = outcomeOf(err)
+		}
+		log.Printf("[DNSHealth] ❌ %s: %v", domain, err)
+		return res
+	}
+	res.Zone = strings.TrimSuffix(zone, ".")
+	if cname != "" && zone == name {
+		res.ApexCNAME = strings.TrimSuffix(cname, ".")
+	}
+
+	hosts, err := p.nameServers(ctx, zone)
+	if err != nil {
+		res.CheckOutcome = outcomeOf(err)
+		log.Printf("[DNSHealth] ❌ %s: NS %s: %v", domain, zone, err)
+		return res
+	}
+	res.CheckOutcome = CheckOutcome{Status: StatusOK}
+	res.NSCount = len(hosts)
+
+	// Name servers
+	res.NameServers = make([]NameServer, len(hosts))
+	soas := make([]*dns.SOA, len(hosts))
+	answered := make([]bool, len(hosts))
+	var g errgroup.Group
+	g.SetLimit(dnsHealthParallel)
+	for i, host := range hosts {
+		g.Go(func() error {
+			res.NameServers[i], soas[i], answered[i] = p.askNameServer(ctx, host, zone)
+			return nil
+		})
+	}
+
+	// Zone contents (in parallel with the name servers)
+	g.Go(func() error {
+		res.WildcardA, res.WildcardMX = p.wildcardProbe(ctx, zone)
+		return nil
+	})
+	g.Go(func() error {
+		ips, _ := p.client.LookupIP(ctx, "ip6", name)
+		res.HasAAAA = len(ips) > 0
+		return nil
+	})
+	aliases := []string{name}
+	for _, label := range dnsHealthAliasLabels {
+		if alias := label + "." + zone; alias != name {
+			aliases = append(aliases, alias)
+		}
+	}
+	dangling := make([]*DanglingCNAME, len(aliases))
+	for i, alias := range aliases {
+		g.Go(func() error {
+			dangling[i] = p.danglingCNAME(ctx, alias)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	for _, d := range dangling {
+		if d != nil {
+			res.Dangling = append(res.Dangling, *d)
+		}
+	}
+
+	asns := map[int]bool{}
+	var serials []uint32
+	for i, ns := range res.NameServers {
+		if ns.ASN > 0 && !asns[ns.ASN] {
+			asns[ns.ASN] = true
+			res.ASNs = append(res.ASNs, ns.ASN)
+		}
+		if slices.ContainsFunc(ns.Addresses, func(a string) bool { return strings.Contains(a, ":") }) {
+			res.IPv6NS++
+		}
+		res.LameChecked = res.LameChecked || answered[i]
+		if ns.Authoritative {
+			if !slices.Contains(serials, ns.Serial) {
+				serials = append(serials, ns.Serial)
+			}
+			if soa == nil {
+				soa = soas[i]
+			}
+		}
+	}
+	slices.Sort(res.ASNs)
+	res.SerialMismatch = len(serials) > 1
+	if res.LameChecked {
+		for _, ns := range res.NameServers {
+			if !ns.Authoritative {
+				res.Lame = append(res.Lame, ns.Name)
+			}
+		}
+	}
+	if soa != nil {
+		res.SOA = &SOARecord{
+			MName: strings.TrimSuffix(soa.Ns, "."), RName: strings.TrimSuffix(soa.Mbox, "."),
+			Serial: soa.Serial, Refresh: soa.Refresh, Retry: soa.Retry, Expire: soa.Expire, Minimum: soa.Minttl,
+		}
+		res.SOAIssues = soaTimerIssues(res.SOA)
+	}
+
+	var problems []string
+	if res.NSCount < 2 {
+		problems = append(problems, fmt.Sprintf("%d name server(s)", res.NSCount))
+	}
+	if len(res.Lame) > 0 {
+		problems = append(problems, "lame: "+strings.Join(res.Lame, ", "))
+	}
+	if !res.LameChecked {
+		problems = append(problems, "no name server reachable (lame delegation not checked)")
+	}
+	if res.SerialMismatch {
+		problems = append(problems, fmt.Sprintf("SOA serials differ %v", serials))
+	}
+	problems = append(problems, res.SOAIssues...)
+	if res.ApexCNAME != "" {
+		problems = append(problems, "CNAME at apex -> "+res.ApexCNAME)
+	}
+	if res.WildcardMX {
+		problems = append(problems, "wildcard MX")
+	}
+	for _, d := range res.Dangling {
+		problems = append(problems, fmt.Sprintf("dangling CNAME %s -> %s", d.Name, d.Target))
+	}
+	if len(problems) > 0 {
+		log.Printf("[DNSHealth] ⚠️ %s: %s", domain, strings.Join(problems, "; "))
+	} else {
+		log.Printf("[DNSHealth] ✓ %s: %d name servers in %d ASNs", domain, res.NSCount, len(res.ASNs))
+	}
+	return res
+}
+
+// findZone returns the zone holding name and its SOA as the resolvers see
+// it, and the CNAME target if name is an alias
+func (p *dnsHealthProbe) findZone(ctx context.Context, name string) (string, *dns.SOA, string, error) {
+	r, err := p.client.query(ctx, name, dns.TypeSOA)
+	if err != nil {
+		return "", nil, "", err
+	}
+	cname := ""
+	for _, rr := range r.Answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.SOA:
+			return name, rr, "", nil
+		case *dns.CNAME:
+			cname = strings.ToLower(rr.Target)
+		}
+	}
+	if cname == "" {
+		if r.Rcode == dns.RcodeNameError {
+			return "", nil, "", &net.DNSError{Err: "no such host", Name: strings.TrimSuffix(name, "."), IsNotFound: true}
+		}
+		for _, rr := range r.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return strings.ToLower(soa.Hdr.Name), soa, "", nil
+			}
+		}
+	}
+	// The SOA after a CNAME is the target's: fall back to the registrable domain
+	return dns.Fqdn(organizationalDomain(name)), nil, cname, nil
+}
+
+// nameServers returns the sorted NS hosts of zone
+func (p *dnsHealthProbe) nameServers(ctx context.Context, zone string) ([]string, error) {
+	r, err := p.client.query(ctx, zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, rr := range r.Answer {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, zone) {
+			if host := strings.ToLower(ns.Ns); !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	slices.Sort(hosts)
+	return hosts, nil
+}
+
+// askNameServer resolves host and asks its first address (IPv4 preferred) for
+// the SOA of zone; answered is false if it didn't answer at all
+func (p *dnsHealthProbe) askNameServer(ctx context.Context, host, zone string) (ns NameServer, soa *dns.SOA, answered bool) {
+	ns.Name = strings.TrimSuffix(host, ".")
+	ips, err := p.client.LookupIP(ctx, "ip", host)
+	if err != nil {
+		ns.Error = "does not resolve: " + err.Error()
+		return ns, nil, false
+	}
+	addr := ips[0]
+	for _, ip := range ips {
+		ns.Addresses = append(ns.Addresses, ip.String())
+	}
+	if i := slices.IndexFunc(ips, func(ip net.IP) bool { return ip.To4() != nil }); i >= 0 {
+		addr = ips[i]
+	}
+	ns.ASN, _ = originASN(ctx, p.client, addr)
+
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+	m.RecursionDesired = false
+	m.SetEdns0(dnsUDPSize, false)
+	qctx, cancel := context.WithTimeout(ctx, dnsHealthNSTimeout)
+	defer cancel()
+	client := &dns.Client{Net: "udp", Timeout: dnsHealthNSTimeout, UDPSize: dnsUDPSize}
+	r, err := exchangeDNS(qctx, client, m, net.JoinHostPort(addr.String(), p.nsPort))
+	switch {
+	case err != nil:
+		ns.Error = err.Error()
+		return ns, nil, false
+	case r.Rcode != dns.RcodeSuccess:
+		ns.Error = fmt.Sprintf("%s for %s", dns.RcodeToString[r.Rcode], zone)
+		return ns, nil, true
+	case !r.Authoritative:
+		ns.Error = "answer is not authoritative"
+		return ns, nil, true
+	}
+	for _, rr := range r.Answer {
+		if s, ok := rr.(*dns.SOA); ok && strings.EqualFold(s.Hdr.Name, zone) {
+			ns.Authoritative, ns.Serial = true, s.Serial
+			return ns, s, true
+		}
+	}
+	ns.Error = "no SOA in the answer"
+	return ns, nil, true
+}
+
+// originASN looks up the AS announcing ip (Team Cymru IP-to-ASN over DNS)
+func originASN(ctx context.Context, client DNSClient, ip net.IP) (int, error) {
+	arpa, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return 0, err
+	}
+	name := strings.TrimSuffix(arpa, "in-addr.arpa.") + "origin.asn.cymru.com"
+	if ip.To4() == nil {
+		name = strings.TrimSuffix(arpa, "ip6.arpa.") + "origin6.asn.cymru.com"
+	}
+	txts, err := client.LookupTXT(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	// "15169 | 8.8.8.0/24 | US | arin | 2023-12-28" (several origins space-separated)
+	for _, txt := range txts {
+		field, _, _ := strings.Cut(txt, "|")
+		if asn := parseASN(strings.TrimSpace(field)); asn > 0 {
+			return asn, nil
+		}
+	}
+	return 0, nil
+}
+
+// wildcardProbe asks for A and MX records of a random name in zone
+func (p *dnsHealthProbe) wildcardProbe(ctx context.Context, zone string) (wildcardA, wildcardMX bool) {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	probe := fmt.Sprintf("wildcard-probe-%x.%s", b, zone)
+	if r, err := p.client.query(ctx, probe, dns.TypeA); err == nil {
+		wildcardA = slices.ContainsFunc(r.Answer, func(rr dns.RR) bool { _, ok := rr.(*dns.A); return ok })
+	}
+	if r, err := p.client.query(ctx, probe, dns.TypeMX); err == nil {
+		wildcardMX = slices.ContainsFunc(r.Answer, func(rr dns.RR) bool { _, ok := rr.(*dns.MX); return ok })
+	}
+	return wildcardA, wildcardMX
+}
+
+// danglingCNAME returns name's alias if the end of its CNAME chain is NXDOMAIN
+func (p *dnsHealthProbe) danglingCNAME(ctx context.Context, name string) *DanglingCNAME {
+	r, err := p.client.query(ctx, name, dns.TypeA)
+	if err != nil || r.Rcode != dns.RcodeNameError {
+		return nil
+	}
+	target := name
+	for range r.Answer { // Bounded: each step consumes one record
+		next := ""
+		for _, rr := range r.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, target) {
+				next = strings.ToLower(cname.Target)
+			}
+		}
+		if next == "" {
+			break
+		}
+		target = next
+	}
+	if target == name {
+		return nil // The name itself doesn't exist
+	}
+	d := &DanglingCNAME{Name: strings.TrimSuffix(name, "."), Target: strings.TrimSuffix(target, ".")}
+	for _, s := range takeoverSuffixes {
+		if strings.HasSuffix(d.Target, s.suffix) {
+			d.Provider, d.Takeover = s.provider, true
+			break
+		}
+	}
+	return d
+}
+
+// soaTimerIssues checks the SOA timers: RFC 1912 §2.2 ranges widened to what
+// the large DNS providers publish, negative TTL per RFC 2308 §5
+func soaTimerIssues(soa *SOARecord) []string {
+	var issues []string
+	check := func(timer string, v, lo, hi uint32) {
+		if v < lo || v > hi {
+			issues = append(issues, fmt.Sprintf("SOA %s %ds outside %d-%ds", timer, v, lo, hi))
+		}
+	}
+	check("refresh", soa.Refresh, 1200, 86400)
+	check("expire", soa.Expire, 604800, 3628800)
+	check("minimum", soa.Minimum, 300, 86400)
+	if soa.Retry == 0 || soa.Retry >= soa.Refresh {
+		issues = append(issues, fmt.Sprintf("SOA retry %ds not below refresh %ds", soa.Retry, soa.Refresh))
+	}
+	if soa.Expire <= soa.Refresh {
+		issues = append(issues, fmt.Sprintf("SOA expire %ds not above refresh %ds", soa.Expire, soa.Refresh))
+	}
+	return issues
+}
@@ -0,0 +1,293 @@
+package vetting
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSOATimerIssues(t *testing.T) {
+	tests := []struct {
+		name string
+		soa  SOARecord
+		want []string
+	}{
+		{"recommended", SOARecord{Refresh: 7200, Retry: 900, Expire: 1209600, Minimum: 3600}, nil},
+		{"range limits", SOARecord{Refresh: 1200, Retry: 1199, Expire: 604800, Minimum: 300}, nil},
+		{"upper limits", SOARecord{Refresh: 86400, Retry: 7200, Expire: 3628800, Minimum: 86400}, nil},
+		{"refresh too short", SOARecord{Refresh: 600, Retry: 300, Expire: 1209600, Minimum: 3600},
+			[]string{"SOA refresh 600s outside 1200-86400s"}},
+		{"refresh too long", SOARecord{Refresh: 172800, Retry: 900, Expire: 1209600, Minimum: 3600},
+			[]string{"SOA refresh 172800s outside 1200-86400s"}},
+		{"expire too short", SOARecord{Refresh: 7200, Retry: 900, Expire: 86400, Minimum: 3600},
+			[]string{"SOA expire 86400s outside 604800-3628800s"}},
+		{"expire too long", SOARecord{Refresh: 7200, Retry: 900, Expire: 4000000, Minimum: 3600},
+			[]string{"SOA expire 4000000s outside 604800-3628800s"}},
+		{"negative TTL too short", SOARecord{Refresh: 7200, Retry: 900, Expire: 1209600, Minimum: 60},
+			[]string{"SOA minimum 60s outside 300-86400s"}},
+		{"negative TTL too long", SOARecord{Refresh: 7200, Retry: 900, Expire: 1209600, Minimum: 604800},
+			[]string{"SOA minimum 604800s outside 300-86400s"}},
+		{"no retry", SOARecord{Refresh: 7200, Retry: 0, Expire: 1209600, Minimum: 3600},
+			[]string{"SOA retry 0s not below refresh 7200s"}},
+		{"retry equals refresh", SOARecord{Refresh: 7200, Retry: 7200, Expire: 1209600, Minimum: 3600},
+			[]string{"SOA retry 7200s not below refresh 7200s"}},
+		{"expire not above refresh", SOARecord{Refresh: 3600, Retry: 600, Expire: 3600, Minimum: 3600},
+			[]string{"SOA expire 3600s outside 604800-3628800s", "SOA expire 3600s not above refresh 3600s"}},
+		{"all zero", SOARecord{},
+			[]string{
+				"SOA refresh 0s outside 1200-86400s",
+				"SOA expire 0s outside 604800-3628800s",
+				"SOA minimum 0s outside 300-86400s",
+				"SOA retry 0s not below refresh 0s",
+				"SOA expire 0s not above refresh 0s",
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := soaTimerIssues(&tt.soa); !slices.Equal(got, tt.want) {
+				t.Errorf("soaTimerIssues = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+const testZoneSOA = "example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 2026101601 7200 900 1209600 3600"
+
+// testZoneRecords is example.test as the resolvers see it: name servers on
+// 127.0.0.2-4 (the test name servers listen there)
+var testZoneRecords = []string{
+	testZoneSOA,
+	"example.test. 3600 IN NS ns1.example.test.",
+	"example.test. 3600 IN NS ns2.example.test.",
+	"example.test. 3600 IN NS ns3.example.test.",
+	"ns1.example.test. 3600 IN A 127.0.0.2",
+	"ns2.example.test. 3600 IN A 127.0.0.3",
+	"ns3.example.test. 3600 IN A 127.0.0.4",
+	"example.test. 300 IN A 192.0.2.10",
+	"example.test. 300 IN MX 10 mx.example.test.",
+	"mx.example.test. 300 IN A 192.0.2.25",
+	"shop.example.test. 300 IN A 192.0.2.11",
+}
+
+// testNameServer answers for example.test with soa, authoritatively or not
+func testNameServer(t *testing.T, soa string, aa bool) dns.Handler {
+	return &staticZone{records: mustRRs(t, soa), soa: mustRRs(t, soa)[0], aa: aa}
+}
+
+// refusingServer answers every query with REFUSED
+var refusingServer = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeRefused)
+	_ = w.WriteMsg(m)
+})
+
+// newTestDNSHealthProbe serves records from a resolver on 127.0.0.1 and the
+// name servers on their addresses, and returns a probe using them
+func newTestDNSHealthProbe(t *testing.T, records []string, nameServers map[string]dns.Handler) *dnsHealthProbe {
+	t.Helper()
+	handlers := map[string]dns.Handler{
+		"127.0.0.1": &staticZone{records: mustRRs(t, records...), soa: mustRRs(t, testZoneSOA)[0]},
+	}
+	for ip, h := range nameServers {
+		handlers[ip] = h
+	}
+	port := startTestDNSServers(t, handlers)
+	return &dnsHealthProbe{client: testDNSClient(t, "127.0.0.1:"+port), nsPort: port}
+}
+
+func TestAnalyzeDNSHealthNameServers(t *testing.T) {
+	serial2 := "example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 2026101602 7200 900 1209600 3600"
+
+	tests := []struct {
+		name        string
+		records     []string
+		nameServers map[string]dns.Handler
+		wantChecked bool
+		wantLame    []string
+		wantSerials bool // Serial mismatch
+	}{
+		{
+			name:    "all authoritative",
+			records: testZoneRecords,
+			nameServers: map[string]dns.Handler{
+				"127.0.0.2": testNameServer(t, testZoneSOA, true),
+				"127.0.0.3": testNameServer(t, testZoneSOA, true),
+				"127.0.0.4": testNameServer(t, testZoneSOA, true),
+			},
+			wantChecked: true,
+		},
+		{
+			name:    "not authoritative, refused and unreachable are lame",
+			records: slices.Concat(testZoneRecords, []string{"example.test. 3600 IN NS ns4.example.test.", "ns4.example.test. 3600 IN A 127.0.0.9"}),
+			nameServers: map[string]dns.Handler{
+				"127.0.0.2": testNameServer(t, testZoneSOA, true),
+				"127.0.0.3": testNameServer(t, testZoneSOA, false),
+				"127.0.0.4": refusingServer,
+			},
+			wantChecked: true,
+			wantLame:    []string{"ns2.example.test", "ns3.example.test", "ns4.example.test"},
+		},
+		{
+			name:    "serials differ",
+			records: testZoneRecords,
+			nameServers: map[string]dns.Handler{
+				"127.0.0.2": testNameServer(t, testZoneSOA, true),
+				"127.0.0.3": testNameServer(t, serial2, true),
+				"127.0.0.4": testNameServer(t, testZoneSOA, true),
+			},
+			wantChecked: true,
+			wantSerials: true,
+		},
+		{
+			// Nothing listens on the name server addresses: the network, not
+			// the delegation, is the likelier problem
+			name:        "no name server answers",
+			records:     testZoneRecords,
+			wantChecked: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestDNSHealthProbe(t, tt.records, tt.nameServers)
+			res := p.analyze(context.Background(), "example.test")
+
+			if res.Status != StatusOK || res.Zone != "example.test" {
+				t.Fatalf("status %s, zone %q: %s", res.Status, res.Zone, res.Error)
+			}
+			if want := len(tt.nameServers); want > 0 && res.NSCount < want {
+				t.Errorf("NSCount = %d, want at least %d", res.NSCount, want)
+			}
+			if res.LameChecked != tt.wantChecked {
+				t.Errorf("LameChecked = %v, want %v", res.LameChecked, tt.wantChecked)
+			}
+			if !slices.Equal(res.Lame, tt.wantLame) {
+				t.Errorf("Lame = %v, want %v", res.Lame, tt.wantLame)
+			}
+			if res.SerialMismatch != tt.wantSerials {
+				t.Errorf("SerialMismatch = %v, want %v", res.SerialMismatch, tt.wantSerials)
+			}
+			if !tt.wantChecked {
+				return
+			}
+			if res.SOA == nil || res.SOA.MName != "ns1.example.test" || res.SOA.Refresh != 7200 {
+				t.Errorf("SOA = %+v", res.SOA)
+			} else if len(res.SOAIssues) != 0 {
+				t.Errorf("SOAIssues = %v, want none", res.SOAIssues)
+			}
+			for _, ns := range res.NameServers {
+				if ns.Authoritative != !slices.Contains(tt.wantLame, ns.Name) {
+					t.Errorf("%s: authoritative=%v (%s)", ns.Name, ns.Authoritative, ns.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeDNSHealthZone(t *testing.T) {
+	nameServers := map[string]dns.Handler{
+		"127.0.0.2": testNameServer(t, testZoneSOA, true),
+		"127.0.0.3": testNameServer(t, testZoneSOA, true),
+		"127.0.0.4": testNameServer(t, testZoneSOA, true),
+	}
+	records := func(extra ...string) []string {
+		return slices.Concat(testZoneRecords, extra)
+	}
+
+	tests := []struct {
+		name    string
+		domain  string
+		records []string
+		check   func(t *testing.T, res DNSHealthResult)
+	}{
+		{
+			name:    "clean zone",
+			domain:  "example.test",
+			records: records(),
+			check: func(t *testing.T, res DNSHealthResult) {
+				if res.WildcardA || res.WildcardMX || res.ApexCNAME != "" || len(res.Dangling) != 0 {
+					t.Errorf("wildcard A/MX %v/%v, apex CNAME %q, dangling %v; want none",
+						res.WildcardA, res.WildcardMX, res.ApexCNAME, res.Dangling)
+				}
+			},
+		},
+		{
+			name:    "subdomain is checked in its zone",
+			domain:  "shop.example.test",
+			records: records(),
+			check: func(t *testing.T, res DNSHealthResult) {
+				if res.Zone != "example.test" || res.NSCount != 3 {
+					t.Errorf("zone %q with %d name servers, want example.test with 3", res.Zone, res.NSCount)
+				}
+			},
+		},
+		{
+			name:    "wildcard A and MX",
+			domain:  "example.test",
+			records: records("*.example.test. 300 IN A 192.0.2.99", "*.example.test. 300 IN MX 10 mx.example.test."),
+			check: func(t *testing.T, res DNSHealthResult) {
+				if !res.WildcardA || !res.WildcardMX {
+					t.Errorf("wildcard A/MX = %v/%v, want both", res.WildcardA, res.WildcardMX)
+				}
+			},
+		},
+		{
+			name:   "dangling CNAMEs",
+			domain: "example.test",
+			records: records(
+				"www.example.test. 300 IN CNAME gone.azurewebsites.net.",
+				"blog.example.test. 300 IN CNAME old.blog-host.test.",
+				"docs.example.test. 300 IN CNAME live.example.test.",
+				"live.example.test. 300 IN A 192.0.2.12",
+			),
+			check: func(t *testing.T, res DNSHealthResult) {
+				want := []DanglingCNAME{
+					{Name: "www.example.test", Target: "gone.azurewebsites.net", Provider: "Azure App Service", Takeover: true},
+					{Name: "blog.example.test", Target: "old.blog-host.test"},
+				}
+				if !slices.Equal(res.Dangling, want) {
+					t.Errorf("Dangling = %+v, want %+v", res.Dangling, want)
+				}
+				if got := res.Takeovers(); !slices.Equal(got, []string{"www.example.test -> gone.azurewebsites.net (Azure App Service)"}) {
+					t.Errorf("Takeovers = %v", got)
+				}
+			},
+		},
+		{
+			name:   "CNAME at the apex",
+			domain: "example.test",
+			records: append(slices.DeleteFunc(records(), func(r string) bool {
+				rr, _ := dns.NewRR(r)
+				h := rr.Header()
+				return h.Name == "example.test." && (h.Rrtype == dns.TypeSOA || h.Rrtype == dns.TypeA || h.Rrtype == dns.TypeMX)
+			}), "example.test. 300 IN CNAME site.provider.test.", "site.provider.test. 300 IN A 192.0.2.80"),
+			check: func(t *testing.T, res DNSHealthResult) {
+				if res.Zone != "example.test" || res.ApexCNAME != "site.provider.test" {
+					t.Errorf("zone %q, apex CNAME %q; want example.test -> site.provider.test", res.Zone, res.ApexCNAME)
+				}
+				if !res.LameChecked || len(res.Lame) != 0 {
+					t.Errorf("LameChecked=%v Lame=%v, want checked without lame servers", res.LameChecked, res.Lame)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestDNSHealthProbe(t, tt.records, nameServers)
+			res := p.analyze(context.Background(), tt.domain)
+			if res.Status != StatusOK {
+				t.Fatalf("status %s: %s", res.Status, res.Error)
+			}
+			tt.check(t, res)
+		})
+	}
+}
+
+func TestAnalyzeDNSHealthMissingDomain(t *testing.T) {
+	p := newTestDNSHealthProbe(t, testZoneRecords, nil)
+	res := p.analyze(context.Background(), "missing.example.test")
+	if res.Status != StatusNegative || res.Zone != "" {
+		t.Errorf("status %s, zone %q; want negative without a zone", res.Status, res.Zone)
+	}
+}
@@ -0,0 +1,175 @@
+package vetting
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startTestDNSServer serves handler over UDP and TCP on one local port and
+// returns the address; the servers stop when the test ends
+func startTestDNSServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+	port := startTestDNSServers(t, map[string]dns.Handler{"127.0.0.1": handler})
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// startTestDNSServers serves each handler on its loopback address, all on
+// the same port (returned), so name servers can be told apart by address
+func startTestDNSServers(t *testing.T, handlers map[string]dns.Handler) string {
+	t.Helper()
+	ips := slices.Sorted(maps.Keys(handlers))
+	for attempt := 0; ; attempt++ {
+		pcs, ls, err := listenTestDNS(ips)
+		if err != nil {
+			if attempt == 5 {
+				t.Fatal(err)
+			}
+			continue
+		}
+		for i, ip := range ips {
+			for _, srv := range []*dns.Server{
+				{PacketConn: pcs[i], Handler: handlers[ip]},
+				{Listener: ls[i], Handler: handlers[ip]},
+			} {
+				started := make(chan struct{})
+				srv.NotifyStartedFunc = func() { close(started) }
+				go srv.ActivateAndServe()
+				<-started
+				t.Cleanup(func() { _ = srv.Shutdown() })
+			}
+		}
+		_, port, _ := net.SplitHostPort(pcs[0].LocalAddr().String())
+		return port
+	}
+}
+
+// listenTestDNS opens UDP and TCP sockets on ips with a shared free port
+func listenTestDNS(ips []string) ([]net.PacketConn, []net.Listener, error) {
+	var pcs []net.PacketConn
+	var ls []net.Listener
+	closeAll := func() {
+		for _, pc := range pcs {
+			pc.Close()
+		}
+		for _, l := range ls {
+			l.Close()
+		}
+	}
+	port := "0"
+	for _, ip := range ips {
+		pc, err := net.ListenPacket("udp", net.JoinHostPort(ip, port))
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		pcs = append(pcs, pc)
+		_, port, _ = net.SplitHostPort(pc.LocalAddr().String())
+		l, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		ls = append(ls, l)
+	}
+	return pcs, ls, nil
+}
+
+// testDNSClient returns a dnsClient whose only upstream is addr
+func testDNSClient(t *testing.T, addr string) *dnsClient {
+	t.Helper()
+	c, err := NewDNSClient([]string{addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*dnsClient)
+}
+
+// mustRRs parses records in zone file format
+func mustRRs(t *testing.T, records ...string) []dns.RR {
+	t.Helper()
+	var out []dns.RR
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// staticZone answers from a fixed set of records: CNAME chains are followed,
+// "*.<name>" owners are wildcards, and a name without records is NXDOMAIN
+// (NODATA if it has records of another type). Negative answers carry soa.
+type staticZone struct {
+	records []dns.RR
+	soa     dns.RR
+	aa      bool // Set the AA flag
+}
+
+func (z *staticZone) lookup(name string) []dns.RR {
+	var out []dns.RR
+	for _, rr := range z.records {
+		if strings.EqualFold(rr.Header().Name, name) {
+			out = append(out, rr)
+		}
+	}
+	if len(out) > 0 {
+		return out
+	}
+	// Wildcard of the closest ancestor
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		wildcard := "*." + dns.Fqdn(strings.Join(labels[i:], "."))
+		for _, rr := range z.records {
+			if strings.EqualFold(rr.Header().Name, wildcard) {
+				rr = dns.Copy(rr)
+				rr.Header().Name = name
+				out = append(out, rr)
+			}
+		}
+		if len(out) > 0 {
+			return out
+		}
+	}
+	return nil
+}
+
+func (z *staticZone) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = z.aa
+	q := req.Question[0]
+	name := q.Name
+	for range 8 {
+		rrs := z.lookup(name)
+		if len(rrs) == 0 {
+			m.Rcode = dns.RcodeNameError
+			break
+		}
+		next := ""
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == q.Qtype {
+				m.Answer = append(m.Answer, rr)
+			} else if cname, ok := rr.(*dns.CNAME); ok {
+				m.Answer = append(m.Answer, rr)
+				next = cname.Target
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	if len(m.Answer) == 0 || m.Rcode == dns.RcodeNameError {
+		if z.soa != nil {
+			m.Ns = append(m.Ns, z.soa)
+		}
+	}
+	_ = w.WriteMsg(m)
+}
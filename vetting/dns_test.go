@@ -0,0 +1,213 @@
+package vetting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// countingHandler counts the queries (per transport) that reach next
+type countingHandler struct {
+	next    dns.Handler
+	queries atomic.Int32
+	tcp     atomic.Int32
+}
+
+func (h *countingHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	h.queries.Add(1)
+	if w.RemoteAddr().Network() == "tcp" {
+		h.tcp.Add(1)
+	}
+	h.next.ServeDNS(w, req)
+}
+
+func TestDNSClientCache(t *testing.T) {
+	zone := &staticZone{
+		records: mustRRs(t,
+			"cached.test. 300 IN A 192.0.2.1",
+			"cached.test. 60 IN A 192.0.2.2",
+			"uncached.test. 0 IN A 192.0.2.3",
+			"alias.test. 120 IN CNAME target.test.",
+			"target.test. 30 IN A 192.0.2.4",
+			"nodata.test. 300 IN TXT \"only text\"",
+		),
+		soa: mustRRs(t, "test. 3600 IN SOA ns.test. hostmaster.test. 1 7200 900 1209600 90")[0],
+	}
+	nosoa := &staticZone{records: zone.records}
+
+	tests := []struct {
+		name    string
+		zone    *staticZone
+		qname   string
+		wantTTL time.Duration // 0 = not cached
+	}{
+		{"lowest answer TTL", zone, "cached.test", 60 * time.Second},
+		{"CNAME chain", zone, "alias.test", 30 * time.Second},
+		{"zero TTL", zone, "uncached.test", 0},
+		{"NXDOMAIN uses the SOA minimum", zone, "missing.test", 90 * time.Second},
+		{"NODATA uses the SOA minimum", zone, "nodata.test", 90 * time.Second},
+		{"negative without SOA", nosoa, "missing.test", dnsNegativeTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &countingHandler{next: tt.zone}
+			c := testDNSClient(t, startTestDNSServer(t, h))
+			ctx := context.Background()
+
+			before := time.Now()
+			if _, err := c.query(ctx, tt.qname, dns.TypeA); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.query(ctx, strings.ToUpper(tt.qname)+".", dns.TypeA); err != nil {
+				t.Fatal(err)
+			}
+
+			c.mu.Lock()
+			entry, cached := c.cache[dns.Fqdn(tt.qname)+"/A"]
+			c.mu.Unlock()
+			if tt.wantTTL == 0 {
+				if cached || h.queries.Load() != 2 {
+					t.Errorf("cached=%v after %d queries, want no caching", cached, h.queries.Load())
+				}
+				return
+			}
+			if !cached {
+				t.Fatal("answer not cached")
+			}
+			if got := h.queries.Load(); got != 1 {
+				t.Errorf("server saw %d queries, want 1 (second answered from the cache)", got)
+			}
+			if ttl := entry.expires.Sub(before); ttl < tt.wantTTL || ttl > tt.wantTTL+time.Second {
+				t.Errorf("cached for %v, want %v", ttl, tt.wantTTL)
+			}
+
+			// An expired entry is fetched again
+			c.mu.Lock()
+			c.cache[dns.Fqdn(tt.qname)+"/A"] = dnsCacheEntry{msg: entry.msg, expires: time.Now().Add(-time.Second)}
+			c.mu.Unlock()
+			if _, err := c.query(ctx, tt.qname, dns.TypeA); err != nil {
+				t.Fatal(err)
+			}
+			if got := h.queries.Load(); got != 2 {
+				t.Errorf("server saw %d queries after expiry, want 2", got)
+			}
+		})
+	}
+}
+
+func TestDNSClientNegativeAnswers(t *testing.T) {
+	zone := &staticZone{
+		records: mustRRs(t,
+			"host.test. 300 IN A 192.0.2.1",
+			"text.test. 300 IN TXT \"v=spf1 -all\"",
+			"dangling.test. 300 IN CNAME gone.test.",
+		),
+		soa: mustRRs(t, "test. 3600 IN SOA ns.test. hostmaster.test. 1 7200 900 1209600 300")[0],
+	}
+	c := testDNSClient(t, startTestDNSServer(t, zone))
+	ctx := context.Background()
+
+	for _, name := range []string{"missing.test", "text.test"} {
+		if _, err := c.LookupIP(ctx, "ip4", name); !isDNSNotFound(err) {
+			t.Errorf("LookupIP(%s) error = %v, want not found", name, err)
+		}
+	}
+	if _, err := c.LookupCNAME(ctx, "missing.test"); !isDNSNotFound(err) {
+		t.Errorf("LookupCNAME(missing.test) error = %v, want not found", err)
+	}
+	if _, err := c.LookupCNAME(ctx, "dangling.test"); !isDNSNotFound(err) {
+		t.Errorf("LookupCNAME(dangling.test) error = %v, want not found", err)
+	}
+	if got, err := c.LookupCNAME(ctx, "host.test"); err != nil || got != "host.test." {
+		t.Errorf("LookupCNAME(host.test) = %q, %v", got, err)
+	}
+}
+
+func TestDNSClientFailover(t *testing.T) {
+	refused := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeRefused)
+		_ = w.WriteMsg(m)
+	}))
+	zone := &staticZone{records: mustRRs(t, "host.test. 300 IN A 192.0.2.1")}
+	good := startTestDNSServer(t, zone)
+
+	c, err := NewDNSClient([]string{refused, good})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err := c.LookupIP(context.Background(), "ip4", "host.test")
+	if err != nil || len(ips) != 1 || ips[0].String() != "192.0.2.1" {
+		t.Errorf("LookupIP = %v, %v; want the second upstream's answer", ips, err)
+	}
+
+	only, err := NewDNSClient([]string{refused})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = only.LookupIP(context.Background(), "ip4", "host.test")
+	if err == nil || isDNSNotFound(err) || !strings.Contains(err.Error(), "REFUSED") {
+		t.Errorf("LookupIP via a refusing upstream = %v, want a REFUSED failure", err)
+	}
+}
+
+func TestDNSClientTruncatedRetry(t *testing.T) {
+	// More TXT records than fit in a 4096-byte UDP answer
+	var records []string
+	for i := range 40 {
+		records = append(records, fmt.Sprintf("big.test. 300 IN TXT \"%03d %s\"", i, strings.Repeat("x", 200)))
+	}
+	records = append(records, "big.test. 300 IN TXT \"v=spf1 ip4:192.0.2.0/24 -all\"")
+	zone := &staticZone{records: mustRRs(t, records...)}
+
+	var mu sync.Mutex
+	var udpSizes []uint16
+	h := &countingHandler{next: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if w.RemoteAddr().Network() == "tcp" {
+			zone.ServeDNS(w, req)
+			return
+		}
+		size := uint16(dns.MinMsgSize)
+		if opt := req.IsEdns0(); opt != nil {
+			size = opt.UDPSize()
+		}
+		mu.Lock()
+		udpSizes = append(udpSizes, size)
+		mu.Unlock()
+		zone.ServeDNS(&truncatingWriter{ResponseWriter: w, size: int(size)}, req)
+	})}
+	c := testDNSClient(t, startTestDNSServer(t, h))
+
+	txts, err := c.LookupTXT(context.Background(), "big.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txts) != len(records) {
+		t.Errorf("got %d TXT records, want %d", len(txts), len(records))
+	}
+	if h.tcp.Load() != 1 || h.queries.Load() != 2 {
+		t.Errorf("queries: %d total, %d over TCP; want a UDP query then a TCP retry", h.queries.Load(), h.tcp.Load())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(udpSizes) != 1 || udpSizes[0] != dnsUDPSize {
+		t.Errorf("EDNS0 buffer sizes %v, want [%d]", udpSizes, dnsUDPSize)
+	}
+}
+
+// truncatingWriter truncates answers to the client's UDP buffer size
+type truncatingWriter struct {
+	dns.ResponseWriter
+	size int
+}
+
+func (w *truncatingWriter) WriteMsg(m *dns.Msg) error {
+	m.Truncate(w.size)
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,256 @@
+package vetting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// DNS UPSTREAMS
+//
+// An upstream is plain DNS (8.8.8.8, udp://8.8.8.8:53, tcp://8.8.8.8:53),
+// DNS-over-TLS (tls://1.1.1.1 or tls://dns.google:853, RFC 7858) or
+// DNS-over-HTTPS (https://cloudflare-dns.com/dns-query, RFC 8484) - the
+// last two work where outbound UDP/53 is blocked or rate-limited. A
+// "timeout" query parameter sets the per-query timeout (tls://1.1.1.1?timeout=3s)
+// and "name" the TLS server name of a DoT address.
+//
+// Health: an upstream whose transport failed dnsUpstreamMaxFailures times in
+// a row is unhealthy for dnsUpstreamCooldown and asked last. DNS errors
+// (SERVFAIL, NXDOMAIN) are about the name, not the upstream, and don't count.
+//
+
+const (
+	dnsUpstreamMaxFailures = 3
+	dnsUpstreamCooldown    = 30 * time.Second
+	dohMaxResponseSize     = 65535
+)
+
+// dnsUpstream is one configured resolver and its health
+type dnsUpstream struct {
+	spec     string
+	proto    string // udp, tcp, tls, https
+	addr     string // host:port, or the DoH URL
+	timeout  time.Duration
+	system   bool         // From resolv.conf, not configured
+	client   *dns.Client  // udp, tcp, tls
+	http     *http.Client // https
+	mu       sync.Mutex
+	health   DNSUpstreamHealth
+	downTill time.Time
+}
+
+// DNSUpstreamHealth is the query history of one upstream
+type DNSUpstreamHealth struct {
+	Upstream    string    `json:"upstream"`
+	Protocol    string    `json:"protocol"`
+	Timeout     string    `json:"timeout"`
+	Healthy     bool      `json:"healthy"`
+	Queries     int       `json:"queries"`
+	Failures    int       `json:"failures"`
+	Consecutive int       `json:"consecutive_failures"`
+	LatencyMs   float64   `json:"latency_ms"` // Moving average of answered queries
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+}
+
+// parseDNSUpstream parses an upstream spec (see the section comment)
+func parseDNSUpstream(spec string) (*dnsUpstream, error) {
+	u := &dnsUpstream{spec: spec, proto: "udp", timeout: dnsQueryTimeout}
+	raw := spec
+	if !strings.Contains(raw, "://") {
+		raw = "udp://" + raw
+	}
+	parsed, err := neturl.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid DNS upstream %q", spec)
+	}
+	q := parsed.Query()
+	if t := q.Get("timeout"); t != "" {
+		if u.timeout, err = time.ParseDuration(t); err != nil || u.timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout in DNS upstream %q", spec)
+		}
+	}
+	serverName := q.Get("name")
+	q.Del("timeout")
+	q.Del("name")
+	parsed.RawQuery = q.Encode()
+
+	u.proto = parsed.Scheme
+	switch u.proto {
+	case "udp", "tcp":
+		u.addr = withDNSPort(parsed.Host, "53")
+		u.client = &dns.Client{Net: u.proto, Timeout: u.timeout, UDPSize: dnsUDPSize}
+	case "tls":
+		u.addr = withDNSPort(parsed.Host, "853")
+		if serverName == "" {
+			serverName = parsed.Hostname()
+		}
+		u.client = &dns.Client{Net: "tcp-tls", Timeout: u.timeout, TLSConfig: &tls.Config{ServerName: serverName}}
+	case "https":
+		u.addr = parsed.String()
+		u.http = &http.Client{Timeout: u.timeout}
+	default:
+		return nil, fmt.Errorf("unsupported DNS upstream scheme %q in %q (udp, tcp, tls, https)", u.proto, spec)
+	}
+	u.health = DNSUpstreamHealth{Upstream: spec, Protocol: u.proto, Timeout: u.timeout.String(), Healthy: true}
+	return u, nil
+}
+
+// parseDNSUpstreams parses every spec; the first invalid one is an error
+func parseDNSUpstreams(specs []string) ([]*dnsUpstream, error) {
+	var out []*dnsUpstream
+	for _, spec := range specs {
+		u, err := parseDNSUpstream(spec)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// exchange sends m within the upstream timeout and records the outcome
+func (u *dnsUpstream) exchange(parent context.Context, m *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(parent, u.timeout)
+	defer cancel()
+	start := time.Now()
+	var r *dns.Msg
+	var err error
+	if u.http != nil {
+		r, err = u.exchangeDoH(ctx, m)
+	} else {
+		r, err = exchangeDNS(ctx, u.client, m, u.addr)
+	}
+	if err != nil && parent.Err() != nil {
+		return nil, err // Caller gave up: not the upstream's fault
+	}
+	u.record(time.Since(start), err)
+	return r, err
+}
+
+// exchangeDoH posts m as application/dns-message (RFC 8484 §4.1)
+func (u *dnsUpstream) exchangeDoH(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	q := m.Copy()
+	q.Id = 0 // Cache-friendly, per RFC 8484
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.addr, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH %s: HTTP %d", u.addr, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, fmt.Errorf("DoH %s: %v", u.addr, err)
+	}
+	r.Id = m.Id
+	return r, nil
+}
+
+// record updates the health after a query
+func (u *dnsUpstream) record(took time.Duration, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	h := &u.health
+	h.Queries++
+	if err != nil {
+		h.Failures++
+		h.Consecutive++
+		h.LastError = err.Error()
+		h.LastFailure = time.Now()
+		if h.Consecutive >= dnsUpstreamMaxFailures {
+			u.downTill = time.Now().Add(dnsUpstreamCooldown)
+		}
+		return
+	}
+	h.Consecutive = 0
+	u.downTill = time.Time{}
+	ms := float64(took.Microseconds()) / 1000
+	if h.LatencyMs == 0 {
+		h.LatencyMs = ms
+	} else {
+		h.LatencyMs = 0.8*h.LatencyMs + 0.2*ms
+	}
+}
+
+func (u *dnsUpstream) healthy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return time.Now().After(u.downTill)
+}
+
+// snapshot returns a copy of the health
+func (u *dnsUpstream) snapshot() DNSUpstreamHealth {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	h := u.health
+	h.Healthy = time.Now().After(u.downTill)
+	return h
+}
+
+// orderUpstreams puts healthy upstreams first (in configured order); the
+// unhealthy ones stay as a last resort
+func orderUpstreams(upstreams []*dnsUpstream) []*dnsUpstream {
+	ordered := slices.Clone(upstreams)
+	slices.SortStableFunc(ordered, func(a, b *dnsUpstream) int {
+		ha, hb := a.healthy(), b.healthy()
+		switch {
+		case ha == hb:
+			return 0
+		case ha:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return ordered
+}
+
+// DNSHealthHandler reports the health of the DNS upstreams
+func DNSHealthHandler(w http.ResponseWriter, r *http.Request) {
+	var health []DNSUpstreamHealth
+	if c, ok := defaultDNS().(*dnsClient); ok {
+		for _, u := range c.upstreams {
+			health = append(health, u.snapshot())
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(health)
+}
+
+// withDNSPort adds port to a server without one
+func withDNSPort(server, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
@@ -0,0 +1,213 @@
+package vetting
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseDNSUpstream(t *testing.T) {
+	tests := []struct {
+		spec       string
+		proto      string
+		addr       string
+		timeout    time.Duration
+		serverName string // DoT only
+		err        string // Substring of the error ("" = valid)
+	}{
+		{spec: "8.8.8.8", proto: "udp", addr: "8.8.8.8:53", timeout: dnsQueryTimeout},
+		{spec: "udp://8.8.8.8:5353", proto: "udp", addr: "8.8.8.8:5353", timeout: dnsQueryTimeout},
+		{spec: "tcp://[2001:4860:4860::8888]", proto: "tcp", addr: "[2001:4860:4860::8888]:53", timeout: dnsQueryTimeout},
+		{spec: "tcp://8.8.8.8?timeout=2s", proto: "tcp", addr: "8.8.8.8:53", timeout: 2 * time.Second},
+		{spec: "tls://1.1.1.1", proto: "tls", addr: "1.1.1.1:853", timeout: dnsQueryTimeout, serverName: "1.1.1.1"},
+		{spec: "tls://dns.google:8853", proto: "tls", addr: "dns.google:8853", timeout: dnsQueryTimeout, serverName: "dns.google"},
+		{spec: "tls://1.1.1.1?name=cloudflare-dns.com&timeout=3s", proto: "tls", addr: "1.1.1.1:853", timeout: 3 * time.Second, serverName: "cloudflare-dns.com"},
+		{spec: "https://cloudflare-dns.com/dns-query", proto: "https", addr: "https://cloudflare-dns.com/dns-query", timeout: dnsQueryTimeout},
+		// Our parameters are removed from the DoH URL, others are kept
+		{spec: "https://dns.example/dns-query?timeout=1500ms&ct=x", proto: "https", addr: "https://dns.example/dns-query?ct=x", timeout: 1500 * time.Millisecond},
+		{spec: "quic://1.1.1.1", err: `unsupported DNS upstream scheme "quic"`},
+		{spec: "http://dns.example/dns-query", err: `unsupported DNS upstream scheme "http"`},
+		{spec: "udp://8.8.8.8?timeout=fast", err: "invalid timeout"},
+		{spec: "udp://8.8.8.8?timeout=-1s", err: "invalid timeout"},
+		{spec: "udp://", err: "invalid DNS upstream"},
+		{spec: "https:///dns-query", err: "invalid DNS upstream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			u, err := parseDNSUpstream(tt.spec)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.proto != tt.proto || u.addr != tt.addr || u.timeout != tt.timeout {
+				t.Errorf("%s %s timeout %v, want %s %s %v", u.proto, u.addr, u.timeout, tt.proto, tt.addr, tt.timeout)
+			}
+			switch tt.proto {
+			case "https":
+				if u.http == nil || u.http.Timeout != tt.timeout || u.client != nil {
+					t.Errorf("DoH upstream without an HTTP client")
+				}
+			case "tls":
+				if u.client == nil || u.client.Net != "tcp-tls" || u.client.TLSConfig.ServerName != tt.serverName {
+					t.Errorf("DoT client %+v, want server name %s", u.client, tt.serverName)
+				}
+			default:
+				if u.client == nil || u.client.Net != tt.proto || u.client.Timeout != tt.timeout {
+					t.Errorf("client %+v", u.client)
+				}
+			}
+			if h := u.snapshot(); !h.Healthy || h.Upstream != tt.spec || h.Protocol != tt.proto {
+				t.Errorf("initial health %+v", h)
+			}
+		})
+	}
+}
+
+func TestDNSUpstreamHealth(t *testing.T) {
+	upstreams, err := parseDNSUpstreams([]string{"192.0.2.1", "192.0.2.2", "192.0.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second, third := upstreams[0], upstreams[1], upstreams[2]
+	order := func() string {
+		var specs []string
+		for _, u := range orderUpstreams(upstreams) {
+			specs = append(specs, u.spec)
+		}
+		return strings.Join(specs, ",")
+	}
+	failure := errors.New("i/o timeout")
+
+	for i := 1; i < dnsUpstreamMaxFailures; i++ {
+		first.record(0, failure)
+	}
+	if !first.healthy() || order() != "192.0.2.1,192.0.2.2,192.0.2.3" {
+		t.Fatalf("unhealthy after %d failures (order %s)", dnsUpstreamMaxFailures-1, order())
+	}
+	first.record(0, failure)
+	if first.healthy() {
+		t.Fatalf("healthy after %d consecutive failures", dnsUpstreamMaxFailures)
+	}
+	h := first.snapshot()
+	if h.Healthy || h.Queries != 3 || h.Failures != 3 || h.Consecutive != 3 || h.LastError != "i/o timeout" || h.LastFailure.IsZero() {
+		t.Errorf("health %+v", h)
+	}
+
+	// Healthy upstreams first, in configured order
+	third.record(0, failure)
+	third.record(0, failure)
+	third.record(0, failure)
+	second.record(20*time.Millisecond, nil)
+	if got := order(); got != "192.0.2.2,192.0.2.1,192.0.2.3" {
+		t.Errorf("order %s, want the healthy upstream first", got)
+	}
+
+	// One answer resets the failure streak and ends the cooldown
+	first.record(10*time.Millisecond, nil)
+	h = first.snapshot()
+	if !h.Healthy || h.Consecutive != 0 || h.Failures != 3 || h.LatencyMs != 10 {
+		t.Errorf("health after an answer %+v", h)
+	}
+	first.record(20*time.Millisecond, nil)
+	if h = first.snapshot(); h.LatencyMs != 12 {
+		t.Errorf("latency %.1fms, want the moving average 12ms", h.LatencyMs)
+	}
+	if got := order(); got != "192.0.2.1,192.0.2.2,192.0.2.3" {
+		t.Errorf("order %s after recovery", got)
+	}
+
+	// A streak must be consecutive
+	second.record(0, failure)
+	second.record(0, failure)
+	second.record(0, nil)
+	second.record(0, failure)
+	if !second.healthy() {
+		t.Error("unhealthy after non-consecutive failures")
+	}
+}
+
+func TestDNSUpstreamDoH(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		case "/garbage":
+			w.Header().Set("Content-Type", "application/dns-message")
+			w.Write([]byte("not a DNS message"))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		q := new(dns.Msg)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" || q.Unpack(body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if q.Id != 0 {
+			http.Error(w, "query id is not 0", http.StatusBadRequest)
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(q)
+		resp.Answer = mustRRs(t, q.Question[0].Name+" 300 IN A 192.0.2.1")
+		packed, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(srv.Close)
+	upstream := func(path string) *dnsUpstream {
+		u, err := parseDNSUpstream(srv.URL + path + "?timeout=2s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.http = srv.Client()
+		return u
+	}
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	ctx := context.Background()
+
+	u := upstream("/dns-query")
+	r, err := u.exchange(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Id != m.Id || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Errorf("answer %v", r)
+	}
+	if h := u.snapshot(); h.Queries != 1 || h.Failures != 0 {
+		t.Errorf("health %+v", h)
+	}
+
+	for path, want := range map[string]string{"/error": "HTTP 503", "/garbage": "DoH " + srv.URL + "/garbage"} {
+		u := upstream(path)
+		if _, err := u.exchange(ctx, m); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want %q", path, err, want)
+		}
+		if h := u.snapshot(); h.Failures != 1 {
+			t.Errorf("%s: failure not recorded: %+v", path, h)
+		}
+	}
+
+	// A caller that gives up does not count against the upstream
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := u.exchange(canceled, m); err == nil {
+		t.Error("canceled exchange succeeded")
+	}
+	if h := u.snapshot(); h.Queries != 1 {
+		t.Errorf("canceled query recorded: %+v", h)
+	}
+}
@@ -0,0 +1,605 @@
+package vetting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+//
+// DNSSEC
+//
+// The chain of trust is validated here rather than trusting a resolver's AD
+// bit: queries go to the public resolvers with DO+CD set (so bogus data is
+// returned instead of SERVFAIL) and every link is checked from the root trust
+// anchor down - DS signed by the parent, DNSKEY matching the DS and signed by
+// it - until the domain's zone or a provably unsigned delegation (a signed
+// NSEC/NSEC3 denial of the DS) is reached. Validating resolvers SERVFAIL a
+// bogus zone, so its mail is not delivered at all.
+//
+
+// DNSSEC validation states (RFC 4035 §4.3)
+const (
+	DNSSECSecure   = "secure"   // Chain of trust validates down to the domain's zone
+	DNSSECInsecure = "insecure" // Provably unsigned delegation
+	DNSSECBogus    = "bogus"    // Validation failed: validating resolvers SERVFAIL
+)
+
+// rootTrustAnchors are the root KSK digests published by IANA (KSK-2017, KSK-2024)
+var rootTrustAnchors = []string{
+	". 0 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 0 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// DNSSECLink is one validated zone of the chain of trust
+type DNSSECLink struct {
+	Zone    string   `json:"zone"`
+	KeyTags []uint16 `json:"key_tags,omitempty"` // Keys matching the DS (trust anchor at the root)
+	Secure  bool     `json:"secure"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// DNSSECRecord is whether one answer (or its non-existence) was authenticated
+type DNSSECRecord struct {
+	Label         string `json:"label"` // mx, spf, dmarc
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Exists        bool   `json:"exists"`
+	Authenticated bool   `json:"authenticated"` // Signature (or signed denial) validates
+	Error         string `json:"error,omitempty"`
+}
+
+// DNSSECResult - DNSSEC signing and chain of trust of the domain
+type DNSSECResult struct {
+	CheckOutcome
+	Zone    string         `json:"zone,omitempty"`   // Zone holding the domain (or the unsigned delegation)
+	Signed  bool           `json:"signed"`           // Zone publishes DNSKEY records
+	State   string         `json:"state,omitempty"`  // secure, insecure, bogus
+	Reason  string         `json:"reason,omitempty"` // Why the chain is insecure or bogus
+	Chain   []DNSSECLink   `json:"chain,omitempty"`
+	Records []DNSSECRecord `json:"records,omitempty"`
+}
+
+// dnssecValidator queries the upstreams in order and validates against anchors
+type dnssecValidator struct {
+	upstreams []*dnsUpstream
+	anchors   []*dns.DS
+}
+
+// dnssecBogus is a validation failure (as opposed to a lookup failure)
+type dnssecBogus string
+
+func (e dnssecBogus) Error() string { return string(e) }
+
+// AnalyzeDNSSEC validates the chain of trust of the domain and its MX, SPF
+// and DMARC answers
+func AnalyzeDNSSEC(ctx context.Context, domain string) DNSSECResult {
+	// Raw DO/CD queries go to the upstreams of the default client
+	client, ok := defaultDNS().(*dnsClient)
+	if !ok {
+		return DNSSECResult{CheckOutcome: skipped("DNS client does not send DNSSEC queries")}
+	}
+	var anchors []*dns.DS
+	for _, s := range rootTrustAnchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return DNSSECResult{CheckOutcome: outcomeOf(err)}
+		}
+		anchors = append(anchors, rr.(*dns.DS))
+	}
+	v := &dnssecValidator{upstreams: client.upstreams, anchors: anchors}
+	return v.analyze(ctx, domain)
+}
+
+func (v *dnssecValidator) analyze(ctx context.Context, domain string) DNSSECResult {
+	var res DNSSECResult
+	domain = dns.Fqdn(strings.ToLower(domain))
+
+	zone, keys, err := v.walkChain(ctx, domain, &res)
+	if err != nil && !errors.As(err, new(dnssecBogus)) {
+		res.CheckOutcome = outcomeOf(err)
+		log.Printf("[DNSSEC] ❌ %s: %v", domain, err)
+		return res
+	}
+	res.Zone = strings.TrimSuffix(zone, ".")
+	if res.Zone == "" {
+		res.Zone = "."
+	}
+	res.CheckOutcome = CheckOutcome{Status: StatusOK}
+
+	// Answers are only authenticated below a secure chain
+	for _, q := range []struct {
+		label, name string
+		qtype       uint16
+	}{
+		{"mx", domain, dns.TypeMX},
+		{"spf", domain, dns.TypeTXT},
+		{"dmarc", "_dmarc." + domain, dns.TypeTXT},
+	} {
+		rec := DNSSECRecord{Label: q.label, Name: strings.TrimSuffix(q.name, "."), Type: dns.TypeToString[q.qtype]}
+		msg, err := v.query(ctx, q.name, q.qtype)
+		if err != nil {
+			rec.Error = err.Error()
+			res.Records = append(res.Records, rec)
+			continue
+		}
+		rrset, sigs := answerRRset(msg, q.name, q.qtype)
+		if q.label == "spf" {
+			rec.Exists = hasTXTPrefix(rrset, "v=spf1")
+		} else if q.label == "dmarc" {
+			rec.Exists = hasTXTPrefix(rrset, "v=DMARC1")
+		} else {
+			rec.Exists = len(rrset) > 0
+		}
+		if res.State == DNSSECSecure {
+			switch {
+			case len(rrset) > 0:
+				err = verifyRRset(rrset, sigs, keys, zone)
+			case hasCNAME(msg):
+				err = fmt.Errorf("answer is an alias into another zone: not validated")
+			default:
+				err = verifyDenial(msg, q.name, q.qtype, keys, zone)
+			}
+			rec.Authenticated = err == nil
+			if err != nil {
+				rec.Error = err.Error()
+			}
+		}
+		res.Records = append(res.Records, rec)
+	}
+
+	switch res.State {
+	case DNSSECSecure:
+		log.Printf("[DNSSEC] ✓ %s: secure (zone %s)", domain, res.Zone)
+	case DNSSECBogus:
+		log.Printf("[DNSSEC] ❌ %s: bogus - %s", domain, res.Reason)
+	default:
+		log.Printf("[DNSSEC] ⚠️ %s: insecure (signed=%v) - %s", domain, res.Signed, res.Reason)
+	}
+	return res
+}
+
+// walkChain validates from the root down to the domain's zone, filling
+// res.State/Reason/Chain/Signed; it returns the last zone reached and its
+// validated keys. A lookup failure is returned as is, a validation failure
+// is a dnssecBogus.
+func (v *dnssecValidator) walkChain(ctx context.Context, domain string, res *DNSSECResult) (string, []*dns.DNSKEY, error) {
+	bogus := func(zone, reason string) (string, []*dns.DNSKEY, error) {
+		res.State, res.Reason = DNSSECBogus, reason
+		res.Chain = append(res.Chain, DNSSECLink{Zone: zone, Error: reason})
+		return zone, nil, dnssecBogus(reason)
+	}
+
+	// Root: DNSKEY must match a trust anchor and be self-signed by it
+	keys, tags, err := v.zoneKeys(ctx, ".", v.anchors)
+	if err != nil {
+		if errors.As(err, new(dnssecBogus)) {
+			return bogus(".", err.Error())
+		}
+		return ".", nil, err
+	}
+	res.Chain = append(res.Chain, DNSSECLink{Zone: ".", KeyTags: tags, Secure: true})
+	zone := "."
+	res.Signed = true
+
+	labels := dns.SplitDomainName(domain)
+	for i := len(labels) - 1; i >= 0; i-- {
+		name := dns.Fqdn(strings.Join(labels[i:], "."))
+		msg, err := v.query(ctx, name, dns.TypeDS)
+		if err != nil {
+			return zone, keys, err
+		}
+		if msg.Rcode == dns.RcodeNameError {
+			// The domain doesn't exist: the denial must still be signed
+			if err := verifyDenial(msg, name, dns.TypeDS, keys, zone); err != nil {
+				return bogus(name, "NXDOMAIN is not authenticated: "+err.Error())
+			}
+			break
+		}
+
+		ds, sigs := answerRRset(msg, name, dns.TypeDS)
+		if len(ds) > 0 {
+			// Signed delegation: DS signed by the parent, DNSKEY matching it
+			if err := verifyRRset(ds, sigs, keys, zone); err != nil {
+				return bogus(name, "DS record is not validly signed by "+zone+": "+err.Error())
+			}
+			var parentDS []*dns.DS
+			for _, rr := range ds {
+				parentDS = append(parentDS, rr.(*dns.DS))
+			}
+			childKeys, tags, err := v.zoneKeys(ctx, name, parentDS)
+			if err != nil {
+				if errors.As(err, new(dnssecBogus)) {
+					return bogus(name, err.Error())
+				}
+				return zone, keys, err
+			}
+			res.Chain = append(res.Chain, DNSSECLink{Zone: name, KeyTags: tags, Secure: true})
+			zone, keys = name, childKeys
+			continue
+		}
+
+		// No DS: only a zone cut (SOA at name) ends the chain
+		soa, err := v.query(ctx, name, dns.TypeSOA)
+		if err != nil {
+			return zone, keys, err
+		}
+		if apex, _ := answerRRset(soa, name, dns.TypeSOA); len(apex) == 0 {
+			continue
+		}
+		if err := verifyNoDS(msg, name, keys, zone); err != nil {
+			return bogus(name, "missing DS is not authenticated by "+zone+": "+err.Error())
+		}
+		res.State, res.Reason = DNSSECInsecure, "no DS record for "+strings.TrimSuffix(name, ".")+" in the parent zone"
+		res.Chain = append(res.Chain, DNSSECLink{Zone: name})
+		// Keys without a DS are an island of security: signed but not validatable
+		dnskey, err := v.query(ctx, name, dns.TypeDNSKEY)
+		if err != nil {
+			return name, nil, err
+		}
+		dnskeys, _ := answerRRset(dnskey, name, dns.TypeDNSKEY)
+		res.Signed = len(dnskeys) > 0
+		if res.Signed {
+			res.Reason = "zone is signed but its parent has no DS record (island of security)"
+		}
+		return name, nil, nil
+	}
+
+	res.State = DNSSECSecure
+	return zone, keys, nil
+}
+
+// zoneKeys fetches the DNSKEY RRset of zone and validates it with a key
+// matching one of the DS records; it returns the keys and the matched tags
+func (v *dnssecValidator) zoneKeys(ctx context.Context, zone string, ds []*dns.DS) ([]*dns.DNSKEY, []uint16, error) {
+	msg, err := v.query(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, nil, err
+	}
+	rrset, sigs := answerRRset(msg, zone, dns.TypeDNSKEY)
+	if len(rrset) == 0 {
+		return nil, nil, dnssecBogus(zone + " has a DS record but no DNSKEY")
+	}
+	var keys, trusted []*dns.DNSKEY
+	var tags []uint16
+	for _, rr := range rrset {
+		key := rr.(*dns.DNSKEY)
+		keys = append(keys, key)
+		for _, d := range ds {
+			if key.KeyTag() != d.KeyTag || key.Algorithm != d.Algorithm {
+				continue
+			}
+			if kd := key.ToDS(d.DigestType); kd != nil && strings.EqualFold(kd.Digest, d.Digest) {
+				trusted = append(trusted, key)
+				tags = append(tags, key.KeyTag())
+				break
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, nil, dnssecBogus("no DNSKEY of " + zone + " matches its DS record")
+	}
+	if err := verifyRRset(rrset, sigs, trusted, zone); err != nil {
+		return nil, nil, dnssecBogus("DNSKEY of " + zone + " is not signed by its DS key: " + err.Error())
+	}
+	return keys, tags, nil
+}
+
+// query sends a DO+CD query to each upstream in turn; SERVFAIL/REFUSED moves
+// on to the next one
+func (v *dnssecValidator) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(dnsUDPSize, true)
+	m.CheckingDisabled = true
+
+	err := fmt.Errorf("no DNS servers")
+	for _, u := range orderUpstreams(v.upstreams) {
+		r, qerr := u.exchange(ctx, m)
+		switch {
+		case qerr != nil:
+			err = qerr
+		case r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError:
+			err = fmt.Errorf("%s %s: %s from %s", name, dns.TypeToString[qtype], dns.RcodeToString[r.Rcode], u.spec)
+		default:
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// answerRRset returns the name/qtype records of the answer and their signatures
+func answerRRset(msg *dns.Msg, name string, qtype uint16) ([]dns.RR, []*dns.RRSIG) {
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range msg.Answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == qtype {
+				sigs = append(sigs, sig)
+			}
+		} else if rr.Header().Rrtype == qtype {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset, sigs
+}
+
+// verifyRRset checks that one signature of the RRset by zone is valid now
+// and made with one of the keys
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, zone string) error {
+	if len(sigs) == 0 {
+		return fmt.Errorf("no RRSIG")
+	}
+	err := fmt.Errorf("no RRSIG by a %s key", strings.TrimSuffix(zone, "."))
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, zone) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if verr := sig.Verify(key, rrset); verr != nil {
+				err = fmt.Errorf("RRSIG %d: %v", sig.KeyTag, verr)
+				continue
+			}
+			if !sig.ValidityPeriod(time.Now()) {
+				err = fmt.Errorf("RRSIG %d is expired or not yet valid", sig.KeyTag)
+				continue
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// verifyDenial checks that a negative answer for name/qtype is signed by the
+// zone and proves the denial: NXDOMAIN by records covering name and the
+// wildcard at its closest encloser, NODATA by a record matching name (or the
+// wildcard) without qtype (RFC 4035 §5.4, RFC 5155 §8)
+func verifyDenial(msg *dns.Msg, name string, qtype uint16, keys []*dns.DNSKEY, zone string) error {
+	nsecs, nsec3s, err := signedDenial(msg, keys, zone)
+	if err != nil {
+		return err
+	}
+	nxdomain := msg.Rcode == dns.RcodeNameError
+	if len(nsec3s) > 0 {
+		return proveNSEC3(nsec3s, name, qtype, nxdomain)
+	}
+	return proveNSEC(nsecs, name, qtype, nxdomain)
+}
+
+// signedDenial returns the NSEC/NSEC3 records of a negative answer once all
+// of them are validly signed by the zone
+func signedDenial(msg *dns.Msg, keys []*dns.DNSKEY, zone string) ([]*dns.NSEC, []*dns.NSEC3, error) {
+	sets := denialRRsets(msg)
+	if len(sets) == 0 {
+		return nil, nil, fmt.Errorf("no NSEC/NSEC3 records in the negative answer")
+	}
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, set := range sets {
+		if err := verifyRRset(set.rrset, set.sigs, keys, zone); err != nil {
+			return nil, nil, fmt.Errorf("%s %s: %v", set.rrset[0].Header().Name, dns.TypeToString[set.rrset[0].Header().Rrtype], err)
+		}
+		for _, rr := range set.rrset {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	return nsecs, nsec3s, nil
+}
+
+// proveNSEC checks an NSEC denial of name/qtype
+func proveNSEC(nsecs []*dns.NSEC, name string, qtype uint16, nxdomain bool) error {
+	if !nxdomain {
+		for _, n := range nsecs {
+			if strings.EqualFold(n.Hdr.Name, name) {
+				return deniesType(n.TypeBitMap, name, qtype)
+			}
+		}
+	}
+	i := slices.IndexFunc(nsecs, func(n *dns.NSEC) bool { return nsecCovers(n, name) })
+	if i < 0 {
+		return fmt.Errorf("no NSEC record covers %s", name)
+	}
+	if !nxdomain && dns.IsSubDomain(name, nsecs[i].NextDomain) {
+		return nil // Empty non-terminal: name only exists as a parent
+	}
+	// The closest encloser is the longest ancestor shared with either end
+	encloser := commonAncestor(name, nsecs[i].Hdr.Name)
+	if next := commonAncestor(name, nsecs[i].NextDomain); dns.CountLabel(next) > dns.CountLabel(encloser) {
+		encloser = next
+	}
+	wildcard := "*." + strings.TrimPrefix(encloser, ".")
+	if !nxdomain {
+		for _, n := range nsecs {
+			if strings.EqualFold(n.Hdr.Name, wildcard) {
+				return deniesType(n.TypeBitMap, wildcard, qtype)
+			}
+		}
+		return fmt.Errorf("no NSEC record matches %s", name)
+	}
+	if !slices.ContainsFunc(nsecs, func(n *dns.NSEC) bool { return nsecCovers(n, wildcard) }) {
+		return fmt.Errorf("no NSEC record denies the wildcard %s", wildcard)
+	}
+	return nil
+}
+
+// proveNSEC3 checks an NSEC3 denial of name/qtype: a matching record, or the
+// closest encloser proof (RFC 5155 §7.2.1) and the wildcard
+func proveNSEC3(nsec3s []*dns.NSEC3, name string, qtype uint16, nxdomain bool) error {
+	match := func(n string) *dns.NSEC3 {
+		i := slices.IndexFunc(nsec3s, func(rr *dns.NSEC3) bool { return rr.Match(n) })
+		if i < 0 {
+			return nil
+		}
+		return nsec3s[i]
+	}
+	covered := func(n string) bool {
+		return slices.ContainsFunc(nsec3s, func(rr *dns.NSEC3) bool { return rr.Cover(n) && !rr.Match(n) })
+	}
+	if !nxdomain {
+		if rr := match(name); rr != nil {
+			return deniesType(rr.TypeBitMap, name, qtype)
+		}
+	}
+	labels := dns.SplitDomainName(name)
+	encloser, nextCloser := "", ""
+	for i := 1; i <= len(labels) && encloser == ""; i++ {
+		if candidate := dns.Fqdn(strings.Join(labels[i:], ".")); match(candidate) != nil {
+			encloser, nextCloser = candidate, dns.Fqdn(strings.Join(labels[i-1:], "."))
+		}
+	}
+	if encloser == "" {
+		return fmt.Errorf("no NSEC3 record matches a closest encloser of %s", name)
+	}
+	if !covered(nextCloser) {
+		return fmt.Errorf("no NSEC3 record covers %s", nextCloser)
+	}
+	wildcard := "*." + strings.TrimPrefix(encloser, ".")
+	if !nxdomain {
+		if rr := match(wildcard); rr != nil {
+			return deniesType(rr.TypeBitMap, wildcard, qtype)
+		}
+		return fmt.Errorf("no NSEC3 record matches %s", name)
+	}
+	if !covered(wildcard) {
+		return fmt.Errorf("no NSEC3 record denies the wildcard %s", wildcard)
+	}
+	return nil
+}
+
+// deniesType checks that a type bitmap proves qtype (and a CNAME) absent
+func deniesType(bitmap []uint16, name string, qtype uint16) error {
+	if slices.Contains(bitmap, qtype) || slices.Contains(bitmap, dns.TypeCNAME) {
+		return fmt.Errorf("NSEC record for %s lists %s", name, dns.TypeToString[qtype])
+	}
+	return nil
+}
+
+// nsecCovers reports whether name falls strictly between the owner and next
+// name of an NSEC record (the last NSEC of the zone wraps to the apex)
+func nsecCovers(n *dns.NSEC, name string) bool {
+	owner, next := n.Hdr.Name, n.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	return canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0
+}
+
+// canonicalCompare orders names in DNSSEC canonical order (RFC 4034 §6.1)
+func canonicalCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// commonAncestor returns the longest name both a and b are (sub)domains of
+func commonAncestor(a, b string) string {
+	labels := dns.SplitDomainName(strings.ToLower(a))
+	n := dns.CompareDomainName(a, b)
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// verifyNoDS checks a signed proof that name has no DS: an NSEC/NSEC3 for
+// name without DS in its bitmap, or an opt-out NSEC3 covering it
+func verifyNoDS(msg *dns.Msg, name string, keys []*dns.DNSKEY, zone string) error {
+	nsecs, nsec3s, err := signedDenial(msg, keys, zone)
+	if err != nil {
+		return err
+	}
+	for _, rr := range nsecs {
+		if strings.EqualFold(rr.Hdr.Name, name) && !slices.Contains(rr.TypeBitMap, dns.TypeDS) {
+			return nil
+		}
+	}
+	for _, rr := range nsec3s {
+		if rr.Match(name) && !slices.Contains(rr.TypeBitMap, dns.TypeDS) {
+			return nil
+		}
+		if rr.Cover(name) && rr.Flags&1 == 1 { // Opt-out span
+			return nil
+		}
+	}
+	return fmt.Errorf("no NSEC/NSEC3 record proves the DS is absent")
+}
+
+type signedRRset struct {
+	rrset []dns.RR
+	sigs  []*dns.RRSIG
+}
+
+// denialRRsets groups the NSEC/NSEC3 records of the authority section by owner
+func denialRRsets(msg *dns.Msg) []signedRRset {
+	byOwner := map[string]*signedRRset{}
+	var order []string
+	get := func(key string) *signedRRset {
+		if s, ok := byOwner[key]; ok {
+			return s
+		}
+		byOwner[key] = &signedRRset{}
+		order = append(order, key)
+		return byOwner[key]
+	}
+	for _, rr := range msg.Ns {
+		h := rr.Header()
+		switch rr := rr.(type) {
+		case *dns.NSEC, *dns.NSEC3:
+			s := get(strings.ToLower(h.Name) + "/" + dns.TypeToString[h.Rrtype])
+			s.rrset = append(s.rrset, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeNSEC || rr.TypeCovered == dns.TypeNSEC3 {
+				s := get(strings.ToLower(h.Name) + "/" + dns.TypeToString[rr.TypeCovered])
+				s.sigs = append(s.sigs, rr)
+			}
+		}
+	}
+	var sets []signedRRset
+	for _, key := range order {
+		if s := byOwner[key]; len(s.rrset) > 0 {
+			sets = append(sets, *s)
+		}
+	}
+	return sets
+}
+
+func hasCNAME(msg *dns.Msg) bool {
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTXTPrefix reports whether a TXT record starts with prefix (case-insensitive)
+func hasTXTPrefix(rrset []dns.RR, prefix string) bool {
+	for _, rr := range rrset {
+		if txt, ok := rr.(*dns.TXT); ok && len(txt.Txt) > 0 &&
+			strings.HasPrefix(strings.ToLower(strings.Join(txt.Txt, "")), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,509 @@
+package vetting
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testZone is a zone of the signed test hierarchy: one ECDSA key (KSK flags)
+// signs every RRset; the negative answers carry NSEC or NSEC3 records
+type testZone struct {
+	origin  string
+	key     *dns.DNSKEY // nil: unsigned
+	priv    crypto.Signer
+	nsec3   bool
+	records []dns.RR // Data, NSEC/NSEC3 and RRSIGs once signed
+}
+
+func newTestZone(t *testing.T, origin string, signed bool) *testZone {
+	t.Helper()
+	z := &testZone{origin: origin}
+	ns := strings.TrimRight("ns."+origin, ".") + "."
+	z.add(t,
+		origin+" 3600 IN SOA "+ns+" hostmaster."+ns+" 1 7200 900 1209600 300",
+		origin+" 3600 IN NS "+ns,
+	)
+	if signed {
+		z.key = &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := z.key.Generate(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z.priv = priv.(crypto.Signer)
+		z.records = append(z.records, z.key)
+	}
+	return z
+}
+
+func (z *testZone) add(t *testing.T, records ...string) {
+	z.records = append(z.records, mustRRs(t, records...)...)
+}
+
+// delegate adds the NS (and DS, if ds is set) of a child zone
+func (z *testZone) delegate(t *testing.T, child string, ds *dns.DS) {
+	z.add(t, child+" 3600 IN NS ns."+child)
+	if ds != nil {
+		ds.Hdr = dns.RR_Header{Name: child, Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600}
+		z.records = append(z.records, ds)
+	}
+}
+
+// ds is the SHA-256 DS record of the zone key
+func (z *testZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+// sign adds the denial chain and signs every authoritative RRset with
+// signatures valid from inception to expiration
+func (z *testZone) sign(t *testing.T, inception, expiration time.Time) {
+	t.Helper()
+	if z.key == nil {
+		return
+	}
+	owners := map[string][]uint16{}
+	for _, rr := range z.records {
+		h := rr.Header()
+		owners[strings.ToLower(h.Name)] = append(owners[strings.ToLower(h.Name)], h.Rrtype)
+	}
+	names := make([]string, 0, len(owners))
+	for name := range owners {
+		names = append(names, name)
+	}
+	if z.nsec3 {
+		z.addNSEC3(names, owners)
+	} else {
+		slices.SortFunc(names, canonicalCompare)
+		for i, name := range names {
+			types := append(slices.Clone(owners[name]), dns.TypeNSEC, dns.TypeRRSIG)
+			slices.Sort(types)
+			z.records = append(z.records, &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+				NextDomain: names[(i+1)%len(names)],
+				TypeBitMap: slices.Compact(types),
+			})
+		}
+	}
+
+	sets := map[string][]dns.RR{}
+	var order []string
+	for _, rr := range z.records {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeNS && !strings.EqualFold(h.Name, z.origin) {
+			continue // Delegation NS records are not signed
+		}
+		key := strings.ToLower(h.Name) + "/" + dns.TypeToString[h.Rrtype]
+		if _, ok := sets[key]; !ok {
+			order = append(order, key)
+		}
+		sets[key] = append(sets[key], rr)
+	}
+	for _, key := range order {
+		rrset := sets[key]
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+			KeyTag:     z.key.KeyTag(),
+			SignerName: z.origin,
+			Algorithm:  z.key.Algorithm,
+			Inception:  uint32(inception.Unix()),
+			Expiration: uint32(expiration.Unix()),
+		}
+		if err := sig.Sign(z.priv, rrset); err != nil {
+			t.Fatalf("signing %s: %v", key, err)
+		}
+		z.records = append(z.records, sig)
+	}
+}
+
+// addNSEC3 adds the NSEC3 chain (SHA-1, no iterations or salt, RFC 9276)
+func (z *testZone) addNSEC3(names []string, owners map[string][]uint16) {
+	// Empty non-terminals between the owners and the apex get a record too
+	all := map[string][]uint16{}
+	for _, name := range names {
+		all[name] = owners[name]
+		for parent := name; parent != z.origin; {
+			_, rest, _ := strings.Cut(parent, ".")
+			parent = dns.Fqdn(rest)
+			if _, ok := all[parent]; !ok {
+				all[parent] = nil
+			}
+		}
+	}
+	type hashed struct {
+		hash  string
+		types []uint16
+	}
+	var chain []hashed
+	for name, types := range all {
+		if len(types) > 0 {
+			types = append(slices.Clone(types), dns.TypeRRSIG)
+		}
+		slices.Sort(types)
+		chain = append(chain, hashed{dns.HashName(name, dns.SHA1, 0, ""), slices.Compact(types)})
+	}
+	slices.SortFunc(chain, func(a, b hashed) int { return strings.Compare(a.hash, b.hash) })
+	for i, h := range chain {
+		z.records = append(z.records, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(h.hash) + "." + z.origin, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			HashLength: 20,
+			NextDomain: chain[(i+1)%len(chain)].hash,
+			TypeBitMap: h.types,
+		})
+	}
+}
+
+// rrset returns the name/qtype records and their signatures
+func (z *testZone) rrset(name string, qtype uint16) []dns.RR {
+	var out []dns.RR
+	for _, rr := range z.records {
+		h := rr.Header()
+		if !strings.EqualFold(h.Name, name) {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); (ok && sig.TypeCovered == qtype) || h.Rrtype == qtype {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// exists reports whether name has records or names below it in the zone
+func (z *testZone) exists(name string) bool {
+	for _, rr := range z.records {
+		if _, ok := rr.(*dns.NSEC3); !ok && dns.IsSubDomain(name, rr.Header().Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// denial returns the signed NSEC/NSEC3 records denying name/qtype
+func (z *testZone) denial(name string, nxdomain bool) []dns.RR {
+	var out []dns.RR
+	addRRset := func(owner string, qtype uint16) {
+		for _, rr := range z.rrset(owner, qtype) {
+			if !slices.Contains(out, rr) {
+				out = append(out, rr)
+			}
+		}
+	}
+	encloser := name
+	for !z.exists(encloser) {
+		_, rest, _ := strings.Cut(encloser, ".")
+		encloser = dns.Fqdn(rest)
+	}
+	for _, rr := range z.records {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if !nxdomain && strings.EqualFold(rr.Hdr.Name, name) ||
+				nxdomain && (nsecCovers(rr, name) || nsecCovers(rr, "*."+encloser)) {
+				addRRset(rr.Hdr.Name, dns.TypeNSEC)
+			}
+		case *dns.NSEC3:
+			nextCloser := name
+			if nxdomain {
+				labels := dns.SplitDomainName(name)
+				nextCloser = dns.Fqdn(strings.Join(labels[len(labels)-dns.CountLabel(encloser)-1:], "."))
+			}
+			if !nxdomain && rr.Match(name) ||
+				nxdomain && (rr.Match(encloser) || rr.Cover(nextCloser) || rr.Cover("*."+encloser)) {
+				addRRset(rr.Hdr.Name, dns.TypeNSEC3)
+			}
+		}
+	}
+	return out
+}
+
+// answer is the authoritative answer of the zone
+func (z *testZone) answer(q dns.Question) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.Response, m.Authoritative = true, true
+	if rrs := z.rrset(q.Name, q.Qtype); len(rrs) > 0 {
+		m.Answer = rrs
+		return m
+	}
+	nxdomain := !z.exists(q.Name)
+	if nxdomain {
+		m.Rcode = dns.RcodeNameError
+	}
+	m.Ns = append(z.rrset(z.origin, dns.TypeSOA), z.denial(strings.ToLower(q.Name), nxdomain)...)
+	return m
+}
+
+// testHierarchy answers for its zones like a resolver with CD set: from the
+// closest zone (the parent for DS queries)
+type testHierarchy []*testZone
+
+func (h testHierarchy) zoneFor(name string, qtype uint16) *testZone {
+	var best *testZone
+	for _, z := range h {
+		if !dns.IsSubDomain(z.origin, name) || (qtype == dns.TypeDS && strings.EqualFold(z.origin, name)) {
+			continue
+		}
+		if best == nil || dns.CountLabel(z.origin) > dns.CountLabel(best.origin) {
+			best = z
+		}
+	}
+	return best
+}
+
+func (h testHierarchy) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	var m *dns.Msg
+	if z := h.zoneFor(q.Name, q.Qtype); z != nil {
+		m = z.answer(q)
+	} else {
+		m = new(dns.Msg)
+		m.Rcode = dns.RcodeRefused
+	}
+	rcode := m.Rcode
+	m.SetReply(req)
+	m.Rcode = rcode
+	_ = w.WriteMsg(m)
+}
+
+// dnssecTestSetup builds root -> test. -> children:
+//
+//	secure.test    signed, DS in test. (NSEC)
+//	hashed.test    signed, DS in test. (NSEC3)
+//	insecure.test  unsigned, no DS
+//	island.test    signed, no DS
+//	mismatch.test  signed, DS of another key
+//	expired.test   signed, signatures expired
+//	badsig.test    signed, DNSKEY signature corrupted
+type dnssecTestSetup struct {
+	zones  map[string]*testZone
+	anchor *dns.DS
+	addr   string
+}
+
+func newDNSSECTestSetup(t *testing.T) *dnssecTestSetup {
+	t.Helper()
+	now := time.Now()
+	valid := func(z *testZone) { z.sign(t, now.Add(-time.Hour), now.Add(time.Hour)) }
+
+	s := &dnssecTestSetup{zones: map[string]*testZone{}}
+	children := map[string]bool{"secure.test.": true, "hashed.test.": true, "insecure.test.": false,
+		"island.test.": true, "mismatch.test.": true, "expired.test.": true, "badsig.test.": true}
+	for origin, signed := range children {
+		z := newTestZone(t, origin, signed)
+		z.add(t,
+			origin+" 3600 IN MX 10 mx."+origin,
+			origin+` 3600 IN TXT "v=spf1 mx -all"`,
+			"mx."+origin+" 3600 IN A 192.0.2.25",
+		)
+		for _, host := range []string{"www", "blog", "shop", "docs", "help", "app", "cdn", "api"} {
+			z.add(t, host+"."+origin+" 3600 IN A 192.0.2.80")
+		}
+		z.nsec3 = origin == "hashed.test."
+		s.zones[origin] = z
+	}
+	root := newTestZone(t, ".", true)
+	tld := newTestZone(t, "test.", true)
+	root.delegate(t, "test.", tld.ds())
+	for origin, z := range s.zones {
+		var ds *dns.DS
+		switch origin {
+		case "insecure.test.", "island.test.":
+		case "mismatch.test.":
+			ds = newTestZone(t, origin, true).ds()
+		default:
+			ds = z.ds()
+		}
+		tld.delegate(t, origin, ds)
+		switch origin {
+		case "expired.test.":
+			z.sign(t, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+		case "badsig.test.":
+			valid(z)
+			for _, rr := range z.rrset(origin, dns.TypeDNSKEY) {
+				if sig, ok := rr.(*dns.RRSIG); ok {
+					raw, _ := base64.StdEncoding.DecodeString(sig.Signature)
+					raw[len(raw)/2] ^= 0xff
+					sig.Signature = base64.StdEncoding.EncodeToString(raw)
+				}
+			}
+		default:
+			valid(z)
+		}
+	}
+	valid(root)
+	valid(tld)
+	s.zones["."], s.zones["test."] = root, tld
+	s.anchor = root.ds()
+
+	h := testHierarchy{}
+	for _, z := range s.zones {
+		h = append(h, z)
+	}
+	s.addr = startTestDNSServer(t, h)
+	return s
+}
+
+func (s *dnssecTestSetup) validator(t *testing.T) *dnssecValidator {
+	t.Helper()
+	u, err := parseDNSUpstream(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dnssecValidator{upstreams: []*dnsUpstream{u}, anchors: []*dns.DS{s.anchor}}
+}
+
+func TestAnalyzeDNSSEC(t *testing.T) {
+	s := newDNSSECTestSetup(t)
+	v := s.validator(t)
+
+	tests := []struct {
+		domain string
+		state  string
+		signed bool
+		reason string // Substring of Reason
+	}{
+		{"secure.test", DNSSECSecure, true, ""},
+		{"hashed.test", DNSSECSecure, true, ""},
+		{"mx.secure.test", DNSSECSecure, true, ""},
+		{"insecure.test", DNSSECInsecure, false, "no DS record"},
+		{"island.test", DNSSECInsecure, true, "island of security"},
+		{"mismatch.test", DNSSECBogus, true, "matches its DS"},
+		{"expired.test", DNSSECBogus, true, "expired"},
+		{"badsig.test", DNSSECBogus, true, "not signed by its DS key"},
+		{"missing.secure.test", DNSSECSecure, true, ""}, // Authenticated NXDOMAIN
+		{"missing.hashed.test", DNSSECSecure, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			res := v.analyze(context.Background(), tt.domain)
+			if res.Status != StatusOK {
+				t.Fatalf("status = %s (%s)", res.Status, res.Error)
+			}
+			if res.State != tt.state || res.Signed != tt.signed {
+				t.Errorf("state = %s, signed = %v, want %s, %v (reason %q)", res.State, res.Signed, tt.state, tt.signed, res.Reason)
+			}
+			if !strings.Contains(res.Reason, tt.reason) {
+				t.Errorf("reason = %q, want it to contain %q", res.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAnalyzeDNSSECRecords(t *testing.T) {
+	s := newDNSSECTestSetup(t)
+	v := s.validator(t)
+
+	for _, domain := range []string{"secure.test", "hashed.test"} {
+		res := v.analyze(context.Background(), domain)
+		want := map[string]bool{"mx": true, "spf": true, "dmarc": false} // Exists
+		for _, rec := range res.Records {
+			if !rec.Authenticated {
+				t.Errorf("%s %s: not authenticated: %s", domain, rec.Label, rec.Error)
+			}
+			if rec.Exists != want[rec.Label] {
+				t.Errorf("%s %s: exists = %v", domain, rec.Label, rec.Exists)
+			}
+		}
+	}
+
+	// Below an insecure delegation nothing is authenticated
+	res := v.analyze(context.Background(), "insecure.test")
+	for _, rec := range res.Records {
+		if rec.Authenticated {
+			t.Errorf("insecure.test %s: authenticated", rec.Label)
+		}
+	}
+}
+
+func TestAnalyzeDNSSECBadAnchor(t *testing.T) {
+	s := newDNSSECTestSetup(t)
+	v := s.validator(t)
+	v.anchors = []*dns.DS{newTestZone(t, ".", true).ds()}
+
+	res := v.analyze(context.Background(), "secure.test")
+	if res.State != DNSSECBogus {
+		t.Errorf("state = %s, want bogus", res.State)
+	}
+}
+
+// A validly signed NSEC/NSEC3 that doesn't cover the name (or lists the type)
+// must not authenticate a denial
+func TestVerifyDenialCoverage(t *testing.T) {
+	s := newDNSSECTestSetup(t)
+	for _, origin := range []string{"secure.test.", "hashed.test."} {
+		z := s.zones[origin]
+		keys := []*dns.DNSKEY{z.key}
+		nxdomain := z.answer(dns.Question{Name: "_dmarc." + origin, Qtype: dns.TypeTXT})
+		nodata := z.answer(dns.Question{Name: origin, Qtype: dns.TypeCAA})
+
+		tests := []struct {
+			name    string
+			msg     *dns.Msg
+			qname   string
+			qtype   uint16
+			wantErr bool
+		}{
+			{"nxdomain", nxdomain, "_dmarc." + origin, dns.TypeTXT, false},
+			{"nodata", nodata, origin, dns.TypeCAA, false},
+			{"nxdomain replayed for another name", nxdomain, uncoveredName(t, nxdomain, origin), dns.TypeA, true},
+			{"nodata replayed as nxdomain", asNXDOMAIN(nodata), uncoveredName(t, nodata, origin), dns.TypeTXT, true},
+			{"nodata for a listed type", nodata, origin, dns.TypeMX, true},
+			{"nodata replayed for another name", nodata, "mx." + origin, dns.TypeAAAA, true},
+		}
+		for _, tt := range tests {
+			err := verifyDenial(tt.msg, tt.qname, tt.qtype, keys, origin)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%s %s: err = %v, want error %v", origin, tt.name, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+// uncoveredName returns a name of the zone no NSEC/NSEC3 record of m covers
+func uncoveredName(t *testing.T, m *dns.Msg, origin string) string {
+	t.Helper()
+	for i := range 100 {
+		name := fmt.Sprintf("zz%d.%s", i, origin)
+		if !slices.ContainsFunc(m.Ns, func(rr dns.RR) bool {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				return nsecCovers(rr, name)
+			case *dns.NSEC3:
+				return rr.Cover(name)
+			}
+			return false
+		}) {
+			return name
+		}
+	}
+	t.Fatalf("every candidate name is covered by %v", m.Ns)
+	return ""
+}
+
+func asNXDOMAIN(m *dns.Msg) *dns.Msg {
+	m = m.Copy()
+	m.Rcode = dns.RcodeNameError
+	return m
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// RFC 4034 §6.1 example order
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.",
+		"zABC.a.EXAMPLE.", "z.example.", "*.z.example."}
+	for i := 1; i < len(ordered); i++ {
+		if canonicalCompare(ordered[i-1], ordered[i]) >= 0 {
+			t.Errorf("%s should sort before %s", ordered[i-1], ordered[i])
+		}
+	}
+}
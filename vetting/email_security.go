@@ -3,240 +3,159 @@ package vetting
 import (
 	"context"
 	"log"
-	"net"
+	"sort"
 	"strings"
-	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 type EmailSecurity struct {
-	HasValidMX  bool   `json:"has_valid_mx"`
-	HasSPF      bool   `json:"has_spf"`
-	HasDMARC    bool   `json:"has_dmarc"`
-	SPFRecord   string `json:"spf_record,omitempty"`
-	DMARCRecord string `json:"dmarc_record,omitempty"`
+	CheckOutcome
+	HasValidMX  bool     `json:"has_valid_mx"`
+	HasSPF      bool     `json:"has_spf"`
+	HasDMARC    bool     `json:"has_dmarc"`
+	SPFRecord   string   `json:"spf_record,omitempty"`
+	DMARCRecord string   `json:"dmarc_record,omitempty"`
+	MXHosts     []string `json:"mx_hosts,omitempty"` // By preference
+	NullMX      bool     `json:"null_mx"`            // RFC 7505: the domain accepts no mail
+
+	// Parsed SPF tree, lookup counts and violations (nil without SPF)
+	SPF *SPFAnalysis `json:"spf,omitempty"`
+	// Parsed DMARC record and effective policy (nil without DMARC)
+	DMARC *DMARCPolicy `json:"dmarc,omitempty"`
+
+	// Per-record outcomes: a failed lookup is not the same as "no record"
+	MXCheck    CheckOutcome `json:"mx_check"`
+	SPFCheck   CheckOutcome `json:"spf_check"`
+	DMARCCheck CheckOutcome `json:"dmarc_check"`
 }
 
-// DNS servers to try (in order)
-var dnsServers = []string{
-	"8.8.8.8:53", // Google Primary
-	"8.8.4.4:53", // Google Secondary
-	"1.1.1.1:53", // Cloudflare Primary
-	"1.0.0.1:53", // Cloudflare Secondary
-	"9.9.9.9:53", // Quad9
+func GetEmailSecurity(ctx context.Context, domain string) EmailSecurity {
+	return getEmailSecurity(ctx, defaultDNS(), domain)
 }
 
-// getResolverWithDNS creates a resolver with a specific DNS server
-func getResolverWithDNS(dnsServer string) *net.Resolver {
-	return &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: 5 * time.Second,
-			}
-			return d.DialContext(ctx, "udp", dnsServer)
-		},
-	}
-}
+func getEmailSecurity(ctx context.Context, dns DNSClient, domain string) EmailSecurity {
+	sec := EmailSecurity{}
+
+	log.Printf("[EmailSecurity] Starting checks for %s", domain)
 
-// lookupTXTWithRetry tries multiple DNS servers
-func lookupTXTWithRetry(domain string) ([]string, error) {
-	var lastErr error
-	var allRecords []string
-
-	// Collect TXT records from ALL DNS servers (domains with many TXT records may be truncated)
-	for _, dns := range dnsServers {
-		resolver := getResolverWithDNS(dns)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-
-		txts, err := resolver.LookupTXT(ctx, domain)
-		cancel()
-
-		if err == nil && len(txts) > 0 {
-			log.Printf("[DNS] TXT lookup for %s via %s returned %d records", domain, dns, len(txts))
-			// Merge records (avoid duplicates)
-			for _, t := range txts {
-				found := false
-				for _, existing := range allRecords {
-					if existing == t {
-						found = true
-						break
-					}
-				}
-				if !found {
-					allRecords = append(allRecords, t)
-				}
+	// MX, SPF and DMARC lookups are independent - run them in parallel
+	// (each goroutine only writes its own fields of sec)
+	var g errgroup.Group
+
+	g.Go(func() error {
+		// -------------------------
+		// MX CHECK
+		// -------------------------
+		mxRecords, err := dns.LookupMX(ctx, domain)
+		if isDNSNotFound(err) {
+			err = nil
+		} else if err != nil {
+			log.Printf("[EmailSecurity] MX lookup failed for %s: %v", domain, err)
+		}
+		// Null MX (RFC 7505): a single "." record says the domain takes no mail
+		if len(mxRecords) == 1 && mxRecords[0].Host == "." {
+			sec.NullMX = true
+			log.Printf("[EmailSecurity] ⚠️ Null MX for %s: domain does not accept mail", domain)
+		} else if len(mxRecords) > 0 {
+			sec.HasValidMX = true
+			sort.SliceStable(mxRecords, func(i, j int) bool { return mxRecords[i].Pref < mxRecords[j].Pref })
+			for _, mx := range mxRecords {
+				sec.MXHosts = append(sec.MXHosts, strings.TrimSuffix(mx.Host, "."))
 			}
+			log.Printf("[EmailSecurity] ✓ MX found for %s: %d records", domain, len(mxRecords))
+		}
+		sec.MXCheck = recordOutcome(err, sec.HasValidMX)
+		return nil
+	})
+
+	g.Go(func() error {
+		// -------------------------
+		// SPF CHECK (TXT record)
+		// -------------------------
+		txts, err := dns.LookupTXT(ctx, domain)
+		if isDNSNotFound(err) {
+			err = nil
 		} else if err != nil {
-			lastErr = err
-			log.Printf("[DNS] TXT lookup for %s failed via %s: %v", domain, dns, err)
+			log.Printf("[EmailSecurity] TXT lookup failed for %s: %v", domain, err)
 		}
-	}
-
-	// Also try system resolver
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	txts, err := net.DefaultResolver.LookupTXT(ctx, domain)
-	if err == nil && len(txts) > 0 {
-		log.Printf("[DNS] TXT lookup for %s via system resolver returned %d records", domain, len(txts))
+		log.Printf("[EmailSecurity] Checking %d TXT records for SPF in %s", len(txts), domain)
 		for _, t := range txts {
-			found := false
-			for _, existing := range allRecords {
-				if existing == t {
-					found = true
-					break
-				}
-			}
-			if !found {
-				allRecords = append(allRecords, t)
+			if isSPFRecord(t) {
+				sec.HasSPF = true
+				sec.SPFRecord = t
+				log.Printf("[EmailSecurity] ✓ SPF found for %s: %s", domain, truncate(t, 50))
+				break
 			}
 		}
-	}
-
-	if len(allRecords) > 0 {
-		log.Printf("[DNS] Total unique TXT records for %s: %d", domain, len(allRecords))
-		return allRecords, nil
-	}
-
-	return nil, lastErr
-}
-
-// lookupMXWithRetry tries multiple DNS servers for MX records
-func lookupMXWithRetry(domain string) ([]*net.MX, error) {
-	var lastErr error
-
-	for _, dns := range dnsServers {
-		resolver := getResolverWithDNS(dns)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-
-		mxs, err := resolver.LookupMX(ctx, domain)
-		cancel()
-
-		if err == nil && len(mxs) > 0 {
-			log.Printf("[DNS] MX lookup for %s succeeded via %s", domain, dns)
-			return mxs, nil
+		if sec.HasSPF {
+			spf := analyzeSPF(ctx, dns, domain)
+			sec.SPF = &spf
 		}
-		lastErr = err
-		log.Printf("[DNS] MX lookup for %s failed via %s: %v", domain, dns, err)
-	}
-
-	// Also try system resolver as fallback
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	mxs, err := net.DefaultResolver.LookupMX(ctx, domain)
-	if err == nil && len(mxs) > 0 {
-		log.Printf("[DNS] MX lookup for %s succeeded via system resolver", domain)
-		return mxs, nil
-	}
-
-	return nil, lastErr
-}
-
-func GetEmailSecurity(domain string) EmailSecurity {
-	sec := EmailSecurity{}
-
-	log.Printf("[EmailSecurity] Starting checks for %s", domain)
-
-	// -------------------------
-	// MX CHECK (with retry)
-	// -------------------------
-	mxRecords, err := lookupMXWithRetry(domain)
-	if err != nil {
-		log.Printf("[EmailSecurity] MX lookup failed for %s after all retries: %v", domain, err)
-	}
-	if len(mxRecords) > 0 {
-		sec.HasValidMX = true
-		log.Printf("[EmailSecurity] ✓ MX found for %s: %d records", domain, len(mxRecords))
-	}
-
-	// -------------------------
-	// SPF CHECK (TXT record with retry)
-	// -------------------------
-	txts, err := lookupTXTWithRetry(domain)
-	if err != nil {
-		log.Printf("[EmailSecurity] TXT lookup failed for %s after all retries: %v", domain, err)
-	}
-	log.Printf("[EmailSecurity] Checking %d TXT records for SPF in %s", len(txts), domain)
-	for _, t := range txts {
-		lower := strings.ToLower(t)
-		if strings.HasPrefix(lower, "v=spf1") || strings.Contains(lower, "v=spf1") {
-			sec.HasSPF = true
-			sec.SPFRecord = t
-			log.Printf("[EmailSecurity] ✓ SPF found for %s: %s", domain, truncate(t, 50))
-			break
+		sec.SPFCheck = recordOutcome(err, sec.HasSPF)
+		return nil
+	})
+
+	g.Go(func() error {
+		// -------------------------
+		// DMARC CHECK (_dmarc.domain, then the organizational domain)
+		// -------------------------
+		policy, err := discoverDMARC(ctx, dns, domain)
+		if err != nil {
+			log.Printf("[EmailSecurity] DMARC lookup failed for %s: %v", domain, err)
 		}
-	}
-
-	// If SPF not found in merged results, try each DNS server directly
-	if !sec.HasSPF {
-		log.Printf("[EmailSecurity] SPF not found in merged TXT, trying individual DNS servers...")
-		spfRecord := findSPFRecord(domain)
-		if spfRecord != "" {
-			sec.HasSPF = true
-			sec.SPFRecord = spfRecord
-			log.Printf("[EmailSecurity] ✓ SPF found via direct search: %s", truncate(spfRecord, 50))
+		if policy != nil {
+			sec.DMARC = policy
+			sec.DMARCRecord = policy.Record
+			// A record receivers discard (no usable policy) is no DMARC
+			sec.HasDMARC = policy.Effective != ""
+			log.Printf("[EmailSecurity] ✓ DMARC found for %s at %s: %s (effective policy %q)",
+				domain, policy.Domain, truncate(policy.Record, 50), policy.Effective)
 		}
-	}
+		sec.DMARCCheck = recordOutcome(err, sec.HasDMARC)
+		return nil
+	})
 
-	// -------------------------
-	// DMARC CHECK (_dmarc.domain with retry)
-	// -------------------------
-	dmarcDomain := "_dmarc." + domain
-	dmarcTXT, err := lookupTXTWithRetry(dmarcDomain)
-	if err != nil {
-		log.Printf("[EmailSecurity] DMARC lookup failed for %s after all retries: %v", dmarcDomain, err)
-	}
+	_ = g.Wait()
+	sec.CheckOutcome = combineOutcomes(sec.MXCheck, sec.SPFCheck, sec.DMARCCheck)
 
-	for _, t := range dmarcTXT {
-		lower := strings.ToLower(t)
-		if strings.HasPrefix(lower, "v=dmarc1") || strings.Contains(lower, "v=dmarc1") {
-			sec.HasDMARC = true
-			sec.DMARCRecord = t
-			log.Printf("[EmailSecurity] ✓ DMARC found for %s: %s", domain, truncate(t, 50))
-			break
-		}
-	}
-
-	log.Printf("[EmailSecurity] Final result for %s: MX=%v, SPF=%v, DMARC=%v",
-		domain, sec.HasValidMX, sec.HasSPF, sec.HasDMARC)
+	log.Printf("[EmailSecurity] Final result for %s: MX=%v, SPF=%v, DMARC=%v (status: %s/%s/%s)",
+		domain, sec.HasValidMX, sec.HasSPF, sec.HasDMARC,
+		sec.MXCheck.Status, sec.SPFCheck.Status, sec.DMARCCheck.Status)
 
 	return sec
 }
 
-// findSPFRecord tries all DNS servers to specifically find SPF record
-// This is a fallback when merged TXT records don't contain SPF due to truncation
-func findSPFRecord(domain string) string {
-	allServers := append(dnsServers, "") // Empty string = system resolver
-
-	for _, dns := range allServers {
-		var resolver *net.Resolver
-		if dns == "" {
-			resolver = net.DefaultResolver
-		} else {
-			resolver = getResolverWithDNS(dns)
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		txts, err := resolver.LookupTXT(ctx, domain)
-		cancel()
+// recordOutcome - a record found is ok even if some lookups failed;
+// missing is negative only if a server actually answered
+func recordOutcome(err error, found bool) CheckOutcome {
+	if found {
+		return CheckOutcome{Status: StatusOK}
+	}
+	if err != nil {
+		return outcomeOf(err)
+	}
+	return negativeIf(true)
+}
 
-		if err != nil {
+// combineOutcomes - negative if any part is negative, unknown only if
+// no part produced an answer (the first part's failure is reported)
+func combineOutcomes(parts ...CheckOutcome) CheckOutcome {
+	combined := CheckOutcome{Status: StatusOK}
+	known := 0
+	for _, o := range parts {
+		if !o.Known() {
 			continue
 		}
-
-		for _, t := range txts {
-			lower := strings.ToLower(t)
-			if strings.HasPrefix(lower, "v=spf1") || strings.Contains(lower, "v=spf1") {
-				serverName := dns
-				if dns == "" {
-					serverName = "system"
-				}
-				log.Printf("[DNS] SPF found via %s for %s", serverName, domain)
-				return t
-			}
+		known++
+		if o.Status == StatusNegative {
+			combined.Status = StatusNegative
 		}
 	}
-
-	return ""
+	if known == 0 && len(parts) > 0 {
+		return parts[0]
+	}
+	return combined
 }
 
 // truncate helper for logging
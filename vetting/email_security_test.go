@@ -0,0 +1,155 @@
+package vetting
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestGetEmailSecurity(t *testing.T) {
+	servfail := &net.DNSError{Err: "server misbehaving", Name: "broken.test"}
+
+	tests := []struct {
+		name   string
+		domain string
+		dns    *FakeDNS
+		check  func(t *testing.T, sec EmailSecurity)
+	}{
+		{
+			name:   "MX, SPF with include and DMARC",
+			domain: "example.com",
+			dns: &FakeDNS{
+				MX: map[string][]*net.MX{"example.com": {
+					{Host: "mx2.example.com.", Pref: 20},
+					{Host: "mx1.example.com.", Pref: 10},
+				}},
+				TXT: map[string][]string{
+					"example.com":        {"google-site-verification=abc", "v=spf1 include:_spf.mail.test -all"},
+					"_spf.mail.test":     {"v=spf1 ip4:192.0.2.0/24 -all"},
+					"_dmarc.example.com": {"v=DMARC1; p=reject; rua=mailto:dmarc@example.com"},
+				},
+			},
+			check: func(t *testing.T, sec EmailSecurity) {
+				if sec.Status != StatusOK || !sec.HasValidMX || !sec.HasSPF || !sec.HasDMARC {
+					t.Errorf("status %s, MX=%v SPF=%v DMARC=%v; want all present", sec.Status, sec.HasValidMX, sec.HasSPF, sec.HasDMARC)
+				}
+				if !slices.Equal(sec.MXHosts, []string{"mx1.example.com", "mx2.example.com"}) {
+					t.Errorf("MXHosts = %v, want preference order", sec.MXHosts)
+				}
+				if sec.SPFRecord != "v=spf1 include:_spf.mail.test -all" {
+					t.Errorf("SPFRecord = %q", sec.SPFRecord)
+				}
+				if sec.SPF == nil || !sec.SPF.Valid || sec.SPF.DNSLookups != 1 || sec.SPF.All != "-all" {
+					t.Errorf("SPF = %+v, want valid with 1 lookup and -all", sec.SPF)
+				}
+				if sec.DMARC == nil || sec.DMARC.Effective != DMARCReject || sec.DMARC.Inherited {
+					t.Errorf("DMARC = %+v, want p=reject at the domain", sec.DMARC)
+				}
+			},
+		},
+		{
+			name:   "SPF violations",
+			domain: "example.com",
+			dns: &FakeDNS{
+				TXT: map[string][]string{
+					"example.com": {"v=spf1 include:nospf.test +all"},
+					"nospf.test":  {"not an spf record"},
+				},
+			},
+			check: func(t *testing.T, sec EmailSecurity) {
+				if !sec.HasSPF || sec.SPF == nil {
+					t.Fatal("SPF record not found")
+				}
+				for _, code := range []string{SPFIncludeNoRecord, SPFPassAll} {
+					if !sec.SPF.HasViolation(code) {
+						t.Errorf("violations %+v, want %s", sec.SPF.Violations, code)
+					}
+				}
+				if sec.SPF.Valid {
+					t.Error("SPF with an include of a domain without SPF is valid")
+				}
+				if sec.HasDMARC || sec.DMARCCheck.Status != StatusNegative {
+					t.Errorf("DMARC check %s, want negative", sec.DMARCCheck.Status)
+				}
+				if sec.Status != StatusNegative {
+					t.Errorf("status %s, want negative", sec.Status)
+				}
+			},
+		},
+		{
+			name:   "multiple SPF records",
+			domain: "example.com",
+			dns: &FakeDNS{
+				TXT: map[string][]string{"example.com": {"v=spf1 -all", "v=spf1 mx -all"}},
+			},
+			check: func(t *testing.T, sec EmailSecurity) {
+				if sec.SPF == nil || !sec.SPF.HasViolation(SPFMultipleRecords) || sec.SPF.RecordCount != 2 {
+					t.Errorf("SPF = %+v, want %s", sec.SPF, SPFMultipleRecords)
+				}
+			},
+		},
+		{
+			name:   "subdomain inherits the organizational DMARC",
+			domain: "mail.example.com",
+			dns: &FakeDNS{
+				TXT: map[string][]string{"_dmarc.example.com": {"v=DMARC1; p=reject; sp=quarantine"}},
+			},
+			check: func(t *testing.T, sec EmailSecurity) {
+				if !sec.HasDMARC || sec.DMARC == nil {
+					t.Fatal("organizational DMARC record not found")
+				}
+				if !sec.DMARC.Inherited || sec.DMARC.Domain != "example.com" || sec.DMARC.Effective != DMARCQuarantine {
+					t.Errorf("DMARC = %+v, want sp=quarantine inherited from example.com", sec.DMARC)
+				}
+			},
+		},
+		{
+			name:   "two DMARC records",
+			domain: "example.com",
+			dns: &FakeDNS{
+				TXT: map[string][]string{"_dmarc.example.com": {"v=DMARC1; p=reject", "v=DMARC1; p=none"}},
+			},
+			check: func(t *testing.T, sec EmailSecurity) {
+				if sec.HasDMARC || sec.DMARC == nil || sec.DMARC.Valid {
+					t.Errorf("HasDMARC=%v DMARC=%+v, want an invalid record and no DMARC", sec.HasDMARC, sec.DMARC)
+				}
+			},
+		},
+		{
+			name:   "null MX",
+			domain: "example.com",
+			dns: &FakeDNS{
+				MX: map[string][]*net.MX{"example.com": {{Host: ".", Pref: 0}}},
+			},
+			check: func(t *testing.T, sec EmailSecurity) {
+				if !sec.NullMX || sec.HasValidMX || len(sec.MXHosts) != 0 {
+					t.Errorf("NullMX=%v HasValidMX=%v MXHosts=%v", sec.NullMX, sec.HasValidMX, sec.MXHosts)
+				}
+			},
+		},
+		{
+			name:   "lookup failures are unknown, not missing",
+			domain: "broken.test",
+			dns: &FakeDNS{Errors: map[string]error{
+				"broken.test":        servfail,
+				"_dmarc.broken.test": servfail,
+			}},
+			check: func(t *testing.T, sec EmailSecurity) {
+				for name, o := range map[string]CheckOutcome{"MX": sec.MXCheck, "SPF": sec.SPFCheck, "DMARC": sec.DMARCCheck} {
+					if o.Status != StatusError {
+						t.Errorf("%s check %s, want error", name, o.Status)
+					}
+				}
+				if sec.Status != StatusError {
+					t.Errorf("status %s, want error", sec.Status)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, getEmailSecurity(context.Background(), tt.dns, tt.domain))
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package vetting
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ML FEATURE EXPORT
+//
+// ScoringFeatures is one flat row per vetted domain with every signal we
+// collect (raw values, not policy-resolved ones, plus each check's status so
+// failed lookups can be told apart from negatives). It is returned in
+// VetResponse.features when the request sets include_features, appended to
+// VETTING_FEATURES_LOG (JSON lines) if configured, and exported as CSV or
+// columnar JSON from /features/export for offline model training.
+//
+
+// ScoringFeatures extracts all features for ML/AI training
+// This helps AI agent learn which factors matter most
+type ScoringFeatures struct {
+	// Row identity and the score the active policy gave
+	VetID         string `json:"vet_id"` // VetResponse.id (join key for outcomes)
+	Domain        string `json:"domain"`
+	Timestamp     string `json:"timestamp"`
+	PolicyVersion string `json:"policy_version"`
+	Profile       string `json:"profile"`
+	Score         int    `json:"score"`
+	Level         string `json:"level"`
+
+	// Binary features
+	IsSubdomain    bool `json:"is_subdomain"`
+	HasHTTPS       bool `json:"has_https"`
+	WebsiteExists  bool `json:"website_exists"`
+	HasValidMX     bool `json:"has_valid_mx"`
+	HasSPF         bool `json:"has_spf"`
+	HasDMARC       bool `json:"has_dmarc"`
+	SPFValid       bool `json:"spf_valid"`       // SPF record without errors (permerror)
+	DMARCInherited bool `json:"dmarc_inherited"` // Policy from the organizational domain
+	HasDKIM        bool `json:"has_dkim"`        // Usable key on a probed selector
+	DKIMWeakKey    bool `json:"dkim_weak_key"`   // RSA key of 1024 bits or less
+	HasTLSRPT      bool `json:"has_tls_rpt"`     // _smtp._tls reporting record published
+	NullMX         bool `json:"null_mx"`         // RFC 7505: accepts no mail
+	MXFCrDNS       bool `json:"mx_fcrdns"`       // Every resolving MX host passes FCrDNS
+	DNSSECSigned   bool `json:"dnssec_signed"`   // Zone publishes DNSKEY records
+	HasBIMI        bool `json:"has_bimi"`        // BIMI record with a logo
+	BIMIReady      bool `json:"bimi_ready"`      // Logo would be shown (see BIMIResult.Ready)
+	SPFAuthorized  bool `json:"spf_authorized"`  // SPF passes all our sending IPs (false if unchecked)
+	GoogleFlagged  bool `json:"google_flagged"`
+	OptInCompliant bool `json:"optin_compliant"`
+	HasCaptcha     bool `json:"has_captcha"`
+	SelfSignedCert bool `json:"self_signed_cert"`
+	DNSDivergent   bool `json:"dns_inconsistent"` // DNS upstreams disagree on A/MX/TXT
+	WildcardMX     bool `json:"wildcard_mx"`      // Any subdomain has MX records
+	ApexCNAME      bool `json:"apex_cname"`       // CNAME at the zone apex
+	SerialMismatch bool `json:"serial_mismatch"`  // Name servers serve different SOA serials
+	HasAAAA        bool `json:"has_aaaa"`
+
+	// Numeric features
+	TLSDaysLeft      int `json:"tls_days_left"`
+	WhoisAgeDays     int `json:"whois_age_days"`
+	MXCount          int `json:"mx_count"`
+	SPFLookups       int `json:"spf_lookups"`      // DNS lookups incl. includes (RFC 7208 limit: 10)
+	SPFVoidLookups   int `json:"spf_void_lookups"` // RFC 7208 limit: 2
+	DKIMKeys         int `json:"dkim_keys"`
+	DKIMMinRSABits   int `json:"dkim_min_rsa_bits"` // 0 without RSA keys
+	MTASTSUncovered  int `json:"mta_sts_uncovered"` // MX hosts the MTA-STS policy does not match
+	MXResolving      int `json:"mx_resolving"`      // MX hosts with A/AAAA records
+	MXNoSTARTTLS     int `json:"mx_no_starttls"`    // Probed MX hosts without STARTTLS
+	RDNSUnconfirmed  int `json:"rdns_unconfirmed"`  // Addresses (domain + sending) without FCrDNS
+	SendingNoFCrDNS  int `json:"sending_no_fcrdns"` // Sending IPs without FCrDNS
+	PTRResidential   int `json:"ptr_residential"`   // Residential/dynamic-looking PTR names
+	PTRGeneric       int `json:"ptr_generic"`       // Auto-generated PTR names
+	NSCount          int `json:"ns_count"`          // Name servers of the zone
+	NSASNs           int `json:"ns_asns"`           // Distinct ASNs of the name servers
+	LameNS           int `json:"lame_ns"`           // Name servers not authoritative for the zone
+	SOAIssues        int `json:"soa_issues"`        // SOA timers out of range
+	DanglingCNAMEs   int `json:"dangling_cnames"`   // Aliases to NXDOMAIN targets
+	TakeoverCNAMEs   int `json:"takeover_cnames"`   // Of which on claimable SaaS hosts
+	DMARCPct         int `json:"dmarc_pct"`         // 0 without DMARC
+	BlacklistCount   int `json:"blacklist_count"`
+	BlacklistPenalty int `json:"blacklist_penalty"`
+	SenderScore      int `json:"sender_score"` // MXToolbox reputation
+	TrafficScore     int `json:"traffic_score"`
+	TrustScore       int `json:"trust_score"`
+	SSLQualityScore  int `json:"ssl_quality_score"`
+	ASN              int `json:"asn"`
+	IPCount          int `json:"ip_count"`
+	UnknownSignals   int `json:"unknown_signals"` // Signals scored by policy, not by data
+
+	// Categorical features
+	DMARCPolicy string `json:"dmarc_policy"` // Effective: none, quarantine, reject ("" without DMARC)
+	SPFAll      string `json:"spf_all"`      // Effective all qualifier: +all, -all, ~all, ?all
+	MTASTSMode  string `json:"mta_sts_mode"` // enforce, testing, none ("" without a usable policy)
+	DNSSEC      string `json:"dnssec"`       // secure, insecure, bogus ("" if unknown)
+	TLSProtocol string `json:"tls_protocol"`
+	ASName      string `json:"as_name"`
+	Country     string `json:"country"`
+
+	// Calculated risk indicators (active policy thresholds)
+	IsNewDomain         bool `json:"is_new_domain"`
+	IsTLSExpiring       bool `json:"is_tls_expiring"` // < 30 days
+	IsLowSenderScore    bool `json:"is_low_sender_score"`
+	IsCriticalBlacklist bool `json:"is_critical_blacklist"`
+
+	// Check statuses (ok, negative, error, timed_out, skipped)
+	HTTPSStatus        CheckStatus `json:"https_status"`
+	WhoisStatus        CheckStatus `json:"whois_status"`
+	MXStatus           CheckStatus `json:"mx_status"`
+	SPFStatus          CheckStatus `json:"spf_status"`
+	DMARCStatus        CheckStatus `json:"dmarc_status"`
+	MXToolboxStatus    CheckStatus `json:"mxtoolbox_status"`
+	AbuseFeedsStatus   CheckStatus `json:"abuse_feeds_status"`
+	SafeBrowsingStatus CheckStatus `json:"safe_browsing_status"`
+	WebsiteStatus      CheckStatus `json:"website_status"`
+	GeoStatus          CheckStatus `json:"geo_status"`
+	SPFAuthStatus      CheckStatus `json:"spf_auth_status"`
+	DKIMStatus         CheckStatus `json:"dkim_status"`
+	ReverseDNSStatus   CheckStatus `json:"reverse_dns_status"`
+	DNSSECStatus       CheckStatus `json:"dnssec_status"`
+	DNSConsensusStatus CheckStatus `json:"dns_consensus_status"`
+	DNSHealthStatus    CheckStatus `json:"dns_health_status"`
+	MXHostsStatus      CheckStatus `json:"mx_hosts_status"`
+	MTASTSStatus       CheckStatus `json:"mta_sts_status"`
+	BIMIStatus         CheckStatus `json:"bimi_status"`
+
+	// Blacklist sources the domain (or its IP/parent) is listed on
+	RBLHits map[string]bool `json:"rbl_hits"`
+}
+
+// ExtractFeatures extracts all features from domain vetting results
+// This is used for ML model training and AI learning
+func ExtractFeatures(domain string, in ScoreInputs, scored ScoreFeatures, summary RiskSummary) ScoringFeatures {
+	f := ScoringFeatures{
+		Domain:        domain,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		PolicyVersion: summary.PolicyVersion,
+		Profile:       summary.Profile,
+		Score:         summary.Score,
+		Level:         summary.Level,
+
+		IsSubdomain:    in.IsSubdomain,
+		HasHTTPS:       in.HTTPS.OK,
+		WebsiteExists:  in.Website.Exists,
+		HasValidMX:     in.Email.HasValidMX,
+		HasSPF:         in.Email.HasSPF,
+		HasDMARC:       in.Email.HasDMARC,
+		GoogleFlagged:  in.SafeBrowsing.Flagged,
+		OptInCompliant: in.OptIn.Compliance,
+		HasCaptcha:     in.OptIn.HasCaptcha,
+		SelfSignedCert: in.SSL.SelfSigned,
+		SPFAuthorized:  in.SPFAuth.Authorized,
+		HasDKIM:        in.DKIM.HasValidKey,
+		DKIMWeakKey:    in.DKIM.HasWeakKey,
+		HasTLSRPT:      in.MTASTS.TLSRPT != nil,
+		NullMX:         in.Email.NullMX,
+		MXFCrDNS:       in.MXHosts.FCrDNS,
+		DNSSECSigned:   in.DNSSEC.Signed,
+		DNSDivergent:   in.DNSConsensus.Inconsistent,
+		WildcardMX:     in.DNSHealth.WildcardMX,
+		ApexCNAME:      in.DNSHealth.ApexCNAME != "",
+		SerialMismatch: in.DNSHealth.SerialMismatch,
+		HasAAAA:        in.DNSHealth.HasAAAA,
+		HasBIMI:        in.BIMI.Record != "" && !in.BIMI.Declined,
+		BIMIReady:      in.BIMI.Ready,
+
+		TLSDaysLeft:      in.TLSDays,
+		WhoisAgeDays:     in.Whois.AgeDays,
+		MXCount:          len(in.Email.MXHosts),
+		DKIMKeys:         len(in.DKIM.Keys),
+		DKIMMinRSABits:   in.DKIM.MinRSABits,
+		MTASTSUncovered:  len(in.MTASTS.UncoveredMX),
+		MXResolving:      in.MXHosts.Resolving,
+		MXNoSTARTTLS:     len(in.MXHosts.NoSTARTTLS),
+		BlacklistCount:   len(in.Blacklists.Hits),
+		BlacklistPenalty: scored.Blacklist.TotalPenalty,
+		RDNSUnconfirmed:  in.ReverseDNS.Unconfirmed,
+		SendingNoFCrDNS:  in.ReverseDNS.SendingUnconfirmed,
+		PTRResidential:   in.ReverseDNS.Residential,
+		PTRGeneric:       in.ReverseDNS.Generic,
+		NSCount:          in.DNSHealth.NSCount,
+		NSASNs:           len(in.DNSHealth.ASNs),
+		LameNS:           len(in.DNSHealth.Lame),
+		SOAIssues:        len(in.DNSHealth.SOAIssues),
+		DanglingCNAMEs:   len(in.DNSHealth.Dangling),
+		TakeoverCNAMEs:   len(in.DNSHealth.Takeovers()),
+		SenderScore:      in.Blacklists.MxRep,
+		TrafficScore:     in.Website.TrafficScore,
+		TrustScore:       in.Website.TrustScore,
+		SSLQualityScore:  in.SSL.Score,
+		ASN:              in.Geo.ASN,
+		IPCount:          len(in.IPs.All),
+		UnknownSignals:   len(scored.UnknownSignals),
+
+		MTASTSMode:  in.MTASTS.Mode(),
+		DNSSEC:      in.DNSSEC.State,
+		TLSProtocol: in.SSL.Protocol,
+		ASName:      in.Geo.ASName,
+		Country:     in.Geo.Country,
+
+		IsNewDomain:         scored.Bool("domain.new"),
+		IsTLSExpiring:       in.TLSDays < 30,
+		IsLowSenderScore:    !scored.Bool("mx.reputation_ok"),
+		IsCriticalBlacklist: scored.Blacklist.IsRejected,
+
+		HTTPSStatus:        in.HTTPS.Status,
+		WhoisStatus:        in.Whois.Status,
+		MXStatus:           in.Email.MXCheck.Status,
+		SPFStatus:          in.Email.SPFCheck.Status,
+		DMARCStatus:        in.Email.DMARCCheck.Status,
+		MXToolboxStatus:    in.Blacklists.MXToolbox.Status,
+		AbuseFeedsStatus:   in.Blacklists.Feeds.Status,
+		SafeBrowsingStatus: in.SafeBrowsing.Status,
+		WebsiteStatus:      in.Website.Status,
+		GeoStatus:          in.Geo.Status,
+		SPFAuthStatus:      in.SPFAuth.Status,
+		DKIMStatus:         in.DKIM.Status,
+		ReverseDNSStatus:   in.ReverseDNS.Status,
+		DNSSECStatus:       in.DNSSEC.Status,
+		DNSConsensusStatus: in.DNSConsensus.Status,
+		DNSHealthStatus:    in.DNSHealth.Status,
+		MXHostsStatus:      in.MXHosts.Status,
+		MTASTSStatus:       in.MTASTS.Status,
+		BIMIStatus:         in.BIMI.Status,
+
+		RBLHits: map[string]bool{},
+	}
+
+	if dmarc := in.Email.DMARC; dmarc != nil && in.Email.HasDMARC {
+		f.DMARCPolicy = dmarc.Effective
+		f.DMARCPct = dmarc.Pct
+		f.DMARCInherited = dmarc.Inherited
+	}
+	if spf := in.Email.SPF; spf != nil {
+		f.SPFValid = spf.Valid
+		f.SPFLookups = spf.DNSLookups
+		f.SPFVoidLookups = spf.VoidLookups
+		f.SPFAll = spf.All
+	}
+	for _, hit := range in.Blacklists.Hits {
+		if hit.Listed {
+			f.RBLHits[rblColumnName(hit.Source)] = true
+		}
+	}
+	return f
+}
+
+// rblColumnName normalizes a blacklist source to a column-safe name
+// ("Spamhaus ZEN" -> "spamhaus_zen", "zen.spamhaus.org" stays as is)
+func rblColumnName(source string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, strings.TrimSpace(source))
+}
+
+//
+// COLUMNS
+//
+
+// featureColumn is one export column: a ScoringFeatures field or one RBL
+type featureColumn struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"` // bool, int64, string
+	value func(f *ScoringFeatures) interface{}
+}
+
+// featureColumns returns the export schema for rows: every scalar field
+// (in struct order) followed by one rbl.<source> column per blacklist - all
+// RBL zones we query plus any other source listed in rows (sorted), so files
+// exported at different times line up
+func featureColumns(rows []ScoringFeatures) []featureColumn {
+	var cols []featureColumn
+
+	t := reflect.TypeOf(ScoringFeatures{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		var typ string
+		switch field.Type.Kind() {
+		case reflect.Bool:
+			typ = "bool"
+		case reflect.Int:
+			typ = "int64"
+		case reflect.String:
+			typ = "string"
+		default:
+			continue // RBLHits is expanded below
+		}
+		index := i
+		cols = append(cols, featureColumn{Name: name, Type: typ, value: func(f *ScoringFeatures) interface{} {
+			v := reflect.ValueOf(f).Elem().Field(index)
+			switch v.Kind() {
+			case reflect.Bool:
+				return v.Bool()
+			case reflect.Int:
+				return v.Int()
+			default:
+				return v.String()
+			}
+		}})
+	}
+
+	sources := map[string]bool{}
+	for _, rbl := range append(append([]string{}, domainRBLs...), ipRBLs...) {
+		sources[rblColumnName(rbl)] = true
+	}
+	for _, row := range rows {
+		for source := range row.RBLHits {
+			sources[source] = true
+		}
+	}
+	names := make([]string, 0, len(sources))
+	for source := range sources {
+		names = append(names, source)
+	}
+	sort.Strings(names)
+	for _, source := range names {
+		source := source
+		cols = append(cols, featureColumn{Name: "rbl." + source, Type: "bool", value: func(f *ScoringFeatures) interface{} {
+			return f.RBLHits[source]
+		}})
+	}
+	return cols
+}
+
+// WriteFeaturesCSV writes rows as CSV with a header line
+func WriteFeaturesCSV(w io.Writer, rows []ScoringFeatures) error {
+	cols := featureColumns(rows)
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = c.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(cols))
+	for r := range rows {
+		for i, c := range cols {
+			record[i] = fmt.Sprint(c.value(&rows[r]))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// FeatureTable is the columnar (Parquet-style) form of a feature export:
+// a typed schema and one value array per column
+type FeatureTable struct {
+	NumRows int                      `json:"num_rows"`
+	Schema  []featureColumn          `json:"schema"`
+	Columns map[string][]interface{} `json:"columns"`
+}
+
+// ColumnarFeatures converts rows to column-major form
+func ColumnarFeatures(rows []ScoringFeatures) FeatureTable {
+	cols := featureColumns(rows)
+	table := FeatureTable{
+		NumRows: len(rows),
+		Schema:  cols,
+		Columns: make(map[string][]interface{}, len(cols)),
+	}
+	for _, c := range cols {
+		values := make([]interface{}, len(rows))
+		for r := range rows {
+			values[r] = c.value(&rows[r])
+		}
+		table.Columns[c.Name] = values
+	}
+	return table
+}
+
+// WriteFeaturesColumnar writes rows as one columnar JSON document
+func WriteFeaturesColumnar(w io.Writer, rows []ScoringFeatures) error {
+	return json.NewEncoder(w).Encode(ColumnarFeatures(rows))
+}
+
+//
+// FEATURE LOG
+//
+
+// FeatureLog appends one JSON line per vetted domain
+type FeatureLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFeatureLog returns a feature log writing to path
+func NewFeatureLog(path string) *FeatureLog {
+	return &FeatureLog{path: path}
+}
+
+// Append adds one row to the log
+func (l *FeatureLog) Append(f ScoringFeatures) error {
+	line, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadAll returns every row in the log (a missing file is an empty log)
+func (l *FeatureLog) ReadAll() ([]ScoringFeatures, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rows []ScoringFeatures
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var f ScoringFeatures
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", l.path, line, err)
+		}
+		rows = append(rows, f)
+	}
+	return rows, scanner.Err()
+}
+
+// featureLog is set by ConfigureFeatureLogFromEnv (nil = not recording)
+var featureLog *FeatureLog
+
+// ConfigureFeatureLogFromEnv records every vetted domain's features to
+// VETTING_FEATURES_LOG (JSON lines), if set
+func ConfigureFeatureLogFromEnv() {
+	if path := os.Getenv("VETTING_FEATURES_LOG"); path != "" {
+		featureLog = NewFeatureLog(path)
+		log.Printf("[Features] Recording feature vectors to %s", path)
+	}
+}
+
+// recordFeatures appends f to the feature log (if configured)
+func recordFeatures(f ScoringFeatures) {
+	if featureLog == nil {
+		return
+	}
+	if err := featureLog.Append(f); err != nil {
+		log.Printf("[Features] ❌ Cannot record features for %s: %v", f.Domain, err)
+	}
+}
+
+// FeaturesExportHandler exports the feature log:
+// GET /features/export?format=csv (default) or format=columnar
+func FeaturesExportHandler(w http.ResponseWriter, r *http.Request) {
+	if featureLog == nil {
+		http.Error(w, "feature log not configured (set VETTING_FEATURES_LOG)", http.StatusNotFound)
+		return
+	}
+	rows, err := featureLog.ReadAll()
+	if err != nil {
+		log.Printf("[Features] ❌ Cannot read feature log: %v", err)
+		http.Error(w, "cannot read feature log", http.StatusInternalServerError)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="features.csv"`)
+		err = WriteFeaturesCSV(w, rows)
+	case "columnar":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="features.columnar.json"`)
+		err = WriteFeaturesColumnar(w, rows)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q (csv, columnar)", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[Features] ❌ Export failed: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package vetting
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFeaturesExportRoundTrip(t *testing.T) {
+	rows := []ScoringFeatures{
+		{
+			VetID: "vet-1", Domain: "example.com", PolicyVersion: "v1", Score: 85, Level: "good",
+			HasDMARC: true, HasDKIM: true, DKIMMinRSABits: 2048, WhoisAgeDays: 4000,
+			DMARCPolicy: DMARCReject, SPFAll: "-all", MXStatus: StatusOK, DKIMStatus: StatusOK,
+			RBLHits: map[string]bool{"custom_list": true},
+		},
+		{
+			VetID: "vet-2", Domain: "new, \"quoted\".test", Score: 20, Level: "high-risk",
+			IsNewDomain: true, WhoisAgeDays: 3, ASName: "AS \"Example\"\nNet",
+			MXStatus: StatusNegative, DKIMStatus: StatusTimedOut,
+			RBLHits: map[string]bool{rblColumnName("Spamhaus ZEN"): true},
+		},
+	}
+
+	var csvOut bytes.Buffer
+	if err := WriteFeaturesCSV(&csvOut, rows); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1+len(rows) {
+		t.Fatalf("%d CSV records, want a header and %d rows", len(records), len(rows))
+	}
+	header := records[0]
+
+	// Scalar fields in struct order, then the sorted rbl.<source> columns
+	if !slices.Equal(header[:7], []string{"vet_id", "domain", "timestamp", "policy_version", "profile", "score", "level"}) {
+		t.Errorf("header starts %v", header[:7])
+	}
+	if slices.Contains(header, "rbl_hits") {
+		t.Error("rbl_hits map exported as a column")
+	}
+	first := slices.IndexFunc(header, func(name string) bool { return strings.HasPrefix(name, "rbl.") })
+	if first < 0 || header[first-1] != "bimi_status" || !slices.IsSorted(header[first:]) {
+		t.Errorf("rbl columns start at %d after %q: %v", first, header[max(first-1, 0)], header[max(first, 0):])
+	}
+	for _, name := range []string{"rbl.custom_list", "rbl.spamhaus_zen"} {
+		if !slices.Contains(header[first:], name) {
+			t.Errorf("header has no %s column", name)
+		}
+	}
+
+	column := func(name string) int {
+		t.Helper()
+		i := slices.Index(header, name)
+		if i < 0 {
+			t.Fatalf("no %s column", name)
+		}
+		return i
+	}
+	for _, tt := range []struct {
+		column string
+		want   [2]string
+	}{
+		{"domain", [2]string{"example.com", `new, "quoted".test`}},
+		{"score", [2]string{"85", "20"}},
+		{"has_dkim", [2]string{"true", "false"}},
+		{"dkim_min_rsa_bits", [2]string{"2048", "0"}},
+		{"is_new_domain", [2]string{"false", "true"}},
+		{"dmarc_policy", [2]string{"reject", ""}},
+		{"as_name", [2]string{"", "AS \"Example\"\nNet"}},
+		{"mx_status", [2]string{"ok", "negative"}},
+		{"dkim_status", [2]string{"ok", "timed_out"}},
+		{"rbl.custom_list", [2]string{"true", "false"}},
+		{"rbl.spamhaus_zen", [2]string{"false", "true"}},
+	} {
+		i := column(tt.column)
+		if got := [2]string{records[1][i], records[2][i]}; got != tt.want {
+			t.Errorf("CSV %s = %q, want %q", tt.column, got, tt.want)
+		}
+	}
+
+	var jsonOut bytes.Buffer
+	if err := WriteFeaturesColumnar(&jsonOut, rows); err != nil {
+		t.Fatal(err)
+	}
+	var table struct {
+		NumRows int `json:"num_rows"`
+		Schema  []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"schema"`
+		Columns map[string][]interface{} `json:"columns"`
+	}
+	dec := json.NewDecoder(&jsonOut)
+	dec.UseNumber()
+	if err := dec.Decode(&table); err != nil {
+		t.Fatal(err)
+	}
+	if table.NumRows != len(rows) || len(table.Schema) != len(header) || len(table.Columns) != len(header) {
+		t.Fatalf("%d rows, %d schema columns, %d columns; want %d and %d", table.NumRows, len(table.Schema), len(table.Columns), len(rows), len(header))
+	}
+
+	types := map[string]string{"score": "int64", "has_dkim": "bool", "dmarc_policy": "string", "mx_status": "string", "rbl.custom_list": "bool"}
+	for i, col := range table.Schema {
+		if col.Name != header[i] {
+			t.Errorf("schema column %d is %s, CSV header %s", i, col.Name, header[i])
+		}
+		if want, ok := types[col.Name]; ok && col.Type != want {
+			t.Errorf("%s type %s, want %s", col.Name, col.Type, want)
+		}
+		// Both exports carry the same values
+		values := table.Columns[col.Name]
+		if len(values) != len(rows) {
+			t.Errorf("%s has %d values", col.Name, len(values))
+			continue
+		}
+		for r, v := range values {
+			if got := fmt.Sprint(v); got != records[r+1][i] {
+				t.Errorf("%s row %d: columnar %q, CSV %q", col.Name, r, got, records[r+1][i])
+			}
+		}
+	}
+	if v := table.Columns["has_dkim"][0]; v != true {
+		t.Errorf("has_dkim = %#v, want a JSON bool", v)
+	}
+	if v := table.Columns["score"][1]; v != json.Number("20") {
+		t.Errorf("score = %#v, want a JSON number", v)
+	}
+}
@@ -1,13 +1,17 @@
 package vetting
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type GeoInfo struct {
+	CheckOutcome
 	Country string `json:"country"`
 	Region  string `json:"region"`
 	City    string `json:"city"`
@@ -16,31 +20,60 @@ type GeoInfo struct {
 	ASName  string `json:"as_name"`
 }
 
-func LookupGeo(ip string) GeoInfo {
+// LookupGeo looks up country, ISP and ASN of an IP (ip-api.com).
+// Addresses ip-api has no data for (private ranges) are negative, not errors.
+func LookupGeo(ctx context.Context, ip string) (GeoInfo, error) {
 	var info GeoInfo
 	if ip == "" {
-		return info
+		info.CheckOutcome = skipped("no IP address")
+		return info, nil
 	}
 
-	url := fmt.Sprintf("http://ip-api.com/json/%s?fields=status,country,regionName,city,isp,as,asname,query", ip)
+	url := fmt.Sprintf("http://ip-api.com/json/%s?fields=status,message,country,regionName,city,isp,as,asname,query", ip)
 	client := http.Client{Timeout: 6 * time.Second}
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return info
+		return info, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return info, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("ip-api returned HTTP %d", resp.StatusCode)
+	}
+
 	var data map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&data)
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return info, fmt.Errorf("ip-api response: %w", err)
+	}
+	if toStr(data["status"]) != "success" {
+		info.CheckOutcome = CheckOutcome{Status: StatusNegative, Error: toStr(data["message"])}
+		return info, nil
+	}
 
+	info.CheckOutcome = CheckOutcome{Status: StatusOK}
 	info.Country = toStr(data["country"])
 	info.Region = toStr(data["regionName"])
 	info.City = toStr(data["city"])
 	info.ISP = toStr(data["isp"])
+	info.ASN = parseASN(toStr(data["as"]))
 	info.ASName = toStr(data["asname"])
 
-	return info
+	return info, nil
+}
+
+// parseASN extracts the AS number from "AS15169 Google LLC" (0 if none)
+func parseASN(as string) int {
+	field, _, _ := strings.Cut(as, " ")
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(field), "AS"))
+	if err != nil {
+		return 0
+	}
+	return n
 }
 
 func toStr(v interface{}) string {
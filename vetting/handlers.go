@@ -3,14 +3,26 @@ package vetting
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
-
-	"golang.org/x/sync/errgroup"
 )
 
+// disabledWhoisAgeDays is the domain age assumed when the WHOIS check is disabled
+// (not "new", so a disabled check doesn't trigger the new-domain penalty)
+const disabledWhoisAgeDays = 60
+
+// resultOr returns the typed result of a check, or fallback if it did not run
+func resultOr[T any](results *CheckResults, name string, fallback T) T {
+	if v, ok := Result[T](results, name); ok {
+		return v
+	}
+	return fallback
+}
+
 // joinReasons combines multiple rejection reasons into a single string
 func joinReasons(reasons []string) string {
 	return strings.Join(reasons, "; ")
@@ -51,40 +63,77 @@ func isSubdomain(domain string) (bool, string) {
 	return true, parent
 }
 
-// getDMARCWarning returns warning message based on DMARC status and record
-func getDMARCWarning(hasDMARC bool, dmarcRecord string) string {
-	if !hasDMARC {
+// getDMARCWarning returns warning message based on DMARC status and the
+// parsed policy (no policy but hasDMARC = lookup failed, nothing to warn about)
+func getDMARCWarning(hasDMARC bool, p *DMARCPolicy) string {
+	switch {
+	case p == nil && hasDMARC:
+		return ""
+	case p == nil:
 		return "CRITICAL: DMARC record is missing. Email authentication will fail. Please add a DMARC record."
-	}
-
-	// Check DMARC policy
-	dmarcLower := strings.ToLower(dmarcRecord)
+	case p.Effective == "":
+		return "CRITICAL: DMARC record is invalid and will be ignored by receivers: " + strings.Join(p.Errors, "; ")
 
-	// Check for p=none (weak policy)
-	if strings.Contains(dmarcLower, "p=none") {
+	// Weak policy (for subdomains: sp, or p if there is no sp)
+	case p.Effective == DMARCNone && p.Inherited:
+		return fmt.Sprintf("WARNING: DMARC policy inherited from %s is 'none' for subdomains. Consider sp=quarantine or a record for this domain.", p.Domain)
+	case p.Effective == DMARCNone:
 		return "WARNING: DMARC policy is set to 'none'. Consider upgrading to 'quarantine' or 'reject' for better protection."
-	}
+	case p.Pct < 100:
+		return fmt.Sprintf("WARNING: DMARC policy '%s' applies to only %d%% of failing mail (pct=%d).", p.Effective, p.Pct, p.Pct)
 
-	// Check for missing rua (reporting)
-	if !strings.Contains(dmarcLower, "rua=") {
+	// Missing rua (reporting), or reports that will never arrive
+	case len(p.RUA) == 0:
 		return "WARNING: DMARC record is missing 'rua' (aggregate reporting). Consider adding for monitoring."
+	case len(p.UnauthorizedDestinations()) > 0:
+		return fmt.Sprintf("WARNING: DMARC reports to %s are not authorized by the destination (RFC 7489 §7.1) and will never arrive.",
+			strings.Join(p.UnauthorizedDestinations(), ", "))
+	case len(p.Errors) > 0:
+		return "WARNING: DMARC record has errors: " + strings.Join(p.Errors, "; ")
 	}
-
 	return "" // No warning - DMARC is properly configured
 }
 
 type VetRequest struct {
 	Domain       string             `json:"domain"`
 	SelfAttested *SelfAttestedOptIn `json:"self_attested,omitempty"`
+	Profile      string             `json:"profile,omitempty"` // Scoring profile (enterprise, transactional, cold_outreach)
+
+	// Return the ML feature vector in VetResponse.features
+	IncludeFeatures bool `json:"include_features,omitempty"`
+
+	// Our sending infrastructure, checked against the domain's SPF
+	// (defaults: VETTING_SENDING_IPS, VETTING_SPF_INCLUDE)
+	SendingIPs []string `json:"sending_ips,omitempty"` // IPs we will send from
+	SPFInclude string   `json:"spf_include,omitempty"` // Our ESP include (e.g. _spf.our-esp.com)
+
+	// DKIM selectors to probe in addition to the common ones
+	DKIMSelectors []string `json:"dkim_selectors,omitempty"`
+
+	// Connect to the MX hosts over SMTP (default: VETTING_SMTP_PROBE)
+	SMTPProbe bool `json:"smtp_probe,omitempty"`
 }
 
 type VetResponse struct {
+	ID           string `json:"id"` // Links outcomes (POST /outcomes) to this result
 	Domain       string `json:"domain"`
 	ParentDomain string `json:"parent_domain,omitempty"` // Only if subdomain
 	IsSubdomain  bool   `json:"is_subdomain"`
 	IPAddress    string `json:"ip_address"`
 	CreatedOn    string `json:"created_on"` // Domain creation date (for reference)
 
+	// FCrDNS and PTR class of the domain's addresses and our sending IPs
+	ReverseDNS ReverseDNSResult `json:"reverse_dns"`
+
+	// DNSSEC chain of trust and whether the MX/SPF/DMARC answers are authenticated
+	DNSSEC DNSSECResult `json:"dnssec"`
+
+	// Whether the DNS upstreams agree on the A/MX/TXT records (dns_inconsistent)
+	DNSConsensus DNSConsensusResult `json:"dns_consensus"`
+
+	// Name servers, lame delegation, SOA, wildcards and dangling CNAMEs
+	DNSHealth DNSHealthResult `json:"dns_health"`
+
 	// Rejection status - if true, no warmup plan should be generated
 	IsRejected   bool   `json:"is_rejected"`
 	RejectReason string `json:"reject_reason,omitempty"`
@@ -104,8 +153,11 @@ type VetResponse struct {
 	// Opt-in checks
 	OptIn OptInCheck `json:"optin"`
 
-	Summary   RiskSummary `json:"summary"`
-	Timestamp string      `json:"timestamp"`
+	Summary   RiskSummary      `json:"summary"`
+	Shadow    *ShadowScore     `json:"shadow,omitempty"`   // Score under the candidate policy (shadow mode only)
+	Features  *ScoringFeatures `json:"features,omitempty"` // Only if include_features was requested
+	Checks    []CheckReport    `json:"checks"`             // Status of every check (ok, error, timed_out)
+	Timestamp string           `json:"timestamp"`
 }
 
 // WebsiteCheckSimple - simplified website check
@@ -116,10 +168,28 @@ type WebsiteCheckSimple struct {
 
 // EmailSecuritySimple - simplified email security (kept essential fields)
 type EmailSecuritySimple struct {
-	HasValidMX   bool   `json:"has_valid_mx"`            // false = -60 penalty
-	HasDMARC     bool   `json:"has_dmarc"`               // CRITICAL: must be true
-	DMARCRecord  string `json:"dmarc_record,omitempty"`  // The actual DMARC record
-	DMARCWarning string `json:"dmarc_warning,omitempty"` // Warning if DMARC has issues
+	HasValidMX   bool         `json:"has_valid_mx"`            // false = -60 penalty
+	HasDMARC     bool         `json:"has_dmarc"`               // CRITICAL: must be true
+	DMARCRecord  string       `json:"dmarc_record,omitempty"`  // The actual DMARC record
+	DMARCWarning string       `json:"dmarc_warning,omitempty"` // Warning if DMARC has issues
+	DMARC        *DMARCPolicy `json:"dmarc,omitempty"`         // Parsed DMARC record and effective policy
+	SPF          *SPFAnalysis `json:"spf,omitempty"`           // Parsed SPF tree and violations
+
+	// Keys found on the probed selectors
+	DKIM DKIMResult `json:"dkim"`
+
+	// MX hosts resolved, reverse-checked and (optionally) probed
+	MXHosts MXAnalysis `json:"mx_hosts"`
+	NullMX  bool       `json:"null_mx"` // Domain publishes that it accepts no mail
+
+	// MTA-STS policy and TLS-RPT record (inbound transport security)
+	MTASTS MTASTSResult `json:"mta_sts"`
+
+	// BIMI record, logo and evidence certificate (readiness)
+	BIMI BIMIResult `json:"bimi"`
+
+	// check_host() result per sending IP (nil if no sending IPs are configured)
+	SPFAuthorization *SPFAuthorization `json:"spf_authorization,omitempty"`
 }
 
 func VetHandler(w http.ResponseWriter, r *http.Request) {
@@ -134,183 +204,161 @@ func VetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for _, ip := range req.SendingIPs {
+		if net.ParseIP(ip) == nil {
+			http.Error(w, fmt.Sprintf("sending_ips: invalid IP %q", ip), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One policy snapshot per request (the policy file may be reloaded meanwhile)
+	scoringPolicy, err := ActiveScoringPolicy().Profile(req.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Normalize domain
 	domain = NormalizeDomain(domain)
 
 	// DETECT SUBDOMAIN
 	isSubdom, parentDomain := isSubdomain(domain)
-
-	// Determine which domain to use for website checks
-	websiteCheckDomain := domain
 	if isSubdom {
-		websiteCheckDomain = parentDomain
 		log.Printf("📍 Subdomain detected: %s → Parent: %s", domain, parentDomain)
 	}
 
-	// BASIC CHECKS
-	ip := LookupIP(domain)
-
-	// HTTPS/Website checks on PARENT domain for subdomains
-	httpsOK, _ := ProbeHTTPS(websiteCheckDomain)
-	ssl := CheckSSLQuality(websiteCheckDomain)
-
-	// Email security on EXACT domain entered (subdomain needs its own MX/SPF/DMARC)
-	emailSec := GetEmailSecurity(domain)
-
-	whoisDays, createdOn, _ := WhoisAgeDays(websiteCheckDomain) // Use parent for WHOIS
-	tlsDays, _ := GetExpirationDate(websiteCheckDomain)
-
-	// Google Safe Browsing - check BOTH domains
-	googleFlagged, googleReason := CheckGoogleReputation(domain)
-	if !googleFlagged && isSubdom {
-		// Also check parent domain
-		parentFlagged, parentReason := CheckGoogleReputation(parentDomain)
-		if parentFlagged {
-			googleFlagged = true
-			googleReason = "Parent domain flagged: " + parentReason
-		}
-	}
-
-	// --- PARALLEL OPERATIONS ---
-	var mxRes *MXBlacklistResult
-	var abuse []BlacklistEntry
-	var parentAbuse []BlacklistEntry
-
-	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
+	// RUN ALL REGISTERED CHECKS (see builtin_checks.go)
+	// Bound by the request context (client disconnect) and the overall vetting budget
+	ctx, cancel := context.WithTimeout(r.Context(), VettingBudget())
 	defer cancel()
 
-	g, _ := errgroup.WithContext(ctx)
-
-	// MXToolbox - check parent domain for subdomains (blacklists typically list parent domains)
-	mxToolboxDomain := domain
-	if isSubdom {
-		mxToolboxDomain = parentDomain
-		log.Printf("🔍 MXToolbox: Checking parent domain %s for subdomain %s", parentDomain, domain)
-	}
-	g.Go(func() error {
-		res, err := FetchMXToolboxBlacklist(mxToolboxDomain)
-		if err == nil && res != nil {
-			mxRes = res
-		}
-		return nil
-	})
-
-	// RBL/Abuse - check exact domain
-	g.Go(func() error {
-		abuse = FetchAdditionalAbuseFeeds(domain)
-		return nil
-	})
-
-	// If subdomain, also check parent domain for blacklists
-	if isSubdom {
-		g.Go(func() error {
-			parentAbuse = FetchAdditionalAbuseFeeds(parentDomain)
-			return nil
-		})
-	}
-
-	// Wait
-	_ = g.Wait()
-
-	// MERGE BLACKLIST RESULTS (both subdomain and parent)
-	var blacklistCombined []BlacklistEntry
-
-	if mxRes != nil {
-		blacklistCombined = append(blacklistCombined, convertMXToBlacklist(mxRes)...)
-	}
-
-	blacklistCombined = append(blacklistCombined, abuse...)
-
-	// Add parent domain blacklist hits (if subdomain)
-	if isSubdom && len(parentAbuse) > 0 {
-		for i := range parentAbuse {
-			parentAbuse[i].Info = "Parent domain: " + parentDomain
-		}
-		blacklistCombined = append(blacklistCombined, parentAbuse...)
-	}
-
-	// ANALYZE BLACKLISTS (Critical vs Penalty-based)
-	blacklistAnalysis := AnalyzeBlacklists(blacklistCombined)
-
-	// Get MX reputation and check if allowed
-	mxRep := 0
-	if mxRes != nil {
-		mxRep = mxRes.MxRep
+	results, err := DefaultRegistry.Run(ctx, domain, &req)
+	if err != nil {
+		log.Printf("❌ Vetting checks could not run for %s: %v", domain, err)
+		http.Error(w, "vetting checks misconfigured", http.StatusInternalServerError)
+		return
 	}
-	mxRepOk := CheckMXReputationAllowed(mxRep)
-
-	// WEBSITE CHECKS on parent domain for subdomains
-	website := CheckWebsite(
-		websiteCheckDomain, // Use parent for website checks
-		whoisDays,
-		httpsOK,
-		ssl,
-		len(blacklistCombined),
-		mxRep,
-		googleFlagged,
-		emailSec,
-	)
-
-	// DETERMINE REJECTION STATUS
-	isRejected := false
-	rejectReason := ""
-	rejectReasons := []string{}
 
-	// Check 1: Critical blacklist = REJECT
-	if blacklistAnalysis.IsRejected {
-		isRejected = true
-		rejectReasons = append(rejectReasons, blacklistAnalysis.RejectReason)
+	// Client went away - nobody is waiting for the response
+	if r.Context().Err() != nil {
+		log.Printf("⚠️ Client disconnected, vetting aborted for: %s", domain)
+		return
 	}
 
-	// Check 2: MX Reputation too low = REJECT
-	if !mxRepOk {
-		isRejected = true
-		rejectReasons = append(rejectReasons, "MX reputation too low (below 40)")
+	// Checks that did not produce an answer are scored by the unknown-data policy:
+	// disabled/skipped checks neither penalize nor reject, failed and timed-out
+	// checks pass or fail per signal (their status is reported in resp.Checks)
+	ips := resultOr(results, CheckIP, ResolvedIPs{CheckOutcome: results.Outcome(CheckIP)})
+	ip := ips.Primary
+	geo := resultOr(results, CheckGeo, GeoInfo{CheckOutcome: results.Outcome(CheckGeo)})
+	https := resultOr(results, CheckHTTPS, HTTPSResult{CheckOutcome: results.Outcome(CheckHTTPS)})
+	ssl := resultOr(results, CheckSSLQualityName, SSLQuality{})
+	tlsDays := resultOr(results, CheckTLSExpiry, TLSExpiry{}).DaysLeft
+	emailOutcome := results.Outcome(CheckEmailSecurity)
+	emailSec := resultOr(results, CheckEmailSecurity, EmailSecurity{
+		CheckOutcome: emailOutcome,
+		HasValidMX:   true,
+		HasDMARC:     true,
+		MXCheck:      emailOutcome,
+		SPFCheck:     emailOutcome,
+		DMARCCheck:   emailOutcome,
+	})
+	whois := resultOr(results, CheckWhois, WhoisResult{CheckOutcome: results.Outcome(CheckWhois), AgeDays: disabledWhoisAgeDays})
+	google := resultOr(results, CheckGoogleSafeBrowsing, SafeBrowsingResult{CheckOutcome: results.Outcome(CheckGoogleSafeBrowsing)})
+	blacklistOutcome := results.Outcome(CheckBlacklists)
+	blacklists := resultOr(results, CheckBlacklists, BlacklistSummary{
+		CheckOutcome: blacklistOutcome,
+		Analysis:     AnalyzeBlacklists(nil),
+		MXToolbox:    blacklistOutcome,
+		Feeds:        blacklistOutcome,
+	})
+	website := resultOr(results, CheckWebsiteName, WebsiteCheck{CheckOutcome: results.Outcome(CheckWebsiteName), Exists: true})
+	optIn := resultOr(results, CheckOptIn, OptInCheck{CheckOutcome: results.Outcome(CheckOptIn), Compliance: true, HasCaptcha: true})
+	spfAuth := resultOr(results, CheckSPFAuthorization, SPFAuthorization{CheckOutcome: results.Outcome(CheckSPFAuthorization)})
+	dkim := resultOr(results, CheckDKIM, DKIMResult{CheckOutcome: results.Outcome(CheckDKIM)})
+	rdns := resultOr(results, CheckReverseDNS, ReverseDNSResult{CheckOutcome: results.Outcome(CheckReverseDNS)})
+	dnssec := resultOr(results, CheckDNSSEC, DNSSECResult{CheckOutcome: results.Outcome(CheckDNSSEC)})
+	consensus := resultOr(results, CheckDNSConsensus, DNSConsensusResult{CheckOutcome: results.Outcome(CheckDNSConsensus)})
+	dnsHealth := resultOr(results, CheckDNSHealth, DNSHealthResult{CheckOutcome: results.Outcome(CheckDNSHealth)})
+	mxHosts := resultOr(results, CheckMXHosts, MXAnalysis{CheckOutcome: results.Outcome(CheckMXHosts)})
+	mtaSTS := resultOr(results, CheckMTASTS, MTASTSResult{CheckOutcome: results.Outcome(CheckMTASTS)})
+	bimi := resultOr(results, CheckBIMI, BIMIResult{CheckOutcome: results.Outcome(CheckBIMI)})
+
+	// SCORE AND DETERMINE REJECTION STATUS (one rule evaluation, see rules.go)
+	// Critical blacklist, MX reputation, website, HTTPS and Google Safe Browsing
+	// are reject rules; CAPTCHA and opt-in compliance are not rejections
+	inputs := ScoreInputs{
+		HTTPS:        https,
+		TLSDays:      tlsDays,
+		Whois:        whois,
+		IsSubdomain:  isSubdom,
+		Blacklists:   blacklists,
+		SafeBrowsing: google,
+		Email:        emailSec,
+		SSL:          ssl,
+		OptIn:        optIn,
+		Website:      website,
+		SPFAuth:      spfAuth,
+		DKIM:         dkim,
+		ReverseDNS:   rdns,
+		DNSSEC:       dnssec,
+		DNSConsensus: consensus,
+		DNSHealth:    dnsHealth,
+		MXHosts:      mxHosts,
+		MTASTS:       mtaSTS,
+		BIMI:         bimi,
+		IPs:          ips,
+		Geo:          geo,
 	}
-
-	// Check 3: CRITICAL - Website must exist
-	if !website.Exists {
-		isRejected = true
-		rejectReasons = append(rejectReasons, "Website does not exist or is not accessible")
+	features := scoringPolicy.Features(inputs)
+	score := scoringPolicy.ScoreFeatures(features)
+
+	// Response fields show the values as the policy resolved them
+	httpsOK := features.Bool("https.ok")
+	website.Exists = features.Bool("website.exists")
+	if !website.Known() {
+		website.HTTPSOk = httpsOK
 	}
-
-	// Check 4: CRITICAL - HTTPS must be enabled
-	if !httpsOK {
-		isRejected = true
-		rejectReasons = append(rejectReasons, "HTTPS is not enabled on the domain")
+	createdOn := whois.CreatedOn
+	googleFlagged := features.Bool("safe_browsing.flagged")
+	googleReason := features.Text("safe_browsing.reason")
+	blacklistCombined := blacklists.Hits
+	blacklistAnalysis := features.Blacklist
+	mxRepOk := features.Bool("mx.reputation_ok")
+
+	// SHADOW SCORING (candidate policy, same check results; see scoring_shadow.go)
+	var shadow *ShadowScore
+	if candidate := CandidateScoringPolicy(); candidate != nil {
+		shadow = ShadowScoreOf(candidate, req.Profile, inputs, score)
+		logShadowScore(domain, score, shadow)
 	}
 
-	// Check 5: CRITICAL - Google Safe Browsing must be clean
-	if googleFlagged {
-		isRejected = true
-		rejectReasons = append(rejectReasons, "Google Safe Browsing flagged this domain as unsafe: "+googleReason)
+	// ML FEATURE VECTOR (see features.go) - always kept with the stored result
+	vetID := newVetID()
+	fv := ExtractFeatures(domain, inputs, features, score)
+	fv.VetID = vetID
+	recordFeatures(fv)
+	var featureVector *ScoringFeatures
+	if req.IncludeFeatures {
+		featureVector = &fv
 	}
 
-	// OPT-IN CHECKS - Real-time CAPTCHA detection (on parent domain for subdomains)
-	optIn := EvaluateOptIn(req.SelfAttested, websiteCheckDomain)
-
-	// Compliance is default true for now (will be discussed with client later)
-	// CAPTCHA check is done via scoring penalty (-50 if not found)
-
-	// Combine reject reasons
-	if len(rejectReasons) > 0 {
+	isRejected := score.Level == "rejected"
+	rejectReason := ""
+	if isRejected {
+		rejectReasons := []string{}
+		for _, fired := range score.FiredRules {
+			if fired.Action == ActionReject {
+				rejectReasons = append(rejectReasons, fired.Reason)
+			}
+		}
 		rejectReason = "REJECTED: " + joinReasons(rejectReasons)
 	}
 
-	// CALCULATE SCORE (using blacklist penalties instead of simple count)
-	score := CalculateScoreV2(
-		httpsOK,
-		tlsDays,
-		whoisDays,
-		blacklistAnalysis, // Pass analysis instead of count
-		mxRepOk,
-		googleFlagged,
-		emailSec,
-		ssl,
-		optIn,
-		website,
-		isRejected,
-	)
+	var spfAuthResp *SPFAuthorization
+	if spfAuth.Status != StatusSkipped {
+		spfAuthResp = &spfAuth
+	}
 
 	// Build response
 	parentDomainResp := ""
@@ -319,12 +367,18 @@ func VetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := VetResponse{
+		ID:           vetID,
 		Domain:       domain,
 		ParentDomain: parentDomainResp,
 		IsSubdomain:  isSubdom,
 		IPAddress:    ip,
 		CreatedOn:    createdOn,
 
+		ReverseDNS:   rdns,
+		DNSSEC:       dnssec,
+		DNSConsensus: consensus,
+		DNSHealth:    dnsHealth,
+
 		IsRejected:   isRejected,
 		RejectReason: rejectReason,
 
@@ -339,7 +393,16 @@ func VetHandler(w http.ResponseWriter, r *http.Request) {
 			HasValidMX:   emailSec.HasValidMX,
 			HasDMARC:     emailSec.HasDMARC,
 			DMARCRecord:  emailSec.DMARCRecord,
-			DMARCWarning: getDMARCWarning(emailSec.HasDMARC, emailSec.DMARCRecord),
+			DMARCWarning: getDMARCWarning(emailSec.HasDMARC, emailSec.DMARC),
+			DMARC:        emailSec.DMARC,
+			SPF:          emailSec.SPF,
+			DKIM:         dkim,
+			MXHosts:      mxHosts,
+			NullMX:       emailSec.NullMX,
+			MTASTS:       mtaSTS,
+			BIMI:         bimi,
+
+			SPFAuthorization: spfAuthResp,
 		},
 
 		Website: WebsiteCheckSimple{
@@ -349,9 +412,17 @@ func VetHandler(w http.ResponseWriter, r *http.Request) {
 		OptIn: optIn,
 
 		Summary:   score,
+		Shadow:    shadow,
+		Features:  featureVector,
+		Checks:    results.Reports(),
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
 
+	// Keep the result so warmup outcomes can be recorded against resp.ID
+	if err := DefaultOutcomeStore.SaveResult(StoredVetting{Response: resp, Features: fv, Inputs: &inputs}); err != nil {
+		log.Printf("[Outcomes] ❌ Cannot store vetting result %s for %s: %v", vetID, domain, err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 
@@ -0,0 +1,336 @@
+package vetting
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// MTA-STS (RFC 8461) AND TLS-RPT (RFC 8460)
+//
+// A domain opts into MTA-STS with a v=STSv1 TXT record at _mta-sts.<domain>;
+// senders then fetch the policy from https://mta-sts.<domain>/.well-known/mta-sts.txt
+// and, in enforce mode, only deliver over authenticated TLS to MX hosts the
+// policy's mx patterns match. The patterns are cross-checked against the real
+// MX records: an uncovered MX under enforce is mail senders refuse to deliver.
+// TLS-RPT (_smtp._tls.<domain>) is where senders report TLS failures.
+//
+
+// MTA-STS policy modes
+const (
+	MTASTSEnforce = "enforce"
+	MTASTSTesting = "testing"
+	MTASTSNone    = "none"
+)
+
+const (
+	mtaSTSMaxPolicySize = 64 * 1024 // §3.3: senders may reject larger policies
+	mtaSTSMaxAge        = 31557600  // §3.2: one year
+	mtaSTSMinMaxAge     = 86400     // Shorter policies expire between deliveries
+)
+
+// mtaSTSIDPattern is the id= syntax of the TXT record (1-32 alphanumerics)
+var mtaSTSIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,32}$`)
+
+// MTASTSPolicy is the policy file served over HTTPS
+type MTASTSPolicy struct {
+	Version string   `json:"version"`
+	Mode    string   `json:"mode"` // enforce, testing, none
+	MX      []string `json:"mx"`   // Host patterns ("*.example.net" matches one label)
+	MaxAge  int      `json:"max_age"`
+	Valid   bool     `json:"valid"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// TLSRPTRecord is the TLS reporting record at _smtp._tls.<domain>
+type TLSRPTRecord struct {
+	Record string   `json:"record"`
+	RUA    []string `json:"rua,omitempty"` // mailto: or https: destinations
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// MTASTSResult - MTA-STS record and policy, cross-checked against the MX hosts
+type MTASTSResult struct {
+	CheckOutcome
+	Record      string        `json:"record,omitempty"` // _mta-sts TXT record
+	ID          string        `json:"id,omitempty"`
+	PolicyURL   string        `json:"policy_url,omitempty"`
+	Policy      *MTASTSPolicy `json:"policy,omitempty"`
+	PolicyError string        `json:"policy_error,omitempty"` // Fetch failed (senders ignore the record)
+	Enforced    bool          `json:"enforced"`               // Valid policy in enforce mode
+	UncoveredMX []string      `json:"uncovered_mx,omitempty"` // MX hosts no mx pattern matches
+	Issues      []string      `json:"issues,omitempty"`
+
+	// TLS-RPT record (nil if none) and whether it could be looked up
+	TLSRPT      *TLSRPTRecord `json:"tls_rpt,omitempty"`
+	TLSRPTCheck CheckOutcome  `json:"tls_rpt_check"`
+}
+
+// Mode returns the mode senders apply ("" without a usable policy)
+func (r MTASTSResult) Mode() string {
+	if r.Policy == nil || !r.Policy.Valid {
+		return ""
+	}
+	return r.Policy.Mode
+}
+
+// mtaSTSClient fetches policies; redirects must not be followed (§3.3)
+var mtaSTSClient = &http.Client{
+	Timeout: 8 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// AnalyzeMTASTS looks up the MTA-STS and TLS-RPT records of domain and
+// cross-checks the policy against mxHosts (nil skips the cross-check)
+func AnalyzeMTASTS(ctx context.Context, domain string, mxHosts []string) MTASTSResult {
+	return analyzeMTASTS(ctx, defaultDNS(), mtaSTSClient, domain, mxHosts)
+}
+
+func analyzeMTASTS(ctx context.Context, dns DNSClient, client *http.Client, domain string, mxHosts []string) MTASTSResult {
+	var res MTASTSResult
+
+	rpt, err := lookupTLSRPT(ctx, dns, domain)
+	res.TLSRPT = rpt
+	res.TLSRPTCheck = recordOutcome(err, rpt != nil)
+
+	txts, err := dns.LookupTXT(ctx, "_mta-sts."+domain)
+	if err != nil && !isDNSNotFound(err) {
+		res.CheckOutcome = outcomeOf(err)
+		return res
+	}
+	var records []string
+	for _, txt := range txts {
+		if isMTASTSRecord(txt) {
+			records = append(records, txt)
+		}
+	}
+	if len(records) == 0 {
+		res.CheckOutcome = negativeIf(true)
+		log.Printf("[MTA-STS] %s: no _mta-sts record (tls-rpt=%v)", domain, rpt != nil)
+		return res
+	}
+	res.CheckOutcome = CheckOutcome{Status: StatusOK}
+	res.Record = records[0]
+
+	// §3.1: more than one record means senders assume no policy
+	if len(records) > 1 {
+		res.Issues = append(res.Issues, fmt.Sprintf("%d _mta-sts records published (must be exactly one); senders ignore MTA-STS", len(records)))
+		return res
+	}
+	if id, ok := mtaSTSRecordID(res.Record); ok {
+		res.ID = id
+	} else {
+		res.Issues = append(res.Issues, `_mta-sts record needs an id= of 1-32 letters/digits; senders ignore it`)
+		return res
+	}
+
+	res.PolicyURL = "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+	body, err := fetchMTASTSPolicy(ctx, client, res.PolicyURL)
+	if err != nil {
+		res.PolicyError = err.Error()
+		res.Issues = append(res.Issues, "policy could not be fetched: "+err.Error())
+		log.Printf("[MTA-STS] ⚠️ %s: policy fetch failed: %v", domain, err)
+		return res
+	}
+	policy := ParseMTASTSPolicy(body)
+	res.Policy = &policy
+	if !policy.Valid {
+		res.Issues = append(res.Issues, "policy is invalid: "+strings.Join(policy.Errors, "; "))
+		return res
+	}
+	res.Enforced = policy.Mode == MTASTSEnforce
+	if policy.Mode != MTASTSNone && policy.MaxAge < mtaSTSMinMaxAge {
+		res.Issues = append(res.Issues, fmt.Sprintf("max_age %d is under a day: the policy expires between deliveries", policy.MaxAge))
+	}
+
+	if policy.Mode != MTASTSNone {
+		for _, host := range mxHosts {
+			if !mtaSTSMatchesAny(policy.MX, host) {
+				res.UncoveredMX = append(res.UncoveredMX, host)
+			}
+		}
+	}
+	switch {
+	case len(res.UncoveredMX) > 0 && res.Enforced:
+		res.Issues = append(res.Issues, fmt.Sprintf("enforce policy does not cover MX %s: MTA-STS senders will refuse to deliver to it", strings.Join(res.UncoveredMX, ", ")))
+	case len(res.UncoveredMX) > 0:
+		res.Issues = append(res.Issues, fmt.Sprintf("policy does not cover MX %s (failures are reported, not enforced, in %s mode)", strings.Join(res.UncoveredMX, ", "), policy.Mode))
+	}
+	if policy.Mode == MTASTSTesting && rpt == nil {
+		res.Issues = append(res.Issues, "testing mode without a TLS-RPT record: nobody receives the failure reports")
+	}
+
+	log.Printf("[MTA-STS] %s: mode=%s max_age=%d mx=%v (uncovered=%v)", domain, policy.Mode, policy.MaxAge, policy.MX, res.UncoveredMX)
+	return res
+}
+
+// isMTASTSRecord reports whether a TXT record is an MTA-STS record (starts
+// with the v=STSv1 tag)
+func isMTASTSRecord(txt string) bool {
+	tag, _, _ := strings.Cut(txt, ";")
+	name, value, ok := strings.Cut(tag, "=")
+	return ok && strings.TrimSpace(name) == "v" && strings.TrimSpace(value) == "STSv1"
+}
+
+// mtaSTSRecordID returns the id= of an MTA-STS record if it is valid
+func mtaSTSRecordID(record string) (string, bool) {
+	for _, tag := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if ok && strings.TrimSpace(name) == "id" {
+			id := strings.TrimSpace(value)
+			return id, mtaSTSIDPattern.MatchString(id)
+		}
+	}
+	return "", false
+}
+
+// fetchMTASTSPolicy downloads the policy file: HTTPS with a valid
+// certificate, 200 only (no redirects), at most mtaSTSMaxPolicySize bytes
+func fetchMTASTSPolicy(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d (senders require 200, redirects are not followed)", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, mtaSTSMaxPolicySize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > mtaSTSMaxPolicySize {
+		return "", fmt.Errorf("policy is larger than %d bytes", mtaSTSMaxPolicySize)
+	}
+	return string(body), nil
+}
+
+// ParseMTASTSPolicy parses a policy file (RFC 8461 §3.2): "key: value" lines
+func ParseMTASTSPolicy(body string) MTASTSPolicy {
+	var p MTASTSPolicy
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			p.Errors = append(p.Errors, fmt.Sprintf("malformed line %q", line))
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		// Only mx may repeat; senders use the first of any other key
+		if key != "mx" && seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch key {
+		case "version":
+			p.Version = value
+		case "mode":
+			p.Mode = value
+		case "mx":
+			p.MX = append(p.MX, strings.ToLower(strings.TrimSuffix(value, ".")))
+		case "max_age":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > mtaSTSMaxAge {
+				p.Errors = append(p.Errors, fmt.Sprintf("max_age %q must be 0-%d seconds", value, mtaSTSMaxAge))
+				continue
+			}
+			p.MaxAge = n
+		}
+	}
+
+	if p.Version != "STSv1" {
+		p.Errors = append(p.Errors, fmt.Sprintf("version %q must be STSv1", p.Version))
+	}
+	switch p.Mode {
+	case MTASTSEnforce, MTASTSTesting, MTASTSNone:
+	default:
+		p.Errors = append(p.Errors, fmt.Sprintf("mode %q must be enforce, testing or none", p.Mode))
+	}
+	if !seen["max_age"] {
+		p.Errors = append(p.Errors, "max_age is missing")
+	}
+	if len(p.MX) == 0 && p.Mode != MTASTSNone {
+		p.Errors = append(p.Errors, "no mx patterns")
+	}
+	p.Valid = len(p.Errors) == 0
+	return p
+}
+
+// mtaSTSMatchesAny reports whether an MX host matches one of the patterns;
+// a leading "*." matches exactly one label (§4.1)
+func mtaSTSMatchesAny(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, rest, found := strings.Cut(host, ".")
+			if found && label != "" && rest == suffix {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupTLSRPT returns the TLS-RPT record of domain (nil if none)
+func lookupTLSRPT(ctx context.Context, dns DNSClient, domain string) (*TLSRPTRecord, error) {
+	txts, err := dns.LookupTXT(ctx, "_smtp._tls."+domain)
+	if err != nil && !isDNSNotFound(err) {
+		return nil, err
+	}
+	for _, txt := range txts {
+		tag, _, _ := strings.Cut(txt, ";")
+		if name, value, ok := strings.Cut(tag, "="); ok && strings.TrimSpace(name) == "v" && strings.TrimSpace(value) == "TLSRPTv1" {
+			rpt := ParseTLSRPT(txt)
+			return &rpt, nil
+		}
+	}
+	return nil, nil
+}
+
+// ParseTLSRPT parses a TLS-RPT record (RFC 8460 §3)
+func ParseTLSRPT(record string) TLSRPTRecord {
+	rpt := TLSRPTRecord{Record: record}
+	for _, tag := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if !ok || strings.TrimSpace(name) != "rua" {
+			continue
+		}
+		for _, uri := range strings.Split(value, ",") {
+			uri = strings.TrimSpace(uri)
+			lower := strings.ToLower(uri)
+			if !strings.HasPrefix(lower, "mailto:") && !strings.HasPrefix(lower, "https:") {
+				rpt.Errors = append(rpt.Errors, fmt.Sprintf("rua %q must be a mailto: or https: URI", uri))
+				continue
+			}
+			rpt.RUA = append(rpt.RUA, uri)
+		}
+	}
+	if len(rpt.RUA) == 0 && len(rpt.Errors) == 0 {
+		rpt.Errors = append(rpt.Errors, "rua= is missing")
+	}
+	rpt.Valid = len(rpt.Errors) == 0
+	return rpt
+}
@@ -0,0 +1,174 @@
+package vetting
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		mode   string
+		mx     []string
+		maxAge int
+		err    string // Substring of an error ("" = valid)
+	}{
+		{
+			name:   "enforce",
+			body:   "version: STSv1\r\nmode: enforce\r\nmx: mx1.example.com.\r\nmx: *.Mail.example.net\r\nmax_age: 604800\r\n",
+			mode:   MTASTSEnforce,
+			mx:     []string{"mx1.example.com", "*.mail.example.net"},
+			maxAge: 604800,
+		},
+		{
+			name:   "first of a repeated key wins",
+			body:   "version: STSv1\nmode: testing\nmode: enforce\nmx: mx.example.com\nmax_age: 86400\nmax_age: 1\n",
+			mode:   MTASTSTesting,
+			mx:     []string{"mx.example.com"},
+			maxAge: 86400,
+		},
+		{
+			name:   "none needs no mx",
+			body:   "version: STSv1\nmode: none\nmax_age: 86400\nunknown: ignored\n",
+			mode:   MTASTSNone,
+			maxAge: 86400,
+		},
+		{name: "wrong version", body: "version: STSv2\nmode: enforce\nmx: mx.example.com\nmax_age: 86400", mode: MTASTSEnforce, mx: []string{"mx.example.com"}, maxAge: 86400, err: `version "STSv2"`},
+		{name: "unknown mode", body: "version: STSv1\nmode: strict\nmx: mx.example.com\nmax_age: 86400", mode: "strict", mx: []string{"mx.example.com"}, maxAge: 86400, err: `mode "strict"`},
+		{name: "no mx", body: "version: STSv1\nmode: enforce\nmax_age: 86400", mode: MTASTSEnforce, maxAge: 86400, err: "no mx patterns"},
+		{name: "max_age missing", body: "version: STSv1\nmode: enforce\nmx: mx.example.com", mode: MTASTSEnforce, mx: []string{"mx.example.com"}, err: "max_age is missing"},
+		{name: "max_age over a year", body: "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: 31557601", mode: MTASTSEnforce, mx: []string{"mx.example.com"}, err: "must be 0-31557600"},
+		{name: "malformed line", body: "version: STSv1\nmode enforce\nmx: mx.example.com\nmax_age: 86400", mx: []string{"mx.example.com"}, maxAge: 86400, err: `malformed line "mode enforce"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ParseMTASTSPolicy(tt.body)
+			if p.Mode != tt.mode || !slices.Equal(p.MX, tt.mx) || p.MaxAge != tt.maxAge {
+				t.Errorf("mode %q mx %v max_age %d, want %q %v %d", p.Mode, p.MX, p.MaxAge, tt.mode, tt.mx, tt.maxAge)
+			}
+			errs := strings.Join(p.Errors, "; ")
+			if p.Valid != (tt.err == "") || !strings.Contains(errs, tt.err) {
+				t.Errorf("valid=%v errors %q, want %q", p.Valid, errs, tt.err)
+			}
+		})
+	}
+}
+
+func TestMTASTSMatchesAny(t *testing.T) {
+	patterns := []string{"mx1.example.com", "*.mail.example.net"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"mx1.example.com", true},
+		{"MX1.Example.com.", true},
+		{"mx2.example.com", false},
+		{"a.mail.example.net", true},
+		{"a.b.mail.example.net", false}, // The wildcard is one label
+		{"mail.example.net", false},
+		{".mail.example.net", false},
+		{"amail.example.net", false},
+	}
+	for _, tt := range tests {
+		if got := mtaSTSMatchesAny(patterns, tt.host); got != tt.want {
+			t.Errorf("mtaSTSMatchesAny(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+// mtaSTSTestServer serves policies over TLS; its client trusts the server and
+// keeps the production redirect policy
+func mtaSTSTestServer(t *testing.T, handler http.Handler) (*httptest.Server, *http.Client) {
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+	client.CheckRedirect = mtaSTSClient.CheckRedirect
+	return srv, client
+}
+
+func TestFetchMTASTSPolicy(t *testing.T) {
+	policy := "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: 86400\n"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/policy", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(policy)) })
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/policy", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/max", func(w http.ResponseWriter, r *http.Request) { w.Write(make([]byte, mtaSTSMaxPolicySize)) })
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) { w.Write(make([]byte, mtaSTSMaxPolicySize+1)) })
+	srv, client := mtaSTSTestServer(t, mux)
+	ctx := context.Background()
+
+	if body, err := fetchMTASTSPolicy(ctx, client, srv.URL+"/policy"); err != nil || body != policy {
+		t.Errorf("policy = %q, %v", body, err)
+	}
+	if _, err := fetchMTASTSPolicy(ctx, client, srv.URL+"/redirect"); err == nil || !strings.Contains(err.Error(), "HTTP 301") {
+		t.Errorf("redirect followed: %v", err)
+	}
+	if _, err := fetchMTASTSPolicy(ctx, client, srv.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("404 error = %v", err)
+	}
+	if body, err := fetchMTASTSPolicy(ctx, client, srv.URL+"/max"); err != nil || len(body) != mtaSTSMaxPolicySize {
+		t.Errorf("policy of the maximum size: %d bytes, %v", len(body), err)
+	}
+	if _, err := fetchMTASTSPolicy(ctx, client, srv.URL+"/large"); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("oversized policy error = %v", err)
+	}
+	// The test certificate is not trusted by the production client
+	if _, err := fetchMTASTSPolicy(ctx, mtaSTSClient, srv.URL+"/policy"); err == nil {
+		t.Error("untrusted certificate accepted")
+	}
+}
+
+func TestAnalyzeMTASTS(t *testing.T) {
+	srv, client := mtaSTSTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "mta-sts.example.com" || r.URL.Path != "/.well-known/mta-sts.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("version: STSv1\nmode: enforce\nmx: *.mx.example.com\nmax_age: 3600\n"))
+	}))
+	// Every policy host is the test server (its certificate is for example.com)
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	transport.TLSClientConfig.ServerName = "example.com"
+	client.Transport = transport
+
+	dns := &FakeDNS{TXT: map[string][]string{
+		"_mta-sts.example.com":   {"v=STSv1; id=20240101T000000"},
+		"_smtp._tls.example.com": {"v=TLSRPTv1; rua=mailto:tls@example.com"},
+		"_mta-sts.badid.test":    {"v=STSv1; id=not-valid!"},
+	}}
+	res := analyzeMTASTS(context.Background(), dns, client, "example.com", []string{"a.mx.example.com", "backup.example.net"})
+	if res.Status != StatusOK || !res.Enforced || res.Mode() != MTASTSEnforce || res.ID != "20240101T000000" {
+		t.Fatalf("result %+v", res)
+	}
+	if !slices.Equal(res.UncoveredMX, []string{"backup.example.net"}) {
+		t.Errorf("uncovered MX %v", res.UncoveredMX)
+	}
+	issues := strings.Join(res.Issues, "; ")
+	for _, want := range []string{"max_age 3600 is under a day", "MTA-STS senders will refuse to deliver"} {
+		if !strings.Contains(issues, want) {
+			t.Errorf("issues %q, want %q", issues, want)
+		}
+	}
+	if res.TLSRPT == nil || !res.TLSRPT.Valid || res.TLSRPTCheck.Status != StatusOK {
+		t.Errorf("TLS-RPT %+v (%s)", res.TLSRPT, res.TLSRPTCheck.Status)
+	}
+
+	res = analyzeMTASTS(context.Background(), dns, client, "badid.test", nil)
+	if res.Policy != nil || !strings.Contains(strings.Join(res.Issues, "; "), "id=") {
+		t.Errorf("invalid id: %+v", res)
+	}
+	res = analyzeMTASTS(context.Background(), dns, client, "none.test", nil)
+	if res.Status != StatusNegative || res.TLSRPTCheck.Status != StatusNegative {
+		t.Errorf("no records: %s, tls-rpt %s", res.Status, res.TLSRPTCheck.Status)
+	}
+}
@@ -0,0 +1,316 @@
+package vetting
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+//
+// MX HOSTS
+//
+// Every MX host is resolved to its A/AAAA records and flagged if the MX
+// names an IP literal (RFC 5321 §5.1) or an alias (RFC 2181 §10.3), and each
+// address gets a forward-confirmed PTR lookup. A null MX (RFC 7505) is
+// reported by GetEmailSecurity. The SMTP probe (VetRequest.smtp_probe or
+// VETTING_SMTP_PROBE=true; outbound port 25 is blocked on many hosts) reads
+// the 220 banner, sends EHLO and records STARTTLS and the certificate.
+//
+
+const (
+	mxMaxHosts       = 10              // MX hosts analyzed
+	mxMaxAddrs       = 4               // Addresses per host with a PTR lookup
+	smtpMaxProbes    = 3               // Hosts probed (by preference)
+	smtpProbeTimeout = 8 * time.Second // Under the mx_hosts check timeout
+)
+
+// smtpPort is the port SMTP probes connect to
+var smtpPort = "25"
+
+// SMTPProbe is what an MX host answered on port 25
+type SMTPProbe struct {
+	Address     string    `json:"address"`
+	Banner      string    `json:"banner,omitempty"` // 220 greeting
+	EHLO        bool      `json:"ehlo"`             // EHLO accepted
+	Extensions  []string  `json:"extensions,omitempty"`
+	STARTTLS    bool      `json:"starttls"` // Advertised in the EHLO response
+	TLSVersion  string    `json:"tls_version,omitempty"`
+	CertValid   bool      `json:"cert_valid"` // Chains to a trusted root and names the MX host
+	CertError   string    `json:"cert_error,omitempty"`
+	CertExpires time.Time `json:"cert_expires,omitzero"`
+	Error       string    `json:"error,omitempty"` // Probe failed (connection, timeout, bad reply)
+}
+
+// MXHost is one MX host resolved, reverse-checked and (optionally) probed
+type MXHost struct {
+	Host      string       `json:"host"`
+	IPv4      []string     `json:"ipv4,omitempty"`
+	IPv6      []string     `json:"ipv6,omitempty"`
+	CNAME     string       `json:"cname,omitempty"` // Host is an alias of this name
+	IPLiteral bool         `json:"ip_literal"`      // MX names an address instead of a host
+	Resolves  bool         `json:"resolves"`        // Has A/AAAA records
+	PTR       []ReverseDNS `json:"ptr,omitempty"`
+	FCrDNS    bool         `json:"fcrdns"` // Every address has forward-confirmed reverse DNS
+	SMTP      *SMTPProbe   `json:"smtp,omitempty"`
+	Error     string       `json:"error,omitempty"` // Address lookup failed
+	Issues    []string     `json:"issues,omitempty"`
+}
+
+// MXAnalysis - the domain's MX hosts in preference order
+type MXAnalysis struct {
+	CheckOutcome
+	Hosts      []MXHost `json:"hosts,omitempty"`
+	Resolving  int      `json:"resolving"`   // Hosts with at least one address
+	FCrDNS     bool     `json:"fcrdns"`      // Every resolving host passes FCrDNS
+	Probed     bool     `json:"smtp_probed"` // SMTP probe ran
+	NoSTARTTLS []string `json:"no_starttls,omitempty"`
+}
+
+// smtpProbeEnabled reports whether MX hosts should be probed over SMTP
+func smtpProbeEnabled(req *VetRequest) bool {
+	if req != nil && req.SMTPProbe {
+		return true
+	}
+	return os.Getenv("VETTING_SMTP_PROBE") == "true"
+}
+
+// AnalyzeMXHosts resolves and reverse-checks the MX hosts (by preference)
+// and, if probe is set, connects to them over SMTP
+func AnalyzeMXHosts(ctx context.Context, hosts []string, probe bool) MXAnalysis {
+	return analyzeMXHosts(ctx, defaultDNS(), hosts, probe)
+}
+
+func analyzeMXHosts(ctx context.Context, dns DNSClient, hosts []string, probe bool) MXAnalysis {
+	res := MXAnalysis{Probed: probe}
+	if len(hosts) == 0 {
+		res.CheckOutcome = skipped("no MX records")
+		return res
+	}
+	if len(hosts) > mxMaxHosts {
+		hosts = hosts[:mxMaxHosts]
+	}
+
+	res.Hosts = make([]MXHost, len(hosts))
+	var g errgroup.Group
+	g.SetLimit(4)
+	for i, host := range hosts {
+		g.Go(func() error {
+			res.Hosts[i] = analyzeMXHost(ctx, dns, host, probe && i < smtpMaxProbes)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	res.FCrDNS = true
+	var lastErr string
+	for _, h := range res.Hosts {
+		if h.Error != "" {
+			lastErr = h.Error
+		}
+		if !h.Resolves {
+			continue
+		}
+		res.Resolving++
+		if !h.FCrDNS {
+			res.FCrDNS = false
+		}
+		if h.SMTP != nil && h.SMTP.EHLO && !h.SMTP.STARTTLS {
+			res.NoSTARTTLS = append(res.NoSTARTTLS, h.Host)
+		}
+	}
+
+	// No resolving host is only a finding if the lookups were answered
+	switch {
+	case res.Resolving > 0:
+		res.CheckOutcome = CheckOutcome{Status: StatusOK}
+	case lastErr != "":
+		res.FCrDNS = false
+		res.CheckOutcome = CheckOutcome{Status: StatusError, Error: lastErr}
+	default:
+		res.FCrDNS = false
+		res.CheckOutcome = negativeIf(true)
+	}
+
+	log.Printf("[MXHosts] %d/%d hosts resolve (fcrdns=%v, probed=%v, no starttls=%v)",
+		res.Resolving, len(res.Hosts), res.FCrDNS, probe, res.NoSTARTTLS)
+	return res
+}
+
+// analyzeMXHost resolves one MX host and checks its addresses
+func analyzeMXHost(ctx context.Context, dns DNSClient, host string, probe bool) MXHost {
+	h := MXHost{Host: host}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		h.IPLiteral = true
+		h.Issues = append(h.Issues, "MX names an IP address, not a host name (RFC 5321): senders ignore it")
+		return h
+	}
+
+	if cname, err := dns.LookupCNAME(ctx, host); err == nil {
+		cname = strings.TrimSuffix(cname, ".")
+		if cname != "" && !strings.EqualFold(cname, host) {
+			h.CNAME = cname
+			h.Issues = append(h.Issues, fmt.Sprintf("MX host is an alias of %s: MX must name the host itself (RFC 2181 §10.3)", cname))
+		}
+	}
+
+	ips, err := dns.LookupIP(ctx, "ip", host)
+	if err != nil && !isDNSNotFound(err) {
+		h.Error = err.Error()
+		return h
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			h.IPv4 = append(h.IPv4, ip.String())
+		} else {
+			h.IPv6 = append(h.IPv6, ip.String())
+		}
+	}
+	h.Resolves = len(ips) > 0
+	if !h.Resolves {
+		h.Issues = append(h.Issues, "MX host has no A/AAAA records: mail to it cannot be delivered")
+		return h
+	}
+
+	// FCrDNS: PTR lookup errors are unknown, not failures
+	h.FCrDNS = true
+	var unconfirmed []string
+	for i, ip := range ips {
+		if i == mxMaxAddrs {
+			break
+		}
+		r := lookupReverseDNS(ctx, dns, ip)
+		h.PTR = append(h.PTR, r)
+		if !r.Confirmed {
+			h.FCrDNS = false
+			if r.Error == "" {
+				unconfirmed = append(unconfirmed, r.IP)
+			}
+		}
+	}
+	if len(unconfirmed) > 0 {
+		h.Issues = append(h.Issues, "no forward-confirmed reverse DNS for "+strings.Join(unconfirmed, ", "))
+	}
+
+	if probe {
+		p := ProbeSMTP(ctx, net.JoinHostPort(ips[0].String(), smtpPort), host)
+		h.SMTP = &p
+		switch {
+		case !p.EHLO:
+			h.Issues = append(h.Issues, "SMTP probe failed: "+p.Error)
+		case !p.STARTTLS:
+			h.Issues = append(h.Issues, "STARTTLS is not offered: mail to this host travels unencrypted")
+		case p.Error != "":
+			h.Issues = append(h.Issues, "STARTTLS failed: "+p.Error)
+		case !p.CertValid:
+			h.Issues = append(h.Issues, "STARTTLS certificate is not valid: "+p.CertError)
+		}
+	}
+	return h
+}
+
+// ProbeSMTP connects to an SMTP server at addr, reads its banner, sends EHLO
+// and, if offered, STARTTLS; host is the MX name the certificate must match
+func ProbeSMTP(ctx context.Context, addr, host string) SMTPProbe {
+	return probeSMTP(ctx, addr, host, nil)
+}
+
+// probeSMTP verifies the certificate against roots (nil = system roots)
+func probeSMTP(ctx context.Context, addr, host string, roots *x509.CertPool) SMTPProbe {
+	p := SMTPProbe{Address: addr}
+	ctx, cancel := context.WithTimeout(ctx, smtpProbeTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	text := textproto.NewConn(conn)
+	_, banner, err := text.ReadResponse(220)
+	if err != nil {
+		p.Error = "banner: " + err.Error()
+		return p
+	}
+	p.Banner = banner
+
+	if err := text.PrintfLine("EHLO %s", smtpHELOName()); err != nil {
+		p.Error = "EHLO: " + err.Error()
+		return p
+	}
+	_, msg, err := text.ReadResponse(250)
+	if err != nil {
+		p.Error = "EHLO: " + err.Error()
+		return p
+	}
+	p.EHLO = true
+	// First line is the greeting, the rest are extensions
+	lines := strings.Split(msg, "\n")
+	for _, ext := range lines[1:] {
+		p.Extensions = append(p.Extensions, ext)
+		if keyword, _, _ := strings.Cut(ext, " "); strings.EqualFold(keyword, "STARTTLS") {
+			p.STARTTLS = true
+		}
+	}
+	if !p.STARTTLS {
+		_ = text.PrintfLine("QUIT")
+		return p
+	}
+
+	if err := text.PrintfLine("STARTTLS"); err != nil {
+		p.Error = "STARTTLS: " + err.Error()
+		return p
+	}
+	if _, _, err := text.ReadResponse(220); err != nil {
+		p.Error = "STARTTLS: " + err.Error()
+		return p
+	}
+	// Verified below, so an invalid certificate is recorded rather than fatal
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		p.Error = "TLS handshake: " + err.Error()
+		return p
+	}
+	state := tlsConn.ConnectionState()
+	p.TLSVersion = tlsVersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		p.CertExpires = leaf.NotAfter
+		intermediates := x509.NewCertPool()
+		for _, c := range state.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates})
+		p.CertValid = err == nil
+		if err != nil {
+			p.CertError = err.Error()
+		}
+	}
+	_, _ = fmt.Fprintf(tlsConn, "QUIT\r\n")
+	return p
+}
+
+// smtpHELOName is the EHLO name of probes (VETTING_SMTP_HELO, else the hostname)
+func smtpHELOName() string {
+	if name := os.Getenv("VETTING_SMTP_HELO"); name != "" {
+		return name
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "localhost"
+}
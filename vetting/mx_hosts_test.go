@@ -0,0 +1,333 @@
+package vetting
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificates issues a certificate for host from a throwaway CA and
+// returns it with a pool holding the CA
+func testCertificates(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(12 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+// startFakeSMTP accepts one connection on a local port and hands it to
+// serve; the returned channel yields the commands the client sent
+func startFakeSMTP(t *testing.T, serve func(conn net.Conn, text *textproto.Conn)) (string, <-chan []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	done := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- nil
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		rec := &commandRecorder{Conn: conn}
+		serve(rec, textproto.NewConn(rec))
+		done <- rec.commands()
+	}()
+	return l.Addr().String(), done
+}
+
+// commandRecorder keeps what the client wrote before STARTTLS
+type commandRecorder struct {
+	net.Conn
+	read strings.Builder
+}
+
+func (c *commandRecorder) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Write(b[:n])
+	return n, err
+}
+
+func (c *commandRecorder) commands() []string {
+	var out []string
+	sc := bufio.NewScanner(strings.NewReader(c.read.String()))
+	for sc.Scan() {
+		// Stop at the TLS handshake
+		if line := sc.Text(); line != "" && line[0] >= ' ' && line[0] < 0x7f {
+			out = append(out, line)
+		} else {
+			break
+		}
+	}
+	return out
+}
+
+// greet sends the banner and answers EHLO with the given extensions
+func greet(text *textproto.Conn, extensions ...string) bool {
+	if err := text.PrintfLine("220 mx.example.test ESMTP ready"); err != nil {
+		return false
+	}
+	if _, err := text.ReadLine(); err != nil {
+		return false
+	}
+	lines := append([]string{"mx.example.test greets you"}, extensions...)
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if err := text.PrintfLine("250%s%s", sep, line); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// serveStartTLS answers STARTTLS and completes the handshake with cert
+func serveStartTLS(conn net.Conn, text *textproto.Conn, cert tls.Certificate) {
+	if line, err := text.ReadLine(); err != nil || line != "STARTTLS" {
+		return
+	}
+	if err := text.PrintfLine("220 go ahead"); err != nil {
+		return
+	}
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	_, _ = textproto.NewConn(tlsConn).ReadLine() // QUIT
+}
+
+func TestProbeSMTP(t *testing.T) {
+	t.Setenv("VETTING_SMTP_HELO", "probe.example.test")
+	cert, roots := testCertificates(t, "mx.example.test")
+
+	tests := []struct {
+		name     string
+		host     string
+		serve    func(conn net.Conn, text *textproto.Conn)
+		want     SMTPProbe
+		wantErr  string // Prefix of SMTPProbe.Error
+		wantCert string // Substring of SMTPProbe.CertError
+		wantCmds []string
+	}{
+		{
+			name: "no STARTTLS",
+			host: "mx.example.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				if greet(text, "PIPELINING", "SIZE 10240000") {
+					_, _ = text.ReadLine() // QUIT
+				}
+			},
+			want: SMTPProbe{
+				Banner:     "mx.example.test ESMTP ready",
+				EHLO:       true,
+				Extensions: []string{"PIPELINING", "SIZE 10240000"},
+			},
+			wantCmds: []string{"EHLO probe.example.test", "QUIT"},
+		},
+		{
+			name: "STARTTLS with a valid certificate",
+			host: "mx.example.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				if greet(text, "PIPELINING", "STARTTLS") {
+					serveStartTLS(conn, text, cert)
+				}
+			},
+			want: SMTPProbe{
+				Banner:     "mx.example.test ESMTP ready",
+				EHLO:       true,
+				Extensions: []string{"PIPELINING", "STARTTLS"},
+				STARTTLS:   true,
+				TLSVersion: "TLS1.3",
+				CertValid:  true,
+			},
+			wantCmds: []string{"EHLO probe.example.test", "STARTTLS"},
+		},
+		{
+			name: "certificate for another host",
+			host: "mail.other.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				if greet(text, "STARTTLS") {
+					serveStartTLS(conn, text, cert)
+				}
+			},
+			want: SMTPProbe{
+				Banner:     "mx.example.test ESMTP ready",
+				EHLO:       true,
+				Extensions: []string{"STARTTLS"},
+				STARTTLS:   true,
+				TLSVersion: "TLS1.3",
+			},
+			wantCert: "mail.other.test",
+		},
+		{
+			name: "failed TLS handshake",
+			host: "mx.example.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				if !greet(text, "STARTTLS") {
+					return
+				}
+				if _, err := text.ReadLine(); err != nil {
+					return
+				}
+				_ = text.PrintfLine("220 go ahead")
+				_ = text.PrintfLine("this is not a TLS server hello")
+			},
+			want: SMTPProbe{
+				Banner:     "mx.example.test ESMTP ready",
+				EHLO:       true,
+				Extensions: []string{"STARTTLS"},
+				STARTTLS:   true,
+			},
+			wantErr: "TLS handshake: ",
+		},
+		{
+			name: "STARTTLS refused",
+			host: "mx.example.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				if greet(text, "STARTTLS") {
+					_, _ = text.ReadLine()
+					_ = text.PrintfLine("454 TLS not available")
+				}
+			},
+			want: SMTPProbe{
+				Banner:     "mx.example.test ESMTP ready",
+				EHLO:       true,
+				Extensions: []string{"STARTTLS"},
+				STARTTLS:   true,
+			},
+			wantErr: "STARTTLS: ",
+		},
+		{
+			name: "banner rejects the client",
+			host: "mx.example.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				_ = text.PrintfLine("554 no service")
+			},
+			wantErr: "banner: ",
+		},
+		{
+			name: "EHLO rejected",
+			host: "mx.example.test",
+			serve: func(conn net.Conn, text *textproto.Conn) {
+				_ = text.PrintfLine("220 mx.example.test ESMTP ready")
+				_, _ = text.ReadLine()
+				_ = text.PrintfLine("502 command not implemented")
+			},
+			want:     SMTPProbe{Banner: "mx.example.test ESMTP ready"},
+			wantErr:  "EHLO: ",
+			wantCmds: []string{"EHLO probe.example.test"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, cmds := startFakeSMTP(t, tt.serve)
+			got := probeSMTP(context.Background(), addr, tt.host, roots)
+
+			if got.Address != addr {
+				t.Errorf("Address = %q, want %q", got.Address, addr)
+			}
+			if got.Banner != tt.want.Banner || got.EHLO != tt.want.EHLO || got.STARTTLS != tt.want.STARTTLS {
+				t.Errorf("banner/EHLO/STARTTLS = %q/%v/%v, want %q/%v/%v",
+					got.Banner, got.EHLO, got.STARTTLS, tt.want.Banner, tt.want.EHLO, tt.want.STARTTLS)
+			}
+			if strings.Join(got.Extensions, ",") != strings.Join(tt.want.Extensions, ",") {
+				t.Errorf("Extensions = %q, want %q", got.Extensions, tt.want.Extensions)
+			}
+			if got.TLSVersion != tt.want.TLSVersion {
+				t.Errorf("TLSVersion = %q, want %q", got.TLSVersion, tt.want.TLSVersion)
+			}
+			if got.CertValid != tt.want.CertValid {
+				t.Errorf("CertValid = %v (%s), want %v", got.CertValid, got.CertError, tt.want.CertValid)
+			}
+			if tt.wantCert != "" && !strings.Contains(got.CertError, tt.wantCert) {
+				t.Errorf("CertError = %q, want it to mention %q", got.CertError, tt.wantCert)
+			}
+			if got.TLSVersion != "" && got.CertExpires.IsZero() {
+				t.Error("CertExpires not set after a handshake")
+			}
+			switch {
+			case tt.wantErr == "" && got.Error != "":
+				t.Errorf("Error = %q, want none", got.Error)
+			case !strings.HasPrefix(got.Error, tt.wantErr):
+				t.Errorf("Error = %q, want prefix %q", got.Error, tt.wantErr)
+			}
+
+			sent := <-cmds
+			if tt.wantCmds != nil && strings.Join(sent, "|") != strings.Join(tt.wantCmds, "|") {
+				t.Errorf("client sent %q, want %q", sent, tt.wantCmds)
+			}
+		})
+	}
+}
+
+func TestProbeSMTPUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	got := probeSMTP(context.Background(), addr, "mx.example.test", nil)
+	if got.EHLO || got.Error == "" {
+		t.Errorf("probe of a closed port = %+v, want a connection error", got)
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 // OptInCheck represents opt-in compliance and security checks
 type OptInCheck struct {
+	CheckOutcome
 	Compliance     bool   `json:"compliance"`                // Default true for now (will discuss with client)
 	HasCaptcha     bool   `json:"has_captcha"`               // Real-time detection
 	CaptchaWarning string `json:"captcha_warning,omitempty"` // Warning if no captcha
@@ -61,9 +62,9 @@ var captchaPatterns = map[string]string{
 // DetectCaptcha detects CAPTCHA - HTTP first (fast), chromedp as fallback
 // This is production-optimized: fast HTTP check handles most cases,
 // chromedp only used when HTTP doesn't find anything (for JS-loaded CAPTCHAs)
-func DetectCaptcha(domain string) (bool, string) {
+func DetectCaptcha(ctx context.Context, domain string) (bool, string) {
 	// Step 1: Try HTTP first (fast, lightweight, handles 70%+ cases)
-	hasCaptcha, captchaType := detectCaptchaWithHTTP(domain)
+	hasCaptcha, captchaType := detectCaptchaWithHTTP(ctx, domain)
 	if hasCaptcha {
 		log.Printf("[CAPTCHA] ✅ Found via HTTP: %s on %s", captchaType, domain)
 		return true, captchaType
@@ -77,7 +78,7 @@ func DetectCaptcha(domain string) (bool, string) {
 	}
 
 	log.Printf("[CAPTCHA] HTTP didn't find CAPTCHA, trying chromedp for %s", domain)
-	hasCaptcha, captchaType = detectCaptchaWithChromedp(domain)
+	hasCaptcha, captchaType = detectCaptchaWithChromedp(ctx, domain)
 	if hasCaptcha {
 		log.Printf("[CAPTCHA] ✅ Found via chromedp: %s on %s", captchaType, domain)
 		return true, captchaType
@@ -88,9 +89,9 @@ func DetectCaptcha(domain string) (bool, string) {
 }
 
 // detectCaptchaWithChromedp uses headless Chrome to render JS and detect CAPTCHAs
-func detectCaptchaWithChromedp(domain string) (bool, string) {
+func detectCaptchaWithChromedp(ctx context.Context, domain string) (bool, string) {
 	// Create context with timeout (15s max for production)
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// Create headless Chrome options
@@ -168,7 +169,7 @@ func checkURLWithChromedp(ctx context.Context, url string) (bool, string) {
 }
 
 // detectCaptchaWithHTTP is the fallback HTTP-based detection
-func detectCaptchaWithHTTP(domain string) (bool, string) {
+func detectCaptchaWithHTTP(ctx context.Context, domain string) (bool, string) {
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -190,7 +191,7 @@ func detectCaptchaWithHTTP(domain string) (bool, string) {
 	}
 
 	for _, url := range urls {
-		hasCaptcha, captchaType := checkURLForCaptchaHTTP(client, url)
+		hasCaptcha, captchaType := checkURLForCaptchaHTTP(ctx, client, url)
 		if hasCaptcha {
 			return true, captchaType
 		}
@@ -200,8 +201,8 @@ func detectCaptchaWithHTTP(domain string) (bool, string) {
 }
 
 // checkURLForCaptchaHTTP checks a single URL for CAPTCHA presence using HTTP
-func checkURLForCaptchaHTTP(client *http.Client, url string) (bool, string) {
-	req, err := http.NewRequest("GET", url, nil)
+func checkURLForCaptchaHTTP(ctx context.Context, client *http.Client, url string) (bool, string) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return false, ""
 	}
@@ -236,14 +237,14 @@ func checkURLForCaptchaHTTP(client *http.Client, url string) (bool, string) {
 }
 
 // EvaluateOptIn performs all opt-in related checks
-func EvaluateOptIn(selfAttested *SelfAttestedOptIn, domain string) OptInCheck {
+func EvaluateOptIn(ctx context.Context, selfAttested *SelfAttestedOptIn, domain string) OptInCheck {
 	// Compliance is default true for now (will be discussed with client later)
 	compliance := true
 
 	// COMMENTED OUT per Naksh - CAPTCHA detection might not be accurate
 	// Will discuss with Manny to decide if we keep or remove this check
 	// Real-time CAPTCHA detection
-	// hasCaptcha, _ := DetectCaptcha(domain)
+	// hasCaptcha, _ := DetectCaptcha(ctx, domain)
 	//
 	// warning := ""
 	// if !hasCaptcha {
@@ -251,6 +252,7 @@ func EvaluateOptIn(selfAttested *SelfAttestedOptIn, domain string) OptInCheck {
 	// }
 
 	return OptInCheck{
+		CheckOutcome:   skipped("CAPTCHA detection disabled"),
 		Compliance:     compliance,
 		HasCaptcha:     true, // Default to true since we're not checking
 		CaptchaWarning: "",
@@ -0,0 +1,429 @@
+package vetting
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// OUTCOME FEEDBACK
+//
+// Every /vet response gets an id and is stored together with its feature
+// vector. After warmup, POST /outcomes records what actually happened to the
+// domain (bounce/complaint rates, inbox placement, mailbox providers that
+// blocked it) against that id. Stored results joined with their outcomes are
+// the labeled dataset used to calibrate scoring (see LabeledDataset).
+//
+// With VETTING_DATA_DIR set, results and outcomes are appended to
+// vet_results.jsonl and outcomes.jsonl there. Only an id -> offset index of
+// the results is kept in memory (a result is read back from disk when an
+// outcome or /vet/result asks for it); outcomes are small and reloaded on
+// startup. Without it, the most recent outcomeMaxMemoryResults results are
+// kept in memory only.
+//
+
+// outcomeMaxMemoryResults bounds the results a memory-only store keeps
+// (the oldest are dropped, with their outcomes)
+const outcomeMaxMemoryResults = 10000
+
+// WarmupOutcome is the observed result of warming up a vetted domain.
+// Rates are fractions (0-1); nil means "not measured", not 0.
+type WarmupOutcome struct {
+	VetID          string   `json:"vet_id"`
+	Domain         string   `json:"domain"`                    // Filled from the stored vetting result
+	BounceRate     *float64 `json:"bounce_rate,omitempty"`     // Hard + soft bounces / sent
+	ComplaintRate  *float64 `json:"complaint_rate,omitempty"`  // Spam complaints / delivered
+	InboxPlacement *float64 `json:"inbox_placement,omitempty"` // Delivered to inbox / delivered
+	BlockedBy      []string `json:"blocked_by,omitempty"`      // Mailbox providers that blocked the domain (gmail, outlook, yahoo, ...)
+	Notes          string   `json:"notes,omitempty"`
+	RecordedAt     string   `json:"recorded_at"`
+}
+
+// Validate checks rates are fractions and something was actually observed
+func (o *WarmupOutcome) Validate() error {
+	if o.VetID == "" {
+		return errors.New("vet_id required")
+	}
+	var errs []error
+	for name, rate := range map[string]*float64{
+		"bounce_rate":     o.BounceRate,
+		"complaint_rate":  o.ComplaintRate,
+		"inbox_placement": o.InboxPlacement,
+	} {
+		if rate != nil && (*rate < 0 || *rate > 1) {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 1, got %v", name, *rate))
+		}
+	}
+	if o.BounceRate == nil && o.ComplaintRate == nil && o.InboxPlacement == nil && len(o.BlockedBy) == 0 {
+		errs = append(errs, errors.New("no outcome given (bounce_rate, complaint_rate, inbox_placement or blocked_by)"))
+	}
+	return errors.Join(errs...)
+}
+
+// StoredVetting is a /vet response kept for outcome labeling. Inputs are the
+// raw check results, so the domain can be rescored under another policy
+// (calibration); results stored before they were kept have none.
+type StoredVetting struct {
+	Response VetResponse     `json:"response"`
+	Features ScoringFeatures `json:"features"`
+	Inputs   *ScoreInputs    `json:"inputs,omitempty"`
+}
+
+// LabeledExample is a vetting result with the outcomes recorded for it
+// (oldest first)
+type LabeledExample struct {
+	StoredVetting
+	Outcomes []WarmupOutcome `json:"outcomes"`
+}
+
+// Latest returns the most recent outcome
+func (e LabeledExample) Latest() WarmupOutcome {
+	return e.Outcomes[len(e.Outcomes)-1]
+}
+
+// OutcomeStore keeps vetting results and their outcomes
+type OutcomeStore struct {
+	mu       sync.RWMutex
+	dir      string                   // "" = memory only
+	index    map[string]resultOffset  // Persisted: where each result is in vet_results.jsonl
+	results  map[string]StoredVetting // Memory only: the most recent results
+	order    []string                 // Memory only: ids in results, oldest first
+	outcomes map[string][]WarmupOutcome
+}
+
+// resultOffset locates one line of vet_results.jsonl
+type resultOffset struct {
+	offset int64
+	length int
+}
+
+// NewOutcomeStore returns a store persisted in dir ("" = memory only),
+// indexing what dir already contains
+func NewOutcomeStore(dir string) (*OutcomeStore, error) {
+	s := &OutcomeStore{
+		dir:      dir,
+		index:    map[string]resultOffset{},
+		results:  map[string]StoredVetting{},
+		outcomes: map[string][]WarmupOutcome{},
+	}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	err := readJSONLines(filepath.Join(dir, "vet_results.jsonl"), func(offset int64, line []byte) error {
+		// Only the id is decoded; the result stays on disk
+		var v struct {
+			Response struct {
+				ID string `json:"id"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(line, &v); err != nil {
+			return err
+		}
+		s.index[v.Response.ID] = resultOffset{offset: offset, length: len(line)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readJSONLines(filepath.Join(dir, "outcomes.jsonl"), func(_ int64, line []byte) error {
+		var o WarmupOutcome
+		if err := json.Unmarshal(line, &o); err != nil {
+			return err
+		}
+		s.outcomes[o.VetID] = append(s.outcomes[o.VetID], o)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveResult stores a vetting result under its response id
+func (s *OutcomeStore) SaveResult(v StoredVetting) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir != "" {
+		ref, err := s.appendLine("vet_results.jsonl", v)
+		if err != nil {
+			return err
+		}
+		s.index[v.Response.ID] = ref
+		return nil
+	}
+
+	if len(s.order) >= outcomeMaxMemoryResults {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		delete(s.results, oldest)
+		delete(s.outcomes, oldest)
+	}
+	s.results[v.Response.ID] = v
+	s.order = append(s.order, v.Response.ID)
+	return nil
+}
+
+// Result returns the stored vetting result for id
+func (s *OutcomeStore) Result(id string) (StoredVetting, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.result(id)
+}
+
+// result looks id up in memory or reads it from vet_results.jsonl (a read
+// failure is logged and treated as unknown); s.mu must be held
+func (s *OutcomeStore) result(id string) (StoredVetting, bool) {
+	if s.dir == "" {
+		v, ok := s.results[id]
+		return v, ok
+	}
+	ref, ok := s.index[id]
+	if !ok {
+		return StoredVetting{}, false
+	}
+	v, err := s.readResult(ref)
+	if err != nil {
+		log.Printf("[Outcomes] ❌ Cannot read vetting result %s: %v", id, err)
+		return StoredVetting{}, false
+	}
+	return v, true
+}
+
+// readResult decodes the line of vet_results.jsonl at ref
+func (s *OutcomeStore) readResult(ref resultOffset) (StoredVetting, error) {
+	var v StoredVetting
+	file, err := os.Open(filepath.Join(s.dir, "vet_results.jsonl"))
+	if err != nil {
+		return v, err
+	}
+	defer file.Close()
+	line := make([]byte, ref.length)
+	if _, err := file.ReadAt(line, ref.offset); err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(line, &v)
+	return v, err
+}
+
+// RecordOutcome validates o (ErrInvalidOutcome) and stores it against its
+// vetting result (ErrUnknownVetID if there is none)
+func (s *OutcomeStore) RecordOutcome(o WarmupOutcome) (WarmupOutcome, error) {
+	if err := o.Validate(); err != nil {
+		return o, fmt.Errorf("%w: %w", ErrInvalidOutcome, err)
+	}
+	for i, provider := range o.BlockedBy {
+		o.BlockedBy[i] = strings.ToLower(strings.TrimSpace(provider))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.result(o.VetID)
+	if !ok {
+		return o, ErrUnknownVetID
+	}
+	o.Domain = v.Response.Domain
+	o.RecordedAt = time.Now().UTC().Format(time.RFC3339)
+	if _, err := s.appendLine("outcomes.jsonl", o); err != nil {
+		return o, err
+	}
+	s.outcomes[o.VetID] = append(s.outcomes[o.VetID], o)
+	return o, nil
+}
+
+var (
+	// ErrUnknownVetID - no stored vetting result has this id
+	ErrUnknownVetID = errors.New("unknown vet_id")
+	// ErrInvalidOutcome - the outcome failed validation
+	ErrInvalidOutcome = errors.New("invalid outcome")
+)
+
+// Outcomes returns the outcomes recorded for a vetting result
+func (s *OutcomeStore) Outcomes(id string) []WarmupOutcome {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]WarmupOutcome(nil), s.outcomes[id]...)
+}
+
+// LabeledDataset returns every vetting result that has at least one outcome
+func (s *OutcomeStore) LabeledDataset() []LabeledExample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var examples []LabeledExample
+	for id, outcomes := range s.outcomes {
+		v, ok := s.result(id)
+		if !ok {
+			continue
+		}
+		examples = append(examples, LabeledExample{
+			StoredVetting: v,
+			Outcomes:      append([]WarmupOutcome(nil), outcomes...),
+		})
+	}
+	sort.Slice(examples, func(i, j int) bool { return examples[i].Response.ID < examples[j].Response.ID })
+	return examples
+}
+
+// appendLine persists one record and returns where it was written (no-op
+// for a memory-only store); s.mu must be held
+func (s *OutcomeStore) appendLine(name string, record interface{}) (resultOffset, error) {
+	if s.dir == "" {
+		return resultOffset{}, nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return resultOffset{}, err
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return resultOffset{}, err
+	}
+	// Writes are serialized by s.mu, so the end is where the line lands
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		file.Close()
+		return resultOffset{}, err
+	}
+	return resultOffset{offset: offset, length: len(line)}, file.Close()
+}
+
+// readJSONLines calls fn with the offset and content of every non-empty
+// line of path (a missing file is empty)
+func readJSONLines(path string, fn func(offset int64, line []byte) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset int64
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		start := offset
+		offset += int64(len(line))
+		if trimmed := bytes.TrimRight(line, "\r\n"); len(bytes.TrimSpace(trimmed)) > 0 {
+			if ferr := fn(start, trimmed); ferr != nil {
+				return fmt.Errorf("%s line %d: %w", path, n, ferr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// newVetID returns a random id for a vetting result
+func newVetID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic("vetting: cannot generate id: " + err.Error())
+	}
+	return "vet_" + hex.EncodeToString(b)
+}
+
+// DefaultOutcomeStore holds /vet results and outcomes (memory only until
+// ConfigureOutcomeStoreFromEnv is called)
+var DefaultOutcomeStore, _ = NewOutcomeStore("")
+
+// ConfigureOutcomeStoreFromEnv persists results and outcomes in
+// VETTING_DATA_DIR (if set)
+func ConfigureOutcomeStoreFromEnv() {
+	dir := os.Getenv("VETTING_DATA_DIR")
+	if dir == "" {
+		log.Printf("[Outcomes] VETTING_DATA_DIR not set, vetting results are kept in memory only")
+		return
+	}
+	store, err := NewOutcomeStore(dir)
+	if err != nil {
+		log.Fatalf("[Outcomes] Cannot open data dir %s: %v", dir, err)
+	}
+	DefaultOutcomeStore = store
+	log.Printf("[Outcomes] Indexed %d vetting results and %d labeled domains from %s",
+		len(store.index), len(store.outcomes), dir)
+}
+
+//
+// HANDLERS
+//
+
+// OutcomeHandler records an outcome (POST /outcomes) or lists the outcomes
+// of one vetting result (GET /outcomes?vet_id=...)
+func OutcomeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var o WarmupOutcome
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			http.Error(w, "invalid input", http.StatusBadRequest)
+			return
+		}
+		recorded, err := DefaultOutcomeStore.RecordOutcome(o)
+		switch {
+		case errors.Is(err, ErrInvalidOutcome):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrUnknownVetID):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			log.Printf("[Outcomes] ❌ Cannot store outcome for %s: %v", o.VetID, err)
+			http.Error(w, "cannot store outcome", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[Outcomes] ✔ Outcome recorded for %s (%s)", recorded.VetID, recorded.Domain)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(recorded)
+
+	case http.MethodGet:
+		id := r.URL.Query().Get("vet_id")
+		if _, ok := DefaultOutcomeStore.Result(id); !ok {
+			http.Error(w, ErrUnknownVetID.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(DefaultOutcomeStore.Outcomes(id))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// VetResultHandler returns a stored vetting result (GET /vet/result?id=...)
+func VetResultHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := DefaultOutcomeStore.Result(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, ErrUnknownVetID.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v.Response)
+}
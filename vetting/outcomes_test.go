@@ -0,0 +1,151 @@
+package vetting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testVetting(id, domain string) StoredVetting {
+	return StoredVetting{Response: VetResponse{ID: id, Domain: domain}}
+}
+
+func rate(v float64) *float64 { return &v }
+
+func TestOutcomeStorePersisted(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewOutcomeStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if err := store.SaveResult(testVetting(fmt.Sprintf("vet_%d", i), fmt.Sprintf("d%d.test", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.RecordOutcome(WarmupOutcome{VetID: "vet_1", BounceRate: rate(0.02)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.results) != 0 {
+		t.Errorf("persisted store keeps %d results in memory, want 0", len(store.results))
+	}
+
+	// Reopened: results are indexed, not loaded, and read back by offset
+	reopened, err := NewOutcomeStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.index) != 3 || len(reopened.results) != 0 {
+		t.Errorf("reopened store: %d indexed, %d in memory; want 3 and 0", len(reopened.index), len(reopened.results))
+	}
+	for i := range 3 {
+		id := fmt.Sprintf("vet_%d", i)
+		v, ok := reopened.Result(id)
+		if !ok || v.Response.Domain != fmt.Sprintf("d%d.test", i) {
+			t.Errorf("Result(%s) = %+v, %v", id, v.Response, ok)
+		}
+	}
+	if _, ok := reopened.Result("vet_missing"); ok {
+		t.Error("Result of an unknown id found")
+	}
+
+	o, err := reopened.RecordOutcome(WarmupOutcome{VetID: "vet_2", BlockedBy: []string{" Gmail "}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Domain != "d2.test" || o.BlockedBy[0] != "gmail" || o.RecordedAt == "" {
+		t.Errorf("recorded outcome = %+v", o)
+	}
+	if err := reopened.SaveResult(testVetting("vet_3", "d3.test")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reopened.Result("vet_3"); !ok || v.Response.Domain != "d3.test" {
+		t.Errorf("Result(vet_3) after append = %+v, %v", v.Response, ok)
+	}
+
+	dataset := reopened.LabeledDataset()
+	if len(dataset) != 2 || dataset[0].Response.ID != "vet_1" || dataset[1].Response.ID != "vet_2" {
+		t.Fatalf("LabeledDataset = %+v, want vet_1 and vet_2", dataset)
+	}
+	if dataset[0].Latest().BounceRate == nil || *dataset[0].Latest().BounceRate != 0.02 {
+		t.Errorf("vet_1 outcome = %+v", dataset[0].Latest())
+	}
+}
+
+func TestOutcomeStoreCRLFAndBlankLines(t *testing.T) {
+	dir := t.TempDir()
+	data := "\r\n" + `{"response":{"id":"vet_a","domain":"a.test"}}` + "\r\n\n" + `{"response":{"id":"vet_b","domain":"b.test"}}`
+	if err := os.WriteFile(filepath.Join(dir, "vet_results.jsonl"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewOutcomeStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, domain := range map[string]string{"vet_a": "a.test", "vet_b": "b.test"} {
+		if v, ok := store.Result(id); !ok || v.Response.Domain != domain {
+			t.Errorf("Result(%s) = %+v, %v", id, v.Response, ok)
+		}
+	}
+}
+
+func TestOutcomeStoreMemoryBound(t *testing.T) {
+	store, err := NewOutcomeStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range outcomeMaxMemoryResults + 2 {
+		id := fmt.Sprintf("vet_%d", i)
+		if err := store.SaveResult(testVetting(id, "d.test")); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if _, err := store.RecordOutcome(WarmupOutcome{VetID: id, InboxPlacement: rate(0.9)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(store.results) != outcomeMaxMemoryResults || len(store.order) != outcomeMaxMemoryResults {
+		t.Errorf("memory store holds %d results, want %d", len(store.results), outcomeMaxMemoryResults)
+	}
+	for _, id := range []string{"vet_0", "vet_1"} {
+		if _, ok := store.Result(id); ok {
+			t.Errorf("oldest result %s was kept", id)
+		}
+	}
+	if len(store.Outcomes("vet_0")) != 0 || len(store.LabeledDataset()) != 0 {
+		t.Error("outcomes of a dropped result were kept")
+	}
+	if _, ok := store.Result(fmt.Sprintf("vet_%d", outcomeMaxMemoryResults+1)); !ok {
+		t.Error("newest result was dropped")
+	}
+}
+
+func TestRecordOutcomeErrors(t *testing.T) {
+	store, _ := NewOutcomeStore("")
+	if err := store.SaveResult(testVetting("vet_1", "d.test")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		outcome WarmupOutcome
+		want    error
+	}{
+		{"no vet_id", WarmupOutcome{BounceRate: rate(0.1)}, ErrInvalidOutcome},
+		{"rate out of range", WarmupOutcome{VetID: "vet_1", ComplaintRate: rate(1.5)}, ErrInvalidOutcome},
+		{"nothing observed", WarmupOutcome{VetID: "vet_1"}, ErrInvalidOutcome},
+		{"unknown id", WarmupOutcome{VetID: "vet_2", BounceRate: rate(0.1)}, ErrUnknownVetID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.RecordOutcome(tt.outcome); !errors.Is(err, tt.want) {
+				t.Errorf("RecordOutcome error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if n := len(store.Outcomes("vet_1")); n != 0 {
+		t.Errorf("%d invalid outcomes stored", n)
+	}
+}
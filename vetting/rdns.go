@@ -0,0 +1,245 @@
+package vetting
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+//
+// REVERSE DNS
+//
+// Forward-confirmed reverse DNS (FCrDNS): a PTR name only counts if it
+// resolves back to the address. Receivers check it on connecting IPs, and
+// distrust PTR names that look like a dynamic/residential pool or an
+// auto-generated name with the address embedded (1-2-3-4.dynamic.isp.net).
+// The check covers every A/AAAA address of the domain and our sending IPs.
+//
+
+// PTR name classes
+const (
+	PTRNone        = "none"        // No PTR record
+	PTRResidential = "residential" // Dynamic/consumer access pool
+	PTRGeneric     = "generic"     // Auto-generated from the address (ISP or cloud default)
+	PTRCustom      = "custom"      // Looks like a named host
+)
+
+const rdnsMaxIPs = 10 // Addresses checked per list
+
+// ptrResidentialTokens are PTR labels (split on digits and punctuation) used
+// by ISPs for consumer access pools
+var ptrResidentialTokens = map[string]bool{
+	"dyn": true, "dynamic": true, "dynip": true, "dhcp": true, "dial": true, "dialup": true,
+	"dsl": true, "adsl": true, "vdsl": true, "xdsl": true, "cable": true, "ppp": true, "pppoe": true,
+	"pool": true, "broadband": true, "bb": true, "cust": true, "customer": true, "cpe": true,
+	"res": true, "residential": true, "home": true, "subscriber": true,
+	"fios": true, "ftth": true, "fttx": true, "mobile": true, "wireless": true, "hsd": true,
+}
+
+// ptrGenericTokens mark provider default names
+var ptrGenericTokens = map[string]bool{
+	"ip": true, "static": true, "unassigned": true, "generic": true, "rev": true,
+	"ec": true, "compute": true, "googleusercontent": true, "vultr": true, "linode": true,
+	"cloudapp": true, "vps": true,
+}
+
+// ReverseDNS is the forward-confirmed PTR lookup of one address
+type ReverseDNS struct {
+	IP        string   `json:"ip"`
+	Names     []string `json:"names,omitempty"` // PTR records
+	Name      string   `json:"name,omitempty"`  // First forward-confirmed name
+	Confirmed bool     `json:"confirmed"`
+	Class     string   `json:"class,omitempty"` // none, residential, generic, custom
+	Error     string   `json:"error,omitempty"` // Lookup failed (unknown)
+}
+
+// ReverseDNSResult - FCrDNS of the domain's addresses and our sending IPs
+type ReverseDNSResult struct {
+	CheckOutcome
+	Domain  []ReverseDNS `json:"domain_ips"`
+	Sending []ReverseDNS `json:"sending_ips,omitempty"`
+
+	// Counts over both lists (addresses whose lookup failed are left out)
+	Unconfirmed int `json:"unconfirmed"`
+	Residential int `json:"residential"`
+	Generic     int `json:"generic"`
+	// Sending IPs without FCrDNS (receivers penalize these directly)
+	SendingUnconfirmed int `json:"sending_unconfirmed"`
+}
+
+// AnalyzeReverseDNS runs FCrDNS over the domain's addresses and the sending IPs
+func AnalyzeReverseDNS(ctx context.Context, domainIPs, sendingIPs []string) ReverseDNSResult {
+	return analyzeReverseDNS(ctx, defaultDNS(), domainIPs, sendingIPs)
+}
+
+func analyzeReverseDNS(ctx context.Context, dns DNSClient, domainIPs, sendingIPs []string) ReverseDNSResult {
+	var res ReverseDNSResult
+	res.Domain = lookupReverseDNSAll(ctx, dns, domainIPs)
+	res.Sending = lookupReverseDNSAll(ctx, dns, sendingIPs)
+	if len(res.Domain)+len(res.Sending) == 0 {
+		res.CheckOutcome = skipped("no addresses")
+		return res
+	}
+
+	answered := 0
+	var lastErr string
+	for i, r := range append(append([]ReverseDNS{}, res.Domain...), res.Sending...) {
+		if r.Error != "" {
+			lastErr = r.Error
+			continue
+		}
+		answered++
+		if !r.Confirmed {
+			res.Unconfirmed++
+			if i >= len(res.Domain) {
+				res.SendingUnconfirmed++
+			}
+		}
+		switch r.Class {
+		case PTRResidential:
+			res.Residential++
+		case PTRGeneric:
+			res.Generic++
+		}
+	}
+	if answered == 0 {
+		res.CheckOutcome = CheckOutcome{Status: StatusError, Error: lastErr}
+	} else {
+		res.CheckOutcome = CheckOutcome{Status: StatusOK}
+	}
+
+	log.Printf("[rDNS] %d domain + %d sending IPs: %d without FCrDNS (%d sending), %d residential, %d generic",
+		len(res.Domain), len(res.Sending), res.Unconfirmed, res.SendingUnconfirmed, res.Residential, res.Generic)
+	return res
+}
+
+// lookupReverseDNSAll checks up to rdnsMaxIPs addresses (invalid ones are skipped)
+func lookupReverseDNSAll(ctx context.Context, dns DNSClient, ips []string) []ReverseDNS {
+	var parsed []net.IP
+	for _, s := range ips {
+		if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil && len(parsed) < rdnsMaxIPs {
+			parsed = append(parsed, ip)
+		}
+	}
+	out := make([]ReverseDNS, len(parsed))
+	var g errgroup.Group
+	g.SetLimit(4)
+	for i, ip := range parsed {
+		g.Go(func() error {
+			out[i] = lookupReverseDNS(ctx, dns, ip)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return out
+}
+
+// lookupReverseDNS looks up the PTR records of ip and confirms each name
+// resolves back to it
+func lookupReverseDNS(ctx context.Context, dns DNSClient, ip net.IP) ReverseDNS {
+	r := ReverseDNS{IP: ip.String()}
+	names, err := dns.LookupAddr(ctx, r.IP)
+	if err != nil && !isDNSNotFound(err) {
+		r.Error = err.Error()
+		return r
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		r.Names = append(r.Names, name)
+		if r.Confirmed {
+			continue
+		}
+		network := "ip4"
+		if ip.To4() == nil {
+			network = "ip6"
+		}
+		addrs, err := dns.LookupIP(ctx, network, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.Equal(ip) {
+				r.Name = name
+				r.Confirmed = true
+				break
+			}
+		}
+	}
+
+	switch {
+	case r.Confirmed:
+		r.Class = ClassifyPTR(r.Name, ip)
+	case len(r.Names) > 0:
+		r.Class = ClassifyPTR(r.Names[0], ip)
+	default:
+		r.Class = PTRNone
+	}
+	return r
+}
+
+// ClassifyPTR heuristically classifies a PTR name of ip: residential pool,
+// generic (address embedded or provider default) or custom
+func ClassifyPTR(name string, ip net.IP) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return PTRNone
+	}
+	// The registrable domain (isp.net) says nothing about the host
+	host := strings.TrimSuffix(strings.TrimSuffix(name, organizationalDomain(name)), ".")
+
+	tokens := strings.FieldsFunc(host, func(r rune) bool { return r < 'a' || r > 'z' })
+	generic := ptrEmbedsIP(name, ip)
+	for _, t := range tokens {
+		if ptrResidentialTokens[t] {
+			return PTRResidential
+		}
+		if ptrGenericTokens[t] {
+			generic = true
+		}
+	}
+	if generic {
+		return PTRGeneric
+	}
+	return PTRCustom
+}
+
+// ptrEmbedsIP reports whether the octets of an IPv4 address appear in the
+// name in order or reversed (1-2-3-4, 4.3.2.1, 001002003004 ...)
+func ptrEmbedsIP(name string, ip net.IP) bool {
+	v4 := ip.To4()
+	if v4 == nil {
+		// IPv6: the low 32 bits in hex is the usual generated form
+		tail := hex.EncodeToString(ip.To16()[12:])
+		return strings.Contains(strings.NewReplacer("-", "", ".", "").Replace(name), tail)
+	}
+	var runs []int
+	for _, run := range strings.FieldsFunc(name, func(r rune) bool { return r < '0' || r > '9' }) {
+		// Zero-padded concatenation (001002003004)
+		if len(run) == 12 {
+			for i := 0; i < 12; i += 3 {
+				n, _ := strconv.Atoi(run[i : i+3])
+				runs = append(runs, n)
+			}
+			continue
+		}
+		n, err := strconv.Atoi(run)
+		if err != nil {
+			n = -1
+		}
+		runs = append(runs, n)
+	}
+	octets := []int{int(v4[0]), int(v4[1]), int(v4[2]), int(v4[3])}
+	reversed := []int{octets[3], octets[2], octets[1], octets[0]}
+	for i := 0; i+4 <= len(runs); i++ {
+		if slices.Equal(runs[i:i+4], octets) || slices.Equal(runs[i:i+4], reversed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package vetting
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClassifyPTR(t *testing.T) {
+	tests := []struct {
+		name, ip string
+		embeds   bool
+		class    string
+	}{
+		{"1-2-3-4.dynamic.isp.net", "1.2.3.4", true, PTRResidential},
+		{"pool-1-2-3-4.nycmny.fios.verizon.net.", "1.2.3.4", true, PTRResidential},
+		{"dsl.isp.net", "1.2.3.4", false, PTRResidential},
+		{"ec2-1-2-3-4.compute-1.amazonaws.com", "1.2.3.4", true, PTRGeneric},
+		{"4.3.2.1.isp.net", "1.2.3.4", true, PTRGeneric},
+		{"4.3.2.1.static.isp.net", "1.2.3.4", true, PTRGeneric},
+		{"host001002003004.isp.net", "1.2.3.4", true, PTRGeneric},
+		{"static.vps.example.net", "1.2.3.4", false, PTRGeneric},
+		{"host-c000-0201.v6.isp.net", "2001:db8::c000:201", true, PTRGeneric},
+		{"mail.example.com", "2001:db8::c000:201", false, PTRCustom},
+		{"mail.example.com", "192.0.2.1", false, PTRCustom},
+		{"MAIL.Example.COM.", "192.0.2.1", false, PTRCustom},
+		{"mail-1-2-3-5.example.com", "1.2.3.4", false, PTRCustom},
+		{"1-2-4-3.example.com", "1.2.3.4", false, PTRCustom},
+		// Tokens of the registrable domain say nothing about the host
+		{"mail.dynamic.com", "192.0.2.1", false, PTRCustom},
+		{"", "192.0.2.1", false, PTRNone},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if got := ptrEmbedsIP(tt.name, ip); got != tt.embeds {
+			t.Errorf("ptrEmbedsIP(%q, %s) = %v, want %v", tt.name, tt.ip, got, tt.embeds)
+		}
+		if got := ClassifyPTR(tt.name, ip); got != tt.class {
+			t.Errorf("ClassifyPTR(%q, %s) = %s, want %s", tt.name, tt.ip, got, tt.class)
+		}
+	}
+}
@@ -0,0 +1,704 @@
+package vetting
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule language - rejection and penalty rules evaluated against RuleFeatures:
+//
+//	when blacklist.critical then reject "{blacklist.reject_reason}"
+//	when dmarc.policy == "none" then penalty 10 "weak DMARC"
+//	when blacklist.penalty > 0 then penalty blacklist.penalty "blacklist penalties"
+//	when not optin.compliant then level high-risk "opt-in non-compliant"
+//
+// Conditions support and/or/not, parentheses and == != < <= > >=.
+// Reasons may reference features as {name}. Feature names and types are
+// checked when the rule is compiled (see ruleFeatureKinds).
+
+// RuleSpec is a rule as written in the scoring policy
+type RuleSpec struct {
+	ID   string `json:"id"`
+	Rule string `json:"rule"`
+}
+
+// RuleAction is what a fired rule does
+type RuleAction string
+
+const (
+	ActionReject  RuleAction = "reject"  // Score 0, level "rejected"
+	ActionPenalty RuleAction = "penalty" // Subtract from the score
+	ActionLevel   RuleAction = "level"   // Cap the risk level (e.g. high-risk)
+)
+
+// FiredRule is a rule whose condition matched (returned in RiskSummary)
+type FiredRule struct {
+	ID      string     `json:"id"`
+	Action  RuleAction `json:"action"`
+	Penalty int        `json:"penalty,omitempty"`
+	Level   string     `json:"level,omitempty"`
+	Reason  string     `json:"reason"`
+}
+
+// Rule is a compiled RuleSpec
+type Rule struct {
+	ID      string
+	Source  string
+	cond    ruleExpr
+	action  RuleAction
+	penalty ruleExpr // Number (literal or feature) for penalty rules
+	level   string
+	reason  string
+}
+
+//
+// FEATURES
+//
+
+type featureKind int
+
+const (
+	kindBool featureKind = iota
+	kindNumber
+	kindString
+)
+
+func (k featureKind) String() string {
+	switch k {
+	case kindBool:
+		return "bool"
+	case kindNumber:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// ruleFeatureKinds is the feature schema rules are checked against
+var ruleFeatureKinds = map[string]featureKind{
+	"domain.age_days":            kindNumber,
+	"domain.new":                 kindBool,
+	"domain.is_subdomain":        kindBool,
+	"https.ok":                   kindBool,
+	"tls.days_left":              kindNumber,
+	"ssl.score":                  kindNumber,
+	"website.exists":             kindBool,
+	"website.traffic_score":      kindNumber,
+	"website.trust_score":        kindNumber,
+	"email.has_mx":               kindBool,
+	"rdns.unconfirmed":           kindNumber,
+	"rdns.sending_unconfirmed":   kindNumber,
+	"rdns.residential":           kindNumber,
+	"rdns.generic":               kindNumber,
+	"dnssec.state":               kindString,
+	"dnssec.signed":              kindBool,
+	"dns.inconsistent":           kindBool,
+	"dns.ns_count":               kindNumber,
+	"dns.ns_asns":                kindNumber,
+	"dns.lame":                   kindNumber,
+	"dns.lame_servers":           kindString,
+	"dns.serial_mismatch":        kindBool,
+	"dns.soa_issues":             kindNumber,
+	"dns.wildcard_a":             kindBool,
+	"dns.wildcard_mx":            kindBool,
+	"dns.apex_cname":             kindBool,
+	"dns.dangling":               kindNumber,
+	"dns.takeover":               kindNumber,
+	"dns.takeover_hosts":         kindString,
+	"dns.has_aaaa":               kindBool,
+	"mx.null":                    kindBool,
+	"mx.resolving":               kindNumber,
+	"mx.fcrdns":                  kindBool,
+	"mx.no_starttls":             kindNumber,
+	"email.has_spf":              kindBool,
+	"email.has_dmarc":            kindBool,
+	"spf.valid":                  kindBool,
+	"spf.dns_lookups":            kindNumber,
+	"spf.void_lookups":           kindNumber,
+	"spf.all":                    kindString,
+	"spf.sending_checked":        kindBool,
+	"spf.sending_authorized":     kindBool,
+	"spf.sending_unauthorized":   kindString,
+	"dkim.found":                 kindBool,
+	"dkim.weak":                  kindBool,
+	"dkim.revoked":               kindBool,
+	"dkim.keys":                  kindNumber,
+	"dkim.min_rsa_bits":          kindNumber,
+	"mta_sts.mode":               kindString,
+	"mta_sts.uncovered_mx":       kindNumber,
+	"tls_rpt.found":              kindBool,
+	"bimi.found":                 kindBool,
+	"bimi.certificate":           kindBool,
+	"bimi.ready":                 kindBool,
+	"dmarc.policy":               kindString,
+	"dmarc.pct":                  kindNumber,
+	"dmarc.inherited":            kindBool,
+	"dmarc.unauthorized_reports": kindNumber,
+	"blacklist.critical":         kindBool,
+	"blacklist.reject_reason":    kindString,
+	"blacklist.hits":             kindNumber,
+	"blacklist.penalty":          kindNumber,
+	"blacklist.penalty_details":  kindString,
+	"mx.reputation":              kindNumber,
+	"mx.reputation_ok":           kindBool,
+	"safe_browsing.flagged":      kindBool,
+	"safe_browsing.reason":       kindString,
+	"optin.compliant":            kindBool,
+	"optin.has_captcha":          kindBool,
+}
+
+// ruleValue is a typed feature or literal value
+type ruleValue struct {
+	kind featureKind
+	b    bool
+	n    float64
+	s    string
+}
+
+func (v ruleValue) String() string {
+	switch v.kind {
+	case kindBool:
+		return strconv.FormatBool(v.b)
+	case kindNumber:
+		return strconv.FormatFloat(v.n, 'f', -1, 64)
+	default:
+		return v.s
+	}
+}
+
+// RuleFeatures is the typed feature set rules are evaluated against
+type RuleFeatures struct {
+	values map[string]ruleValue
+}
+
+// NewRuleFeatures returns an empty feature set (unset features are false/0/"")
+func NewRuleFeatures() *RuleFeatures {
+	return &RuleFeatures{values: map[string]ruleValue{}}
+}
+
+func (f *RuleFeatures) set(name string, v ruleValue) {
+	kind, ok := ruleFeatureKinds[name]
+	if !ok || kind != v.kind {
+		panic(fmt.Sprintf("vetting: feature %q is not a %s", name, v.kind))
+	}
+	f.values[name] = v
+}
+
+// SetBool sets a bool feature
+func (f *RuleFeatures) SetBool(name string, b bool) { f.set(name, ruleValue{kind: kindBool, b: b}) }
+
+// SetNumber sets a number feature
+func (f *RuleFeatures) SetNumber(name string, n float64) {
+	f.set(name, ruleValue{kind: kindNumber, n: n})
+}
+
+// SetString sets a string feature
+func (f *RuleFeatures) SetString(name string, s string) {
+	f.set(name, ruleValue{kind: kindString, s: s})
+}
+
+// Bool returns a bool feature (false if unset)
+func (f *RuleFeatures) Bool(name string) bool { return f.get(name).b }
+
+// Number returns a number feature (0 if unset)
+func (f *RuleFeatures) Number(name string) float64 { return f.get(name).n }
+
+// Text returns a string feature ("" if unset)
+func (f *RuleFeatures) Text(name string) string { return f.get(name).s }
+
+func (f *RuleFeatures) get(name string) ruleValue {
+	if v, ok := f.values[name]; ok {
+		return v
+	}
+	return ruleValue{kind: ruleFeatureKinds[name]}
+}
+
+//
+// EVALUATION
+//
+
+// RuleOutcome is the combined result of all fired rules
+type RuleOutcome struct {
+	Fired         []FiredRule
+	Rejected      bool
+	RejectReasons []string
+	TotalPenalty  int
+	Level         string // Worst level forced by level rules ("" = none)
+}
+
+// EvaluateRules runs every rule (in order) against the features
+func EvaluateRules(rules []*Rule, f *RuleFeatures) RuleOutcome {
+	var out RuleOutcome
+	for _, r := range rules {
+		if !r.cond.eval(f).b {
+			continue
+		}
+		fired := FiredRule{ID: r.ID, Action: r.action, Reason: r.interpolate(f)}
+		switch r.action {
+		case ActionReject:
+			out.Rejected = true
+			out.RejectReasons = append(out.RejectReasons, fired.Reason)
+		case ActionPenalty:
+			fired.Penalty = int(math.Round(r.penalty.eval(f).n))
+			if fired.Penalty <= 0 {
+				continue
+			}
+			out.TotalPenalty += fired.Penalty
+		case ActionLevel:
+			fired.Level = r.level
+			out.Level = worseLevel(out.Level, r.level)
+		}
+		out.Fired = append(out.Fired, fired)
+	}
+	return out
+}
+
+// levelRank orders risk levels from best to worst
+var levelRank = map[string]int{"good": 0, "medium": 1, "high-risk": 2}
+
+// worseLevel returns the worse of two levels ("" = no level)
+func worseLevel(a, b string) string {
+	if a == "" || levelRank[b] > levelRank[a] {
+		return b
+	}
+	return a
+}
+
+// interpolate replaces {feature} references in the reason
+func (r *Rule) interpolate(f *RuleFeatures) string {
+	if !strings.Contains(r.reason, "{") {
+		return r.reason
+	}
+	var b strings.Builder
+	rest := r.reason
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String()
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			b.WriteString(rest)
+			return b.String()
+		}
+		b.WriteString(rest[:start])
+		b.WriteString(f.get(rest[start+1 : start+end]).String())
+		rest = rest[start+end+1:]
+	}
+}
+
+//
+// COMPILER
+//
+
+// CompileRules compiles rule specs (ids must be unique)
+func CompileRules(specs []RuleSpec) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(specs))
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if spec.ID == "" {
+			return nil, fmt.Errorf("rule %q: id is required", spec.Rule)
+		}
+		if seen[spec.ID] {
+			return nil, fmt.Errorf("rule %s: duplicate id", spec.ID)
+		}
+		seen[spec.ID] = true
+
+		r, err := CompileRule(spec.ID, spec.Rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// CompileRule parses and type-checks one rule
+func CompileRule(id, src string) (*Rule, error) {
+	toks, err := lexRule(src)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", id, err)
+	}
+	p := &ruleParser{toks: toks}
+	r, err := p.parseRule()
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", id, err)
+	}
+	r.ID = id
+	r.Source = src
+	return r, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type ruleToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexRule(src string) ([]ruleToken, error) {
+	var toks []ruleToken
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			toks = append(toks, ruleToken{tokLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, ruleToken{tokRParen, ")", i})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+			}
+			toks = append(toks, ruleToken{tokString, s, i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, ruleToken{tokNumber, src[i:j], i})
+			i = j
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			j := i + 1
+			if j < len(src) && src[j] == '=' {
+				j++
+			}
+			op := src[i:j]
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("unknown operator %q at %d", op, i)
+			}
+			toks = append(toks, ruleToken{tokOp, op, i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || strings.ContainsRune("_.-", rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, ruleToken{tokIdent, src[i:j], i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return append(toks, ruleToken{tokEOF, "", len(src)}), nil
+}
+
+type ruleParser struct {
+	toks []ruleToken
+	i    int
+}
+
+func (p *ruleParser) peek() ruleToken { return p.toks[p.i] }
+
+func (p *ruleParser) next() ruleToken {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *ruleParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && t.text == word
+}
+
+func (p *ruleParser) expectKeyword(word string) error {
+	t := p.next()
+	if t.kind != tokIdent || t.text != word {
+		return fmt.Errorf("expected %q at %d, got %q", word, t.pos, t.text)
+	}
+	return nil
+}
+
+// parseRule: when <condition> then <action>
+func (p *ruleParser) parseRule() (*Rule, error) {
+	if err := p.expectKeyword("when"); err != nil {
+		return nil, err
+	}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if cond.kind() != kindBool {
+		return nil, fmt.Errorf("condition must be bool, got %s", cond.kind())
+	}
+	if err := p.expectKeyword("then"); err != nil {
+		return nil, err
+	}
+
+	r := &Rule{cond: cond}
+	t := p.next()
+	switch RuleAction(t.text) {
+	case ActionReject:
+		r.action = ActionReject
+	case ActionPenalty:
+		r.action = ActionPenalty
+		amount, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if amount.kind() != kindNumber {
+			return nil, fmt.Errorf("penalty must be a number, got %s", amount.kind())
+		}
+		r.penalty = amount
+	case ActionLevel:
+		r.action = ActionLevel
+		lt := p.next()
+		if _, ok := levelRank[lt.text]; !ok || (lt.kind != tokIdent && lt.kind != tokString) {
+			return nil, fmt.Errorf("unknown level %q at %d (use good, medium or high-risk)", lt.text, lt.pos)
+		}
+		r.level = lt.text
+	default:
+		return nil, fmt.Errorf("expected reject, penalty or level at %d, got %q", t.pos, t.text)
+	}
+
+	reason := p.next()
+	if reason.kind != tokString {
+		return nil, fmt.Errorf("expected reason string at %d", reason.pos)
+	}
+	if err := checkReasonRefs(reason.text); err != nil {
+		return nil, err
+	}
+	r.reason = reason.text
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return r, nil
+}
+
+// checkReasonRefs verifies {feature} references in a reason
+func checkReasonRefs(reason string) error {
+	rest := reason
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			return nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return fmt.Errorf("unclosed { in reason %q", reason)
+		}
+		name := rest[start+1 : start+end]
+		if _, ok := ruleFeatureKinds[name]; !ok {
+			return fmt.Errorf("unknown feature %q in reason", name)
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+func (p *ruleParser) parseOr() (ruleExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != kindBool || right.kind() != kindBool {
+			return nil, fmt.Errorf("or needs bool operands")
+		}
+		left = &logicExpr{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != kindBool || right.kind() != kindBool {
+			return nil, fmt.Errorf("and needs bool operands")
+		}
+		left = &logicExpr{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseNot() (ruleExpr, error) {
+	if p.isKeyword("not") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if x.kind() != kindBool {
+			return nil, fmt.Errorf("not needs a bool operand")
+		}
+		return &notExpr{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *ruleParser) parseComparison() (ruleExpr, error) {
+	var left ruleExpr
+	if p.peek().kind == tokLParen {
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at %d", t.pos)
+		}
+		left = x
+	} else {
+		x, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = x
+	}
+
+	if p.peek().kind != tokOp {
+		return left, nil
+	}
+	op := p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if left.kind() != right.kind() {
+		return nil, fmt.Errorf("cannot compare %s with %s at %d", left.kind(), right.kind(), op.pos)
+	}
+	if op.text != "==" && op.text != "!=" && left.kind() != kindNumber {
+		return nil, fmt.Errorf("%s needs number operands at %d", op.text, op.pos)
+	}
+	return &compareExpr{op: op.text, left: left, right: right}, nil
+}
+
+func (p *ruleParser) parseOperand() (ruleExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return &literalExpr{v: ruleValue{kind: kindString, s: t.text}}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalExpr{v: ruleValue{kind: kindNumber, n: n}}, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &literalExpr{v: ruleValue{kind: kindBool, b: t.text == "true"}}, nil
+		}
+		kind, ok := ruleFeatureKinds[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature %q at %d", t.text, t.pos)
+		}
+		return &featureExpr{name: t.text, k: kind}, nil
+	}
+	return nil, fmt.Errorf("expected a value at %d, got %q", t.pos, t.text)
+}
+
+//
+// EXPRESSIONS
+//
+
+type ruleExpr interface {
+	kind() featureKind
+	eval(f *RuleFeatures) ruleValue
+}
+
+type literalExpr struct{ v ruleValue }
+
+func (e *literalExpr) kind() featureKind              { return e.v.kind }
+func (e *literalExpr) eval(f *RuleFeatures) ruleValue { return e.v }
+
+type featureExpr struct {
+	name string
+	k    featureKind
+}
+
+func (e *featureExpr) kind() featureKind              { return e.k }
+func (e *featureExpr) eval(f *RuleFeatures) ruleValue { return f.get(e.name) }
+
+type notExpr struct{ x ruleExpr }
+
+func (e *notExpr) kind() featureKind { return kindBool }
+func (e *notExpr) eval(f *RuleFeatures) ruleValue {
+	return ruleValue{kind: kindBool, b: !e.x.eval(f).b}
+}
+
+type logicExpr struct {
+	op          string
+	left, right ruleExpr
+}
+
+func (e *logicExpr) kind() featureKind { return kindBool }
+func (e *logicExpr) eval(f *RuleFeatures) ruleValue {
+	l := e.left.eval(f).b
+	if e.op == "and" {
+		return ruleValue{kind: kindBool, b: l && e.right.eval(f).b}
+	}
+	return ruleValue{kind: kindBool, b: l || e.right.eval(f).b}
+}
+
+type compareExpr struct {
+	op          string
+	left, right ruleExpr
+}
+
+func (e *compareExpr) kind() featureKind { return kindBool }
+func (e *compareExpr) eval(f *RuleFeatures) ruleValue {
+	l, r := e.left.eval(f), e.right.eval(f)
+	var res bool
+	switch e.op {
+	case "==":
+		res = l == r
+	case "!=":
+		res = l != r
+	case "<":
+		res = l.n < r.n
+	case "<=":
+		res = l.n <= r.n
+	case ">":
+		res = l.n > r.n
+	case ">=":
+		res = l.n >= r.n
+	}
+	return ruleValue{kind: kindBool, b: res}
+}
@@ -0,0 +1,283 @@
+package vetting
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCompileRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{`if https.ok then reject "x"`, `expected "when"`},
+		{`when https.ok reject "x"`, `expected "then"`},
+		{`when https.ok then drop "x"`, "expected reject, penalty or level"},
+		{`when https.ok then reject`, "expected reason string"},
+		{`when https.ok then reject "x" extra`, `unexpected "extra"`},
+		{`when https.ok then reject "unclosed`, "unterminated string"},
+		{`when https.ok = true then reject "x"`, "unknown operator"},
+		{`when https.ok then reject "x" ;`, "unexpected"},
+		{`when (https.ok then reject "x"`, "expected )"},
+		{`when https.ok then level critical "x"`, "unknown level"},
+
+		// Type errors against ruleFeatureKinds
+		{`when https.okay then reject "x"`, `unknown feature "https.okay"`},
+		{`when ssl.score then reject "x"`, "condition must be bool"},
+		{`when ssl.score == "high" then reject "x"`, "cannot compare number with string"},
+		{`when spf.all > "~all" then reject "x"`, "> needs number operands"},
+		{`when not ssl.score then reject "x"`, "not needs a bool operand"},
+		{`when https.ok and ssl.score then reject "x"`, "and needs bool operands"},
+		{`when ssl.score or https.ok then reject "x"`, "or needs bool operands"},
+		{`when https.ok then penalty "10" "x"`, "penalty must be a number, got string"},
+		{`when https.ok then penalty https.ok "x"`, "penalty must be a number, got bool"},
+		{`when https.ok then reject "{https.okay}"`, `unknown feature "https.okay" in reason`},
+		{`when https.ok then reject "{https.ok"`, "unclosed { in reason"},
+	}
+	for _, tt := range tests {
+		_, err := CompileRule("r", tt.rule)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("CompileRule(%s) error = %v, want %q", tt.rule, err, tt.want)
+		}
+	}
+
+	if _, err := CompileRules([]RuleSpec{{"a", `when https.ok then reject "x"`}, {"a", `when https.ok then reject "y"`}}); err == nil {
+		t.Error("duplicate rule ids accepted")
+	}
+	if _, err := CompileRules([]RuleSpec{{"", `when https.ok then reject "x"`}}); err == nil {
+		t.Error("rule without id accepted")
+	}
+}
+
+func TestRuleConditions(t *testing.T) {
+	f := NewRuleFeatures()
+	f.SetBool("https.ok", true)
+	f.SetBool("website.exists", false)
+	f.SetBool("domain.new", false)
+	f.SetNumber("ssl.score", 70)
+	f.SetString("dmarc.policy", "none")
+
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		// not binds tighter than and, and tighter than or
+		{`not website.exists and https.ok`, true},
+		{`not (website.exists and https.ok)`, true},
+		{`not website.exists and domain.new`, false},
+		{`https.ok or website.exists and domain.new`, true},
+		{`(https.ok or website.exists) and domain.new`, false},
+		{`domain.new and website.exists or https.ok`, true},
+		{`not not https.ok`, true},
+
+		{`ssl.score == 70`, true},
+		{`ssl.score != 70`, false},
+		{`ssl.score < 70`, false},
+		{`ssl.score <= 70`, true},
+		{`ssl.score > 69.5`, true},
+		{`ssl.score >= 71`, false},
+		{`dmarc.policy == "none"`, true},
+		{`dmarc.policy != "reject"`, true},
+		{`https.ok == true`, true},
+		{`website.exists != false`, false},
+
+		// Unset features read as false / 0 / ""
+		{`dkim.found`, false},
+		{`dkim.keys == 0`, true},
+		{`spf.all == ""`, true},
+	}
+	for _, tt := range tests {
+		r, err := CompileRule("r", `when `+tt.cond+` then reject "x"`)
+		if err != nil {
+			t.Errorf("%s: %v", tt.cond, err)
+			continue
+		}
+		if got := r.cond.eval(f).b; got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	rules, err := CompileRules([]RuleSpec{
+		{"unsigned", `when not dkim.found then penalty 10 "no DKIM"`},
+		{"from_feature", `when blacklist.penalty > 0 then penalty blacklist.penalty "listed [{blacklist.penalty_details}]"`},
+		{"zero", `when https.ok then penalty 0 "reported, not scored"`},
+		{"medium", `when ssl.score < 80 then level medium "ssl {ssl.score}"`},
+		{"high", `when not optin.compliant then level high-risk "opt-in"`},
+		{"gone", `when not website.exists then reject "no website"`},
+		{"flagged", `when safe_browsing.flagged then reject "flagged: {safe_browsing.reason}"`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewRuleFeatures()
+	f.SetBool("https.ok", true)
+	f.SetBool("website.exists", true)
+	f.SetBool("optin.compliant", false)
+	f.SetNumber("ssl.score", 60)
+	f.SetNumber("blacklist.penalty", 15)
+	f.SetString("blacklist.penalty_details", "a (-10), b (-5)")
+
+	out := EvaluateRules(rules, f)
+	var ids []string
+	for _, r := range out.Fired {
+		ids = append(ids, r.ID)
+	}
+	if want := []string{"unsigned", "from_feature", "medium", "high"}; !slices.Equal(ids, want) {
+		t.Errorf("fired %v, want %v", ids, want)
+	}
+	if out.Rejected || out.TotalPenalty != 25 || out.Level != "high-risk" {
+		t.Errorf("outcome rejected=%v penalty=%d level=%q", out.Rejected, out.TotalPenalty, out.Level)
+	}
+	if r := out.Fired[1]; r.Action != ActionPenalty || r.Penalty != 15 || r.Reason != "listed [a (-10), b (-5)]" {
+		t.Errorf("penalty rule = %+v", r)
+	}
+	if r := out.Fired[2]; r.Action != ActionLevel || r.Level != "medium" || r.Reason != "ssl 60" {
+		t.Errorf("level rule = %+v", r)
+	}
+
+	f.SetBool("website.exists", false)
+	f.SetBool("safe_browsing.flagged", true)
+	f.SetString("safe_browsing.reason", "MALWARE")
+	out = EvaluateRules(rules, f)
+	if !out.Rejected || !slices.Equal(out.RejectReasons, []string{"no website", "flagged: MALWARE"}) {
+		t.Errorf("rejected=%v reasons %q", out.Rejected, out.RejectReasons)
+	}
+}
+
+func TestRuleInterpolate(t *testing.T) {
+	f := NewRuleFeatures()
+	f.SetNumber("dkim.min_rsa_bits", 1024)
+	f.SetString("spf.all", "~all")
+	tests := []struct{ reason, want string }{
+		{"plain", "plain"},
+		{"weak ({dkim.min_rsa_bits}-bit)", "weak (1024-bit)"},
+		{"x } y {dkim.min_rsa_bits}", "x } y 1024"},
+		{"{spf.all}{dkim.min_rsa_bits}", "~all1024"},
+		{"{dkim.keys} keys", "0 keys"},
+	}
+	for _, tt := range tests {
+		r := &Rule{reason: tt.reason}
+		if err := checkReasonRefs(tt.reason); err != nil {
+			t.Errorf("checkReasonRefs(%q): %v", tt.reason, err)
+		}
+		if got := r.interpolate(f); got != tt.want {
+			t.Errorf("interpolate(%q) = %q, want %q", tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultRulesScore(t *testing.T) {
+	w := DefaultScoringWeights()
+	tests := []struct {
+		name      string
+		edit      func(in *ScoreInputs)
+		score     int
+		level     string
+		fired     []string
+		reasonHas string
+	}{
+		{
+			name:  "healthy",
+			edit:  func(in *ScoreInputs) {},
+			score: 100,
+			level: "good",
+		},
+		{
+			name: "no DMARC",
+			edit: func(in *ScoreInputs) {
+				in.Email.HasDMARC, in.Email.DMARC = false, nil
+			},
+			score: 100 - w.NoDMARC,
+			level: "good",
+			fired: []string{RuleNoDMARC},
+		},
+		{
+			name: "new domain with p=none and a weak key",
+			edit: func(in *ScoreInputs) {
+				in.Whois.AgeDays = 10
+				in.Email.DMARC.Effective = DMARCNone
+				in.DKIM.HasWeakKey, in.DKIM.MinRSABits = true, 1024
+			},
+			score:     100 - w.DomainTooNew - w.DMARCPolicyNone - w.WeakDKIMKey,
+			level:     "medium",
+			fired:     []string{RuleDomainTooNew, RuleDMARCPolicyNone, RuleWeakDKIMKey},
+			reasonHas: "1024-bit RSA",
+		},
+		{
+			name: "no MX on an unknown blacklist",
+			edit: func(in *ScoreInputs) {
+				in.Email.HasValidMX = false
+				in.Blacklists.Hits = []BlacklistEntry{{Source: "Example RBL", Listed: true}}
+			},
+			score: 100 - w.NoMXRecord - w.UnknownBlacklist,
+			level: "high-risk",
+			fired: []string{RuleBlacklistPenalty, RuleNoMXRecord},
+		},
+		{
+			name: "score floors at zero",
+			edit: func(in *ScoreInputs) {
+				in.Whois.AgeDays = 10
+				in.Email.HasValidMX = false
+				in.Email.HasDMARC, in.Email.DMARC = false, nil
+				in.Blacklists.Hits = []BlacklistEntry{{Source: "Example RBL", Listed: true}}
+			},
+			score: 0,
+			level: "high-risk",
+			fired: []string{RuleDomainTooNew, RuleBlacklistPenalty, RuleNoMXRecord, RuleNoDMARC},
+		},
+		{
+			name: "opt-in non-compliant forces high-risk",
+			edit: func(in *ScoreInputs) {
+				in.OptIn.Compliance = false
+			},
+			score: 100,
+			level: "high-risk",
+			fired: []string{RuleOptInNonCompliant},
+		},
+		{
+			name: "critical blacklist rejects",
+			edit: func(in *ScoreInputs) {
+				in.Blacklists.Hits = []BlacklistEntry{{Source: "Spamhaus ZEN", Listed: true}}
+				in.Email.HasDMARC, in.Email.DMARC = false, nil
+			},
+			score:     0,
+			level:     "rejected",
+			fired:     []string{RuleCriticalBlacklist, RuleNoDMARC},
+			reasonHas: "Spamhaus ZEN",
+		},
+		{
+			name: "no website rejects",
+			edit: func(in *ScoreInputs) {
+				in.Website.Exists = false
+			},
+			score:     0,
+			level:     "rejected",
+			fired:     []string{RuleWebsiteMissing},
+			reasonHas: "Website does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := healthyInputs()
+			tt.edit(&in)
+			got := CalculateScoreV2(in)
+			if got.Score != tt.score || got.Level != tt.level {
+				t.Errorf("score %d %s, want %d %s (%s)", got.Score, got.Level, tt.score, tt.level, got.Reason)
+			}
+			var ids []string
+			for _, r := range got.FiredRules {
+				ids = append(ids, r.ID)
+			}
+			if !slices.Equal(ids, tt.fired) {
+				t.Errorf("fired %v, want %v", ids, tt.fired)
+			}
+			if !strings.Contains(got.Reason, tt.reasonHas) {
+				t.Errorf("reason %q, want %q", got.Reason, tt.reasonHas)
+			}
+		})
+	}
+}
@@ -9,174 +9,296 @@ type RiskSummary struct {
 	Score  int    `json:"score"`
 	Level  string `json:"level"`
 	Reason string `json:"reason"`
+	// Version of the scoring policy that produced this score
+	PolicyVersion string `json:"policy_version"`
+	// Scoring profile used (VetRequest.profile, "default" if none)
+	Profile string `json:"profile"`
 	// AI-ready fields
-	// Features  ScoringFeatures  `json:"features,omitempty"`  // For ML training
 	// Weights   ScoringWeights   `json:"weights,omitempty"`   // Weights used
 	Breakdown PenaltyBreakdown `json:"breakdown,omitempty"` // Penalty breakdown
+	// Rules that fired (reject, penalty and level rules, by id)
+	FiredRules []FiredRule `json:"fired_rules,omitempty"`
+	// Signals scored by the unknown-data policy (their check failed or timed out)
+	UnknownSignals []string `json:"unknown_signals,omitempty"`
+}
+
+// ScoreInputs are the check results a domain is scored on, as the checks
+// returned them. Results that could not be determined (failed or timed-out
+// checks) are resolved by the policy's unknown-data settings while building
+// the rule features, so every policy scores the same inputs its own way.
+type ScoreInputs struct {
+	HTTPS        HTTPSResult        `json:"https"`
+	TLSDays      int                `json:"tls_days"`
+	Whois        WhoisResult        `json:"whois"`
+	IsSubdomain  bool               `json:"is_subdomain"`
+	Blacklists   BlacklistSummary   `json:"blacklists"`
+	SafeBrowsing SafeBrowsingResult `json:"safe_browsing"`
+	Email        EmailSecurity      `json:"email"`
+	SSL          SSLQuality         `json:"ssl"`
+	OptIn        OptInCheck         `json:"optin"`
+	Website      WebsiteCheck       `json:"website"`
+	SPFAuth      SPFAuthorization   `json:"spf_authorization"`
+	DKIM         DKIMResult         `json:"dkim"`
+	ReverseDNS   ReverseDNSResult   `json:"reverse_dns"`
+	DNSSEC       DNSSECResult       `json:"dnssec"`
+	DNSConsensus DNSConsensusResult `json:"dns_consensus"`
+	DNSHealth    DNSHealthResult    `json:"dns_health"`
+	MXHosts      MXAnalysis         `json:"mx_hosts"`
+	MTASTS       MTASTSResult       `json:"mta_sts"`
+	BIMI         BIMIResult         `json:"bimi"`
+
+	// Exported as features only (not scored)
+	IPs ResolvedIPs `json:"ips"`
+	Geo GeoInfo     `json:"geo"`
 }
 
 // CalculateScoreV2 - New scoring with blacklist analysis and rejection support
-func CalculateScoreV2(
-	httpsOK bool,
-	tlsDays int,
-	whoisDays int,
-	blacklistAnalysis BlacklistAnalysis,
-	mxRepOk bool,
-	googleFlagged bool,
-	email EmailSecurity,
-	ssl SSLQuality,
-	optIn OptInCheck,
-	website WebsiteCheck,
-	isRejected bool,
-) RiskSummary {
-	thresholds := DefaultScoringThresholds()
+// (rejection and penalties come from the active policy's rules)
+func CalculateScoreV2(in ScoreInputs) RiskSummary {
+	return ActiveScoringPolicy().Score(in)
+}
+
+// ScoreFeatures are the rule features of one domain plus what the policy
+// derived while building them
+type ScoreFeatures struct {
+	*RuleFeatures
+	Blacklist      BlacklistAnalysis
+	UnknownSignals []string // Signals scored by policy, not by data
+}
+
+// Features builds the rule feature set. Results that could not be determined
+// are resolved by the unknown-data policy (a failed WHOIS lookup is not a
+// zero-day-old domain, a DNS timeout is not "no record").
+func (p *ScoringPolicy) Features(in ScoreInputs) ScoreFeatures {
+	unknown := []string{}
+	policy := p.UnknownData
+
+	httpsOK := policy.HTTPS.Resolve(SignalHTTPS, in.HTTPS.CheckOutcome, in.HTTPS.OK, &unknown)
+	websiteExists := policy.Website.Resolve(SignalWebsite, in.Website.CheckOutcome, in.Website.Exists, &unknown)
+	googleFlagged := !policy.SafeBrowsing.Resolve(SignalSafeBrowsing, in.SafeBrowsing.CheckOutcome, !in.SafeBrowsing.Flagged, &unknown)
+	googleReason := in.SafeBrowsing.Reason
+	if googleFlagged && !in.SafeBrowsing.Flagged {
+		googleReason = "lookup failed (unknown-data policy: fail)"
+	}
+	// A failed MXToolbox call is not a reputation of 0
+	mxRepOK := policy.MXReputation.Resolve(SignalMXReputation, in.Blacklists.MXToolbox, p.MXReputationAllowed(in.Blacklists.MxRep), &unknown)
+	isNew := !policy.DomainAge.Resolve(SignalDomainAge, in.Whois.CheckOutcome, in.Whois.AgeDays >= p.Thresholds.NewDomainDays, &unknown)
+	hasMX := policy.MX.Resolve(SignalMX, in.Email.MXCheck, in.Email.HasValidMX, &unknown)
+	hasDMARC := policy.DMARC.Resolve(SignalDMARC, in.Email.DMARCCheck, in.Email.HasDMARC, &unknown)
+	hasDKIM := policy.DKIM.Resolve(SignalDKIM, in.DKIM.CheckOutcome, in.DKIM.HasValidKey, &unknown)
+
+	blacklist := p.AnalyzeBlacklists(in.Blacklists.Hits)
+	var spf SPFAnalysis
+	if in.Email.SPF != nil {
+		spf = *in.Email.SPF
+	}
+	// Effective policy for the exact domain (sp of the organizational domain if inherited)
+	dmarcPolicy, dmarcPct := "", 0
+	if dmarc := in.Email.DMARC; dmarc != nil && in.Email.HasDMARC {
+		dmarcPolicy, dmarcPct = dmarc.Effective, dmarc.Pct
+	}
+
+	f := NewRuleFeatures()
+	f.SetNumber("domain.age_days", float64(in.Whois.AgeDays))
+	f.SetBool("domain.new", isNew)
+	f.SetBool("domain.is_subdomain", in.IsSubdomain)
+	f.SetBool("https.ok", httpsOK)
+	f.SetNumber("tls.days_left", float64(in.TLSDays))
+	f.SetNumber("ssl.score", float64(in.SSL.Score))
+	f.SetBool("website.exists", websiteExists)
+	f.SetNumber("website.traffic_score", float64(in.Website.TrafficScore))
+	f.SetNumber("website.trust_score", float64(in.Website.TrustScore))
+	f.SetBool("email.has_mx", hasMX)
+	f.SetNumber("rdns.unconfirmed", float64(in.ReverseDNS.Unconfirmed))
+	f.SetNumber("rdns.sending_unconfirmed", float64(in.ReverseDNS.SendingUnconfirmed))
+	f.SetNumber("rdns.residential", float64(in.ReverseDNS.Residential))
+	f.SetNumber("rdns.generic", float64(in.ReverseDNS.Generic))
+	f.SetString("dnssec.state", in.DNSSEC.State)
+	f.SetBool("dnssec.signed", in.DNSSEC.Signed)
+	f.SetBool("dns.inconsistent", in.DNSConsensus.Inconsistent)
+	f.SetNumber("dns.ns_count", float64(in.DNSHealth.NSCount))
+	f.SetNumber("dns.ns_asns", float64(len(in.DNSHealth.ASNs)))
+	f.SetNumber("dns.lame", float64(len(in.DNSHealth.Lame)))
+	f.SetString("dns.lame_servers", strings.Join(in.DNSHealth.Lame, ", "))
+	f.SetBool("dns.serial_mismatch", in.DNSHealth.SerialMismatch)
+	f.SetNumber("dns.soa_issues", float64(len(in.DNSHealth.SOAIssues)))
+	f.SetBool("dns.wildcard_a", in.DNSHealth.WildcardA)
+	f.SetBool("dns.wildcard_mx", in.DNSHealth.WildcardMX)
+	f.SetBool("dns.apex_cname", in.DNSHealth.ApexCNAME != "")
+	f.SetNumber("dns.dangling", float64(len(in.DNSHealth.Dangling)))
+	f.SetNumber("dns.takeover", float64(len(in.DNSHealth.Takeovers())))
+	f.SetString("dns.takeover_hosts", strings.Join(in.DNSHealth.Takeovers(), ", "))
+	f.SetBool("dns.has_aaaa", in.DNSHealth.HasAAAA)
+	f.SetBool("mx.null", in.Email.NullMX)
+	f.SetNumber("mx.resolving", float64(in.MXHosts.Resolving))
+	f.SetBool("mx.fcrdns", in.MXHosts.FCrDNS)
+	f.SetNumber("mx.no_starttls", float64(len(in.MXHosts.NoSTARTTLS)))
+	f.SetBool("email.has_spf", in.Email.HasSPF)
+	f.SetBool("spf.valid", spf.Valid)
+	f.SetNumber("spf.dns_lookups", float64(spf.DNSLookups))
+	f.SetNumber("spf.void_lookups", float64(spf.VoidLookups))
+	f.SetString("spf.all", spf.All)
+	// Only a definite answer counts: not configured or DNS failures don't fire rules
+	f.SetBool("spf.sending_checked", in.SPFAuth.Known())
+	f.SetBool("spf.sending_authorized", in.SPFAuth.Authorized)
+	f.SetString("spf.sending_unauthorized", strings.Join(in.SPFAuth.Unauthorized(), ", "))
+	f.SetBool("email.has_dmarc", hasDMARC)
+	f.SetString("dmarc.policy", dmarcPolicy)
+	f.SetNumber("dmarc.pct", float64(dmarcPct))
+	f.SetBool("dkim.found", hasDKIM)
+	f.SetBool("dkim.weak", in.DKIM.HasWeakKey)
+	f.SetBool("dkim.revoked", in.DKIM.HasRevoked)
+	f.SetNumber("dkim.keys", float64(len(in.DKIM.Keys)))
+	f.SetNumber("dkim.min_rsa_bits", float64(in.DKIM.MinRSABits))
+	f.SetString("mta_sts.mode", in.MTASTS.Mode())
+	f.SetNumber("mta_sts.uncovered_mx", float64(len(in.MTASTS.UncoveredMX)))
+	f.SetBool("tls_rpt.found", in.MTASTS.TLSRPT != nil)
+	f.SetBool("bimi.found", in.BIMI.Record != "" && !in.BIMI.Declined)
+	f.SetBool("bimi.certificate", in.BIMI.Certificate.Valid())
+	f.SetBool("bimi.ready", in.BIMI.Ready)
+	f.SetBool("dmarc.inherited", in.Email.DMARC != nil && in.Email.DMARC.Inherited)
+	f.SetNumber("dmarc.unauthorized_reports", float64(len(in.Email.DMARC.UnauthorizedDestinations())))
+	f.SetBool("blacklist.critical", blacklist.IsRejected)
+	f.SetString("blacklist.reject_reason", blacklist.RejectReason)
+	f.SetNumber("blacklist.hits", float64(len(in.Blacklists.Hits)))
+	f.SetNumber("blacklist.penalty", float64(blacklist.TotalPenalty))
+	f.SetString("blacklist.penalty_details", strings.Join(blacklist.PenaltyDetails, ", "))
+	f.SetNumber("mx.reputation", float64(in.Blacklists.MxRep))
+	f.SetBool("mx.reputation_ok", mxRepOK)
+	f.SetBool("safe_browsing.flagged", googleFlagged)
+	f.SetString("safe_browsing.reason", googleReason)
+	f.SetBool("optin.compliant", in.OptIn.Compliance)
+	f.SetBool("optin.has_captcha", in.OptIn.HasCaptcha)
+	return ScoreFeatures{RuleFeatures: f, Blacklist: blacklist, UnknownSignals: unknown}
+}
+
+// Score builds the features of in and scores them
+func (p *ScoringPolicy) Score(in ScoreInputs) RiskSummary {
+	return p.ScoreFeatures(p.Features(in))
+}
+
+// ScoreFeatures evaluates the policy's rules. Rejection and penalties share
+// this one path: any fired reject rule scores 0 ("rejected"), otherwise
+// penalties are subtracted from 100 and the level comes from the thresholds
+// (level rules can only make it worse).
+func (p *ScoringPolicy) ScoreFeatures(features ScoreFeatures) RiskSummary {
+	unknown := features.UnknownSignals
+	outcome := EvaluateRules(p.rules, features.RuleFeatures)
 
 	// If rejected, return score 0
-	if isRejected {
-		rejectReasons := []string{}
-		if blacklistAnalysis.IsRejected {
-			rejectReasons = append(rejectReasons, blacklistAnalysis.RejectReason)
-		}
-		if !mxRepOk {
-			rejectReasons = append(rejectReasons, "MX reputation too low")
-		}
-		if !website.Exists {
-			rejectReasons = append(rejectReasons, "Website does not exist (CRITICAL)")
-		}
-		if !httpsOK {
-			rejectReasons = append(rejectReasons, "HTTPS not enabled (CRITICAL)")
-		}
-		if googleFlagged {
-			rejectReasons = append(rejectReasons, "Google Safe Browsing flagged (CRITICAL)")
-		}
-		// Opt-in compliance is default true for now (will be discussed with client later)
-		reason := "REJECTED: " + strings.Join(rejectReasons, "; ")
+	if outcome.Rejected {
 		return RiskSummary{
-			Score:  0,
-			Level:  "rejected",
-			Reason: reason,
+			Score:         0,
+			Level:         "rejected",
+			PolicyVersion: p.Version,
+			Profile:       p.ProfileName(),
+			Reason:        "REJECTED: " + joinReasons(outcome.RejectReasons) + unknownDataNote(unknown),
 			Breakdown: PenaltyBreakdown{
 				StartingScore:  100,
 				TotalPenalties: 100,
 				FinalScore:     0,
 			},
+			FiredRules:     outcome.Fired,
+			UnknownSignals: unknown,
 		}
 	}
 
-	// Initialize penalty breakdown
 	breakdown := PenaltyBreakdown{
 		StartingScore:  100,
-		BlacklistCount: len(blacklistAnalysis.PenaltyDetails),
+		BlacklistCount: len(features.Blacklist.PenaltyDetails),
 	}
-
-	score := 100
-
-	// Hard-coded weights (default values)
-	// NOTE: HTTPS and Website existence are now CRITICAL (auto-reject)
-	// so they don't have penalties here - they cause rejection before scoring
-	// NOTE: Spamhaus removed - we check it via DNS blacklist instead
-	// NOTE: SPF removed from scoring per user request
-	const (
-		weightDomainTooNew = 20
-		// Google Safe Browsing is now CRITICAL (reject), not penalty
-		weightNoMXRecord      = 60 // Increased: MX is important for reply-to
-		weightNoDMARC         = 20 // CRITICAL but warning-based (not reject)
-		weightDMARCPolicyNone = 10 // p=none is weak policy
-		// Traffic score removed - no real API integrated yet
-		// Opt-in compliance is now CRITICAL (reject), not penalty
-		// COMMENTED OUT per Naksh - CAPTCHA detection might not be accurate
-		// Will discuss with Manny to decide if we keep or remove this check
-		// weightNoCaptcha = 50 // IMPORTANT: no captcha = exposed to bots
-	)
-
-	// Domain age
-	if whoisDays < 60 {
-		penalty := weightDomainTooNew
-		score -= penalty
-		breakdown.DomainTooNew = penalty
-	}
-
-	// BLACKLIST PENALTIES (using analysis instead of simple count)
-	if blacklistAnalysis.TotalPenalty > 0 {
-		score -= blacklistAnalysis.TotalPenalty
-		breakdown.BlacklistPenalty = blacklistAnalysis.TotalPenalty
-	}
-
-	// Google Safe Browsing is now CRITICAL (reject), handled in handlers.go
-
-	// Email Security (SPF removed per user request)
-	if !email.HasValidMX {
-		penalty := weightNoMXRecord
-		score -= penalty
-		breakdown.NoMXRecord = penalty
-	}
-	if !email.HasDMARC {
-		penalty := weightNoDMARC
-		score -= penalty
-		breakdown.NoDMARC = penalty
-	} else {
-		// Check DMARC policy - p=none is weak and gets -10 penalty
-		// p=quarantine and p=reject are good, no penalty
-		if strings.Contains(strings.ToLower(email.DMARCRecord), "p=none") {
-			penalty := weightDMARCPolicyNone
-			score -= penalty
-			breakdown.DMARCPolicyNone = penalty
-		}
+	for _, fired := range outcome.Fired {
+		breakdown.add(fired)
 	}
 
-	// Spamhaus removed - we check it via DNS blacklist (zen.spamhaus.org) instead
-
-	// Website checks - Exists and HTTPS are CRITICAL (rejection, not penalty)
-	// Traffic score removed - no real API integrated yet
-
-	// Opt-in compliance is now CRITICAL (rejection), handled in handlers.go
-
-	// COMMENTED OUT per Naksh - CAPTCHA detection might not be accurate
-	// Will discuss with Manny to decide if we keep or remove this check
-	// CAPTCHA is IMPORTANT - no captcha = exposed to bots (-50)
-	// if !optIn.HasCaptcha {
-	// 	penalty := weightNoCaptcha
-	// 	score -= penalty
-	// 	breakdown.NoCaptcha = penalty
-	// }
-
-	// Calculate total penalties
-	breakdown.TotalPenalties = 100 - score
+	score := 100 - outcome.TotalPenalty
+	breakdown.TotalPenalties = outcome.TotalPenalty
 
 	// Ensure score stays within 0-100 range (prevent negative scores)
 	if score < 0 {
 		score = 0
 		breakdown.TotalPenalties = 100
 	}
-	if score > 100 {
-		score = 100
-		breakdown.TotalPenalties = 0
-	}
-
 	breakdown.FinalScore = score
 
 	// LEVEL (using configurable thresholds)
 	level := "good"
-	if score <= thresholds.HighRiskMax {
+	if score <= p.Thresholds.HighRiskMax {
 		level = "high-risk"
-	} else if score <= thresholds.MediumMax {
+	} else if score <= p.Thresholds.MediumMax {
 		level = "medium"
 	}
+	if outcome.Level != "" {
+		level = worseLevel(level, outcome.Level)
+	}
 
-	// If opt-in compliance fails, always mark as high-risk (mandatory)
-	if !optIn.Compliance {
-		level = "high-risk"
+	return RiskSummary{
+		Score:          score,
+		Level:          level,
+		PolicyVersion:  p.Version,
+		Profile:        p.ProfileName(),
+		Reason:         buildRuleReason(score, level, outcome.Fired) + unknownDataNote(unknown),
+		Breakdown:      breakdown,
+		FiredRules:     outcome.Fired,
+		UnknownSignals: unknown,
 	}
+}
 
-	// Build reason with details
-	reason := buildReasonV2(score, level, breakdown, blacklistAnalysis)
+// add records a fired built-in penalty rule in its breakdown field
+// (custom rules only show up in FiredRules and TotalPenalties)
+func (b *PenaltyBreakdown) add(fired FiredRule) {
+	switch fired.ID {
+	case RuleDomainTooNew:
+		b.DomainTooNew = fired.Penalty
+	case RuleBlacklistPenalty:
+		b.BlacklistPenalty = fired.Penalty
+	case RuleNoMXRecord:
+		b.NoMXRecord = fired.Penalty
+	case RuleNoDMARC:
+		b.NoDMARC = fired.Penalty
+	case RuleDMARCPolicyNone:
+		b.DMARCPolicyNone = fired.Penalty
+	case RuleNoDKIM:
+		b.NoDKIM = fired.Penalty
+	case RuleWeakDKIMKey:
+		b.WeakDKIMKey = fired.Penalty
+	case RuleSingleNameServer:
+		b.SingleNameServer = fired.Penalty
+	case RuleLameDelegation:
+		b.LameDelegation = fired.Penalty
+	case RuleApexCNAME:
+		b.ApexCNAME = fired.Penalty
+	case RuleWildcardMX:
+		b.WildcardMX = fired.Penalty
+	case RuleDanglingCNAME:
+		b.DanglingCNAME = fired.Penalty
+	}
+}
 
-	return RiskSummary{
-		Score:     score,
-		Level:     level,
-		Reason:    reason,
-		Breakdown: breakdown,
+// buildRuleReason creates the reason string from the fired (non-reject) rules
+func buildRuleReason(score int, level string, fired []FiredRule) string {
+	reasons := []string{}
+	for _, r := range fired {
+		if r.Penalty > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s (-%d)", r.Reason, r.Penalty))
+		} else {
+			reasons = append(reasons, r.Reason)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return "All checks passed"
+	}
+
+	return fmt.Sprintf("Score: %d, Level: %s. Issues: %s", score, level, strings.Join(reasons, ", "))
+}
+
+// unknownDataNote mentions the signals that were scored by policy, not by data
+func unknownDataNote(unknownSignals []string) string {
+	if len(unknownSignals) == 0 {
+		return ""
 	}
+	return fmt.Sprintf(" (unknown data: %s)", strings.Join(unknownSignals, ", "))
 }
 
 // CalculateScore - Legacy function (kept for backward compatibility)
@@ -394,65 +516,6 @@ func CalculateScoreWithWeights(
 	}
 }
 
-// buildReasonV2 creates a detailed reason string with blacklist analysis
-func buildReasonV2(score int, level string, breakdown PenaltyBreakdown, blAnalysis BlacklistAnalysis) string {
-	reasons := []string{}
-
-	if breakdown.HTTPSMissing > 0 {
-		reasons = append(reasons, "missing HTTPS (-20)")
-	}
-	if breakdown.DomainTooNew > 0 {
-		reasons = append(reasons, "new domain (-20)")
-	}
-	if breakdown.BlacklistPenalty > 0 {
-		details := strings.Join(blAnalysis.PenaltyDetails, ", ")
-		if details != "" {
-			reasons = append(reasons, fmt.Sprintf("blacklist penalties: %s", details))
-		} else {
-			reasons = append(reasons, fmt.Sprintf("blacklist penalty (-%d)", breakdown.BlacklistPenalty))
-		}
-	}
-	if breakdown.OptInNonCompliant > 0 {
-		reasons = append(reasons, "opt-in non-compliant (-25)")
-	}
-	if breakdown.GoogleFlagged > 0 {
-		reasons = append(reasons, "flagged by Google Safe Browsing (-30)")
-	}
-	if breakdown.NoSPF > 0 {
-		reasons = append(reasons, "no SPF record (-10)")
-	}
-	if breakdown.NoDMARC > 0 {
-		reasons = append(reasons, "no DMARC record (-10)")
-	}
-	if breakdown.NoMXRecord > 0 {
-		reasons = append(reasons, "no MX record (-10)")
-	}
-	if breakdown.TLSExpiringSoon > 0 {
-		reasons = append(reasons, "TLS expiring soon (-10)")
-	}
-	if breakdown.SpamhausHigh > 0 {
-		reasons = append(reasons, "high Spamhaus score (-20)")
-	}
-	if breakdown.WebsiteNotExists > 0 {
-		reasons = append(reasons, "website not accessible (-15)")
-	}
-	if breakdown.TrafficScoreLow > 0 {
-		reasons = append(reasons, "low traffic score (-10)")
-	}
-	if breakdown.TrustScoreLow > 0 {
-		reasons = append(reasons, "low trust score (-15)")
-	}
-	if breakdown.NoCaptcha > 0 {
-		reasons = append(reasons, "no CAPTCHA (-5)")
-	}
-
-	if len(reasons) == 0 {
-		return "All checks passed"
-	}
-
-	return fmt.Sprintf("Score: %d, Level: %s. Issues: %s", score, level, strings.Join(reasons, ", "))
-}
-
 // buildReason creates a detailed reason string (legacy)
 func buildReason(score int, level string, breakdown PenaltyBreakdown) string {
 	reasons := []string{}
@@ -2,107 +2,132 @@ package vetting
 
 // ScoringWeights defines configurable weights for scoring calculation
 // These can be adjusted by AI agent based on real-world performance data
-// type ScoringWeights struct {
-// 	// Website checks
-// 	HTTPSMissing     int `json:"https_missing"`      // Default: 20
-// 	TLSExpiringSoon  int `json:"tls_expiring_soon"`  // Default: 10
-// 	WebsiteNotExists int `json:"website_not_exists"` // Default: 15
-
-// 	// Domain age
-// 	DomainTooNew int `json:"domain_too_new"` // Default: 20
-
-// 	// Reputation
-// 	SenderScoreLow  int `json:"sender_score_low"`  // Default: 10
-// 	BlacklistPerHit int `json:"blacklist_per_hit"` // Default: 10
-// 	GoogleFlagged   int `json:"google_flagged"`    // Default: 30
-// 	SpamhausHigh    int `json:"spamhaus_high"`     // Default: 20
-
-// 	// Email security
-// 	NoMXRecord int `json:"no_mx_record"` // Default: 10
-// 	NoSPF      int `json:"no_spf"`       // Default: 10
-// 	NoDMARC    int `json:"no_dmarc"`     // Default: 10
-
-// 	// Website scores (traffic & trust)
-// 	TrafficScoreLow    int `json:"traffic_score_low"`    // Default: 10
-// 	TrafficScoreMedium int `json:"traffic_score_medium"` // Default: 5
-// 	TrustScoreLow      int `json:"trust_score_low"`      // Default: 15
-// 	TrustScoreMedium   int `json:"trust_score_medium"`   // Default: 8
-
-// 	// Opt-in (mandatory)
-// 	OptInNonCompliant int `json:"optin_non_compliant"` // Default: 25
-// 	NoCaptcha         int `json:"no_captcha"`          // Default: 5
-// }
+// (loaded from the scoring policy file, see scoring_policy.go; cmd/calibrate
+// fits them to recorded warmup outcomes, see calibration.go)
+type ScoringWeights struct {
+	// Domain age
+	DomainTooNew int `json:"domain_too_new"` // Default: 20
+
+	// Email security (SPF not scored)
+	NoMXRecord      int `json:"no_mx_record"`      // Default: 60 (MX is important for reply-to)
+	NoDMARC         int `json:"no_dmarc"`          // Default: 20
+	DMARCPolicyNone int `json:"dmarc_policy_none"` // Default: 10 (p=none is weak policy)
+	NoDKIM          int `json:"no_dkim"`           // Default: 0 (guessed selectors miss real keys; reported, not scored)
+	WeakDKIMKey     int `json:"weak_dkim_key"`     // Default: 5 (RSA key of 1024 bits or less)
+
+	// DNS health (zone and delegation)
+	// Default: 0 (reported, not scored) until cmd/calibrate fits them
+	SingleNameServer int `json:"single_nameserver"` // RFC 1034 asks for two
+	LameDelegation   int `json:"lame_delegation"`   // NS not authoritative for the zone
+	ApexCNAME        int `json:"apex_cname"`        // Breaks MX/TXT at the apex
+	WildcardMX       int `json:"wildcard_mx"`       // Every subdomain accepts mail
+	DanglingCNAME    int `json:"dangling_cname"`    // Alias to an unclaimed SaaS host
+
+	// Blacklists not listed in BlacklistPenalties
+	UnknownBlacklist int `json:"unknown_blacklist"` // Default: 10
+}
 
 // DefaultScoringWeights returns default weights (current hard-coded values)
-// func DefaultScoringWeights() ScoringWeights {
-// 	return ScoringWeights{
-// 		HTTPSMissing:       20,
-// 		TLSExpiringSoon:    10,
-// 		WebsiteNotExists:   15,
-// 		DomainTooNew:       20,
-// 		SenderScoreLow:     10,
-// 		BlacklistPerHit:    10,
-// 		GoogleFlagged:      30,
-// 		SpamhausHigh:       20,
-// 		NoMXRecord:         10,
-// 		NoSPF:              10,
-// 		NoDMARC:            10,
-// 		TrafficScoreLow:    10,
-// 		TrafficScoreMedium: 5,
-// 		TrustScoreLow:      15,
-// 		TrustScoreMedium:   8,
-// 		OptInNonCompliant:  25,
-// 		NoCaptcha:          5,
-// 	}
-// }
+func DefaultScoringWeights() ScoringWeights {
+	return ScoringWeights{
+		DomainTooNew:     20,
+		NoMXRecord:       60,
+		NoDMARC:          20,
+		DMARCPolicyNone:  10,
+		NoDKIM:           0,
+		WeakDKIMKey:      5,
+		SingleNameServer: 0,
+		LameDelegation:   0,
+		ApexCNAME:        0,
+		WildcardMX:       0,
+		DanglingCNAME:    0,
+		UnknownBlacklist: 10,
+	}
+}
 
 // ScoringThresholds defines thresholds for risk levels
-// AI can adjust these based on actual outcomes
+// AI can adjust these based on actual outcomes (HighRiskMax and MediumMax
+// are fitted by cmd/calibrate)
 type ScoringThresholds struct {
 	HighRiskMax int `json:"high_risk_max"` // Default: 40
 	MediumMax   int `json:"medium_max"`    // Default: 70
 	// Good: 71-100
+
+	NewDomainDays   int `json:"new_domain_days"`   // Default: 60 (younger = DomainTooNew penalty)
+	MinMXReputation int `json:"min_mx_reputation"` // Default: 40 (lower = reject)
 }
 
 // DefaultScoringThresholds returns default thresholds
 func DefaultScoringThresholds() ScoringThresholds {
 	return ScoringThresholds{
-		HighRiskMax: 40,
-		MediumMax:   70,
+		HighRiskMax:     40,
+		MediumMax:       70,
+		NewDomainDays:   60,
+		MinMXReputation: 40,
 	}
 }
 
-// ScoringFeatures extracts all features for ML/AI training
-// This helps AI agent learn which factors matter most
-// type ScoringFeatures struct {
-// 	// Binary features
-// 	HasHTTPS       bool `json:"has_https"`
-// 	WebsiteExists  bool `json:"website_exists"`
-// 	HasValidMX     bool `json:"has_valid_mx"`
-// 	HasSPF         bool `json:"has_spf"`
-// 	HasDMARC       bool `json:"has_dmarc"`
-// 	GoogleFlagged  bool `json:"google_flagged"`
-// 	OptInCompliant bool `json:"optin_compliant"`
-// 	HasCaptcha     bool `json:"has_captcha"`
+// UnknownAction decides how a signal is scored when its check failed or timed out
+type UnknownAction string
+
+const (
+	UnknownPass UnknownAction = "pass" // Treat as if the check passed (no penalty/reject)
+	UnknownFail UnknownAction = "fail" // Treat as if the check was negative
+)
+
+// Signal names (used in UnknownDataPolicy and RiskSummary.UnknownSignals)
+const (
+	SignalDomainAge    = "domain_age"
+	SignalMX           = "mx"
+	SignalDMARC        = "dmarc"
+	SignalDKIM         = "dkim"
+	SignalMXReputation = "mx_reputation"
+	SignalHTTPS        = "https"
+	SignalWebsite      = "website"
+	SignalSafeBrowsing = "safe_browsing"
+)
+
+// UnknownDataPolicy is the explicit policy for signals whose check could not
+// produce an answer (error or timed_out). Skipped checks (disabled or not
+// configured) always pass.
+type UnknownDataPolicy struct {
+	DomainAge    UnknownAction `json:"domain_age"`    // Default: pass (no "new domain" penalty)
+	MX           UnknownAction `json:"mx"`            // Default: pass
+	DMARC        UnknownAction `json:"dmarc"`         // Default: pass
+	DKIM         UnknownAction `json:"dkim"`          // Default: pass
+	MXReputation UnknownAction `json:"mx_reputation"` // Default: pass
+	HTTPS        UnknownAction `json:"https"`         // Default: fail (reject)
+	Website      UnknownAction `json:"website"`       // Default: fail (reject)
+	SafeBrowsing UnknownAction `json:"safe_browsing"` // Default: pass
+}
 
-// 	// Numeric features
-// 	TLSDaysLeft     int     `json:"tls_days_left"`
-// 	WhoisAgeDays    int     `json:"whois_age_days"`
-// 	BlacklistCount  int     `json:"blacklist_count"`
-// 	SenderScore     int     `json:"sender_score"`
-// 	SpamhausScore   float64 `json:"spamhaus_score"`
-// 	TrafficScore    int     `json:"traffic_score"`
-// 	TrustScore      int     `json:"trust_score"`
-// 	SSLQualityScore int     `json:"ssl_quality_score"`
+// DefaultUnknownDataPolicy returns the default unknown-data policy
+func DefaultUnknownDataPolicy() UnknownDataPolicy {
+	return UnknownDataPolicy{
+		DomainAge:    UnknownPass,
+		MX:           UnknownPass,
+		DMARC:        UnknownPass,
+		DKIM:         UnknownPass,
+		MXReputation: UnknownPass,
+		HTTPS:        UnknownFail,
+		Website:      UnknownFail,
+		SafeBrowsing: UnknownPass,
+	}
+}
 
-// 	// Calculated risk indicators
-// 	IsNewDomain      bool `json:"is_new_domain"`       // < 60 days
-// 	IsTLSExpiring    bool `json:"is_tls_expiring"`     // < 30 days
-// 	IsLowSenderScore bool `json:"is_low_sender_score"` // < 60
-// 	IsHighSpamhaus   bool `json:"is_high_spamhaus"`    // > 30
-// 	IsLowTraffic     bool `json:"is_low_traffic"`      // < 5
-// 	IsLowTrust       bool `json:"is_low_trust"`        // < 5
-// }
+// Resolve returns whether a signal passes. Known answers are used as is,
+// skipped checks pass, and failed/timed out checks follow the action
+// (the signal is then added to unknown).
+func (a UnknownAction) Resolve(signal string, o CheckOutcome, pass bool, unknown *[]string) bool {
+	switch {
+	case o.Known():
+		return pass
+	case o.Status == StatusSkipped:
+		return true
+	}
+	*unknown = append(*unknown, signal)
+	return a != UnknownFail
+}
 
 // PenaltyBreakdown shows which penalties were applied and their values
 type PenaltyBreakdown struct {
@@ -119,6 +144,13 @@ type PenaltyBreakdown struct {
 	NoSPF              int `json:"no_spf,omitempty"`
 	NoDMARC            int `json:"no_dmarc,omitempty"`
 	DMARCPolicyNone    int `json:"dmarc_policy_none,omitempty"` // p=none penalty
+	NoDKIM             int `json:"no_dkim,omitempty"`
+	WeakDKIMKey        int `json:"weak_dkim_key,omitempty"`
+	SingleNameServer   int `json:"single_nameserver,omitempty"`
+	LameDelegation     int `json:"lame_delegation,omitempty"`
+	ApexCNAME          int `json:"apex_cname,omitempty"`
+	WildcardMX         int `json:"wildcard_mx,omitempty"`
+	DanglingCNAME      int `json:"dangling_cname,omitempty"`
 	TrafficScoreLow    int `json:"traffic_score_low,omitempty"`
 	TrafficScoreMedium int `json:"traffic_score_medium,omitempty"`
 	TrustScoreLow      int `json:"trust_score_low,omitempty"`
@@ -129,46 +161,3 @@ type PenaltyBreakdown struct {
 	FinalScore         int `json:"final_score"`
 	StartingScore      int `json:"starting_score"`
 }
-
-// ExtractFeatures extracts all features from domain vetting results
-// This is used for ML model training and AI learning
-// func ExtractFeatures(
-// 	httpsOK bool,
-// 	tlsDays int,
-// 	whoisDays int,
-// 	blacklistCount int,
-// 	mxRep int,
-// 	googleFlagged bool,
-// 	email EmailSecurity,
-// 	ssl SSLQuality,
-// 	spam SpamhausResponse,
-// 	optIn OptInCheck,
-// 	website WebsiteCheck,
-// ) ScoringFeatures {
-// 	return ScoringFeatures{
-// 		HasHTTPS:       httpsOK,
-// 		WebsiteExists:  website.Exists,
-// 		HasValidMX:     email.HasValidMX,
-// 		HasSPF:         email.HasSPF,
-// 		HasDMARC:       email.HasDMARC,
-// 		GoogleFlagged:  googleFlagged,
-// 		OptInCompliant: optIn.Compliance,
-// 		HasCaptcha:     optIn.HasCaptcha,
-
-// 		TLSDaysLeft:     tlsDays,
-// 		WhoisAgeDays:    whoisDays,
-// 		BlacklistCount:  blacklistCount,
-// 		SenderScore:     mxRep,
-// 		SpamhausScore:   spam.Score,
-// 		TrafficScore:    website.TrafficScore,
-// 		TrustScore:      website.TrustScore,
-// 		SSLQualityScore: ssl.Score,
-
-// 		IsNewDomain:      whoisDays < 60,
-// 		IsTLSExpiring:    tlsDays < 30,
-// 		IsLowSenderScore: mxRep < 60,
-// 		IsHighSpamhaus:   spam.Score > 30,
-// 		IsLowTraffic:     website.TrafficScore < 5,
-// 		IsLowTrust:       website.TrustScore < 5,
-// 	}
-// }
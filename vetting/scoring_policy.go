@@ -0,0 +1,452 @@
+package vetting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ScoringPolicy is everything the deliverability team can tune without a
+// redeploy: weights, thresholds, blacklist lists and the unknown-data policy.
+// Loaded from a versioned JSON file (SCORING_POLICY_FILE) and hot-reloaded.
+type ScoringPolicy struct {
+	Version     string            `json:"version"`
+	Weights     ScoringWeights    `json:"weights"`
+	Thresholds  ScoringThresholds `json:"thresholds"`
+	UnknownData UnknownDataPolicy `json:"unknown_data"`
+
+	// Blacklists (see blacklist_config.go for the built-in defaults)
+	CriticalBlacklists       []string       `json:"critical_blacklists"`
+	BlacklistPenalties       map[string]int `json:"blacklist_penalties"`
+	UCEProtectLevelPenalties map[int]int    `json:"uceprotect_level_penalties"`
+
+	// Reject/penalty rules (see rules.go). Without rules in the file the
+	// built-in rules are generated from the weights and thresholds above.
+	Rules []RuleSpec `json:"rules,omitempty"`
+
+	// Rule adjustments (mostly used by profiles): drop rules by id, add rules
+	DisableRules []string   `json:"disable_rules,omitempty"`
+	ExtraRules   []RuleSpec `json:"extra_rules,omitempty"`
+
+	// Named profiles per customer segment (selected by VetRequest.profile).
+	// Each profile starts from this policy and overrides only what it sets
+	// (see scoring_profiles.go for the built-in ones).
+	ProfileSpecs map[string]json.RawMessage `json:"profiles,omitempty"`
+
+	rules    []*Rule                   // Compiled rules
+	name     string                    // Profile name (DefaultProfile for the base policy)
+	profiles map[string]*ScoringPolicy // Resolved profiles (base policy only)
+}
+
+// Built-in rule ids (penalty rules fill the matching PenaltyBreakdown field)
+const (
+	RuleCriticalBlacklist = "critical_blacklist"
+	RuleMXReputationLow   = "mx_reputation_low"
+	RuleWebsiteMissing    = "website_missing"
+	RuleHTTPSMissing      = "https_missing"
+	RuleGoogleFlagged     = "google_flagged"
+	RuleDomainTooNew      = "domain_too_new"
+	RuleBlacklistPenalty  = "blacklist_penalty"
+	RuleNoMXRecord        = "no_mx_record"
+	RuleNoDMARC           = "no_dmarc"
+	RuleDMARCPolicyNone   = "dmarc_policy_none"
+	RuleNoDKIM            = "no_dkim"
+	RuleWeakDKIMKey       = "weak_dkim_key"
+	RuleSingleNameServer  = "single_nameserver"
+	RuleLameDelegation    = "lame_delegation"
+	RuleApexCNAME         = "apex_cname"
+	RuleWildcardMX        = "wildcard_mx"
+	RuleDanglingCNAME     = "dangling_cname"
+	RuleOptInNonCompliant = "optin_non_compliant"
+	RuleSPFNotAuthorized  = "spf_sender_not_authorized"
+	RuleDNSSECBogus       = "dnssec_bogus"
+)
+
+// DefaultRules returns the built-in rules: the five critical reject checks
+// and the CalculateScoreV2 penalties, using the given weights/thresholds
+func DefaultRules(w ScoringWeights, t ScoringThresholds) []RuleSpec {
+	return []RuleSpec{
+		// CRITICAL - auto reject
+		{RuleCriticalBlacklist, `when blacklist.critical then reject "{blacklist.reject_reason}"`},
+		{RuleMXReputationLow, fmt.Sprintf(`when not mx.reputation_ok then reject "MX reputation too low (below %d)"`, t.MinMXReputation)},
+		{RuleWebsiteMissing, `when not website.exists then reject "Website does not exist or is not accessible"`},
+		{RuleHTTPSMissing, `when not https.ok then reject "HTTPS is not enabled on the domain"`},
+		{RuleGoogleFlagged, `when safe_browsing.flagged then reject "Google Safe Browsing flagged this domain as unsafe: {safe_browsing.reason}"`},
+
+		// Penalties
+		{RuleDomainTooNew, fmt.Sprintf(`when domain.new then penalty %d "new domain"`, w.DomainTooNew)},
+		{RuleBlacklistPenalty, `when blacklist.penalty > 0 then penalty blacklist.penalty "blacklist penalties [{blacklist.penalty_details}]"`},
+		{RuleNoMXRecord, fmt.Sprintf(`when not email.has_mx then penalty %d "no MX record"`, w.NoMXRecord)},
+		{RuleNoDMARC, fmt.Sprintf(`when not email.has_dmarc then penalty %d "no DMARC record"`, w.NoDMARC)},
+		{RuleDMARCPolicyNone, fmt.Sprintf(`when dmarc.policy == "none" then penalty %d "weak DMARC policy p=none"`, w.DMARCPolicyNone)},
+		{RuleNoDKIM, fmt.Sprintf(`when not dkim.found then penalty %d "no DKIM key on common selectors"`, w.NoDKIM)},
+		{RuleWeakDKIMKey, fmt.Sprintf(`when dkim.found and dkim.weak then penalty %d "weak DKIM key ({dkim.min_rsa_bits}-bit RSA)"`, w.WeakDKIMKey)},
+		{RuleSingleNameServer, fmt.Sprintf(`when dns.ns_count == 1 then penalty %d "single name server"`, w.SingleNameServer)},
+		{RuleLameDelegation, fmt.Sprintf(`when dns.lame > 0 then penalty %d "lame delegation ({dns.lame_servers})"`, w.LameDelegation)},
+		{RuleApexCNAME, fmt.Sprintf(`when dns.apex_cname then penalty %d "CNAME at the zone apex"`, w.ApexCNAME)},
+		{RuleWildcardMX, fmt.Sprintf(`when dns.wildcard_mx then penalty %d "wildcard MX record"`, w.WildcardMX)},
+		{RuleDanglingCNAME, fmt.Sprintf(`when dns.takeover > 0 then penalty %d "dangling CNAME to an unclaimed host ({dns.takeover_hosts})"`, w.DanglingCNAME)},
+
+		// Opt-in compliance is mandatory: always high-risk
+		{RuleOptInNonCompliant, `when not optin.compliant then level high-risk "opt-in non-compliant"`},
+		// SPF that doesn't authorize our sending IPs blocks warmup
+		{RuleSPFNotAuthorized, `when spf.sending_checked and not spf.sending_authorized then level high-risk "SPF does not authorize our sending IPs: {spf.sending_unauthorized}"`},
+		// A bogus chain makes validating receivers SERVFAIL the domain's mail
+		{RuleDNSSECBogus, `when dnssec.state == "bogus" then level high-risk "DNSSEC validation fails: validating resolvers reject the domain"`},
+	}
+}
+
+// defaultPolicyVersion is the version of the built-in (compiled-in) policy
+const defaultPolicyVersion = "builtin"
+
+// defaultPolicyReloadInterval is how often the policy file is checked for changes
+const defaultPolicyReloadInterval = 10 * time.Second
+
+// DefaultScoringPolicy returns the built-in policy (current hard-coded values)
+func DefaultScoringPolicy() *ScoringPolicy {
+	p := &ScoringPolicy{
+		Version:                  defaultPolicyVersion,
+		Weights:                  DefaultScoringWeights(),
+		Thresholds:               DefaultScoringThresholds(),
+		UnknownData:              DefaultUnknownDataPolicy(),
+		CriticalBlacklists:       append([]string(nil), CriticalBlacklists...),
+		BlacklistPenalties:       map[string]int{},
+		UCEProtectLevelPenalties: map[int]int{},
+	}
+	for name, penalty := range BlacklistPenalties {
+		p.BlacklistPenalties[name] = penalty
+	}
+	for level, penalty := range UCEProtectLevelPenalties {
+		p.UCEProtectLevelPenalties[level] = penalty
+	}
+	if err := p.resolve(); err != nil {
+		panic("vetting: built-in scoring policy: " + err.Error())
+	}
+	return p
+}
+
+// resolve compiles the base policy's rules and builds its profiles
+func (p *ScoringPolicy) resolve() error {
+	p.name = DefaultProfile
+	if err := p.compileRules(); err != nil {
+		return err
+	}
+	return p.resolveProfiles()
+}
+
+// compileRules compiles Rules (the built-in ones if there are none),
+// minus DisableRules, plus ExtraRules
+func (p *ScoringPolicy) compileRules() error {
+	specs := p.Rules
+	if len(specs) == 0 {
+		specs = DefaultRules(p.Weights, p.Thresholds)
+	}
+
+	disabled := map[string]bool{}
+	for _, id := range p.DisableRules {
+		disabled[id] = true
+	}
+	var active []RuleSpec
+	for _, spec := range specs {
+		if disabled[spec.ID] {
+			delete(disabled, spec.ID)
+			continue
+		}
+		active = append(active, spec)
+	}
+	for id := range disabled {
+		return fmt.Errorf("disable_rules: unknown rule %q", id)
+	}
+	active = append(active, p.ExtraRules...)
+
+	rules, err := CompileRules(active)
+	if err != nil {
+		return err
+	}
+	p.rules = rules
+	return nil
+}
+
+// activePolicy is swapped atomically on reload; requests take one snapshot
+var activePolicy atomic.Pointer[ScoringPolicy]
+
+// ActiveScoringPolicy returns the policy currently in effect.
+// Callers must not modify it (take it once per request and use it throughout).
+func ActiveScoringPolicy() *ScoringPolicy {
+	if p := activePolicy.Load(); p != nil {
+		return p
+	}
+	return defaultPolicy
+}
+
+var defaultPolicy = DefaultScoringPolicy()
+
+// SetScoringPolicy makes p the active policy (nil restores the built-in one)
+func SetScoringPolicy(p *ScoringPolicy) {
+	activePolicy.Store(p)
+}
+
+// candidatePolicy is scored alongside the active policy (shadow mode)
+var candidatePolicy atomic.Pointer[ScoringPolicy]
+
+// CandidateScoringPolicy returns the shadow policy, or nil if shadow scoring is off
+func CandidateScoringPolicy() *ScoringPolicy {
+	return candidatePolicy.Load()
+}
+
+// SetCandidateScoringPolicy enables shadow scoring under p (nil disables it)
+func SetCandidateScoringPolicy(p *ScoringPolicy) {
+	candidatePolicy.Store(p)
+}
+
+// ParseScoringPolicy parses a JSON policy. Fields missing from the file keep
+// their built-in defaults; the blacklist lists/maps replace the defaults
+// when present.
+func ParseScoringPolicy(data []byte) (*ScoringPolicy, error) {
+	p := DefaultScoringPolicy()
+	p.Version = ""
+	p.ProfileSpecs = nil
+
+	if err := decodePolicyOnto(p, data); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if err := p.resolve(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return p, nil
+}
+
+// decodePolicyOnto decodes JSON over p (only the fields present change)
+func decodePolicyOnto(p *ScoringPolicy, data []byte) error {
+	// Maps would otherwise be merged into the defaults
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return fmt.Errorf("invalid policy JSON: %w", err)
+	}
+	if _, ok := present["blacklist_penalties"]; ok {
+		p.BlacklistPenalties = nil
+	}
+	if _, ok := present["uceprotect_level_penalties"]; ok {
+		p.UCEProtectLevelPenalties = nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+	return nil
+}
+
+// clone returns a deep copy of the policy settings (without compiled rules or profiles)
+func (p *ScoringPolicy) clone() *ScoringPolicy {
+	c := *p
+	c.CriticalBlacklists = append([]string(nil), p.CriticalBlacklists...)
+	c.BlacklistPenalties = make(map[string]int, len(p.BlacklistPenalties))
+	for name, penalty := range p.BlacklistPenalties {
+		c.BlacklistPenalties[name] = penalty
+	}
+	c.UCEProtectLevelPenalties = make(map[int]int, len(p.UCEProtectLevelPenalties))
+	for level, penalty := range p.UCEProtectLevelPenalties {
+		c.UCEProtectLevelPenalties[level] = penalty
+	}
+	c.Rules = append([]RuleSpec(nil), p.Rules...)
+	c.DisableRules = append([]string(nil), p.DisableRules...)
+	c.ExtraRules = append([]RuleSpec(nil), p.ExtraRules...)
+	c.ProfileSpecs = nil
+	c.rules = nil
+	c.name = ""
+	c.profiles = nil
+	return &c
+}
+
+// LoadScoringPolicy reads and validates a policy file
+func LoadScoringPolicy(path string) (*ScoringPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseScoringPolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate rejects policies that would silently misbehave
+func (p *ScoringPolicy) Validate() error {
+	var errs []error
+	if p.Version == "" {
+		errs = append(errs, errors.New("version is required"))
+	}
+
+	w := p.Weights
+	for name, v := range map[string]int{
+		"weights.domain_too_new":    w.DomainTooNew,
+		"weights.no_mx_record":      w.NoMXRecord,
+		"weights.no_dmarc":          w.NoDMARC,
+		"weights.dmarc_policy_none": w.DMARCPolicyNone,
+		"weights.no_dkim":           w.NoDKIM,
+		"weights.weak_dkim_key":     w.WeakDKIMKey,
+		"weights.single_nameserver": w.SingleNameServer,
+		"weights.lame_delegation":   w.LameDelegation,
+		"weights.apex_cname":        w.ApexCNAME,
+		"weights.wildcard_mx":       w.WildcardMX,
+		"weights.dangling_cname":    w.DanglingCNAME,
+		"weights.unknown_blacklist": w.UnknownBlacklist,
+	} {
+		if v < 0 || v > 100 {
+			errs = append(errs, fmt.Errorf("%s must be 0-100, got %d", name, v))
+		}
+	}
+
+	t := p.Thresholds
+	if t.HighRiskMax < 0 || t.HighRiskMax >= t.MediumMax || t.MediumMax > 100 {
+		errs = append(errs, fmt.Errorf("thresholds must satisfy 0 <= high_risk_max < medium_max <= 100, got %d/%d", t.HighRiskMax, t.MediumMax))
+	}
+	if t.NewDomainDays < 0 || t.MinMXReputation < 0 || t.MinMXReputation > 100 {
+		errs = append(errs, fmt.Errorf("thresholds.new_domain_days and min_mx_reputation must be non-negative (mx reputation 0-100)"))
+	}
+
+	u := p.UnknownData
+	for name, a := range map[string]UnknownAction{
+		SignalDomainAge:    u.DomainAge,
+		SignalMX:           u.MX,
+		SignalDMARC:        u.DMARC,
+		SignalDKIM:         u.DKIM,
+		SignalMXReputation: u.MXReputation,
+		SignalHTTPS:        u.HTTPS,
+		SignalWebsite:      u.Website,
+		SignalSafeBrowsing: u.SafeBrowsing,
+	} {
+		if a != UnknownPass && a != UnknownFail {
+			errs = append(errs, fmt.Errorf("unknown_data.%s must be %q or %q, got %q", name, UnknownPass, UnknownFail, a))
+		}
+	}
+
+	for _, name := range p.CriticalBlacklists {
+		if name == "" {
+			errs = append(errs, errors.New("critical_blacklists contains an empty name"))
+		}
+	}
+	for name, penalty := range p.BlacklistPenalties {
+		if name == "" || penalty < 0 {
+			errs = append(errs, fmt.Errorf("blacklist_penalties[%q] must have a name and a non-negative penalty", name))
+		}
+	}
+	for level, penalty := range p.UCEProtectLevelPenalties {
+		if level < 1 || level > 3 || penalty < 0 {
+			errs = append(errs, fmt.Errorf("uceprotect_level_penalties[%d]: level must be 1-3 and penalty non-negative", level))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//
+// HOT RELOAD
+//
+
+// WatchScoringPolicy loads path and reloads it whenever the file changes
+// (polled every interval until ctx is done). An invalid file is logged and
+// the previous policy stays active.
+func WatchScoringPolicy(ctx context.Context, path string, interval time.Duration) error {
+	return watchPolicyFile(ctx, path, interval, "scoring", SetScoringPolicy, ActiveScoringPolicy)
+}
+
+// WatchCandidateScoringPolicy is WatchScoringPolicy for the shadow policy
+func WatchCandidateScoringPolicy(ctx context.Context, path string, interval time.Duration) error {
+	return watchPolicyFile(ctx, path, interval, "candidate", SetCandidateScoringPolicy, CandidateScoringPolicy)
+}
+
+// watchPolicyFile loads path into one policy slot (set/current) and polls it
+func watchPolicyFile(ctx context.Context, path string, interval time.Duration, kind string, set func(*ScoringPolicy), current func() *ScoringPolicy) error {
+	p, err := LoadScoringPolicy(path)
+	if err != nil {
+		return err
+	}
+	set(p)
+	log.Printf("[Policy] Loaded %s policy %s from %s", kind, p.Version, path)
+
+	last, _ := os.Stat(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Printf("[Policy] ⚠️ Cannot stat %s, keeping %s policy %s: %v", path, kind, policyVersion(current()), err)
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			p, err := LoadScoringPolicy(path)
+			if err != nil {
+				log.Printf("[Policy] ❌ Reload failed, keeping %s policy %s: %v", kind, policyVersion(current()), err)
+				continue
+			}
+			previous := policyVersion(current())
+			set(p)
+			log.Printf("[Policy] 🔄 %s policy reloaded: %s → %s", kind, previous, p.Version)
+		}
+	}()
+	return nil
+}
+
+// policyVersion returns the version of p for logging ("none" if nil)
+func policyVersion(p *ScoringPolicy) string {
+	if p == nil {
+		return "none"
+	}
+	return p.Version
+}
+
+// ConfigureScoringPolicyFromEnv loads SCORING_POLICY_FILE (if set) and
+// watches it for changes every SCORING_POLICY_RELOAD (default 10s).
+// Without a file the built-in policy is used. SCORING_CANDIDATE_POLICY_FILE
+// enables shadow scoring: /vet also scores every domain under that policy.
+func ConfigureScoringPolicyFromEnv() {
+	interval := defaultPolicyReloadInterval
+	if v := os.Getenv("SCORING_POLICY_RELOAD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			interval = d
+		} else {
+			log.Printf("[Policy] Invalid SCORING_POLICY_RELOAD %q, using %v", v, interval)
+		}
+	}
+
+	if path := os.Getenv("SCORING_POLICY_FILE"); path != "" {
+		if err := WatchScoringPolicy(context.Background(), path, interval); err != nil {
+			log.Fatalf("[Policy] Cannot load scoring policy: %v", err)
+		}
+	} else {
+		log.Printf("[Policy] Using built-in scoring policy")
+	}
+
+	if path := os.Getenv("SCORING_CANDIDATE_POLICY_FILE"); path != "" {
+		if err := WatchCandidateScoringPolicy(context.Background(), path, interval); err != nil {
+			log.Fatalf("[Policy] Cannot load candidate policy: %v", err)
+		}
+		if CandidateScoringPolicy().Version == ActiveScoringPolicy().Version {
+			log.Printf("[Policy] ⚠️ Candidate policy has the same version as the active one (%s); shadow deltas will be hard to tell apart", ActiveScoringPolicy().Version)
+		}
+	}
+}
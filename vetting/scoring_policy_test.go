@@ -0,0 +1,135 @@
+package vetting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseScoringPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		err    string // Substring of the error ("" = valid)
+	}{
+		{name: "version only", policy: `{"version":"v1"}`},
+		{name: "example file", policy: string(mustReadFile(t, "../scoring_policy.example.json"))},
+		{name: "not JSON", policy: `{"version":`, err: "invalid policy JSON"},
+		{name: "unknown field", policy: `{"version":"v1","weigths":{}}`, err: `unknown field "weigths"`},
+		{name: "unknown weight", policy: `{"version":"v1","weights":{"no_spf":10}}`, err: `unknown field "no_spf"`},
+		{name: "missing version", policy: `{"weights":{"no_dmarc":10}}`, err: "version is required"},
+		{name: "negative weight", policy: `{"version":"v1","weights":{"no_dmarc":-1}}`, err: "weights.no_dmarc must be 0-100, got -1"},
+		{name: "weight over 100", policy: `{"version":"v1","weights":{"unknown_blacklist":101}}`, err: "weights.unknown_blacklist must be 0-100"},
+		{name: "thresholds inverted", policy: `{"version":"v1","thresholds":{"high_risk_max":70,"medium_max":40}}`, err: "high_risk_max < medium_max"},
+		{name: "thresholds equal", policy: `{"version":"v1","thresholds":{"high_risk_max":70,"medium_max":70}}`, err: "got 70/70"},
+		{name: "medium over 100", policy: `{"version":"v1","thresholds":{"medium_max":101}}`, err: "medium_max <= 100"},
+		{name: "negative high risk", policy: `{"version":"v1","thresholds":{"high_risk_max":-1}}`, err: "0 <= high_risk_max"},
+		{name: "mx reputation over 100", policy: `{"version":"v1","thresholds":{"min_mx_reputation":101}}`, err: "min_mx_reputation"},
+		{name: "unknown data action", policy: `{"version":"v1","unknown_data":{"https":"ignore"}}`, err: `unknown_data.https must be "pass" or "fail"`},
+		{name: "uceprotect level", policy: `{"version":"v1","uceprotect_level_penalties":{"4":10}}`, err: "uceprotect_level_penalties[4]"},
+		{name: "negative blacklist penalty", policy: `{"version":"v1","blacklist_penalties":{"spamcop":-5}}`, err: `blacklist_penalties["spamcop"]`},
+		{name: "bad rule", policy: `{"version":"v1","rules":[{"id":"x","rule":"when nope then reject \"x\""}]}`, err: "invalid policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseScoringPolicy([]byte(tt.policy))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+			if p != nil {
+				t.Errorf("policy returned with an error")
+			}
+		})
+	}
+}
+
+func TestParseScoringPolicyDefaults(t *testing.T) {
+	p, err := ParseScoringPolicy([]byte(`{"version":"v1","weights":{"no_dmarc":30},"blacklist_penalties":{"spamcop":15}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultScoringPolicy()
+	if p.Weights.NoDMARC != 30 || p.Weights.NoMXRecord != defaults.Weights.NoMXRecord || p.Thresholds != defaults.Thresholds {
+		t.Errorf("weights %+v thresholds %+v, want no_dmarc=30 and the other defaults", p.Weights, p.Thresholds)
+	}
+	// Maps in the file replace the defaults instead of merging into them
+	if len(p.BlacklistPenalties) != 1 || p.BlacklistPenalties["spamcop"] != 15 {
+		t.Errorf("blacklist penalties %v, want only spamcop", p.BlacklistPenalties)
+	}
+	if len(p.UCEProtectLevelPenalties) != len(defaults.UCEProtectLevelPenalties) {
+		t.Errorf("uceprotect penalties %v, want the defaults", p.UCEProtectLevelPenalties)
+	}
+	if len(defaults.BlacklistPenalties) == 1 {
+		t.Errorf("defaults modified: %v", defaults.BlacklistPenalties)
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWatchPolicyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var slot atomic.Pointer[ScoringPolicy]
+	version := func() string { return policyVersion(slot.Load()) }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	write(`{"version":"bad","weights":{"no_dmarc":-1}}`)
+	if err := watchPolicyFile(ctx, path, 5*time.Millisecond, "test", slot.Store, slot.Load); err == nil || slot.Load() != nil {
+		t.Fatalf("invalid initial policy: error %v, policy %s", err, version())
+	}
+
+	write(`{"version":"v1"}`)
+	if err := watchPolicyFile(ctx, path, 5*time.Millisecond, "test", slot.Store, slot.Load); err != nil || version() != "v1" {
+		t.Fatalf("initial load: error %v, policy %s", err, version())
+	}
+
+	// A broken file keeps the previous policy
+	write(`{"version":"v2","weights":{"no_dmarc":-1}}`)
+	time.Sleep(50 * time.Millisecond)
+	if version() != "v1" {
+		t.Fatalf("policy %s after a failed reload, want v1", version())
+	}
+
+	write(`{"version":"v3-fixed"}`)
+	waitFor(t, func() bool { return version() == "v3-fixed" })
+
+	os.Remove(path)
+	time.Sleep(50 * time.Millisecond)
+	if version() != "v3-fixed" {
+		t.Fatalf("policy %s after the file was removed, want v3-fixed", version())
+	}
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
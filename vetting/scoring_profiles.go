@@ -0,0 +1,129 @@
+package vetting
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultProfile is the profile used when VetRequest.profile is empty
+// (the base scoring policy itself)
+const DefaultProfile = "default"
+
+// Built-in scoring profiles per customer segment
+const (
+	ProfileEnterprise    = "enterprise"
+	ProfileTransactional = "transactional"
+	ProfileColdOutreach  = "cold_outreach"
+)
+
+// builtinProfiles adjust a copy of the base policy (DefaultScoringThresholds
+// and the blacklist configs unless the policy file changed them).
+// A profile of the same name in the policy file replaces the built-in one.
+var builtinProfiles = map[string]func(p *ScoringPolicy){
+	// Enterprise senders: high volume on an established brand - higher bar
+	// (capped so any valid base policy still gives a valid profile)
+	ProfileEnterprise: func(p *ScoringPolicy) {
+		p.Thresholds.MediumMax = min(p.Thresholds.MediumMax+10, 100)
+		p.Thresholds.HighRiskMax = min(p.Thresholds.HighRiskMax+10, p.Thresholds.MediumMax-1)
+		p.Weights.NoDMARC = min(p.Weights.NoDMARC+10, 100)
+	},
+
+	// Transactional senders: a sending-only subdomain needs no website of its
+	// own, so a missing website only rejects the organizational domain
+	ProfileTransactional: func(p *ScoringPolicy) {
+		if len(p.Rules) > 0 {
+			return // Custom rule set - the file decides
+		}
+		p.DisableRules = append(p.DisableRules, RuleWebsiteMissing)
+		p.ExtraRules = append(p.ExtraRules, RuleSpec{
+			ID:   "website_missing_apex",
+			Rule: `when not website.exists and not domain.is_subdomain then reject "Website does not exist or is not accessible"`,
+		})
+	},
+
+	// Cold outreach prospects: new domains and weak DMARC are the main risk,
+	// and listings that are only penalties elsewhere reject
+	ProfileColdOutreach: func(p *ScoringPolicy) {
+		p.Thresholds.NewDomainDays = 90
+		p.Weights.DomainTooNew = 30
+		p.Weights.DMARCPolicyNone = 20
+		p.CriticalBlacklists = append(p.CriticalBlacklists, "spamcop", "barracuda")
+	},
+}
+
+// resolveProfiles builds the built-in profiles and the policy file's profiles
+func (p *ScoringPolicy) resolveProfiles() error {
+	p.profiles = map[string]*ScoringPolicy{}
+
+	for name, adjust := range builtinProfiles {
+		if _, ok := p.ProfileSpecs[name]; ok {
+			continue
+		}
+		prof := p.clone()
+		adjust(prof)
+		if err := p.addProfile(name, prof); err != nil {
+			return err
+		}
+	}
+
+	for name, raw := range p.ProfileSpecs {
+		if name == DefaultProfile || name == "" {
+			return fmt.Errorf("profile %q: the default profile is the base policy", name)
+		}
+		prof := p.clone()
+		if err := decodePolicyOnto(prof, raw); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		if prof.ProfileSpecs != nil {
+			return fmt.Errorf("profile %s: profiles cannot be nested", name)
+		}
+		if prof.Version != p.Version {
+			return fmt.Errorf("profile %s: profiles share the policy version (%s)", name, p.Version)
+		}
+		if err := p.addProfile(name, prof); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ScoringPolicy) addProfile(name string, prof *ScoringPolicy) error {
+	prof.name = name
+	if err := prof.Validate(); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	if err := prof.compileRules(); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	p.profiles[name] = prof
+	return nil
+}
+
+// Profile returns the named profile of the policy ("" = default)
+func (p *ScoringPolicy) Profile(name string) (*ScoringPolicy, error) {
+	if name == "" || name == DefaultProfile {
+		return p, nil
+	}
+	if prof, ok := p.profiles[name]; ok {
+		return prof, nil
+	}
+	return nil, fmt.Errorf("unknown scoring profile %q (available: %v)", name, p.ProfileNames())
+}
+
+// ProfileName returns the name of this profile
+func (p *ScoringPolicy) ProfileName() string {
+	if p.name == "" {
+		return DefaultProfile
+	}
+	return p.name
+}
+
+// ProfileNames lists the available profiles (default first)
+func (p *ScoringPolicy) ProfileNames() []string {
+	names := make([]string, 0, len(p.profiles))
+	for name := range p.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
@@ -0,0 +1,101 @@
+package vetting
+
+import "testing"
+
+func TestEnterpriseProfileCapped(t *testing.T) {
+	defaults := DefaultScoringPolicy()
+	tests := []struct {
+		name                    string
+		policy                  string
+		wantHigh, wantMedium    int
+		wantNoDMARC             int
+		wantBaseHigh, wantBaseM int
+	}{
+		{
+			name:         "defaults",
+			policy:       `{"version":"t"}`,
+			wantHigh:     defaults.Thresholds.HighRiskMax + 10,
+			wantMedium:   defaults.Thresholds.MediumMax + 10,
+			wantNoDMARC:  defaults.Weights.NoDMARC + 10,
+			wantBaseHigh: defaults.Thresholds.HighRiskMax,
+			wantBaseM:    defaults.Thresholds.MediumMax,
+		},
+		{
+			name:         "high medium threshold and weight",
+			policy:       `{"version":"t","thresholds":{"high_risk_max":50,"medium_max":95},"weights":{"no_dmarc":95}}`,
+			wantHigh:     60,
+			wantMedium:   100,
+			wantNoDMARC:  100,
+			wantBaseHigh: 50,
+			wantBaseM:    95,
+		},
+		{
+			name:         "high risk squeezed below medium",
+			policy:       `{"version":"t","thresholds":{"high_risk_max":98,"medium_max":99},"weights":{"no_dmarc":100}}`,
+			wantHigh:     99,
+			wantMedium:   100,
+			wantNoDMARC:  100,
+			wantBaseHigh: 98,
+			wantBaseM:    99,
+		},
+		{
+			name:         "thresholds at the top",
+			policy:       `{"version":"t","thresholds":{"high_risk_max":99,"medium_max":100}}`,
+			wantHigh:     99,
+			wantMedium:   100,
+			wantNoDMARC:  defaults.Weights.NoDMARC + 10,
+			wantBaseHigh: 99,
+			wantBaseM:    100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseScoringPolicy([]byte(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Thresholds.HighRiskMax != tt.wantBaseHigh || p.Thresholds.MediumMax != tt.wantBaseM {
+				t.Errorf("base thresholds %d/%d changed", p.Thresholds.HighRiskMax, p.Thresholds.MediumMax)
+			}
+			prof, err := p.Profile(ProfileEnterprise)
+			if err != nil {
+				t.Fatal(err)
+			}
+			th := prof.Thresholds
+			if th.HighRiskMax != tt.wantHigh || th.MediumMax != tt.wantMedium || prof.Weights.NoDMARC != tt.wantNoDMARC {
+				t.Errorf("enterprise %d/%d no_dmarc %d, want %d/%d no_dmarc %d",
+					th.HighRiskMax, th.MediumMax, prof.Weights.NoDMARC, tt.wantHigh, tt.wantMedium, tt.wantNoDMARC)
+			}
+		})
+	}
+}
+
+func TestProfileSpecs(t *testing.T) {
+	p, err := ParseScoringPolicy([]byte(`{"version":"t","profiles":{"strict":{"thresholds":{"medium_max":90}},"enterprise":{"weights":{"no_dmarc":40}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict, err := p.Profile("strict")
+	if err != nil || strict.Thresholds.MediumMax != 90 || strict.ProfileName() != "strict" {
+		t.Errorf("strict profile = %+v, %v", strict, err)
+	}
+	// A file profile replaces the built-in one of the same name
+	ent, _ := p.Profile(ProfileEnterprise)
+	if ent.Weights.NoDMARC != 40 || ent.Thresholds.MediumMax != p.Thresholds.MediumMax {
+		t.Errorf("enterprise from the file = no_dmarc %d, medium %d", ent.Weights.NoDMARC, ent.Thresholds.MediumMax)
+	}
+	if _, err := p.Profile("missing"); err == nil {
+		t.Error("unknown profile accepted")
+	}
+
+	for _, bad := range []string{
+		`{"version":"t","profiles":{"default":{}}}`,
+		`{"version":"t","profiles":{"x":{"profiles":{}}}}`,
+		`{"version":"t","profiles":{"x":{"version":"other"}}}`,
+		`{"version":"t","profiles":{"x":{"thresholds":{"medium_max":101}}}}`,
+	} {
+		if _, err := ParseScoringPolicy([]byte(bad)); err == nil {
+			t.Errorf("ParseScoringPolicy(%s) accepted", bad)
+		}
+	}
+}
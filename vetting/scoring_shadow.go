@@ -0,0 +1,97 @@
+package vetting
+
+import (
+	"log"
+	"strings"
+)
+
+//
+// SHADOW SCORING
+//
+// With a candidate policy loaded (SCORING_CANDIDATE_POLICY_FILE), /vet scores
+// every domain under both policies from the same check results. Only the
+// active policy decides the response; the candidate's score and the delta
+// are logged and returned in VetResponse.shadow for comparison.
+//
+
+// ShadowScore is a domain's score under the candidate policy, compared to
+// the active one
+type ShadowScore struct {
+	PolicyVersion       string   `json:"policy_version"`
+	ActivePolicyVersion string   `json:"active_policy_version"`
+	Profile             string   `json:"profile"`
+	Score               int      `json:"score"`
+	Level               string   `json:"level"`
+	Reason              string   `json:"reason"`
+	ScoreDelta          int      `json:"score_delta"` // candidate - active
+	LevelChanged        bool     `json:"level_changed"`
+	RejectionChanged    bool     `json:"rejection_changed"`
+	RulesAdded          []string `json:"rules_added,omitempty"`   // Fired only under the candidate
+	RulesRemoved        []string `json:"rules_removed,omitempty"` // Fired only under the active policy
+	Error               string   `json:"error,omitempty"`         // Candidate could not score (e.g. unknown profile)
+}
+
+// ShadowScoreOf scores in under the candidate policy (same profile as the
+// active score) and compares it to active
+func ShadowScoreOf(candidate *ScoringPolicy, profile string, in ScoreInputs, active RiskSummary) *ShadowScore {
+	policy, err := candidate.Profile(profile)
+	if err != nil {
+		return &ShadowScore{
+			PolicyVersion:       candidate.Version,
+			ActivePolicyVersion: active.PolicyVersion,
+			Profile:             profile,
+			Error:               err.Error(),
+		}
+	}
+	return CompareScores(active, policy.Score(in))
+}
+
+// CompareScores returns the delta of the candidate score against the active one
+func CompareScores(active, candidate RiskSummary) *ShadowScore {
+	return &ShadowScore{
+		PolicyVersion:       candidate.PolicyVersion,
+		ActivePolicyVersion: active.PolicyVersion,
+		Profile:             candidate.Profile,
+		Score:               candidate.Score,
+		Level:               candidate.Level,
+		Reason:              candidate.Reason,
+		ScoreDelta:          candidate.Score - active.Score,
+		LevelChanged:        candidate.Level != active.Level,
+		RejectionChanged:    (candidate.Level == "rejected") != (active.Level == "rejected"),
+		RulesAdded:          firedRuleDiff(candidate.FiredRules, active.FiredRules),
+		RulesRemoved:        firedRuleDiff(active.FiredRules, candidate.FiredRules),
+	}
+}
+
+// firedRuleDiff returns the ids of rules fired in a but not in b
+func firedRuleDiff(a, b []FiredRule) []string {
+	inB := map[string]bool{}
+	for _, r := range b {
+		inB[r.ID] = true
+	}
+	var ids []string
+	for _, r := range a {
+		if !inB[r.ID] {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// logShadowScore logs one domain's shadow comparison
+func logShadowScore(domain string, active RiskSummary, shadow *ShadowScore) {
+	if shadow.Error != "" {
+		log.Printf("[Shadow] %s: candidate %s could not score: %s", domain, shadow.PolicyVersion, shadow.Error)
+		return
+	}
+	marker := "="
+	if shadow.ScoreDelta != 0 || shadow.LevelChanged {
+		marker = "Δ"
+	}
+	log.Printf("[Shadow] %s %s: %s %d/%s → %s %d/%s (delta %+d, +[%s] -[%s])",
+		marker, domain,
+		active.PolicyVersion, active.Score, active.Level,
+		shadow.PolicyVersion, shadow.Score, shadow.Level,
+		shadow.ScoreDelta,
+		strings.Join(shadow.RulesAdded, ","), strings.Join(shadow.RulesRemoved, ","))
+}
@@ -0,0 +1,786 @@
+package vetting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// SPF (RFC 7208)
+//
+// AnalyzeSPF fetches a domain's SPF record, resolves every include: and
+// redirect= recursively and probes the a, mx and exists targets, counting
+// DNS lookups against the limit of 10 and void lookups against the limit
+// of 2. The parsed tree and all violations (multiple records, syntax,
+// limits, includes without SPF, permissive all qualifiers) are returned in
+// EmailSecurity.SPF.
+//
+
+// RFC 7208 processing limits (§4.6.4)
+const (
+	spfMaxDNSLookups  = 10 // include, a, mx, ptr, exists, redirect
+	spfMaxVoidLookups = 2  // Lookups answered with NXDOMAIN or no records
+	spfMaxMXPTRNames  = 10 // Names looked up per mx/ptr mechanism
+	// spfResolveCap stops resolving runaway records; the lookups are still counted
+	spfResolveCap = 3 * spfMaxDNSLookups
+)
+
+// SPF violation codes
+const (
+	SPFMultipleRecords   = "multiple_records"     // More than one v=spf1 record (permerror)
+	SPFSyntax            = "syntax"               // Unparseable term (permerror)
+	SPFLookupLimit       = "lookup_limit"         // More than 10 DNS lookups (permerror)
+	SPFVoidLookupLimit   = "void_lookup_limit"    // More than 2 void lookups (permerror)
+	SPFIncludeNoRecord   = "include_without_spf"  // include: target has no SPF record (permerror)
+	SPFRedirectNoRecord  = "redirect_without_spf" // redirect= target has no SPF record (permerror)
+	SPFLoop              = "include_loop"         // include/redirect back to a record being evaluated (permerror)
+	SPFMXLimit           = "mx_limit"             // mx mechanism with more than 10 MX hosts (permerror)
+	SPFLookupFailed      = "lookup_failed"        // DNS failure (temperror; result unknown)
+	SPFPassAll           = "pass_all"             // +all authorizes every sender
+	SPFNeutralAll        = "neutral_all"          // ?all asserts nothing
+	SPFSoftfailAll       = "softfail_all"         // ~all only marks unauthorized mail as suspicious
+	SPFNoAll             = "no_all"               // No all and no redirect (neutral default)
+	SPFPtrMechanism      = "ptr_mechanism"        // ptr is deprecated (slow, unreliable)
+	SPFUnresolvableMacro = "macro_needs_ip"       // Macro needs the sending IP; target not probed
+)
+
+// SPF violation severities
+const (
+	SPFSeverityError   = "error"   // The record does not work (permerror)
+	SPFSeverityWarning = "warning" // Works, but weakly or unreliably
+	SPFSeverityInfo    = "info"
+)
+
+// SPFTerm is one mechanism or modifier of an SPF record
+type SPFTerm struct {
+	Qualifier string     `json:"qualifier,omitempty"` // + - ~ ? (mechanisms only)
+	Mechanism string     `json:"mechanism,omitempty"` // all, include, a, mx, ptr, ip4, ip6, exists
+	Modifier  string     `json:"modifier,omitempty"`  // redirect, exp or an unknown modifier
+	Value     string     `json:"value,omitempty"`     // Domain-spec, IP network or modifier value
+	Prefix4   int        `json:"prefix4,omitempty"`   // a/mx IPv4 CIDR length (32 = exact)
+	Prefix6   int        `json:"prefix6,omitempty"`   // a/mx IPv6 CIDR length (128 = exact)
+	Include   *SPFRecord `json:"include,omitempty"`   // Resolved include: target
+}
+
+// String returns the term as written in a record (normalized)
+func (t SPFTerm) String() string {
+	if t.Modifier != "" {
+		return t.Modifier + "=" + t.Value
+	}
+	s := t.Mechanism
+	if t.Qualifier != "+" {
+		s = t.Qualifier + s
+	}
+	if t.Value != "" {
+		s += ":" + t.Value
+	}
+	if t.Mechanism == "a" || t.Mechanism == "mx" {
+		if t.Prefix4 != 32 {
+			s += "/" + strconv.Itoa(t.Prefix4)
+		}
+		if t.Prefix6 != 128 {
+			s += "//" + strconv.Itoa(t.Prefix6)
+		}
+	}
+	return s
+}
+
+// SPFRecord is a parsed SPF record with its includes and redirect resolved
+type SPFRecord struct {
+	Domain   string     `json:"domain"`
+	Raw      string     `json:"record,omitempty"`
+	Terms    []SPFTerm  `json:"terms,omitempty"`
+	Redirect *SPFRecord `json:"redirect,omitempty"` // Resolved redirect= target
+	Error    string     `json:"error,omitempty"`    // Why this record is missing or unusable
+}
+
+// modifier returns the value of a modifier ("" if absent)
+func (r *SPFRecord) modifier(name string) string {
+	for _, t := range r.Terms {
+		if t.Modifier == name {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// all returns the record's all mechanism (nil if none)
+func (r *SPFRecord) all() *SPFTerm {
+	for i := range r.Terms {
+		if r.Terms[i].Mechanism == "all" {
+			return &r.Terms[i]
+		}
+	}
+	return nil
+}
+
+// SPFViolation is one problem found in an SPF record or its includes
+type SPFViolation struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Domain   string `json:"domain"` // Record the problem is in
+	Message  string `json:"message"`
+}
+
+// SPFAnalysis is the result of AnalyzeSPF
+type SPFAnalysis struct {
+	Domain      string         `json:"domain"`
+	RecordCount int            `json:"record_count"` // v=spf1 records published (must be 1)
+	Record      *SPFRecord     `json:"tree,omitempty"`
+	DNSLookups  int            `json:"dns_lookups"`  // Counted against the limit of 10
+	VoidLookups int            `json:"void_lookups"` // Counted against the limit of 2
+	All         string         `json:"all"`          // Effective all qualifier: +all, -all, ~all, ?all ("" = none)
+	Valid       bool           `json:"valid"`        // No error-severity violation
+	Violations  []SPFViolation `json:"violations,omitempty"`
+}
+
+// HasViolation reports whether the analysis found a violation with code
+func (a *SPFAnalysis) HasViolation(code string) bool {
+	for _, v := range a.Violations {
+		if v.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// PARSER
+//
+
+// isSPFRecord reports whether a TXT record is an SPF record ("v=spf1" then
+// a space or the end)
+func isSPFRecord(txt string) bool {
+	if len(txt) < 6 || !strings.EqualFold(txt[:6], "v=spf1") {
+		return false
+	}
+	return len(txt) == 6 || txt[6] == ' '
+}
+
+// ParseSPF parses an SPF record. On a syntax error the terms parsed so far
+// are returned along with the error (the record is a permerror).
+func ParseSPF(domain, raw string) (*SPFRecord, error) {
+	rec := &SPFRecord{Domain: domain, Raw: raw}
+	if !isSPFRecord(raw) {
+		return rec, errors.New(`record does not start with "v=spf1"`)
+	}
+
+	seen := map[string]bool{}
+	for _, field := range strings.Fields(raw[6:]) {
+		term, err := parseSPFTerm(field)
+		if err != nil {
+			return rec, fmt.Errorf("%q: %w", field, err)
+		}
+		if term.Modifier == "redirect" || term.Modifier == "exp" {
+			if seen[term.Modifier] {
+				return rec, fmt.Errorf("%s= appears more than once", term.Modifier)
+			}
+			seen[term.Modifier] = true
+		}
+		rec.Terms = append(rec.Terms, term)
+	}
+	return rec, nil
+}
+
+// parseSPFTerm parses one directive or modifier
+func parseSPFTerm(field string) (SPFTerm, error) {
+	// Modifier: name "=" macro-string (a name never contains ':' or '/')
+	if name, value, ok := strings.Cut(field, "="); ok && isSPFModifierName(name) {
+		name = strings.ToLower(name)
+		if (name == "redirect" || name == "exp") && value == "" {
+			return SPFTerm{}, errors.New("missing domain")
+		}
+		if err := validateSPFMacroString(value); err != nil {
+			return SPFTerm{}, err
+		}
+		return SPFTerm{Modifier: name, Value: value}, nil
+	}
+
+	term := SPFTerm{Qualifier: "+"}
+	if strings.ContainsRune("+-~?", rune(field[0])) {
+		term.Qualifier = field[:1]
+		field = field[1:]
+	}
+
+	nameEnd := strings.IndexAny(field, ":/")
+	if nameEnd < 0 {
+		nameEnd = len(field)
+	}
+	term.Mechanism = strings.ToLower(field[:nameEnd])
+	rest := field[nameEnd:]
+
+	switch term.Mechanism {
+	case "all":
+		if rest != "" {
+			return term, errors.New("all takes no arguments")
+		}
+
+	case "include", "exists":
+		if !strings.HasPrefix(rest, ":") || len(rest) == 1 {
+			return term, fmt.Errorf("%s requires a domain", term.Mechanism)
+		}
+		term.Value = rest[1:]
+		if err := validateSPFMacroString(term.Value); err != nil {
+			return term, err
+		}
+
+	case "a", "mx":
+		term.Prefix4, term.Prefix6 = 32, 128
+		spec := rest
+		if strings.HasPrefix(spec, ":") {
+			spec = spec[1:]
+			if i := strings.Index(spec, "/"); i >= 0 {
+				term.Value, spec = spec[:i], spec[i:]
+			} else {
+				term.Value, spec = spec, ""
+			}
+			if term.Value == "" {
+				return term, errors.New("empty domain")
+			}
+			if err := validateSPFMacroString(term.Value); err != nil {
+				return term, err
+			}
+		}
+		if err := parseDualCIDR(spec, &term); err != nil {
+			return term, err
+		}
+
+	case "ptr":
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") || len(rest) == 1 {
+				return term, errors.New("ptr takes only a domain")
+			}
+			term.Value = rest[1:]
+			if err := validateSPFMacroString(term.Value); err != nil {
+				return term, err
+			}
+		}
+
+	case "ip4", "ip6":
+		if !strings.HasPrefix(rest, ":") {
+			return term, fmt.Errorf("%s requires an address", term.Mechanism)
+		}
+		network, err := parseSPFNetwork(term.Mechanism, rest[1:])
+		if err != nil {
+			return term, err
+		}
+		term.Value = network.String()
+
+	default:
+		return term, fmt.Errorf("unknown mechanism %q", term.Mechanism)
+	}
+	return term, nil
+}
+
+// isSPFModifierName checks name = ALPHA *( ALPHA / DIGIT / "-" / "_" / "." )
+func isSPFModifierName(name string) bool {
+	if name == "" || !isASCIILetter(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		c := name[i]
+		if !isASCIILetter(c) && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parseDualCIDR parses the "/n", "//m" or "/n//m" suffix of a or mx
+func parseDualCIDR(spec string, term *SPFTerm) error {
+	if spec == "" {
+		return nil
+	}
+	v4, v6, hasV6 := strings.Cut(spec, "//")
+	if strings.HasPrefix(spec, "//") {
+		v4, v6, hasV6 = "", spec[2:], true
+	}
+	if v4 != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(v4, "/"))
+		if err != nil || !strings.HasPrefix(v4, "/") || n < 0 || n > 32 {
+			return fmt.Errorf("invalid IPv4 CIDR length %q", v4)
+		}
+		term.Prefix4 = n
+	}
+	if hasV6 {
+		n, err := strconv.Atoi(v6)
+		if err != nil || n < 0 || n > 128 {
+			return fmt.Errorf("invalid IPv6 CIDR length %q", v6)
+		}
+		term.Prefix6 = n
+	}
+	return nil
+}
+
+// parseSPFNetwork parses the address of ip4:/ip6: (optionally with /len)
+func parseSPFNetwork(mechanism, value string) (*net.IPNet, error) {
+	addr, prefix, hasPrefix := strings.Cut(value, "/")
+	ip := net.ParseIP(addr)
+	bits := 32
+	if mechanism == "ip6" {
+		bits = 128
+	}
+	if ip == nil || (mechanism == "ip4") != (ip.To4() != nil && !strings.Contains(addr, ":")) {
+		return nil, fmt.Errorf("invalid %s address %q", mechanism, addr)
+	}
+	ones := bits
+	if hasPrefix {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n < 0 || n > bits {
+			return nil, fmt.Errorf("invalid CIDR length %q", prefix)
+		}
+		ones = n
+	}
+	if bits == 32 {
+		ip = ip.To4()
+	}
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}, nil
+}
+
+//
+// MACROS (RFC 7208 §7)
+//
+
+// errSPFMacroNeedsIP - the macro refers to the connecting IP, which static
+// analysis does not have
+var errSPFMacroNeedsIP = errors.New("macro needs the sending IP")
+
+// spfMacroEnv is what macros expand to
+type spfMacroEnv struct {
+	Sender string // MAIL FROM (postmaster@<domain> if empty)
+	Domain string // Current domain (changes with include/redirect)
+	HELO   string
+	IP     net.IP // nil in static analysis
+}
+
+// validateSPFMacroString checks the macro syntax without expanding
+func validateSPFMacroString(s string) error {
+	_, err := expandSPFMacros(s, spfMacroEnv{Domain: "example.com", IP: net.IPv4(192, 0, 2, 1)}, true)
+	return err
+}
+
+// expandSPFMacros expands a macro-string (exp allows the c, r and t letters)
+func expandSPFMacros(s string, env spfMacroEnv, exp bool) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", errors.New("macro: trailing %")
+		}
+		i++
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", errors.New("macro: missing }")
+			}
+			value, err := expandSPFMacro(s[i+1:i+end], env, exp)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", fmt.Errorf("macro: invalid escape %%%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// expandSPFMacro expands the inside of one %{...}: letter, digits, r, delimiters
+func expandSPFMacro(m string, env spfMacroEnv, exp bool) (string, error) {
+	if m == "" {
+		return "", errors.New("macro: empty %{}")
+	}
+	letter := m[0]
+	upper := letter >= 'A' && letter <= 'Z'
+	if upper {
+		letter += 'a' - 'A'
+	}
+
+	sender := env.Sender
+	if sender == "" {
+		sender = "postmaster@" + env.Domain
+	}
+	local, senderDomain, ok := strings.Cut(sender, "@")
+	if !ok {
+		local, senderDomain = "postmaster", sender
+	}
+
+	var value string
+	switch letter {
+	case 's':
+		value = sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = env.Domain
+	case 'h':
+		value = env.HELO
+	case 'i', 'c', 'v', 'p':
+		if env.IP == nil {
+			return "", errSPFMacroNeedsIP
+		}
+		switch letter {
+		case 'i':
+			value = spfDottedIP(env.IP)
+		case 'c':
+			value = env.IP.String()
+		case 'v':
+			value = "in-addr"
+			if env.IP.To4() == nil {
+				value = "ip6"
+			}
+		case 'p':
+			value = "unknown" // Validated PTR names are not looked up (RFC 7208 §5.5 discourages it)
+		}
+		if letter == 'c' && !exp {
+			return "", errors.New("macro: %{c} is only allowed in exp")
+		}
+	case 'r', 't':
+		if !exp {
+			return "", fmt.Errorf("macro: %%{%c} is only allowed in exp", letter)
+		}
+		value = "unknown"
+		if letter == 't' {
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return "", fmt.Errorf("macro: unknown letter %q", m[0])
+	}
+
+	// Transformers: keep the rightmost N parts, r reverses; then delimiters
+	rest := m[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		n, err := strconv.Atoi(rest[:digits])
+		if err != nil || n == 0 {
+			return "", fmt.Errorf("macro: invalid part count %q", rest[:digits])
+		}
+		keep = n
+	}
+	rest = rest[digits:]
+	reverse := false
+	if strings.HasPrefix(rest, "r") || strings.HasPrefix(rest, "R") {
+		reverse = true
+		rest = rest[1:]
+	}
+	delims := "."
+	if rest != "" {
+		for _, c := range rest {
+			if !strings.ContainsRune(".-+,/_=", c) {
+				return "", fmt.Errorf("macro: invalid delimiter %q", c)
+			}
+		}
+		delims = rest
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delims, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	value = strings.Join(parts, ".")
+	if upper {
+		value = url.QueryEscape(value)
+	}
+	return value, nil
+}
+
+// spfDottedIP formats an IP for %{i}: dotted quad, or dot-separated nibbles for IPv6
+func spfDottedIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	const hexDigits = "0123456789abcdef"
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, string(hexDigits[b>>4]), string(hexDigits[b&0x0f]))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// spfTargetDomain expands a domain-spec (the current domain if empty) and
+// shortens it to 253 characters by dropping labels on the left (§7.3)
+func spfTargetDomain(spec string, env spfMacroEnv) (string, error) {
+	if spec == "" {
+		return env.Domain, nil
+	}
+	domain, err := expandSPFMacros(spec, env, false)
+	if err != nil {
+		return "", err
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	for len(domain) > 253 {
+		_, rest, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = rest
+	}
+	return domain, nil
+}
+
+//
+// ANALYSIS
+//
+
+// AnalyzeSPF parses and resolves the SPF record of domain
+func AnalyzeSPF(ctx context.Context, domain string) SPFAnalysis {
+	return analyzeSPF(ctx, defaultDNS(), domain)
+}
+
+// spfAnalyzer walks an SPF record tree, counting lookups
+type spfAnalyzer struct {
+	ctx        context.Context
+	dns        DNSClient
+	lookups    int
+	voids      int
+	violations []SPFViolation
+}
+
+func analyzeSPF(ctx context.Context, dns DNSClient, domain string) SPFAnalysis {
+	a := &spfAnalyzer{ctx: ctx, dns: dns}
+	analysis := SPFAnalysis{Domain: domain}
+
+	rec, count := a.resolve(domain, nil)
+	analysis.Record = rec
+	analysis.RecordCount = count
+	analysis.DNSLookups = a.lookups
+	analysis.VoidLookups = a.voids
+
+	if a.lookups > spfMaxDNSLookups {
+		a.violate(SPFLookupLimit, SPFSeverityError, domain, fmt.Sprintf("%d DNS lookups (limit %d); receivers return permerror", a.lookups, spfMaxDNSLookups))
+	}
+	if a.voids > spfMaxVoidLookups {
+		a.violate(SPFVoidLookupLimit, SPFSeverityError, domain, fmt.Sprintf("%d void lookups (limit %d); receivers may return permerror", a.voids, spfMaxVoidLookups))
+	}
+
+	// Effective all: the record's own, else the redirect target's
+	for r := rec; r != nil && r.Error == ""; r = r.Redirect {
+		if all := r.all(); all != nil {
+			analysis.All = all.Qualifier + "all"
+			break
+		}
+	}
+	if count > 0 && rec.Error == "" {
+		switch analysis.All {
+		case "+all":
+			a.violate(SPFPassAll, SPFSeverityError, domain, "+all authorizes every server on the internet to send as this domain")
+		case "?all":
+			a.violate(SPFNeutralAll, SPFSeverityWarning, domain, "?all (neutral) gives receivers no policy for unauthorized senders")
+		case "~all":
+			a.violate(SPFSoftfailAll, SPFSeverityInfo, domain, "~all (softfail) only marks unauthorized mail as suspicious")
+		case "":
+			a.violate(SPFNoAll, SPFSeverityWarning, domain, "no all mechanism or redirect; unmatched senders get neutral")
+		}
+	}
+
+	analysis.Violations = a.violations
+	analysis.Valid = count > 0
+	for _, v := range a.violations {
+		if v.Severity == SPFSeverityError {
+			analysis.Valid = false
+		}
+	}
+
+	log.Printf("[SPF] %s: %d records, %d lookups, %d void, all=%q, %d violations",
+		domain, count, a.lookups, a.voids, analysis.All, len(a.violations))
+	return analysis
+}
+
+func (a *spfAnalyzer) violate(code, severity, domain, message string) {
+	a.violations = append(a.violations, SPFViolation{Code: code, Severity: severity, Domain: domain, Message: message})
+}
+
+// fetch returns the SPF records published at domain (void says the lookup
+// had no answer at all)
+func (a *spfAnalyzer) fetch(domain string) (records []string, void bool, err error) {
+	txts, err := a.dns.LookupTXT(a.ctx, domain)
+	if err != nil {
+		if isDNSNotFound(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	for _, txt := range txts {
+		if isSPFRecord(txt) {
+			records = append(records, txt)
+		}
+	}
+	return records, len(txts) == 0, nil
+}
+
+// resolve fetches, parses and resolves the record of domain; path holds the
+// records being evaluated (loop detection). Returns the record and how many
+// SPF records domain publishes.
+func (a *spfAnalyzer) resolve(domain string, path []string) (*SPFRecord, int) {
+	rec := &SPFRecord{Domain: domain}
+
+	records, void, err := a.fetch(domain)
+	if void {
+		a.voids++
+	}
+	if err != nil {
+		rec.Error = "DNS lookup failed: " + err.Error()
+		a.violate(SPFLookupFailed, SPFSeverityWarning, domain, rec.Error)
+		return rec, 0
+	}
+	switch len(records) {
+	case 0:
+		rec.Error = "no SPF record"
+		return rec, 0
+	case 1:
+	default:
+		a.violate(SPFMultipleRecords, SPFSeverityError, domain, fmt.Sprintf("%d SPF records published (must be exactly one); receivers return permerror", len(records)))
+	}
+
+	parsed, err := ParseSPF(domain, records[0])
+	rec.Raw, rec.Terms = parsed.Raw, parsed.Terms
+	if err != nil {
+		rec.Error = "syntax error: " + err.Error()
+		a.violate(SPFSyntax, SPFSeverityError, domain, rec.Error)
+		return rec, len(records)
+	}
+
+	path = append(path, strings.ToLower(domain))
+	env := spfMacroEnv{Domain: domain}
+	for i := range rec.Terms {
+		a.resolveTerm(&rec.Terms[i], env, path)
+	}
+
+	// redirect= is only used when the record has no all mechanism
+	if redirect := rec.modifier("redirect"); redirect != "" && rec.all() == nil {
+		a.lookups++
+		target, ok := a.target(redirect, env)
+		if ok && a.checkLoop(domain, target, path) && a.lookups <= spfResolveCap {
+			child, count := a.resolve(target, path)
+			if count == 0 && child.Error == "no SPF record" {
+				a.violate(SPFRedirectNoRecord, SPFSeverityError, domain, fmt.Sprintf("redirect=%s has no SPF record (permerror)", target))
+			}
+			rec.Redirect = child
+		}
+	}
+	return rec, len(records)
+}
+
+// resolveTerm counts the lookups of one mechanism and resolves includes
+func (a *spfAnalyzer) resolveTerm(t *SPFTerm, env spfMacroEnv, path []string) {
+	domain := env.Domain
+	switch t.Mechanism {
+	case "include":
+		a.lookups++
+		target, ok := a.target(t.Value, env)
+		if !ok || !a.checkLoop(domain, target, path) || a.lookups > spfResolveCap {
+			return
+		}
+		child, count := a.resolve(target, path)
+		if count == 0 && child.Error == "no SPF record" {
+			a.violate(SPFIncludeNoRecord, SPFSeverityError, domain, fmt.Sprintf("include:%s has no SPF record (permerror)", target))
+		}
+		t.Include = child
+
+	case "a":
+		a.lookups++
+		target, ok := a.target(t.Value, env)
+		if !ok || a.lookups > spfResolveCap {
+			return
+		}
+		ips, err := a.dns.LookupIP(a.ctx, "ip", target)
+		a.countVoid(domain, "a:"+target, len(ips), err)
+
+	case "mx":
+		a.lookups++
+		target, ok := a.target(t.Value, env)
+		if !ok || a.lookups > spfResolveCap {
+			return
+		}
+		mxs, err := a.dns.LookupMX(a.ctx, target)
+		a.countVoid(domain, "mx:"+target, len(mxs), err)
+		if len(mxs) > spfMaxMXPTRNames {
+			a.violate(SPFMXLimit, SPFSeverityError, domain, fmt.Sprintf("mx:%s has %d MX hosts (limit %d); receivers return permerror", target, len(mxs), spfMaxMXPTRNames))
+		}
+
+	case "ptr":
+		a.lookups++
+		a.violate(SPFPtrMechanism, SPFSeverityWarning, domain, "ptr is deprecated (RFC 7208 §5.5): slow and unreliable, some receivers ignore it")
+
+	case "exists":
+		a.lookups++
+		target, ok := a.target(t.Value, env)
+		if !ok || a.lookups > spfResolveCap {
+			return
+		}
+		ips, err := a.dns.LookupIP(a.ctx, "ip4", target)
+		a.countVoid(domain, "exists:"+target, len(ips), err)
+	}
+}
+
+// target expands a domain-spec; false if it can't be resolved statically
+func (a *spfAnalyzer) target(spec string, env spfMacroEnv) (string, bool) {
+	target, err := spfTargetDomain(spec, env)
+	if errors.Is(err, errSPFMacroNeedsIP) {
+		a.violate(SPFUnresolvableMacro, SPFSeverityInfo, env.Domain, fmt.Sprintf("%s depends on the sending IP; not probed", spec))
+		return "", false
+	}
+	if err != nil {
+		a.violate(SPFSyntax, SPFSeverityError, env.Domain, fmt.Sprintf("%s: %v", spec, err))
+		return "", false
+	}
+	return target, true
+}
+
+// checkLoop reports a loop if target is already being evaluated
+func (a *spfAnalyzer) checkLoop(domain, target string, path []string) bool {
+	for _, p := range path {
+		if p == strings.ToLower(target) {
+			a.violate(SPFLoop, SPFSeverityError, domain, fmt.Sprintf("%s includes %s, which is already being evaluated", domain, target))
+			return false
+		}
+	}
+	return true
+}
+
+// countVoid records void lookups and DNS failures of a/mx/exists targets
+func (a *spfAnalyzer) countVoid(domain, what string, answers int, err error) {
+	switch {
+	case err != nil && !isDNSNotFound(err):
+		a.violate(SPFLookupFailed, SPFSeverityWarning, domain, fmt.Sprintf("%s: DNS lookup failed: %v", what, err))
+	case answers == 0:
+		a.voids++
+	}
+}
@@ -0,0 +1,518 @@
+package vetting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+//
+// SPF AUTHORIZATION (RFC 7208 check_host)
+//
+// The domain's SPF record is evaluated for every IP we will send from, the
+// way a receiving MTA would. The IPs come from VetRequest.sending_ips, or
+// are sampled from the networks of our ESP include (VetRequest.spf_include,
+// e.g. "_spf.our-esp.com"). VETTING_SENDING_IPS and VETTING_SPF_INCLUDE set
+// the defaults for requests that give neither. Any IP that does not get
+// "pass" is a warmup blocker: receivers will fail or distrust our mail.
+//
+
+// SPFResult is a check_host() result
+type SPFResult string
+
+const (
+	SPFNone      SPFResult = "none"      // No SPF record
+	SPFNeutral   SPFResult = "neutral"   // ?all or no match
+	SPFPass      SPFResult = "pass"      // IP authorized
+	SPFFail      SPFResult = "fail"      // IP explicitly not authorized (-all)
+	SPFSoftfail  SPFResult = "softfail"  // IP probably not authorized (~all)
+	SPFTempError SPFResult = "temperror" // DNS failure; retry later
+	SPFPermError SPFResult = "permerror" // Record broken (syntax, limits)
+)
+
+// spfMaxSampleIPs caps the IPs sampled from the ESP include's networks
+const spfMaxSampleIPs = 10
+
+// SPFHostResult is the check_host() result for one sending IP
+type SPFHostResult struct {
+	IP        string    `json:"ip"`
+	Result    SPFResult `json:"result"`
+	Mechanism string    `json:"mechanism,omitempty"` // Top-level term that matched
+	Error     string    `json:"error,omitempty"`     // Why temperror/permerror
+}
+
+// SPFAuthorization - whether the domain's SPF authorizes our sending IPs
+type SPFAuthorization struct {
+	CheckOutcome
+	Include        string          `json:"include,omitempty"` // ESP include checked for
+	IncludePresent bool            `json:"include_present"`   // The domain's SPF tree contains the include
+	Results        []SPFHostResult `json:"results"`
+	Authorized     bool            `json:"authorized"` // Every IP got pass
+}
+
+// Unauthorized lists the IPs that did not get pass ("ip (result)")
+func (a SPFAuthorization) Unauthorized() []string {
+	var out []string
+	for _, r := range a.Results {
+		if r.Result != SPFPass {
+			out = append(out, fmt.Sprintf("%s (%s)", r.IP, r.Result))
+		}
+	}
+	return out
+}
+
+// CheckHost evaluates domain's SPF policy for a message from ip (sender is
+// the MAIL FROM address; "" uses postmaster@domain)
+func CheckHost(ctx context.Context, ip net.IP, domain, sender string) SPFHostResult {
+	return checkHost(ctx, defaultDNS(), ip, domain, sender)
+}
+
+func checkHost(ctx context.Context, dns DNSClient, ip net.IP, domain, sender string) SPFHostResult {
+	e := &spfEvaluator{ctx: ctx, dns: dns, ip: ip, sender: sender}
+	res := SPFHostResult{IP: ip.String()}
+	res.Result, res.Mechanism, res.Error = e.checkHost(domain)
+	return res
+}
+
+// spfEvalError ends evaluation with temperror or permerror
+type spfEvalError struct {
+	result SPFResult
+	msg    string
+}
+
+func (e *spfEvalError) Error() string { return e.msg }
+
+func spfTempError(format string, args ...interface{}) error {
+	return &spfEvalError{result: SPFTempError, msg: fmt.Sprintf(format, args...)}
+}
+
+func spfPermError(format string, args ...interface{}) error {
+	return &spfEvalError{result: SPFPermError, msg: fmt.Sprintf(format, args...)}
+}
+
+// spfEvaluator runs check_host() for one IP; the lookup limits span the
+// whole evaluation (includes and redirects included)
+type spfEvaluator struct {
+	ctx     context.Context
+	dns     DNSClient
+	ip      net.IP
+	sender  string
+	lookups int
+	voids   int
+}
+
+// checkHost returns the result, the matched term and the error message
+func (e *spfEvaluator) checkHost(domain string) (SPFResult, string, string) {
+	result, matched, err := e.evaluate(domain)
+	if err != nil {
+		return result, matched, err.Error()
+	}
+	return result, matched, ""
+}
+
+// spfErrorResult is temperror or permerror, as the error says
+func spfErrorResult(err error) SPFResult {
+	var evalErr *spfEvalError
+	if errors.As(err, &evalErr) {
+		return evalErr.result
+	}
+	return SPFPermError
+}
+
+func (e *spfEvaluator) evaluate(domain string) (SPFResult, string, error) {
+	txts, err := e.dns.LookupTXT(e.ctx, domain)
+	if err != nil && !isDNSNotFound(err) {
+		return SPFTempError, "", spfTempError("%s: DNS lookup failed: %v", domain, err)
+	}
+	var records []string
+	for _, txt := range txts {
+		if isSPFRecord(txt) {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return SPFNone, "", nil
+	case 1:
+	default:
+		return SPFPermError, "", spfPermError("%s: %d SPF records", domain, len(records))
+	}
+
+	rec, err := ParseSPF(domain, records[0])
+	if err != nil {
+		return SPFPermError, "", spfPermError("%s: %v", domain, err)
+	}
+
+	env := spfMacroEnv{Sender: e.sender, Domain: domain, IP: e.ip}
+	for _, term := range rec.Terms {
+		if term.Mechanism == "" {
+			continue
+		}
+		match, err := e.match(term, env)
+		if err != nil {
+			return spfErrorResult(err), term.String(), err
+		}
+		if match {
+			return spfQualifierResult(term.Qualifier), term.String(), nil
+		}
+	}
+
+	// No mechanism matched: follow redirect=, else neutral
+	if redirect := rec.modifier("redirect"); redirect != "" {
+		if err := e.countLookup(); err != nil {
+			return SPFPermError, "", err
+		}
+		target, err := spfTargetDomain(redirect, env)
+		if err != nil {
+			return SPFPermError, "", spfPermError("redirect=%s: %v", redirect, err)
+		}
+		result, matched, err := e.evaluate(target)
+		if result == SPFNone {
+			return SPFPermError, "", spfPermError("redirect=%s has no SPF record", target)
+		}
+		return result, "redirect=" + target + spfMatchedSuffix(matched), err
+	}
+	return SPFNeutral, "", nil
+}
+
+// match reports whether a mechanism matches the IP
+func (e *spfEvaluator) match(t SPFTerm, env spfMacroEnv) (bool, error) {
+	switch t.Mechanism {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		_, network, err := net.ParseCIDR(t.Value)
+		if err != nil {
+			return false, spfPermError("%s: %v", t, err)
+		}
+		if (t.Mechanism == "ip4") != (e.ip.To4() != nil) {
+			return false, nil
+		}
+		return network.Contains(e.ip), nil
+
+	case "include":
+		target, err := e.lookupTarget(t, env)
+		if err != nil {
+			return false, err
+		}
+		result, _, err := e.evaluate(target)
+		switch result {
+		case SPFPass:
+			return true, nil
+		case SPFFail, SPFSoftfail, SPFNeutral:
+			return false, nil
+		case SPFNone:
+			return false, spfPermError("include:%s has no SPF record", target)
+		default:
+			return false, err
+		}
+
+	case "a":
+		target, err := e.lookupTarget(t, env)
+		if err != nil {
+			return false, err
+		}
+		ips, err := e.lookupIPs(target, true)
+		if err != nil {
+			return false, err
+		}
+		return e.matchAny(ips, t), nil
+
+	case "mx":
+		target, err := e.lookupTarget(t, env)
+		if err != nil {
+			return false, err
+		}
+		mxs, err := e.dns.LookupMX(e.ctx, target)
+		if err != nil && !isDNSNotFound(err) {
+			return false, spfTempError("mx:%s: DNS lookup failed: %v", target, err)
+		}
+		if len(mxs) == 0 {
+			return false, e.countVoid()
+		}
+		if len(mxs) > spfMaxMXPTRNames {
+			return false, spfPermError("mx:%s has %d MX hosts (limit %d)", target, len(mxs), spfMaxMXPTRNames)
+		}
+		for _, mx := range mxs {
+			ips, err := e.lookupIPs(strings.TrimSuffix(mx.Host, "."), false)
+			if err != nil {
+				return false, err
+			}
+			if e.matchAny(ips, t) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "ptr":
+		target, err := e.lookupTarget(t, env)
+		if err != nil {
+			return false, err
+		}
+		return e.matchPTR(target), nil
+
+	case "exists":
+		target, err := e.lookupTarget(t, env)
+		if err != nil {
+			return false, err
+		}
+		ips, err := e.dns.LookupIP(e.ctx, "ip4", target)
+		if err != nil && !isDNSNotFound(err) {
+			return false, spfTempError("exists:%s: DNS lookup failed: %v", target, err)
+		}
+		if len(ips) == 0 {
+			return false, e.countVoid()
+		}
+		return true, nil
+	}
+	return false, spfPermError("unknown mechanism %q", t.Mechanism)
+}
+
+// lookupTarget counts the term's DNS lookup and expands its domain-spec
+func (e *spfEvaluator) lookupTarget(t SPFTerm, env spfMacroEnv) (string, error) {
+	if err := e.countLookup(); err != nil {
+		return "", err
+	}
+	target, err := spfTargetDomain(t.Value, env)
+	if err != nil {
+		return "", spfPermError("%s: %v", t, err)
+	}
+	return target, nil
+}
+
+func (e *spfEvaluator) countLookup() error {
+	e.lookups++
+	if e.lookups > spfMaxDNSLookups {
+		return spfPermError("more than %d DNS lookups", spfMaxDNSLookups)
+	}
+	return nil
+}
+
+func (e *spfEvaluator) countVoid() error {
+	e.voids++
+	if e.voids > spfMaxVoidLookups {
+		return spfPermError("more than %d void lookups", spfMaxVoidLookups)
+	}
+	return nil
+}
+
+// lookupIPs resolves the addresses of host (void counts for a, not for MX hosts)
+func (e *spfEvaluator) lookupIPs(host string, countVoid bool) ([]net.IP, error) {
+	network := "ip4"
+	if e.ip.To4() == nil {
+		network = "ip6"
+	}
+	ips, err := e.dns.LookupIP(e.ctx, network, host)
+	if err != nil && !isDNSNotFound(err) {
+		return nil, spfTempError("%s: DNS lookup failed: %v", host, err)
+	}
+	if len(ips) == 0 && countVoid {
+		return nil, e.countVoid()
+	}
+	return ips, nil
+}
+
+// matchAny reports whether the IP is within the term's CIDR of any address
+func (e *spfEvaluator) matchAny(ips []net.IP, t SPFTerm) bool {
+	for _, ip := range ips {
+		bits, ones := 128, t.Prefix6
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits, ones = v4, 32, t.Prefix4
+		}
+		network := net.IPNet{IP: ip.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}
+		if network.Contains(e.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPTR checks the IP's validated PTR names against target (§5.5);
+// DNS failures here just mean no match
+func (e *spfEvaluator) matchPTR(target string) bool {
+	names, err := e.dns.LookupAddr(e.ctx, e.ip.String())
+	if err != nil {
+		return false
+	}
+	if len(names) > spfMaxMXPTRNames {
+		names = names[:spfMaxMXPTRNames]
+	}
+	target = strings.ToLower(target)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name != target && !strings.HasSuffix(name, "."+target) {
+			continue
+		}
+		ips, _ := e.dns.LookupIP(e.ctx, "ip", name)
+		for _, ip := range ips {
+			if ip.Equal(e.ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spfQualifierResult maps a qualifier to the result of a match
+func spfQualifierResult(q string) SPFResult {
+	switch q {
+	case "-":
+		return SPFFail
+	case "~":
+		return SPFSoftfail
+	case "?":
+		return SPFNeutral
+	default:
+		return SPFPass
+	}
+}
+
+func spfMatchedSuffix(matched string) string {
+	if matched == "" {
+		return ""
+	}
+	return " → " + matched
+}
+
+//
+// AUTHORIZATION CHECK
+//
+
+// SendingIdentity returns the sending IPs and ESP include to check: the
+// request's, else VETTING_SENDING_IPS / VETTING_SPF_INCLUDE
+func SendingIdentity(req *VetRequest) (ips []string, include string) {
+	if req != nil && (len(req.SendingIPs) > 0 || req.SPFInclude != "") {
+		return req.SendingIPs, req.SPFInclude
+	}
+	for _, ip := range strings.Split(os.Getenv("VETTING_SENDING_IPS"), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, strings.TrimSpace(os.Getenv("VETTING_SPF_INCLUDE"))
+}
+
+// EvaluateSPFAuthorization evaluates domain's SPF for our sending IPs (tree is
+// the domain's analyzed SPF, nil to analyze it here)
+func EvaluateSPFAuthorization(ctx context.Context, domain string, tree *SPFAnalysis, sendingIPs []string, include string) SPFAuthorization {
+	return evaluateSPFAuthorization(ctx, defaultDNS(), domain, tree, sendingIPs, include)
+}
+
+func evaluateSPFAuthorization(ctx context.Context, dns DNSClient, domain string, tree *SPFAnalysis, sendingIPs []string, include string) SPFAuthorization {
+	auth := SPFAuthorization{Include: include}
+	if len(sendingIPs) == 0 && include == "" {
+		auth.CheckOutcome = skipped("no sending IPs or SPF include configured")
+		return auth
+	}
+
+	ips := make([]net.IP, 0, len(sendingIPs))
+	var invalid []string
+	for _, s := range sendingIPs {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			invalid = append(invalid, fmt.Sprintf("%q", s))
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	// A misconfigured IP list is an error of ours, not an SPF finding
+	if len(invalid) > 0 {
+		auth.CheckOutcome = CheckOutcome{Status: StatusError, Error: "invalid sending IPs: " + strings.Join(invalid, ", ")}
+		log.Printf("[SPF] ❌ %s: %s", domain, auth.Error)
+		return auth
+	}
+	if include != "" {
+		if tree == nil {
+			analysis := analyzeSPF(ctx, dns, domain)
+			tree = &analysis
+		}
+		auth.IncludePresent = spfTreeIncludes(tree.Record, include)
+		// Without explicit IPs, sample the include's own networks
+		if len(ips) == 0 {
+			ips = spfSampleIPs(analyzeSPF(ctx, dns, include).Record, spfMaxSampleIPs)
+		}
+	}
+	if len(ips) == 0 {
+		auth.CheckOutcome = CheckOutcome{Status: StatusError, Error: "no sending IPs to check (include has no ip4/ip6 networks)"}
+		return auth
+	}
+
+	auth.Authorized = true
+	tempErrors := 0
+	for _, ip := range ips {
+		res := checkHost(ctx, dns, ip, domain, "")
+		auth.Results = append(auth.Results, res)
+		if res.Result != SPFPass {
+			auth.Authorized = false
+		}
+		if res.Result == SPFTempError {
+			tempErrors++
+		}
+	}
+
+	// Only DNS failures is unknown, not unauthorized
+	switch {
+	case tempErrors == len(ips):
+		auth.CheckOutcome = CheckOutcome{Status: StatusError, Error: auth.Results[0].Error}
+	default:
+		auth.CheckOutcome = negativeIf(!auth.Authorized)
+	}
+
+	if auth.Authorized {
+		log.Printf("[SPF] ✓ %s authorizes all %d sending IPs", domain, len(ips))
+	} else {
+		log.Printf("[SPF] ⚠️ %s does not authorize: %s", domain, strings.Join(auth.Unauthorized(), ", "))
+	}
+	return auth
+}
+
+// spfTreeIncludes reports whether include is reachable through include: or
+// redirect= from rec
+func spfTreeIncludes(rec *SPFRecord, include string) bool {
+	if rec == nil {
+		return false
+	}
+	include = strings.ToLower(strings.TrimSuffix(include, "."))
+	if strings.ToLower(rec.Domain) == include {
+		return true
+	}
+	for _, t := range rec.Terms {
+		if t.Mechanism == "include" && (strings.EqualFold(t.Value, include) || spfTreeIncludes(t.Include, include)) {
+			return true
+		}
+	}
+	return spfTreeIncludes(rec.Redirect, include)
+}
+
+// spfSampleIPs returns the first address of each ip4/ip6 network in rec
+// (authorizing ones only, includes followed)
+func spfSampleIPs(rec *SPFRecord, max int) []net.IP {
+	var ips []net.IP
+	var walk func(r *SPFRecord)
+	walk = func(r *SPFRecord) {
+		if r == nil {
+			return
+		}
+		for _, t := range r.Terms {
+			if len(ips) >= max {
+				return
+			}
+			switch {
+			case (t.Mechanism == "ip4" || t.Mechanism == "ip6") && t.Qualifier == "+":
+				if ip, _, err := net.ParseCIDR(t.Value); err == nil {
+					ips = append(ips, ip)
+				}
+			case t.Mechanism == "include":
+				walk(t.Include)
+			}
+		}
+		walk(r.Redirect)
+	}
+	walk(rec)
+	return ips
+}
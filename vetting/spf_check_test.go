@@ -0,0 +1,140 @@
+package vetting
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCheckHost(t *testing.T) {
+	servfail := &net.DNSError{Err: "server misbehaving", Name: "broken.test"}
+	includes := func(n int) string {
+		var b strings.Builder
+		b.WriteString("v=spf1")
+		for i := range n {
+			fmt.Fprintf(&b, " include:i%d.test", i)
+		}
+		return b.String() + " -all"
+	}
+	dns := &FakeDNS{
+		TXT: map[string][]string{
+			"ip4.test":      {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"ip6.test":      {"v=spf1 ip6:2001:db8::/32 ~all"},
+			"a.test":        {"v=spf1 a:mail.a.test/24 -all"},
+			"mx.test":       {"v=spf1 mx -all"},
+			"include.test":  {"google-site-verification=x", "v=spf1 include:_spf.esp.test ?all"},
+			"_spf.esp.test": {"v=spf1 ip4:203.0.113.0/24 -all"},
+			"exists.test":   {"v=spf1 exists:%{i}.allow.exists.test -all"},
+			"redirect.test": {"v=spf1 redirect=ip4.test"},
+			"neutral.test":  {"v=spf1 ip4:198.51.100.1"},
+			"ten.test":      {includes(10)},
+			"eleven.test":   {includes(11)},
+			"void2.test":    {"v=spf1 a:v1.test mx:v2.test -all"},
+			"void3.test":    {"v=spf1 a:v1.test mx:v2.test exists:v3.test -all"},
+			"noinc.test":    {"v=spf1 include:nospf.test -all"},
+			"nospf.test":    {"not spf"},
+			"noredir.test":  {"v=spf1 redirect=nospf.test"},
+			"incfail.test":  {"v=spf1 include:broken.test -all"},
+			"twice.test":    {"v=spf1 -all", "v=spf1 +all"},
+			"syntax.test":   {"v=spf1 ip4:300.1.1.1 -all"},
+		},
+		IP: map[string][]net.IP{
+			"mail.a.test":                 {net.ParseIP("192.0.2.1")},
+			"mx1.mx.test":                 {net.ParseIP("198.51.100.5")},
+			"192.0.2.7.allow.exists.test": {net.ParseIP("127.0.0.2")},
+		},
+		MX: map[string][]*net.MX{
+			"mx.test": {{Host: "mx1.mx.test.", Pref: 10}},
+		},
+		Errors: map[string]error{"broken.test": servfail},
+	}
+	for i := range 11 {
+		dns.TXT[fmt.Sprintf("i%d.test", i)] = []string{"v=spf1 -all"}
+	}
+
+	tests := []struct {
+		domain    string
+		ip        string
+		result    SPFResult
+		mechanism string
+		err       string
+	}{
+		{"ip4.test", "192.0.2.10", SPFPass, "ip4:192.0.2.0/24", ""},
+		{"ip4.test", "198.51.100.1", SPFFail, "-all", ""},
+		{"ip4.test", "2001:db8::1", SPFFail, "-all", ""},
+		{"ip6.test", "2001:db8::1", SPFPass, "ip6:2001:db8::/32", ""},
+		{"ip6.test", "192.0.2.10", SPFSoftfail, "~all", ""},
+		{"a.test", "192.0.2.200", SPFPass, "a:mail.a.test/24", ""},
+		{"a.test", "192.0.3.1", SPFFail, "-all", ""},
+		{"mx.test", "198.51.100.5", SPFPass, "mx", ""},
+		{"mx.test", "198.51.100.6", SPFFail, "-all", ""},
+		{"include.test", "203.0.113.9", SPFPass, "include:_spf.esp.test", ""},
+		{"include.test", "192.0.2.10", SPFNeutral, "?all", ""},
+		{"exists.test", "192.0.2.7", SPFPass, "exists:%{i}.allow.exists.test", ""},
+		{"exists.test", "192.0.2.8", SPFFail, "-all", ""},
+		{"redirect.test", "192.0.2.10", SPFPass, "redirect=ip4.test → ip4:192.0.2.0/24", ""},
+		{"redirect.test", "198.51.100.1", SPFFail, "redirect=ip4.test → -all", ""},
+		{"neutral.test", "192.0.2.10", SPFNeutral, "", ""},
+		{"none.test", "192.0.2.10", SPFNone, "", ""},
+
+		// Limits
+		{"ten.test", "192.0.2.10", SPFFail, "-all", ""},
+		{"eleven.test", "192.0.2.10", SPFPermError, "include:i10.test", "more than 10 DNS lookups"},
+		{"void2.test", "192.0.2.10", SPFFail, "-all", ""},
+		{"void3.test", "192.0.2.10", SPFPermError, "exists:v3.test", "more than 2 void lookups"},
+
+		// Errors
+		{"noinc.test", "192.0.2.10", SPFPermError, "include:nospf.test", "include:nospf.test has no SPF record"},
+		{"noredir.test", "192.0.2.10", SPFPermError, "", "redirect=nospf.test has no SPF record"},
+		{"twice.test", "192.0.2.10", SPFPermError, "", "2 SPF records"},
+		{"syntax.test", "192.0.2.10", SPFPermError, "", "syntax.test:"},
+		{"broken.test", "192.0.2.10", SPFTempError, "", "DNS lookup failed"},
+		{"incfail.test", "192.0.2.10", SPFTempError, "include:broken.test", "DNS lookup failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain+"/"+tt.ip, func(t *testing.T) {
+			res := checkHost(context.Background(), dns, net.ParseIP(tt.ip), tt.domain, "")
+			if res.Result != tt.result || res.Mechanism != tt.mechanism {
+				t.Errorf("result %s (%q), want %s (%q); error %q", res.Result, res.Mechanism, tt.result, tt.mechanism, res.Error)
+			}
+			if (tt.err == "") != (res.Error == "") || !strings.Contains(res.Error, tt.err) {
+				t.Errorf("error %q, want %q", res.Error, tt.err)
+			}
+		})
+	}
+}
+
+func TestEvaluateSPFAuthorization(t *testing.T) {
+	dns := &FakeDNS{TXT: map[string][]string{
+		"example.com":   {"v=spf1 include:_spf.esp.test -all"},
+		"_spf.esp.test": {"v=spf1 ip4:203.0.113.0/24 ip6:2001:db8::/32 -all"},
+		"other.test":    {"v=spf1 ip4:198.51.100.0/24 -all"},
+	}}
+	ctx := context.Background()
+
+	auth := evaluateSPFAuthorization(ctx, dns, "example.com", nil, nil, "_spf.esp.test")
+	if auth.Status != StatusOK || !auth.Authorized || !auth.IncludePresent || len(auth.Results) != 2 {
+		t.Errorf("sampled from the include: %+v", auth)
+	}
+
+	auth = evaluateSPFAuthorization(ctx, dns, "example.com", nil, []string{"203.0.113.5", "198.51.100.1"}, "")
+	if auth.Status != StatusNegative || auth.Authorized || strings.Join(auth.Unauthorized(), ",") != "198.51.100.1 (fail)" {
+		t.Errorf("explicit IPs: %+v", auth)
+	}
+
+	auth = evaluateSPFAuthorization(ctx, dns, "example.com", nil, []string{"203.0.113.5", "mail.example.com", "300.1.1.1"}, "")
+	if auth.Status != StatusError || auth.Error != `invalid sending IPs: "mail.example.com", "300.1.1.1"` || len(auth.Results) != 0 {
+		t.Errorf("invalid IPs: status %s, error %q", auth.Status, auth.Error)
+	}
+
+	auth = evaluateSPFAuthorization(ctx, dns, "example.com", nil, nil, "other.test")
+	if auth.IncludePresent || auth.Authorized {
+		t.Errorf("include missing from the tree: %+v", auth)
+	}
+
+	if auth := evaluateSPFAuthorization(ctx, dns, "example.com", nil, nil, ""); auth.Status != StatusSkipped {
+		t.Errorf("nothing configured: status %s", auth.Status)
+	}
+}
@@ -1,12 +1,17 @@
 package vetting
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
 	"strings"
 	"time"
 )
 
 type SSLQuality struct {
+	CheckOutcome
 	ValidUntil string `json:"valid_until"`
 	SelfSigned bool   `json:"self_signed"`
 	Protocol   string `json:"protocol"`
@@ -14,18 +19,138 @@ type SSLQuality struct {
 	Score      int    `json:"score"` // 0-100
 }
 
-func CheckSSLQuality(domain string) SSLQuality {
-	q := SSLQuality{}
+func CheckSSLQuality(ctx context.Context, domain string) SSLQuality {
+	hs, _ := DialTLSHandshake(ctx, domain)
+	return hs.SSLQuality()
+}
+
+// TLSHandshake is one TLS handshake to domain:443, shared by the HTTPS,
+// SSL quality and expiry checks (instead of each dialing on its own)
+type TLSHandshake struct {
+	CheckOutcome // ok = connected, negative = no TLS on :443
+	State        tls.ConnectionState
+	Verified     bool  // Certificate chain is valid for the domain
+	VerifyErr    error // Why verification failed (if it did)
+}
 
-	conn, err := tls.Dial("tcp", domain+":443", &tls.Config{
-		InsecureSkipVerify: true,
-	})
+// DialTLSHandshake connects to domain:443 once.
+// The certificate is verified separately so the same handshake serves both
+// the strict HTTPS probe and the lenient SSL quality check.
+func DialTLSHandshake(ctx context.Context, domain string) (TLSHandshake, error) {
+	d := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 5 * time.Second},
+		Config: &tls.Config{
+			ServerName:         domain,
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := d.DialContext(ctx, "tcp", domain+":443")
 	if err != nil {
-		return q
+		return TLSHandshake{CheckOutcome: handshakeOutcome(err)}, err
 	}
 	defer conn.Close()
 
-	state := conn.ConnectionState()
+	hs := TLSHandshake{
+		CheckOutcome: CheckOutcome{Status: StatusOK},
+		State:        conn.(*tls.Conn).ConnectionState(),
+	}
+	hs.VerifyErr = verifyPeerCertificates(hs.State.PeerCertificates, domain)
+	hs.Verified = hs.VerifyErr == nil
+	return hs, nil
+}
+
+// handshakeOutcome classifies a failed TLS dial: no address, connection
+// refused or a handshake failure mean the site has no HTTPS (negative);
+// timeouts and temporary DNS failures leave it unknown
+func handshakeOutcome(err error) CheckOutcome {
+	if isTimeout(err) {
+		return outcomeOf(err)
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && !dnsErr.IsNotFound {
+		return CheckOutcome{Status: StatusError, Error: err.Error()}
+	}
+	return CheckOutcome{Status: StatusNegative, Error: err.Error()}
+}
+
+// verifyPeerCertificates does the verification tls.Dial would have done
+func verifyPeerCertificates(certs []*x509.Certificate, domain string) error {
+	if len(certs) == 0 {
+		return errors.New("no peer certificates")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       domain,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// HTTPSResult - HTTPS enabled with a valid certificate
+type HTTPSResult struct {
+	CheckOutcome
+	OK       bool `json:"ok"`
+	DaysLeft int  `json:"days_left"`
+}
+
+// TLSExpiry - days until the (verified) certificate expires
+type TLSExpiry struct {
+	CheckOutcome
+	DaysLeft  int    `json:"days_left"`
+	ExpiresOn string `json:"expires_on"`
+}
+
+// HTTPSResult returns the HTTPS verdict with the handshake's outcome
+// (a failed connection or invalid certificate is negative)
+func (hs TLSHandshake) HTTPSResult() HTTPSResult {
+	if !hs.Known() {
+		return HTTPSResult{CheckOutcome: hs.CheckOutcome}
+	}
+	ok, days := hs.HTTPS()
+	res := HTTPSResult{CheckOutcome: negativeIf(!ok), OK: ok, DaysLeft: days}
+	if !ok && hs.VerifyErr != nil {
+		res.Error = hs.VerifyErr.Error()
+	}
+	return res
+}
+
+// TLSExpiry returns the certificate expiry with the handshake's outcome
+func (hs TLSHandshake) TLSExpiry() TLSExpiry {
+	if !hs.Known() {
+		return TLSExpiry{CheckOutcome: hs.CheckOutcome}
+	}
+	days, on := hs.Expiry()
+	return TLSExpiry{CheckOutcome: negativeIf(on == ""), DaysLeft: days, ExpiresOn: on}
+}
+
+// HTTPS returns whether HTTPS is properly enabled and days until the certificate expires
+func (hs TLSHandshake) HTTPS() (bool, int) {
+	if !hs.Verified {
+		return false, 0
+	}
+	days := int(time.Until(hs.State.PeerCertificates[0].NotAfter).Hours() / 24)
+	return true, days
+}
+
+// Expiry returns days until the (verified) certificate expires and the expiry date
+func (hs TLSHandshake) Expiry() (int, string) {
+	if !hs.Verified {
+		return 0, ""
+	}
+	expiry := hs.State.PeerCertificates[0].NotAfter
+	return int(time.Until(expiry).Hours() / 24), expiry.Format("02/01/2006")
+}
+
+// SSLQuality grades the handshake (certificate verification not required)
+func (hs TLSHandshake) SSLQuality() SSLQuality {
+	if hs.Status != StatusOK {
+		return SSLQuality{CheckOutcome: hs.CheckOutcome}
+	}
+	q := SSLQuality{CheckOutcome: CheckOutcome{Status: StatusOK}}
+	state := hs.State
 
 	// Expiry
 	if len(state.PeerCertificates) > 0 {
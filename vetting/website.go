@@ -1,6 +1,7 @@
 package vetting
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"time"
@@ -8,72 +9,91 @@ import (
 
 // WebsiteCheck represents website-related checks
 type WebsiteCheck struct {
-	Exists      bool `json:"exists"`       // Binary: website exists and is accessible
-	HTTPSOk     bool `json:"https_ok"`     // Binary: HTTPS available
-	TrafficScore int `json:"traffic_score"` // 1-10 score
-	TrustScore   int `json:"trust_score"`   // 1-10 score
+	CheckOutcome
+	Exists       bool `json:"exists"`        // Binary: website exists and is accessible
+	HTTPSOk      bool `json:"https_ok"`      // Binary: HTTPS available
+	TrafficScore int  `json:"traffic_score"` // 1-10 score
+	TrustScore   int  `json:"trust_score"`   // 1-10 score
 }
 
-// CheckWebsiteExistence verifies if the website is accessible
-func CheckWebsiteExistence(domain string) bool {
+// CheckWebsiteExistence verifies if the website is accessible.
+// Returns an error only if every attempt timed out (existence unknown);
+// refused connections, unknown hosts and error pages mean "does not exist".
+func CheckWebsiteExistence(ctx context.Context, domain string) (bool, error) {
 	// Try HTTPS first
 	client := &http.Client{Timeout: 5 * time.Second}
-	
+
 	urls := []string{
 		"https://" + domain,
 		"http://" + domain,
 		"https://www." + domain,
 		"http://www." + domain,
 	}
-	
+
+	var timeoutErr error
+	timeouts := 0
 	for _, url := range urls {
-		resp, err := client.Get(url)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			continue
+		}
+		resp, err := client.Do(req)
 		if err == nil {
 			resp.Body.Close()
 			// Consider it exists if we get any 2xx or 3xx response
 			if resp.StatusCode >= 200 && resp.StatusCode < 400 {
-				return true
+				return true, nil
 			}
+		} else if isTimeout(err) {
+			timeouts++
+			timeoutErr = err
 		}
 	}
-	
-	return false
+
+	if timeouts == len(urls) {
+		return false, timeoutErr
+	}
+	return false, nil
 }
 
 // CalculateTrafficScore estimates traffic score (1-10) based on available signals
 // This is a simplified version - in production, you'd use APIs like SimilarWeb, Alexa, etc.
-func CalculateTrafficScore(domain string, whoisDays int, hasHTTPS bool, ssl SSLQuality) int {
+// exists is the result of CheckWebsiteExistence (probed once, shared with CheckWebsite)
+func CalculateTrafficScore(whoisDays int, hasHTTPS bool, ssl SSLQuality, exists bool) int {
 	score := 1 // Start with minimum
-	
+
 	// Domain age contributes to traffic likelihood
 	if whoisDays > 365 {
 		score += 2
 	} else if whoisDays > 180 {
 		score += 1
 	}
-	
+
 	// HTTPS presence suggests active site
 	if hasHTTPS {
 		score += 2
 	}
-	
+
 	// SSL quality indicates professional setup
 	if ssl.Score >= 80 {
 		score += 2
 	} else if ssl.Score >= 60 {
 		score += 1
 	}
-	
+
 	// Website exists and is accessible
-	if CheckWebsiteExistence(domain) {
+	if exists {
 		score += 3
 	}
-	
+
 	// Cap at 10
 	if score > 10 {
 		score = 10
 	}
-	
+
 	return score
 }
 
@@ -88,12 +108,12 @@ func CalculateTrustScore(
 	ssl SSLQuality,
 ) int {
 	score := 1 // Start with minimum
-	
+
 	// HTTPS is fundamental
 	if hasHTTPS {
 		score += 2
 	}
-	
+
 	// Domain age builds trust
 	if whoisDays > 365 {
 		score += 2
@@ -102,7 +122,7 @@ func CalculateTrustScore(
 	} else if whoisDays < 60 {
 		score -= 1 // New domains are less trusted
 	}
-	
+
 	// Clean blacklist status
 	if blacklistCount == 0 {
 		score += 2
@@ -111,7 +131,7 @@ func CalculateTrustScore(
 	} else {
 		score -= blacklistCount // Penalize multiple listings
 	}
-	
+
 	// Good sender reputation
 	if mxRep >= 80 {
 		score += 2
@@ -120,24 +140,24 @@ func CalculateTrustScore(
 	} else if mxRep < 40 {
 		score -= 1
 	}
-	
+
 	// Not flagged by Google
 	if !googleFlagged {
 		score += 1
 	} else {
 		score -= 2
 	}
-	
+
 	// Email security setup
 	if emailSec.HasSPF && emailSec.HasDMARC {
 		score += 1
 	}
-	
+
 	// SSL quality
 	if ssl.Score >= 80 {
 		score += 1
 	}
-	
+
 	// Ensure score stays within 1-10 range
 	if score < 1 {
 		score = 1
@@ -145,13 +165,14 @@ func CalculateTrustScore(
 	if score > 10 {
 		score = 10
 	}
-	
+
 	return score
 }
 
 // CheckWebsite performs all website-related checks
+// exists is the result of CheckWebsiteExistence
 func CheckWebsite(
-	domain string,
+	exists bool,
 	whoisDays int,
 	hasHTTPS bool,
 	ssl SSLQuality,
@@ -160,19 +181,20 @@ func CheckWebsite(
 	googleFlagged bool,
 	emailSec EmailSecurity,
 ) WebsiteCheck {
-	exists := CheckWebsiteExistence(domain)
-	
+	websiteReachable := exists
+
 	// If website doesn't exist, use HTTPS check as fallback
 	if !exists {
 		exists = hasHTTPS
 	}
-	
-	trafficScore := CalculateTrafficScore(domain, whoisDays, hasHTTPS, ssl)
+
+	trafficScore := CalculateTrafficScore(whoisDays, hasHTTPS, ssl, websiteReachable)
 	trustScore := CalculateTrustScore(hasHTTPS, whoisDays, blacklistCount, mxRep, googleFlagged, emailSec, ssl)
-	
+
 	return WebsiteCheck{
-		Exists:      exists,
-		HTTPSOk:     hasHTTPS,
+		CheckOutcome: negativeIf(!exists),
+		Exists:       exists,
+		HTTPSOk:      hasHTTPS,
 		TrafficScore: trafficScore,
 		TrustScore:   trustScore,
 	}
@@ -182,15 +204,14 @@ func CheckWebsite(
 func NormalizeDomain(domain string) string {
 	domain = strings.TrimSpace(domain)
 	domain = strings.ToLower(domain)
-	
+
 	// Remove protocol if present
 	domain = strings.TrimPrefix(domain, "http://")
 	domain = strings.TrimPrefix(domain, "https://")
 	domain = strings.TrimPrefix(domain, "www.")
-	
+
 	// Remove trailing slash
 	domain = strings.TrimSuffix(domain, "/")
-	
+
 	return domain
 }
-